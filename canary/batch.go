@@ -57,10 +57,11 @@ type (
 	// TODO: to get rid of it:
 	//  after batch job has an API, we should use the API: https://github.com/uber/cadence/issues/2225
 	BatchParams struct {
-		DomainName string
-		Query      string
-		Reason     string
-		BatchType  string
+		DomainName       string
+		Query            string
+		Reason           string
+		BatchType        string
+		OperatorIdentity string
 	}
 )
 
@@ -168,10 +169,11 @@ func startBatchWorkflow(ctx context.Context, domain, startTime string) error {
 	sdkClient := getContextValue(ctx, ctxKeyActivitySystemClient).(*activityContext).cadence
 
 	params := BatchParams{
-		DomainName: domain,
-		Query:      "WorkflowType = '" + wfTypeBatchParent + "' AND CloseTime = missing AND StartTime <'" + startTime + "' ",
-		Reason:     "batch canary",
-		BatchType:  "terminate",
+		DomainName:       domain,
+		Query:            "WorkflowType = '" + wfTypeBatchParent + "' AND CloseTime = missing AND StartTime <'" + startTime + "' ",
+		Reason:           "batch canary",
+		BatchType:        "terminate",
+		OperatorIdentity: "admin",
 	}
 
 	options := client.StartWorkflowOptions{
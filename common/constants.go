@@ -142,4 +142,7 @@ const (
 	AdvancedVisibilityWritingModeOn = "on"
 	// AdvancedVisibilityWritingModeDual means write to both normal visibility and advanced visibility store
 	AdvancedVisibilityWritingModeDual = "dual"
+	// AdvancedVisibilityWritingModeMirror means history only writes to advanced visibility store, and the indexer
+	// mirrors the indexed records to normal visibility store, so that the two stores can be compared during a migration
+	AdvancedVisibilityWritingModeMirror = "mirror"
 )
@@ -453,6 +453,16 @@ func ShardTimerAcks(shardTimerAcks interface{}) Tag {
 	return newObjectTag("shard-timer-acks", shardTimerAcks)
 }
 
+// OperatorIdentity returns tag for the identity of who launched an operation
+func OperatorIdentity(identity string) Tag {
+	return newStringTag("operator-identity", identity)
+}
+
+// ShardEngineInitLatency returns tag for ShardEngineInitLatency
+func ShardEngineInitLatency(latency time.Duration) Tag {
+	return newDurationTag("shard-engine-init-latency", latency)
+}
+
 // task queue processor
 
 // TaskID returns tag for TaskID
@@ -588,6 +598,21 @@ func TokenLastEventID(id int64) Tag {
 	return newInt64("token-last-event-id", id)
 }
 
+// VisibilityQueryKind returns tag for the kind of a visibility query
+func VisibilityQueryKind(queryKind string) Tag {
+	return newStringTag("visibility-query-kind", queryKind)
+}
+
+// VisibilityQueryLatency returns tag for the latency of a visibility query
+func VisibilityQueryLatency(latency time.Duration) Tag {
+	return newDurationTag("visibility-query-latency", latency)
+}
+
+// VisibilityQueryPlan returns tag for the plan of a visibility query
+func VisibilityQueryPlan(plan string) Tag {
+	return newStringTag("visibility-query-plan", plan)
+}
+
 ///////////////////  XDC tags defined here: xdc- ///////////////////
 
 // SourceCluster returns tag for SourceCluster
@@ -66,6 +66,9 @@ type (
 		RemoveListener(service string, name string) error
 		// GetReachableMembers returns addresses of all members of the ring
 		GetReachableMembers() ([]string, error)
+		// EvictSelf marks this host as leaving the ring, so that the other members stop routing to it
+		// before it's gone. It must only be called when the host is about to shut down
+		EvictSelf() error
 	}
 
 	// ServiceResolver provides membership information for a specific cadence service.
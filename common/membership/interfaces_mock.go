@@ -168,6 +168,20 @@ func (mr *MockMonitorMockRecorder) GetReachableMembers() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetReachableMembers", reflect.TypeOf((*MockMonitor)(nil).GetReachableMembers))
 }
 
+// EvictSelf mocks base method
+func (m *MockMonitor) EvictSelf() error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "EvictSelf")
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// EvictSelf indicates an expected call of EvictSelf
+func (mr *MockMonitorMockRecorder) EvictSelf() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "EvictSelf", reflect.TypeOf((*MockMonitor)(nil).EvictSelf))
+}
+
 // MockServiceResolver is a mock of ServiceResolver interface
 type MockServiceResolver struct {
 	ctrl     *gomock.Controller
@@ -150,3 +150,7 @@ func (rpo *ringpopMonitor) RemoveListener(service string, name string) error {
 func (rpo *ringpopMonitor) GetReachableMembers() ([]string, error) {
 	return rpo.rp.GetReachableMembers()
 }
+
+func (rpo *ringpopMonitor) EvictSelf() error {
+	return rpo.rp.SelfEvict()
+}
@@ -249,6 +249,12 @@ const (
 	PersistenceUpdateAckLevelScope
 	// PersistenceGetAckLevelScope tracks GetAckLevel calls made by service to persistence layer
 	PersistenceGetAckLevelScope
+	// PersistenceSQLVisibilityQueryScope tracks the queries made by sql visibility store to database, tagged by query kind
+	PersistenceSQLVisibilityQueryScope
+	// PersistenceSQLConnPoolScope tracks the connection pool shared by the sql stores other than visibility
+	PersistenceSQLConnPoolScope
+	// PersistenceSQLVisibilityConnPoolScope tracks the connection pool of sql visibility store
+	PersistenceSQLVisibilityConnPoolScope
 	// HistoryClientStartWorkflowExecutionScope tracks RPC calls to history service
 	HistoryClientStartWorkflowExecutionScope
 	// HistoryClientRecordActivityTaskHeartbeatScope tracks RPC calls to history service
@@ -951,6 +957,8 @@ const (
 	BatcherScope
 	// HistoryScavengerScope is scope used by all metrics emitted by worker.history.Scavenger module
 	HistoryScavengerScope
+	// VisibilityDriftScannerScope is scope used by all metrics emitted by the worker.Scanner visibility drift scan job
+	VisibilityDriftScannerScope
 	// ParentClosePolicyProcessorScope is scope used by all metrics emitted by worker.ParentClosePolicyProcessor
 	ParentClosePolicyProcessorScope
 
@@ -1029,6 +1037,9 @@ var ScopeDefs = map[ServiceIdx]map[int]scopeDefinition{
 		PersistenceDeleteQueueMessagesScope:                      {operation: "DeleteQueueMessages"},
 		PersistenceUpdateAckLevelScope:                           {operation: "UpdateAckLevel"},
 		PersistenceGetAckLevelScope:                              {operation: "GetAckLevel"},
+		PersistenceSQLVisibilityQueryScope:                       {operation: "SQLVisibilityQuery"},
+		PersistenceSQLConnPoolScope:                              {operation: "SQLConnPool"},
+		PersistenceSQLVisibilityConnPoolScope:                    {operation: "SQLVisibilityConnPool"},
 
 		ClusterMetadataArchivalConfigScope: {operation: "ArchivalConfig"},
 
@@ -1373,6 +1384,7 @@ var ScopeDefs = map[ServiceIdx]map[int]scopeDefinition{
 		ArchiverArchivalWorkflowScope:          {operation: "ArchiverArchivalWorkflow"},
 		TaskListScavengerScope:                 {operation: "tasklistscavenger"},
 		HistoryScavengerScope:                  {operation: "historyscavenger"},
+		VisibilityDriftScannerScope:            {operation: "visibilitydriftscanner"},
 		BatcherScope:                           {operation: "batcher"},
 		ParentClosePolicyProcessorScope:        {operation: "ParentClosePolicyProcessor"},
 	},
@@ -1412,6 +1424,11 @@ const (
 	PersistenceErrDomainAlreadyExistsCounter
 	PersistenceErrBadRequestCounter
 	PersistenceSampledCounter
+	PersistenceSQLConnPoolOpen
+	PersistenceSQLConnPoolInUse
+	PersistenceSQLConnPoolIdle
+	PersistenceSQLConnPoolWaitCount
+	PersistenceSQLConnPoolWaitDuration
 
 	CadenceClientRequests
 	CadenceClientFailures
@@ -1528,6 +1545,11 @@ const (
 	ShardItemCreatedCounter
 	ShardItemRemovedCounter
 	ShardItemAcquisitionLatency
+	ShardItemEngineInitLatency
+	ShardItemReacquiredCounter
+	ShardHandoffLatency
+	ShardAcquisitionLatency
+	ShardRangeIDGapCounter
 	ShardInfoReplicationPendingTasksTimer
 	ShardInfoTransferActivePendingTasksTimer
 	ShardInfoTransferStandbyPendingTasksTimer
@@ -1546,6 +1568,7 @@ const (
 	SyncShardFromRemoteFailure
 	MembershipChangedCounter
 	NumShardsGauge
+	NumAcquiringShardsGauge
 	GetEngineForShardErrorCounter
 	GetEngineForShardLatency
 	RemoveEngineForShardLatency
@@ -1680,8 +1703,10 @@ const (
 	ESProcessorFailures
 	ESProcessorCorruptedData
 	ESProcessorProcessMsgLatency
+	ESProcessorFlushedOnShutdown
 	IndexProcessorCorruptedData
 	IndexProcessorProcessMsgLatency
+	IndexProcessorMirrorDivergence
 	ArchiverNonRetryableErrorCount
 	ArchiverStartedCount
 	ArchiverStoppedCount
@@ -1712,6 +1737,7 @@ const (
 	TaskDeletedCount
 	TaskListProcessedCount
 	TaskListDeletedCount
+	TaskListSkippedCount
 	TaskListOutstandingCount
 	StartedCount
 	StoppedCount
@@ -1719,9 +1745,30 @@ const (
 	ExecutorTasksDroppedCount
 	BatcherProcessorSuccess
 	BatcherProcessorFailures
+	BatcherProcessorSkipped
+	BatcherProcessorSkippedClosed
+	BatcherProcessorSkippedOversized
+	BatcherProcessorNotFound
+	BatcherOperationDeadlineExceeded
+	BatcherProcessorArchived
+	BatcherTerminateTagFailures
+	BatcherVerificationFailures
+	BatcherSuppressedOnStandby
+	BatcherProcessorDeleted
+	BatcherJobStarted
+	BatcherUpsertSearchAttributesSignals
+	BatcherChildrenProcessed
+	BatcherDescendantsDiscovered
+	BatcherDescendantsTruncated
+	BatcherResultSinkFailures
+	BatcherErrorRateExceeded
 	HistoryScavengerSuccessCount
 	HistoryScavengerErrorCount
 	HistoryScavengerSkipCount
+	VisibilityDriftScannerVisibilityOpen
+	VisibilityDriftScannerExecutionOpen
+	VisibilityDriftScannerDrift
+	VisibilityDriftScannerErrorCount
 	ParentClosePolicyProcessorSuccess
 	ParentClosePolicyProcessorFailures
 
@@ -1763,6 +1810,11 @@ var MetricDefs = map[ServiceIdx]map[int]metricDefinition{
 		PersistenceErrDomainAlreadyExistsCounter:            {metricName: "persistence_errors_domain_already_exists", metricType: Counter},
 		PersistenceErrBadRequestCounter:                     {metricName: "persistence_errors_bad_request", metricType: Counter},
 		PersistenceSampledCounter:                           {metricName: "persistence_sampled", metricType: Counter},
+		PersistenceSQLConnPoolOpen:                          {metricName: "persistence_sql_conn_pool_open", metricType: Gauge},
+		PersistenceSQLConnPoolInUse:                         {metricName: "persistence_sql_conn_pool_in_use", metricType: Gauge},
+		PersistenceSQLConnPoolIdle:                          {metricName: "persistence_sql_conn_pool_idle", metricType: Gauge},
+		PersistenceSQLConnPoolWaitCount:                     {metricName: "persistence_sql_conn_pool_wait_count", metricType: Gauge},
+		PersistenceSQLConnPoolWaitDuration:                  {metricName: "persistence_sql_conn_pool_wait_duration_ms", metricType: Gauge},
 		CadenceClientRequests:                               {metricName: "cadence_client_requests", metricType: Counter},
 		CadenceClientFailures:                               {metricName: "cadence_client_errors", metricType: Counter},
 		CadenceClientLatency:                                {metricName: "cadence_client_latency", metricType: Timer},
@@ -1858,6 +1910,11 @@ var MetricDefs = map[ServiceIdx]map[int]metricDefinition{
 		ShardItemCreatedCounter:                           {metricName: "sharditem_created_count", metricType: Counter},
 		ShardItemRemovedCounter:                           {metricName: "sharditem_removed_count", metricType: Counter},
 		ShardItemAcquisitionLatency:                       {metricName: "sharditem_acquisition_latency", metricType: Timer},
+		ShardItemEngineInitLatency:                        {metricName: "sharditem_engine_init_latency", metricType: Timer},
+		ShardItemReacquiredCounter:                        {metricName: "sharditem_reacquired_count", metricType: Counter},
+		ShardHandoffLatency:                               {metricName: "shard_handoff_latency", metricType: Timer},
+		ShardAcquisitionLatency:                           {metricName: "shard_acquisition_latency", metricType: Timer},
+		ShardRangeIDGapCounter:                            {metricName: "shard_range_id_gap", metricType: Counter},
 		ShardInfoReplicationPendingTasksTimer:             {metricName: "shardinfo_replication_pending_task", metricType: Timer},
 		ShardInfoTransferActivePendingTasksTimer:          {metricName: "shardinfo_transfer_active_pending_task", metricType: Timer},
 		ShardInfoTransferStandbyPendingTasksTimer:         {metricName: "shardinfo_transfer_standby_pending_task", metricType: Timer},
@@ -1876,6 +1933,7 @@ var MetricDefs = map[ServiceIdx]map[int]metricDefinition{
 		SyncShardFromRemoteFailure:                        {metricName: "syncshard_remote_failed", metricType: Counter},
 		MembershipChangedCounter:                          {metricName: "membership_changed_count", metricType: Counter},
 		NumShardsGauge:                                    {metricName: "numshards_gauge", metricType: Gauge},
+		NumAcquiringShardsGauge:                           {metricName: "num_acquiring_shards_gauge", metricType: Gauge},
 		GetEngineForShardErrorCounter:                     {metricName: "get_engine_for_shard_errors", metricType: Counter},
 		GetEngineForShardLatency:                          {metricName: "get_engine_for_shard_latency", metricType: Timer},
 		RemoveEngineForShardLatency:                       {metricName: "remove_engine_for_shard_latency", metricType: Timer},
@@ -2002,8 +2060,10 @@ var MetricDefs = map[ServiceIdx]map[int]metricDefinition{
 		ESProcessorFailures:                           {metricName: "es_processor_errors"},
 		ESProcessorCorruptedData:                      {metricName: "es_processor_corrupted_data"},
 		ESProcessorProcessMsgLatency:                  {metricName: "es_processor_process_msg_latency", metricType: Timer},
+		ESProcessorFlushedOnShutdown:                  {metricName: "es_processor_flushed_on_shutdown"},
 		IndexProcessorCorruptedData:                   {metricName: "index_processor_corrupted_data"},
 		IndexProcessorProcessMsgLatency:               {metricName: "index_processor_process_msg_latency", metricType: Timer},
+		IndexProcessorMirrorDivergence:                {metricName: "index_processor_mirror_divergence"},
 		ArchiverNonRetryableErrorCount:                {metricName: "archiver_non_retryable_error"},
 		ArchiverStartedCount:                          {metricName: "archiver_started"},
 		ArchiverStoppedCount:                          {metricName: "archiver_stopped"},
@@ -2034,6 +2094,7 @@ var MetricDefs = map[ServiceIdx]map[int]metricDefinition{
 		TaskDeletedCount:                              {metricName: "task_deleted", metricType: Gauge},
 		TaskListProcessedCount:                        {metricName: "tasklist_processed", metricType: Gauge},
 		TaskListDeletedCount:                          {metricName: "tasklist_deleted", metricType: Gauge},
+		TaskListSkippedCount:                          {metricName: "tasklist_skipped", metricType: Gauge},
 		TaskListOutstandingCount:                      {metricName: "tasklist_outstanding", metricType: Gauge},
 		StartedCount:                                  {metricName: "started", metricType: Counter},
 		StoppedCount:                                  {metricName: "stopped", metricType: Counter},
@@ -2041,9 +2102,30 @@ var MetricDefs = map[ServiceIdx]map[int]metricDefinition{
 		ExecutorTasksDroppedCount:                     {metricName: "executor_dropped", metricType: Counter},
 		BatcherProcessorSuccess:                       {metricName: "batcher_processor_requests", metricType: Counter},
 		BatcherProcessorFailures:                      {metricName: "batcher_processor_errors", metricType: Counter},
+		BatcherProcessorSkipped:                       {metricName: "batcher_processor_skipped", metricType: Counter},
+		BatcherProcessorSkippedClosed:                 {metricName: "batcher_processor_skipped_closed", metricType: Counter},
+		BatcherProcessorSkippedOversized:              {metricName: "batcher_processor_skipped_oversized", metricType: Counter},
+		BatcherProcessorNotFound:                      {metricName: "batcher_processor_not_found", metricType: Counter},
+		BatcherOperationDeadlineExceeded:              {metricName: "batcher_operation_deadline_exceeded", metricType: Counter},
+		BatcherProcessorArchived:                      {metricName: "batcher_processor_archived", metricType: Counter},
+		BatcherTerminateTagFailures:                   {metricName: "batcher_terminate_tag_failures", metricType: Counter},
+		BatcherVerificationFailures:                   {metricName: "batcher_verification_failures", metricType: Counter},
+		BatcherSuppressedOnStandby:                    {metricName: "batcher_suppressed_on_standby", metricType: Counter},
+		BatcherProcessorDeleted:                       {metricName: "batcher_processor_deleted", metricType: Counter},
+		BatcherJobStarted:                             {metricName: "batcher_job_started", metricType: Counter},
+		BatcherUpsertSearchAttributesSignals:          {metricName: "batcher_upsert_search_attributes_signals", metricType: Counter},
+		BatcherChildrenProcessed:                      {metricName: "batcher_children_processed", metricType: Counter},
+		BatcherDescendantsDiscovered:                  {metricName: "batcher_descendants_discovered", metricType: Counter},
+		BatcherDescendantsTruncated:                   {metricName: "batcher_descendants_truncated", metricType: Counter},
+		BatcherResultSinkFailures:                     {metricName: "batcher_result_sink_failures", metricType: Counter},
+		BatcherErrorRateExceeded:                      {metricName: "batcher_error_rate_exceeded", metricType: Counter},
 		HistoryScavengerSuccessCount:                  {metricName: "scavenger_success", metricType: Counter},
 		HistoryScavengerErrorCount:                    {metricName: "scavenger_errors", metricType: Counter},
 		HistoryScavengerSkipCount:                     {metricName: "scavenger_skips", metricType: Counter},
+		VisibilityDriftScannerVisibilityOpen:          {metricName: "visibility_drift_visibility_open", metricType: Gauge},
+		VisibilityDriftScannerExecutionOpen:           {metricName: "visibility_drift_execution_open", metricType: Gauge},
+		VisibilityDriftScannerDrift:                   {metricName: "visibility_drift", metricType: Gauge},
+		VisibilityDriftScannerErrorCount:              {metricName: "visibility_drift_errors", metricType: Counter},
 		ParentClosePolicyProcessorSuccess:             {metricName: "parent_close_policy_processor_requests", metricType: Counter},
 		ParentClosePolicyProcessorFailures:            {metricName: "parent_close_policy_processor_errors", metricType: Counter},
 	},
@@ -20,6 +20,10 @@
 
 package metrics
 
+import (
+	"strconv"
+)
+
 const (
 	revisionTag     = "revision"
 	branchTag       = "branch"
@@ -31,6 +35,10 @@ const (
 	domain        = "domain"
 	targetCluster = "target_cluster"
 	taskList      = "tasklist"
+	queryKind     = "query_kind"
+	shardID       = "shard_id"
+	acquisition   = "acquisition_type"
+	batchType     = "batch_type"
 
 	domainAllValue = "all"
 	unknownValue   = "_unknown_"
@@ -60,6 +68,22 @@ type (
 	taskListTag struct {
 		value string
 	}
+
+	queryKindTag struct {
+		value string
+	}
+
+	shardIDTag struct {
+		value string
+	}
+
+	acquisitionTypeTag struct {
+		value string
+	}
+
+	batchTypeTag struct {
+		value string
+	}
 )
 
 // DomainTag returns a new domain tag. For timers, this also ensures that we
@@ -147,3 +171,72 @@ func (d taskListTag) Key() string {
 func (d taskListTag) Value() string {
 	return d.value
 }
+
+// QueryKindTag returns a new query kind tag, which tells the shape of a database query
+func QueryKindTag(value string) Tag {
+	if len(value) == 0 {
+		value = unknownValue
+	}
+	return queryKindTag{value}
+}
+
+// Key returns the key of the query kind tag
+func (d queryKindTag) Key() string {
+	return queryKind
+}
+
+// Value returns the value of the query kind tag
+func (d queryKindTag) Value() string {
+	return d.value
+}
+
+// ShardIDTag returns a new shard id tag
+func ShardIDTag(value int) Tag {
+	return shardIDTag{strconv.Itoa(value)}
+}
+
+// Key returns the key of the shard id tag
+func (d shardIDTag) Key() string {
+	return shardID
+}
+
+// Value returns the value of the shard id tag
+func (d shardIDTag) Value() string {
+	return d.value
+}
+
+// AcquisitionTypeTag returns a new acquisition type tag, which tells why a shard is acquired
+func AcquisitionTypeTag(value string) Tag {
+	if len(value) == 0 {
+		value = unknownValue
+	}
+	return acquisitionTypeTag{value}
+}
+
+// Key returns the key of the acquisition type tag
+func (d acquisitionTypeTag) Key() string {
+	return acquisition
+}
+
+// Value returns the value of the acquisition type tag
+func (d acquisitionTypeTag) Value() string {
+	return d.value
+}
+
+// BatchTypeTag returns a new batch type tag, which tells the operation of a batch job
+func BatchTypeTag(value string) Tag {
+	if len(value) == 0 {
+		value = unknownValue
+	}
+	return batchTypeTag{value}
+}
+
+// Key returns the key of the batch type tag
+func (d batchTypeTag) Key() string {
+	return batchType
+}
+
+// Value returns the value of the batch type tag
+func (d batchTypeTag) Value() string {
+	return d.value
+}
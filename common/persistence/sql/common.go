@@ -64,7 +64,8 @@ func (m *sqlStore) txExecute(operation string, f func(tx sqlplugin.Tx) error) er
 			*workflow.InternalServiceError,
 			*persistence.WorkflowExecutionAlreadyStartedError,
 			*workflow.DomainAlreadyExistsError,
-			*persistence.ShardOwnershipLostError:
+			*persistence.ShardOwnershipLostError,
+			*persistence.TimeoutError:
 			return err
 		default:
 			return &workflow.InternalServiceError{
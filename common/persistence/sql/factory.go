@@ -21,11 +21,14 @@
 package sql
 
 import (
+	"database/sql"
 	"fmt"
 	"sync"
+	"time"
 
 	"github.com/uber/cadence/common"
 	"github.com/uber/cadence/common/log"
+	"github.com/uber/cadence/common/metrics"
 	p "github.com/uber/cadence/common/persistence"
 	"github.com/uber/cadence/common/persistence/sql/sqlplugin"
 	"github.com/uber/cadence/common/service/config"
@@ -34,10 +37,11 @@ import (
 type (
 	// Factory vends store objects backed by MySQL
 	Factory struct {
-		cfg         config.SQL
-		dbConn      dbConn
-		clusterName string
-		logger      log.Logger
+		cfg           config.SQL
+		dbConn        dbConn
+		clusterName   string
+		logger        log.Logger
+		metricsClient metrics.Client
 	}
 
 	// dbConn represents a logical mysql connection - its a
@@ -46,19 +50,21 @@ type (
 	dbConn struct {
 		sync.Mutex
 		sqlplugin.DB
-		refCnt int
-		cfg    *config.SQL
+		refCnt        int
+		cfg           *config.SQL
+		metricsClient metrics.Client
 	}
 )
 
 // NewFactory returns an instance of a factory object which can be used to create
-// datastores backed by any kind of SQL store
-func NewFactory(cfg config.SQL, clusterName string, logger log.Logger) *Factory {
+// datastores backed by any kind of SQL store, metricsClient is optional
+func NewFactory(cfg config.SQL, clusterName string, logger log.Logger, metricsClient metrics.Client) *Factory {
 	return &Factory{
-		cfg:         cfg,
-		clusterName: clusterName,
-		logger:      logger,
-		dbConn:      newRefCountedDBConn(&cfg),
+		cfg:           cfg,
+		clusterName:   clusterName,
+		logger:        logger,
+		metricsClient: metricsClient,
+		dbConn:        newRefCountedDBConn(&cfg, metricsClient),
 	}
 }
 
@@ -109,7 +115,7 @@ func (f *Factory) NewExecutionStore(shardID int) (p.ExecutionStore, error) {
 
 // NewVisibilityStore returns a visibility store
 func (f *Factory) NewVisibilityStore() (p.VisibilityStore, error) {
-	return NewSQLVisibilityStore(f.cfg, f.logger)
+	return NewSQLVisibilityStore(f.cfg, f.logger, f.metricsClient)
 }
 
 // NewQueue returns a new queue backed by sql
@@ -130,9 +136,10 @@ func (f *Factory) Close() {
 // newRefCountedDBConn returns a  logical mysql connection that
 // uses reference counting to decide when to close the
 // underlying connection object. The reference count gets incremented
-// everytime get() is called and decremented everytime Close() is called
-func newRefCountedDBConn(cfg *config.SQL) dbConn {
-	return dbConn{cfg: cfg}
+// everytime get() is called and decremented everytime Close() is called.
+// metricsClient is optional and used for emitting the stats of the connection pool
+func newRefCountedDBConn(cfg *config.SQL, metricsClient metrics.Client) dbConn {
+	return dbConn{cfg: cfg, metricsClient: metricsClient}
 }
 
 // get returns a mysql db connection and increments a reference count
@@ -146,6 +153,9 @@ func (c *dbConn) get() (sqlplugin.DB, error) {
 		if err != nil {
 			return nil, err
 		}
+		if c.metricsClient != nil {
+			conn.SetConnPoolObserver(newConnPoolObserver(c.metricsClient, metrics.PersistenceSQLConnPoolScope))
+		}
 		c.DB = conn
 	}
 	c.refCnt++
@@ -178,3 +188,15 @@ func (c *dbConn) Close() error {
 	}
 	return nil
 }
+
+// newConnPoolObserver returns the observer emitting the stats of a connection pool as gauges of the scope
+func newConnPoolObserver(metricsClient metrics.Client, scope int) sqlplugin.ConnPoolObserver {
+	return func(stats sql.DBStats) {
+		sw := metricsClient.Scope(scope)
+		sw.UpdateGauge(metrics.PersistenceSQLConnPoolOpen, float64(stats.OpenConnections))
+		sw.UpdateGauge(metrics.PersistenceSQLConnPoolInUse, float64(stats.InUse))
+		sw.UpdateGauge(metrics.PersistenceSQLConnPoolIdle, float64(stats.Idle))
+		sw.UpdateGauge(metrics.PersistenceSQLConnPoolWaitCount, float64(stats.WaitCount))
+		sw.UpdateGauge(metrics.PersistenceSQLConnPoolWaitDuration, float64(stats.WaitDuration/time.Millisecond))
+	}
+}
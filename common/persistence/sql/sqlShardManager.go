@@ -22,6 +22,7 @@ package sql
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"time"
 
@@ -142,14 +143,27 @@ func (m *sqlShardManager) UpdateShard(request *persistence.UpdateShardRequest) e
 		if err := lockShard(tx, request.ShardInfo.ShardID, request.PreviousRangeID); err != nil {
 			return err
 		}
-		result, err := tx.UpdateShards(row)
+		result, err := tx.UpdateShards(row, request.PreviousRangeID)
 		if err != nil {
+			var lostErr *sqlplugin.ShardOwnershipLostError
+			if errors.As(err, &lostErr) {
+				return &persistence.ShardOwnershipLostError{
+					ShardID: request.ShardInfo.ShardID,
+					Msg:     fmt.Sprintf("Failed to update shard. %v", lostErr),
+				}
+			}
 			return err
 		}
 		rowsAffected, err := result.RowsAffected()
 		if err != nil {
 			return fmt.Errorf("rowsAffected returned error for shardID %v: %v", request.ShardInfo.ShardID, err)
 		}
+		if rowsAffected == 0 {
+			return &persistence.ShardOwnershipLostError{
+				ShardID: request.ShardInfo.ShardID,
+				Msg:     fmt.Sprintf("Failed to update shard. Previous range ID: %v no longer matches", request.PreviousRangeID),
+			}
+		}
 		if rowsAffected != 1 {
 			return fmt.Errorf("rowsAffected returned %v shards instead of one", rowsAffected)
 		}
@@ -161,6 +175,11 @@ func (m *sqlShardManager) UpdateShard(request *persistence.UpdateShardRequest) e
 func lockShard(tx sqlplugin.Tx, shardID int, oldRangeID int64) error {
 	rangeID, err := tx.WriteLockShards(&sqlplugin.ShardsFilter{ShardID: int64(shardID)})
 	if err != nil {
+		if _, ok := err.(*sqlplugin.ShardLockTimeoutError); ok {
+			return &persistence.TimeoutError{
+				Msg: fmt.Sprintf("Failed to lock shard with ID: %v. Error: %v", shardID, err),
+			}
+		}
 		if err == sql.ErrNoRows {
 			return &workflow.InternalServiceError{
 				Message: fmt.Sprintf("Failed to lock shard with ID %v that does not exist.", shardID),
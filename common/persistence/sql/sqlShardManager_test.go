@@ -0,0 +1,60 @@
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package sql
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	workflow "github.com/uber/cadence/.gen/go/shared"
+	p "github.com/uber/cadence/common/persistence"
+	"github.com/uber/cadence/common/persistence/sql/sqlplugin"
+)
+
+// lockingTx returns err, or rangeID if err is nil, from WriteLockShards
+type lockingTx struct {
+	sqlplugin.Tx
+	rangeID int
+	err     error
+}
+
+func (tx *lockingTx) WriteLockShards(filter *sqlplugin.ShardsFilter) (int, error) {
+	return tx.rangeID, tx.err
+}
+
+func TestLockShardErrors(t *testing.T) {
+	err := lockShard(&lockingTx{err: &sqlplugin.ShardLockTimeoutError{ShardID: 1, Timeout: time.Second}}, 1, 1)
+	_, ok := err.(*p.TimeoutError)
+	require.True(t, ok, "lock timeout is mapped to a persistence timeout: %v", err)
+
+	err = lockShard(&lockingTx{err: errors.New("connection reset")}, 1, 1)
+	_, ok = err.(*workflow.InternalServiceError)
+	require.True(t, ok, "other errors are internal: %v", err)
+
+	err = lockShard(&lockingTx{rangeID: 2}, 1, 1)
+	_, ok = err.(*p.ShardOwnershipLostError)
+	require.True(t, ok, "range ID mismatch loses the shard: %v", err)
+
+	require.NoError(t, lockShard(&lockingTx{rangeID: 1}, 1, 1))
+}
@@ -28,15 +28,36 @@ import (
 
 	workflow "github.com/uber/cadence/.gen/go/shared"
 	"github.com/uber/cadence/common"
+	"github.com/uber/cadence/common/cache"
 	"github.com/uber/cadence/common/log"
+	"github.com/uber/cadence/common/log/tag"
+	"github.com/uber/cadence/common/metrics"
 	p "github.com/uber/cadence/common/persistence"
 	"github.com/uber/cadence/common/persistence/sql/sqlplugin"
 	"github.com/uber/cadence/common/service/config"
 )
 
+const (
+	// recentInsertsCacheSize bounds the runs remembered by sqlVisibilityStore.recentInserts
+	recentInsertsCacheSize = 10000
+	// recentInsertsTTL bounds how long a run is remembered by sqlVisibilityStore.recentInserts, as the run may be
+	// deleted by another host meanwhile, e.g. by the retention of the domain
+	recentInsertsTTL = 10 * time.Second
+)
+
 type (
 	sqlVisibilityStore struct {
 		sqlStore
+		// recentInserts are the runs recently inserted by RecordWorkflowExecutionStarted keyed by recentInsertKey,
+		// so that a started record delivered again, e.g. under replication, doesn't cost a round trip for an
+		// insert which would do nothing. The database stays the source of truth, a run missing here is inserted.
+		// Only the deletes of this store forget a run right away, any other one is forgotten after recentInsertsTTL
+		recentInserts cache.Cache
+	}
+
+	recentInsertKey struct {
+		domainID string
+		runID    string
 	}
 
 	visibilityPageToken struct {
@@ -45,21 +66,36 @@ type (
 	}
 )
 
-// NewSQLVisibilityStore creates an instance of ExecutionStore
-func NewSQLVisibilityStore(cfg config.SQL, logger log.Logger) (p.VisibilityStore, error) {
+// NewSQLVisibilityStore creates an instance of ExecutionStore, metricsClient is optional
+// and used for emitting the latency of visibility queries by their kind
+func NewSQLVisibilityStore(cfg config.SQL, logger log.Logger, metricsClient metrics.Client) (p.VisibilityStore, error) {
 	db, err := NewSQLDB(&cfg)
 	if err != nil {
 		return nil, err
 	}
+	if metricsClient != nil {
+		db.SetQueryObserver(newVisibilityQueryObserver(metricsClient))
+		db.SetConnPoolObserver(newConnPoolObserver(metricsClient, metrics.PersistenceSQLVisibilityConnPoolScope))
+	}
+	db.SetQueryPlanObserver(newVisibilityQueryPlanObserver(logger))
+	return newSQLVisibilityStore(db, logger), nil
+}
+
+func newSQLVisibilityStore(db sqlplugin.DB, logger log.Logger) *sqlVisibilityStore {
 	return &sqlVisibilityStore{
 		sqlStore: sqlStore{
 			db:     db,
 			logger: logger,
 		},
-	}, nil
+		recentInserts: cache.New(recentInsertsCacheSize, &cache.Options{TTL: recentInsertsTTL}),
+	}
 }
 
 func (s *sqlVisibilityStore) RecordWorkflowExecutionStarted(request *p.InternalRecordWorkflowExecutionStartedRequest) error {
+	key := recentInsertKey{domainID: request.DomainUUID, runID: request.RunID}
+	if s.recentInserts.Get(key) != nil {
+		return nil
+	}
 	_, err := s.db.InsertIntoVisibility(&sqlplugin.VisibilityRow{
 		DomainID:         request.DomainUUID,
 		WorkflowID:       request.WorkflowID,
@@ -70,7 +106,9 @@ func (s *sqlVisibilityStore) RecordWorkflowExecutionStarted(request *p.InternalR
 		Memo:             request.Memo.Data,
 		Encoding:         string(request.Memo.GetEncoding()),
 	})
-
+	if err == nil {
+		s.recentInserts.Put(key, struct{}{})
+	}
 	return err
 }
 
@@ -241,6 +279,7 @@ func (s *sqlVisibilityStore) GetClosedWorkflowExecution(request *p.GetClosedWork
 }
 
 func (s *sqlVisibilityStore) DeleteWorkflowExecution(request *p.VisibilityDeleteWorkflowExecutionRequest) error {
+	s.recentInserts.Delete(recentInsertKey{domainID: request.DomainID, runID: request.RunID})
 	_, err := s.db.DeleteFromVisibility(&sqlplugin.VisibilityFilter{
 		DomainID: request.DomainID,
 		RunID:    &request.RunID,
@@ -263,6 +302,29 @@ func (s *sqlVisibilityStore) CountWorkflowExecutions(request *p.CountWorkflowExe
 	return nil, p.NewOperationNotSupportErrorForVis()
 }
 
+func newVisibilityQueryObserver(metricsClient metrics.Client) sqlplugin.QueryObserver {
+	return func(queryKind string, latency time.Duration, err error) {
+		scope := metricsClient.Scope(metrics.PersistenceSQLVisibilityQueryScope, metrics.QueryKindTag(queryKind))
+		scope.IncCounter(metrics.PersistenceRequests)
+		scope.RecordTimer(metrics.PersistenceLatency, latency)
+		if err != nil {
+			scope.IncCounter(metrics.PersistenceFailures)
+		}
+	}
+}
+
+func newVisibilityQueryPlanObserver(logger log.Logger) sqlplugin.QueryPlanObserver {
+	return func(queryKind string, latency time.Duration, plan string, err error) {
+		if err != nil {
+			logger.Warn("Failed to explain slow visibility query",
+				tag.VisibilityQueryKind(queryKind), tag.VisibilityQueryLatency(latency), tag.Error(err))
+			return
+		}
+		logger.Warn("Slow visibility query",
+			tag.VisibilityQueryKind(queryKind), tag.VisibilityQueryLatency(latency), tag.VisibilityQueryPlan(plan))
+	}
+}
+
 func (s *sqlVisibilityStore) rowToInfo(row *sqlplugin.VisibilityRow) *p.VisibilityWorkflowExecutionInfo {
 	if row.ExecutionTime.UnixNano() == 0 {
 		row.ExecutionTime = row.StartTime
@@ -0,0 +1,110 @@
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package sql
+
+import (
+	"database/sql"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/uber/cadence/common/cache"
+	"github.com/uber/cadence/common/log"
+	p "github.com/uber/cadence/common/persistence"
+	"github.com/uber/cadence/common/persistence/sql/sqlplugin"
+)
+
+// insertCountingDB counts the visibility inserts reaching the database
+type insertCountingDB struct {
+	sqlplugin.DB
+	inserts int64
+}
+
+func (db *insertCountingDB) InsertIntoVisibility(row *sqlplugin.VisibilityRow) (sql.Result, error) {
+	atomic.AddInt64(&db.inserts, 1)
+	return nil, nil
+}
+
+func (db *insertCountingDB) DeleteFromVisibility(filter *sqlplugin.VisibilityFilter) (sql.Result, error) {
+	return nil, nil
+}
+
+func newStartedRequest(runID string) *p.InternalRecordWorkflowExecutionStartedRequest {
+	return &p.InternalRecordWorkflowExecutionStartedRequest{
+		DomainUUID: "domain",
+		WorkflowID: "workflow",
+		RunID:      runID,
+		Memo:       &p.DataBlob{},
+	}
+}
+
+func TestRecordWorkflowExecutionStartedDeduplication(t *testing.T) {
+	db := &insertCountingDB{}
+	store := newSQLVisibilityStore(db, log.NewNoop())
+
+	require.NoError(t, store.RecordWorkflowExecutionStarted(newStartedRequest("run")))
+	require.NoError(t, store.RecordWorkflowExecutionStarted(newStartedRequest("run")))
+	require.Equal(t, int64(1), db.inserts)
+	require.NoError(t, store.RecordWorkflowExecutionStarted(newStartedRequest("another-run")))
+	require.Equal(t, int64(2), db.inserts)
+
+	// a deleted run is inserted again
+	require.NoError(t, store.DeleteWorkflowExecution(&p.VisibilityDeleteWorkflowExecutionRequest{
+		DomainID: "domain",
+		RunID:    "run",
+	}))
+	require.NoError(t, store.RecordWorkflowExecutionStarted(newStartedRequest("run")))
+	require.Equal(t, int64(3), db.inserts)
+}
+
+func TestRecordWorkflowExecutionStartedDeduplicationExpires(t *testing.T) {
+	db := &insertCountingDB{}
+	store := newSQLVisibilityStore(db, log.NewNoop())
+	store.recentInserts = cache.New(recentInsertsCacheSize, &cache.Options{TTL: time.Millisecond})
+
+	require.NoError(t, store.RecordWorkflowExecutionStarted(newStartedRequest("run")))
+	require.NoError(t, store.RecordWorkflowExecutionStarted(newStartedRequest("run")))
+	require.Equal(t, int64(1), db.inserts)
+
+	// the run may have been deleted by another host meanwhile, so it's inserted again once it expires
+	time.Sleep(5 * time.Millisecond)
+	require.NoError(t, store.RecordWorkflowExecutionStarted(newStartedRequest("run")))
+	require.Equal(t, int64(2), db.inserts)
+}
+
+// BenchmarkRecordWorkflowExecutionStartedDuplicates records every started workflow 4 times, as under a replication
+// delivering the same events again, only a quarter of the records reach the database
+func BenchmarkRecordWorkflowExecutionStartedDuplicates(b *testing.B) {
+	db := &insertCountingDB{}
+	store := newSQLVisibilityStore(db, log.NewNoop())
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := store.RecordWorkflowExecutionStarted(newStartedRequest(fmt.Sprintf("run-%v", i/4))); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.StopTimer()
+	b.Logf("%v of %v records inserted", db.inserts, b.N)
+}
@@ -0,0 +1,63 @@
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package sqlplugin
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+type (
+	// visibilityCursor is the keyset of the last row of a page of the keyset paginated visibility queries,
+	// it's only exposed as an opaque token so that callers don't depend on the ordering columns
+	visibilityCursor struct {
+		StartTime int64  `json:"startTime"`
+		RunID     string `json:"runID"`
+	}
+)
+
+// NextVisibilityCursor returns the cursor to read the page after rows with VisibilityFilter.Cursor,
+// it's empty if rows is empty as there is no next page
+func NextVisibilityCursor(rows []VisibilityRow) string {
+	if len(rows) == 0 {
+		return ""
+	}
+	last := rows[len(rows)-1]
+	data, _ := json.Marshal(visibilityCursor{
+		StartTime: last.StartTime.UnixNano(),
+		RunID:     last.RunID,
+	})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// DecodeVisibilityCursor returns the start time and run ID of the last row of the previous page
+func DecodeVisibilityCursor(cursor string) (time.Time, string, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, "", fmt.Errorf("invalid visibility cursor: %v", err)
+	}
+	var c visibilityCursor
+	if err := json.Unmarshal(data, &c); err != nil {
+		return time.Time{}, "", fmt.Errorf("invalid visibility cursor: %v", err)
+	}
+	return time.Unix(0, c.StartTime), c.RunID, nil
+}
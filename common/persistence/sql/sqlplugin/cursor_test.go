@@ -0,0 +1,52 @@
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package sqlplugin
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestVisibilityCursorRoundTrip(t *testing.T) {
+	startTime := time.Now()
+	cursor := NextVisibilityCursor([]VisibilityRow{
+		{RunID: "run-1", StartTime: startTime.Add(-time.Hour)},
+		{RunID: "run-2", StartTime: startTime},
+	})
+	require.NotEmpty(t, cursor)
+
+	lastStartTime, lastRunID, err := DecodeVisibilityCursor(cursor)
+	require.NoError(t, err)
+	require.True(t, startTime.Equal(lastStartTime))
+	require.Equal(t, "run-2", lastRunID)
+}
+
+func TestVisibilityCursorOfEmptyPage(t *testing.T) {
+	require.Empty(t, NextVisibilityCursor(nil))
+}
+
+func TestDecodeInvalidVisibilityCursor(t *testing.T) {
+	_, _, err := DecodeVisibilityCursor("not a cursor")
+	require.Error(t, err)
+	_, _, err = DecodeVisibilityCursor("bm90IGpzb24")
+	require.Error(t, err)
+}
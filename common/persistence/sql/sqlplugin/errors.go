@@ -0,0 +1,51 @@
+// Copyright (c) 2019 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package sqlplugin
+
+import (
+	"fmt"
+	"time"
+)
+
+type (
+	// ShardLockTimeoutError is returned when the write lock on a shard row
+	// can't be acquired within the configured timeout. It's safe to retry
+	ShardLockTimeoutError struct {
+		ShardID int64
+		Timeout time.Duration
+	}
+
+	// ShardOwnershipLostError is returned when a shard row is not updated as its range_id no longer
+	// matches the expected one, i.e. the shard is acquired by another host. It must not be retried
+	ShardOwnershipLostError struct {
+		ShardID         int64
+		ExpectedRangeID int64
+		ActualRangeID   int64
+	}
+)
+
+func (e *ShardLockTimeoutError) Error() string {
+	return fmt.Sprintf("timed out acquiring lock on shard %v after %v", e.ShardID, e.Timeout)
+}
+
+func (e *ShardOwnershipLostError) Error() string {
+	return fmt.Sprintf("ownership of shard %v is lost, expected range_id %v but is %v", e.ShardID, e.ExpectedRangeID, e.ActualRangeID)
+}
@@ -21,6 +21,7 @@
 package sqlplugin
 
 import (
+	"context"
 	"database/sql"
 	"time"
 
@@ -478,7 +479,31 @@ type (
 		CloseStatus      *int32
 		MinStartTime     *time.Time
 		MaxStartTime     *time.Time
-		PageSize         *int
+		// MaxOpenAge selects the open workflows started longer than MaxOpenAge ago, oldest first
+		MaxOpenAge *time.Duration
+		// Cursor is the NextVisibilityCursor of the previous page of MaxOpenAge, empty for the first page
+		Cursor   string
+		PageSize *int
+		// ColumnProjection is the set of columns read by the list queries, the lookups by RunID always read all
+		ColumnProjection VisibilityColumnProjection
+		// ReadFromPrimary reads from the primary rather than from the read replica, so that a page doesn't miss the
+		// workflows closed just before it because of the replication lag, e.g. for a batch operation mutating the
+		// workflows it lists. Every page is still a separate query, the pages don't share a snapshot. Only
+		// supported by postgres, ignored by the others
+		ReadFromPrimary bool
+		// WorkflowTypeCaseInsensitive matches WorkflowTypeName regardless of its case, for the workflow types
+		// registered with inconsistent casing. The match can't use the index on workflow_type_name unless the
+		// database has a functional index on LOWER(workflow_type_name). Only supported by postgres, ignored by the others
+		WorkflowTypeCaseInsensitive bool
+	}
+
+	// VisibilityColumnProjection is the set of columns of executions_visibility that SelectFromVisibility reads
+	VisibilityColumnProjection int
+
+	// BucketCount is the number of workflows started within the time bucket beginning at BucketStart
+	BucketCount struct {
+		BucketStart time.Time
+		Count       int64
 	}
 
 	// QueueRow represents a row in queue table
@@ -510,7 +535,10 @@ type (
 		SelectFromDomainMetadata() (*DomainMetadataRow, error)
 
 		InsertIntoShards(rows *ShardsRow) (sql.Result, error)
-		UpdateShards(row *ShardsRow) (sql.Result, error)
+		// UpdateShards updates the shard row only if its current range_id matches the given rangeID,
+		// zero rows affected means the shard ownership is lost. The postgres plugin returns
+		// *ShardOwnershipLostError instead of zero rows affected
+		UpdateShards(row *ShardsRow, rangeID int64) (sql.Result, error)
 		SelectFromShards(filter *ShardsFilter) (*ShardsRow, error)
 		ReadLockShards(filter *ShardsFilter) (int, error)
 		WriteLockShards(filter *ShardsFilter) (int, error)
@@ -676,13 +704,33 @@ type (
 		// SelectFromVisibility returns one or more rows from visibility table
 		// Required filter params:
 		// - getClosedWorkflowExecution - retrieves single row - {domainID, runID, closed=true}
+		// - getWorkflowExecution - retrieves single row whether it's open or closed - {domainID, runID, closed=false}
+		//   sql.ErrNoRows is returned by both if the row doesn't exist
 		// - All other queries retrieve multiple rows (range):
 		//   - MUST specify following required params:
 		//     - domainID, minStartTime, maxStartTime, runID and pageSize where some or all of these may come from previous page token
 		//   - OPTIONALLY specify one of following params
 		//     - workflowID, workflowTypeName, closeStatus (along with closed=true)
+		//     - or both of workflowTypeName and closeStatus (along with closed=true)
+		// - getOpenWorkflowExecutionsOlderThan - {domainID, maxOpenAge, pageSize}, the rows are ordered by
+		//   start time and then runID, the next page is read with the cursor from NextVisibilityCursor
 		SelectFromVisibility(filter *VisibilityFilter) ([]VisibilityRow, error)
 		DeleteFromVisibility(filter *VisibilityFilter) (sql.Result, error)
+		// DeleteOldRunsFromVisibility deletes all runs of the workflowID except the newest keep ones by start time,
+		// returns the number of deleted rows
+		DeleteOldRunsFromVisibility(domainID, workflowID string, keep int) (int64, error)
+		// BackfillExecutionTime sets execution_time to start_time for the rows of the domain written before
+		// execution_time existed, batchSize rows are updated per statement, returns the number of updated rows
+		BackfillExecutionTime(domainID string, batchSize int) (int64, error)
+		// CountByCloseStatusFromVisibility returns the number of closed workflows grouped by close status
+		// Required filter params - {domainID, minStartTime, maxStartTime}
+		CountByCloseStatusFromVisibility(filter *VisibilityFilter) (map[int32]int64, error)
+		// CountStartedByBucketFromVisibility returns the number of workflows started within each bucket of
+		// [min, max), the buckets begin at min and buckets without any started workflow are omitted
+		CountStartedByBucketFromVisibility(domainID string, min, max time.Time, bucket time.Duration) ([]BucketCount, error)
+		// ListWorkflowTypesFromVisibility returns up to limit distinct workflow types of the domain, the most
+		// frequent ones first and then alphabetically
+		ListWorkflowTypesFromVisibility(domainID string, limit int) ([]string, error)
 
 		InsertIntoQueue(row *QueueRow) (sql.Result, error)
 		GetLastEnqueuedMessageIDForUpdate(queueType common.QueueType) (int, error)
@@ -721,9 +769,29 @@ type (
 		BeginTx() (Tx, error)
 		PluginName() string
 		IsDupEntryError(err error) bool
+		// PingContext verifies the connection to the database is alive, establishing one if necessary
+		PingContext(ctx context.Context) error
+		// SetQueryObserver sets the observer notified of every visibility query, must be called before any query
+		SetQueryObserver(observer QueryObserver)
+		// SetQueryPlanObserver sets the observer notified of the plans of slow visibility queries, it only takes
+		// effect if the plugin is configured to explain slow queries. Must be called before any query
+		SetQueryPlanObserver(observer QueryPlanObserver)
+		// SetConnPoolObserver sets the observer periodically notified of the stats of the connection pool until
+		// the DB is closed, it only takes effect if the plugin reports the stats. Must be called at most once
+		SetConnPoolObserver(observer ConnPoolObserver)
 		Close() error
 	}
 
+	// QueryObserver is notified of the kind, latency and error of a query, e.g. to emit metrics
+	QueryObserver func(queryKind string, latency time.Duration, err error)
+
+	// QueryPlanObserver is notified of the plan of a slow query along with its kind and latency,
+	// err is set if the plan couldn't be retrieved
+	QueryPlanObserver func(queryKind string, latency time.Duration, plan string, err error)
+
+	// ConnPoolObserver is notified of the stats of a connection pool, e.g. to emit them as gauges
+	ConnPoolObserver func(stats sql.DBStats)
+
 	// AdminDB defines the API for admin SQL operations for CLI and testing suites
 	AdminDB interface {
 		adminCRUD
@@ -738,3 +806,28 @@ type (
 		Select(dest interface{}, query string, args ...interface{}) error
 	}
 )
+
+const (
+	// VisibilityColumnsAll reads all the columns into VisibilityRow
+	VisibilityColumnsAll VisibilityColumnProjection = iota
+	// VisibilityColumnsMinimal only reads WorkflowID, RunID, StartTime and CloseStatus into VisibilityRow,
+	// leaving out memo for the listings that don't need it
+	VisibilityColumnsMinimal
+)
+
+// Kinds of visibility queries reported to QueryObserver
+const (
+	VisibilityQueryKindInsert                = "insert"
+	VisibilityQueryKindReplace               = "replace"
+	VisibilityQueryKindClosedByRunID         = "closed_by_run_id"
+	VisibilityQueryKindByRunID               = "by_run_id"
+	VisibilityQueryKindOpenByWorkflowID      = "open_by_workflow_id"
+	VisibilityQueryKindClosedByWorkflowID    = "closed_by_workflow_id"
+	VisibilityQueryKindClosedByTypeAndStatus = "closed_by_type_and_status"
+	VisibilityQueryKindOpenByType            = "open_by_type"
+	VisibilityQueryKindClosedByType          = "closed_by_type"
+	VisibilityQueryKindClosedByStatus        = "closed_by_status"
+	VisibilityQueryKindOpen                  = "open"
+	VisibilityQueryKindOpenOlderThan         = "open_older_than"
+	VisibilityQueryKindClosed                = "closed"
+)
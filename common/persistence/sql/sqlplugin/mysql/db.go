@@ -21,6 +21,9 @@
 package mysql
 
 import (
+	"context"
+	"time"
+
 	"github.com/go-sql-driver/mysql"
 	"github.com/jmoiron/sqlx"
 
@@ -33,6 +36,8 @@ type db struct {
 	tx        *sqlx.Tx
 	conn      sqlplugin.Conn
 	converter DataConverter
+	// queryObserver is notified of visibility queries, nil means no observer
+	queryObserver sqlplugin.QueryObserver
 }
 
 var _ sqlplugin.AdminDB = (*db)(nil)
@@ -67,7 +72,9 @@ func (mdb *db) BeginTx() (sqlplugin.Tx, error) {
 	if err != nil {
 		return nil, err
 	}
-	return NewDB(mdb.db, xtx), nil
+	tx := NewDB(mdb.db, xtx)
+	tx.queryObserver = mdb.queryObserver
+	return tx, nil
 }
 
 // Commit commits a previously started transaction
@@ -80,6 +87,30 @@ func (mdb *db) Rollback() error {
 	return mdb.tx.Rollback()
 }
 
+// PingContext verifies the connection to the database is alive, establishing one if necessary
+func (mdb *db) PingContext(ctx context.Context) error {
+	return mdb.db.PingContext(ctx)
+}
+
+// SetQueryObserver sets the observer notified of every visibility query
+func (mdb *db) SetQueryObserver(observer sqlplugin.QueryObserver) {
+	mdb.queryObserver = observer
+}
+
+// SetQueryPlanObserver is a no-op as mysql plugin doesn't explain slow queries
+func (mdb *db) SetQueryPlanObserver(observer sqlplugin.QueryPlanObserver) {
+}
+
+// SetConnPoolObserver is a no-op as mysql plugin doesn't report connection pool stats
+func (mdb *db) SetConnPoolObserver(observer sqlplugin.ConnPoolObserver) {
+}
+
+func (mdb *db) observeQuery(queryKind string, startTime time.Time, err error) {
+	if mdb.queryObserver != nil {
+		mdb.queryObserver(queryKind, time.Since(startTime), err)
+	}
+}
+
 // Close closes the connection to the mysql db
 func (mdb *db) Close() error {
 	return mdb.db.Close()
@@ -36,7 +36,7 @@ const (
 
 	updateShardQry = `UPDATE shards 
  SET range_id = ?, data = ?, data_encoding = ? 
- WHERE shard_id = ?`
+ WHERE shard_id = ? AND range_id = ?`
 
 	lockShardQry     = `SELECT range_id FROM shards WHERE shard_id = ? FOR UPDATE`
 	readLockShardQry = `SELECT range_id FROM shards WHERE shard_id = ? LOCK IN SHARE MODE`
@@ -47,9 +47,9 @@ func (mdb *db) InsertIntoShards(row *sqlplugin.ShardsRow) (sql.Result, error) {
 	return mdb.conn.Exec(createShardQry, row.ShardID, row.RangeID, row.Data, row.DataEncoding)
 }
 
-// UpdateShards updates one or more rows into shards table
-func (mdb *db) UpdateShards(row *sqlplugin.ShardsRow) (sql.Result, error) {
-	return mdb.conn.Exec(updateShardQry, row.RangeID, row.Data, row.DataEncoding, row.ShardID)
+// UpdateShards updates one or more rows into shards table if the current range_id matches the given rangeID
+func (mdb *db) UpdateShards(row *sqlplugin.ShardsRow, rangeID int64) (sql.Result, error) {
+	return mdb.conn.Exec(updateShardQry, row.RangeID, row.Data, row.DataEncoding, row.ShardID, rangeID)
 }
 
 // SelectFromShards reads one or more rows from shards table
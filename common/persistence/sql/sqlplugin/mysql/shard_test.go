@@ -0,0 +1,115 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package mysql
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+
+	"github.com/uber/cadence/common"
+	pt "github.com/uber/cadence/common/persistence/persistence-tests"
+	"github.com/uber/cadence/common/persistence/sql"
+	"github.com/uber/cadence/common/persistence/sql/sqlplugin"
+)
+
+type (
+	shardSuite struct {
+		pt.TestBase
+		// override suite.Suite.Assertions with require.Assertions; this means that s.NotNil(nil) will stop the test,
+		// not merely log an error
+		*require.Assertions
+		db sqlplugin.DB
+	}
+)
+
+func TestShardSuite(t *testing.T) {
+	s := new(shardSuite)
+	s.TestBase = pt.NewTestBaseWithSQL(GetTestClusterOption())
+	s.TestBase.Setup()
+	suite.Run(t, s)
+}
+
+// SetupSuite implementation
+func (s *shardSuite) SetupSuite() {
+	cfg := s.DefaultTestCluster.Config()
+	db, err := sql.NewSQLDB(cfg.DataStores[cfg.DefaultStore].SQL)
+	s.Require().NoError(err)
+	s.db = db
+}
+
+// SetupTest implementation
+func (s *shardSuite) SetupTest() {
+	s.Assertions = require.New(s.T())
+}
+
+// TearDownSuite implementation
+func (s *shardSuite) TearDownSuite() {
+	s.NoError(s.db.Close())
+	s.TearDownWorkflowStore()
+}
+
+func (s *shardSuite) TestUpdateShardsStaleRangeID() {
+	shardID := int64(1001)
+	_, err := s.db.InsertIntoShards(&sqlplugin.ShardsRow{
+		ShardID:      shardID,
+		RangeID:      1,
+		Data:         []byte("owner-a"),
+		DataEncoding: string(common.EncodingTypeThriftRW),
+	})
+	s.NoError(err)
+
+	row, err := s.db.SelectFromShards(&sqlplugin.ShardsFilter{ShardID: shardID})
+	s.NoError(err)
+	s.Equal(int64(1), row.RangeID)
+	s.Equal([]byte("owner-a"), row.Data)
+
+	// writer b steals the shard by bumping the range_id
+	result, err := s.db.UpdateShards(&sqlplugin.ShardsRow{
+		ShardID:      shardID,
+		RangeID:      2,
+		Data:         []byte("owner-b"),
+		DataEncoding: string(common.EncodingTypeThriftRW),
+	}, 1)
+	s.NoError(err)
+	rowsAffected, err := result.RowsAffected()
+	s.NoError(err)
+	s.Equal(int64(1), rowsAffected)
+
+	// writer a still thinks it owns the shard with range_id 1
+	result, err = s.db.UpdateShards(&sqlplugin.ShardsRow{
+		ShardID:      shardID,
+		RangeID:      1,
+		Data:         []byte("owner-a-stale"),
+		DataEncoding: string(common.EncodingTypeThriftRW),
+	}, 1)
+	s.NoError(err)
+	rowsAffected, err = result.RowsAffected()
+	s.NoError(err)
+	s.Equal(int64(0), rowsAffected)
+
+	// the shard is read regardless of its range_id
+	row, err = s.db.SelectFromShards(&sqlplugin.ShardsFilter{ShardID: shardID})
+	s.NoError(err)
+	s.Equal(int64(2), row.RangeID)
+	s.Equal([]byte("owner-b"), row.Data)
+}
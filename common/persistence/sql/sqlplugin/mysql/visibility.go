@@ -24,6 +24,7 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/uber/cadence/common/persistence/sql/sqlplugin"
 )
@@ -33,9 +34,12 @@ const (
 		`domain_id, workflow_id, run_id, start_time, execution_time, workflow_type_name, memo, encoding) ` +
 		`VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
 
-	templateCreateWorkflowExecutionClosed = `REPLACE INTO executions_visibility (` +
+	// only the close fields are updated for an existing row, unlike REPLACE this keeps the rest e.g. memo upserted after start.
+	// a plain UPDATE with fallback doesn't work since mysql reports no affected rows for an UPDATE not changing anything
+	templateCreateWorkflowExecutionClosed = `INSERT INTO executions_visibility (` +
 		`domain_id, workflow_id, run_id, start_time, execution_time, workflow_type_name, close_time, close_status, history_length, memo, encoding) ` +
-		`VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+		`VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) ` +
+		`ON DUPLICATE KEY UPDATE close_time = VALUES(close_time), close_status = VALUES(close_status), history_length = VALUES(history_length)`
 
 	// RunID condition is needed for correct pagination
 	templateConditions = ` AND domain_id = ?
@@ -48,13 +52,22 @@ const (
 	templateOpenFieldNames = `workflow_id, run_id, start_time, execution_time, workflow_type_name, memo, encoding`
 	templateOpenSelect     = `SELECT ` + templateOpenFieldNames + ` FROM executions_visibility WHERE close_status IS NULL `
 
-	templateClosedSelect = `SELECT ` + templateOpenFieldNames + `, close_time, close_status, history_length
-		 FROM executions_visibility WHERE close_status IS NOT NULL `
+	// every template reading closed rows selects templateClosedFieldNames, so that they can't drift apart
+	templateClosedFieldNames = templateOpenFieldNames + `, close_time, close_status, history_length`
+	templateClosedSelect     = `SELECT ` + templateClosedFieldNames + ` FROM executions_visibility WHERE close_status IS NOT NULL `
 
 	templateGetOpenWorkflowExecutions = templateOpenSelect + templateConditions
 
 	templateGetClosedWorkflowExecutions = templateClosedSelect + templateConditions
 
+	templateOlderThanConditions = `AND domain_id = ?
+		 AND start_time < ?
+		 AND (start_time, run_id) > (?, ?)
+		 ORDER BY start_time, run_id
+		 LIMIT ?`
+
+	templateGetOpenWorkflowExecutionsOlderThan = templateOpenSelect + templateOlderThanConditions
+
 	templateGetOpenWorkflowExecutionsByType = templateOpenSelect + `AND workflow_type_name = ?` + templateConditions
 
 	templateGetClosedWorkflowExecutionsByType = templateClosedSelect + `AND workflow_type_name = ?` + templateConditions
@@ -65,21 +78,104 @@ const (
 
 	templateGetClosedWorkflowExecutionsByStatus = templateClosedSelect + `AND close_status = ?` + templateConditions
 
-	templateGetClosedWorkflowExecution = `SELECT workflow_id, run_id, start_time, execution_time, memo, encoding, close_time, workflow_type_name, close_status, history_length 
+	templateGetClosedWorkflowExecutionsByTypeAndStatus = templateClosedSelect + `AND workflow_type_name = ? AND close_status = ?` + templateConditions
+
+	// the list templates of VisibilityColumnsMinimal select the same rows with fewer columns, see minimalTemplates
+	templateMinimalFieldNames   = `workflow_id, run_id, start_time, close_status`
+	templateMinimalOpenSelect   = `SELECT ` + templateMinimalFieldNames + ` FROM executions_visibility WHERE close_status IS NULL `
+	templateMinimalClosedSelect = `SELECT ` + templateMinimalFieldNames + ` FROM executions_visibility WHERE close_status IS NOT NULL `
+
+	templateGetWorkflowExecution = `SELECT ` + templateClosedFieldNames + `
+		 FROM executions_visibility
+		 WHERE domain_id = ? AND run_id = ?`
+
+	templateGetClosedWorkflowExecution = `SELECT ` + templateClosedFieldNames + `
 		 FROM executions_visibility
 		 WHERE domain_id = ? AND close_status IS NOT NULL
 		 AND run_id = ?`
 
+	templateCountClosedWorkflowExecutionsByStatus = `SELECT close_status, COUNT(*) AS count
+		 FROM executions_visibility
+		 WHERE domain_id = ? AND close_status IS NOT NULL
+		 AND start_time >= ?
+		 AND start_time <= ?
+		 GROUP BY close_status`
+
+	// buckets are numbered from 0, min is the lower bound of the first bucket
+	templateCountStartedWorkflowExecutionsByBucket = `SELECT FLOOR(TIMESTAMPDIFF(MICROSECOND, ?, start_time) / ?) AS bucket, COUNT(*) AS count
+		 FROM executions_visibility
+		 WHERE domain_id = ?
+		 AND start_time >= ?
+		 AND start_time < ?
+		 GROUP BY bucket
+		 ORDER BY bucket`
+
+	// grouping returns the same rows as DISTINCT and counts them along the way, so ordering by frequency is cheap
+	templateListWorkflowTypes = `SELECT workflow_type_name
+		 FROM executions_visibility
+		 WHERE domain_id = ?
+		 GROUP BY workflow_type_name
+		 ORDER BY COUNT(*) DESC, workflow_type_name
+		 LIMIT ?`
+
 	templateDeleteWorkflowExecution = "DELETE FROM executions_visibility WHERE domain_id=? AND run_id=?"
+
+	// mysql neither allows LIMIT in an IN subquery nor selecting from the table being deleted from,
+	// so join against a derived table instead; the huge LIMIT is required for OFFSET
+	templateDeleteOldRunsOfWorkflowExecution = `DELETE v FROM executions_visibility v JOIN (
+		SELECT run_id FROM executions_visibility WHERE domain_id = ? AND workflow_id = ?
+		ORDER BY start_time DESC, run_id LIMIT 18446744073709551615 OFFSET ?) old ON v.run_id = old.run_id
+		WHERE v.domain_id = ?`
+
+	// rows written before execution_time existed have it at either the zero time or the epoch
+	templateBackfillExecutionTime = `UPDATE executions_visibility SET execution_time = start_time
+		WHERE domain_id = ? AND (execution_time IS NULL OR execution_time <= ?) LIMIT ?`
 )
 
 var errCloseParams = errors.New("missing one of {closeStatus, closeTime, historyLength} params")
 
+// minimalTemplates maps the list templates to their VisibilityColumnsMinimal counterparts
+var minimalTemplates = map[string]string{
+	templateGetOpenWorkflowExecutions:                  templateMinimalOpenSelect + templateConditions,
+	templateGetClosedWorkflowExecutions:                templateMinimalClosedSelect + templateConditions,
+	templateGetOpenWorkflowExecutionsByType:            templateMinimalOpenSelect + `AND workflow_type_name = ?` + templateConditions,
+	templateGetClosedWorkflowExecutionsByType:          templateMinimalClosedSelect + `AND workflow_type_name = ?` + templateConditions,
+	templateGetOpenWorkflowExecutionsByID:              templateMinimalOpenSelect + `AND workflow_id = ?` + templateConditions,
+	templateGetClosedWorkflowExecutionsByID:            templateMinimalClosedSelect + `AND workflow_id = ?` + templateConditions,
+	templateGetClosedWorkflowExecutionsByStatus:        templateMinimalClosedSelect + `AND close_status = ?` + templateConditions,
+	templateGetClosedWorkflowExecutionsByTypeAndStatus: templateMinimalClosedSelect + `AND workflow_type_name = ? AND close_status = ?` + templateConditions,
+	templateGetOpenWorkflowExecutionsOlderThan:         templateMinimalOpenSelect + templateOlderThanConditions,
+}
+
+// projectQuery returns the VisibilityColumnsMinimal counterpart of the list template if the filter asks for it
+func projectQuery(filter *sqlplugin.VisibilityFilter, query string) string {
+	if minimalQuery, ok := minimalTemplates[query]; ok && filter.ColumnProjection == sqlplugin.VisibilityColumnsMinimal {
+		return minimalQuery
+	}
+	return query
+}
+
+type closeStatusCount struct {
+	CloseStatus int32
+	Count       int64
+}
+
+type bucketCount struct {
+	Bucket int64
+	Count  int64
+}
+
 // InsertIntoVisibility inserts a row into visibility table. If an row already exist,
 // its left as such and no update will be made
 func (mdb *db) InsertIntoVisibility(row *sqlplugin.VisibilityRow) (sql.Result, error) {
+	startTime := time.Now()
 	row.StartTime = mdb.converter.ToMySQLDateTime(row.StartTime)
-	return mdb.conn.Exec(templateCreateWorkflowExecutionStarted,
+	// non-delayed workflows may come without execution time, which should be same as start time
+	if row.ExecutionTime.IsZero() || row.ExecutionTime.UnixNano() == 0 {
+		row.ExecutionTime = row.StartTime
+	}
+	row.ExecutionTime = mdb.converter.ToMySQLDateTime(row.ExecutionTime)
+	result, err := mdb.conn.Exec(templateCreateWorkflowExecutionStarted,
 		row.DomainID,
 		row.WorkflowID,
 		row.RunID,
@@ -88,15 +184,19 @@ func (mdb *db) InsertIntoVisibility(row *sqlplugin.VisibilityRow) (sql.Result, e
 		row.WorkflowTypeName,
 		row.Memo,
 		row.Encoding)
+	mdb.observeQuery(sqlplugin.VisibilityQueryKindInsert, startTime, err)
+	return result, err
 }
 
-// ReplaceIntoVisibility replaces an existing row if it exist or creates a new row in visibility table
+// ReplaceIntoVisibility replaces an existing row if it exist or creates a new row in visibility table.
+// For an existing row only the close fields are updated, so the rest e.g. memo upserted after start are kept
 func (mdb *db) ReplaceIntoVisibility(row *sqlplugin.VisibilityRow) (sql.Result, error) {
 	switch {
 	case row.CloseStatus != nil && row.CloseTime != nil && row.HistoryLength != nil:
+		startTime := time.Now()
 		row.StartTime = mdb.converter.ToMySQLDateTime(row.StartTime)
 		closeTime := mdb.converter.ToMySQLDateTime(*row.CloseTime)
-		return mdb.conn.Exec(templateCreateWorkflowExecutionClosed,
+		result, err := mdb.conn.Exec(templateCreateWorkflowExecutionClosed,
 			row.DomainID,
 			row.WorkflowID,
 			row.RunID,
@@ -108,6 +208,8 @@ func (mdb *db) ReplaceIntoVisibility(row *sqlplugin.VisibilityRow) (sql.Result,
 			*row.HistoryLength,
 			row.Memo,
 			row.Encoding)
+		mdb.observeQuery(sqlplugin.VisibilityQueryKindReplace, startTime, err)
+		return result, err
 	default:
 		return nil, errCloseParams
 	}
@@ -118,6 +220,40 @@ func (mdb *db) DeleteFromVisibility(filter *sqlplugin.VisibilityFilter) (sql.Res
 	return mdb.conn.Exec(templateDeleteWorkflowExecution, filter.DomainID, filter.RunID)
 }
 
+// DeleteOldRunsFromVisibility deletes all runs of the workflowID except the newest keep ones
+func (mdb *db) DeleteOldRunsFromVisibility(domainID, workflowID string, keep int) (int64, error) {
+	if keep < 0 {
+		return 0, fmt.Errorf("invalid number of runs to keep: %v", keep)
+	}
+	result, err := mdb.conn.Exec(templateDeleteOldRunsOfWorkflowExecution, domainID, workflowID, keep, domainID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// BackfillExecutionTime sets execution_time to start_time for the rows missing execution_time in batches
+func (mdb *db) BackfillExecutionTime(domainID string, batchSize int) (int64, error) {
+	if batchSize <= 0 {
+		return 0, fmt.Errorf("invalid batch size: %v", batchSize)
+	}
+	var total int64
+	for {
+		result, err := mdb.conn.Exec(templateBackfillExecutionTime, domainID, mdb.converter.ToMySQLDateTime(time.Unix(0, 0)), batchSize)
+		if err != nil {
+			return total, err
+		}
+		updated, err := result.RowsAffected()
+		if err != nil {
+			return total, err
+		}
+		total += updated
+		if updated < int64(batchSize) {
+			return total, nil
+		}
+	}
+}
+
 // SelectFromVisibility reads one or more rows from visibility table
 func (mdb *db) SelectFromVisibility(filter *sqlplugin.VisibilityFilter) ([]sqlplugin.VisibilityRow, error) {
 	var err error
@@ -128,20 +264,47 @@ func (mdb *db) SelectFromVisibility(filter *sqlplugin.VisibilityFilter) ([]sqlpl
 	if filter.MaxStartTime != nil {
 		*filter.MaxStartTime = mdb.converter.ToMySQLDateTime(*filter.MaxStartTime)
 	}
+	var queryKind string
+	startTime := time.Now()
 	switch {
+	case filter.MaxOpenAge != nil:
+		queryKind = sqlplugin.VisibilityQueryKindOpenOlderThan
+		lastStartTime, lastRunID := time.Unix(0, 0), ""
+		if filter.Cursor != "" {
+			if lastStartTime, lastRunID, err = sqlplugin.DecodeVisibilityCursor(filter.Cursor); err != nil {
+				return nil, err
+			}
+		}
+		err = mdb.conn.Select(&rows,
+			projectQuery(filter, templateGetOpenWorkflowExecutionsOlderThan),
+			filter.DomainID,
+			mdb.converter.ToMySQLDateTime(time.Now().Add(-*filter.MaxOpenAge)),
+			mdb.converter.ToMySQLDateTime(lastStartTime),
+			lastRunID,
+			*filter.PageSize)
 	case filter.MinStartTime == nil && filter.RunID != nil && filter.Closed:
+		queryKind = sqlplugin.VisibilityQueryKindClosedByRunID
 		var row sqlplugin.VisibilityRow
 		err = mdb.conn.Get(&row, templateGetClosedWorkflowExecution, filter.DomainID, *filter.RunID)
 		if err == nil {
 			rows = append(rows, row)
 		}
+	case filter.MinStartTime == nil && filter.RunID != nil:
+		queryKind = sqlplugin.VisibilityQueryKindByRunID
+		var row sqlplugin.VisibilityRow
+		err = mdb.conn.Get(&row, templateGetWorkflowExecution, filter.DomainID, *filter.RunID)
+		if err == nil {
+			rows = append(rows, row)
+		}
 	case filter.MinStartTime != nil && filter.WorkflowID != nil:
 		qry := templateGetOpenWorkflowExecutionsByID
+		queryKind = sqlplugin.VisibilityQueryKindOpenByWorkflowID
 		if filter.Closed {
 			qry = templateGetClosedWorkflowExecutionsByID
+			queryKind = sqlplugin.VisibilityQueryKindClosedByWorkflowID
 		}
 		err = mdb.conn.Select(&rows,
-			qry,
+			projectQuery(filter, qry),
 			*filter.WorkflowID,
 			filter.DomainID,
 			mdb.converter.ToMySQLDateTime(*filter.MinStartTime),
@@ -149,13 +312,27 @@ func (mdb *db) SelectFromVisibility(filter *sqlplugin.VisibilityFilter) ([]sqlpl
 			*filter.RunID,
 			*filter.MinStartTime,
 			*filter.PageSize)
+	case filter.MinStartTime != nil && filter.WorkflowTypeName != nil && filter.CloseStatus != nil:
+		queryKind = sqlplugin.VisibilityQueryKindClosedByTypeAndStatus
+		err = mdb.conn.Select(&rows,
+			projectQuery(filter, templateGetClosedWorkflowExecutionsByTypeAndStatus),
+			*filter.WorkflowTypeName,
+			*filter.CloseStatus,
+			filter.DomainID,
+			mdb.converter.ToMySQLDateTime(*filter.MinStartTime),
+			mdb.converter.ToMySQLDateTime(*filter.MaxStartTime),
+			*filter.RunID,
+			mdb.converter.ToMySQLDateTime(*filter.MaxStartTime),
+			*filter.PageSize)
 	case filter.MinStartTime != nil && filter.WorkflowTypeName != nil:
 		qry := templateGetOpenWorkflowExecutionsByType
+		queryKind = sqlplugin.VisibilityQueryKindOpenByType
 		if filter.Closed {
 			qry = templateGetClosedWorkflowExecutionsByType
+			queryKind = sqlplugin.VisibilityQueryKindClosedByType
 		}
 		err = mdb.conn.Select(&rows,
-			qry,
+			projectQuery(filter, qry),
 			*filter.WorkflowTypeName,
 			filter.DomainID,
 			mdb.converter.ToMySQLDateTime(*filter.MinStartTime),
@@ -164,8 +341,9 @@ func (mdb *db) SelectFromVisibility(filter *sqlplugin.VisibilityFilter) ([]sqlpl
 			*filter.MaxStartTime,
 			*filter.PageSize)
 	case filter.MinStartTime != nil && filter.CloseStatus != nil:
+		queryKind = sqlplugin.VisibilityQueryKindClosedByStatus
 		err = mdb.conn.Select(&rows,
-			templateGetClosedWorkflowExecutionsByStatus,
+			projectQuery(filter, templateGetClosedWorkflowExecutionsByStatus),
 			*filter.CloseStatus,
 			filter.DomainID,
 			mdb.converter.ToMySQLDateTime(*filter.MinStartTime),
@@ -175,11 +353,13 @@ func (mdb *db) SelectFromVisibility(filter *sqlplugin.VisibilityFilter) ([]sqlpl
 			*filter.PageSize)
 	case filter.MinStartTime != nil:
 		qry := templateGetOpenWorkflowExecutions
+		queryKind = sqlplugin.VisibilityQueryKindOpen
 		if filter.Closed {
 			qry = templateGetClosedWorkflowExecutions
+			queryKind = sqlplugin.VisibilityQueryKindClosed
 		}
 		err = mdb.conn.Select(&rows,
-			qry,
+			projectQuery(filter, qry),
 			filter.DomainID,
 			mdb.converter.ToMySQLDateTime(*filter.MinStartTime),
 			mdb.converter.ToMySQLDateTime(*filter.MaxStartTime),
@@ -189,6 +369,7 @@ func (mdb *db) SelectFromVisibility(filter *sqlplugin.VisibilityFilter) ([]sqlpl
 	default:
 		return nil, fmt.Errorf("invalid query filter")
 	}
+	mdb.observeQuery(queryKind, startTime, err)
 	if err != nil {
 		return nil, err
 	}
@@ -202,3 +383,66 @@ func (mdb *db) SelectFromVisibility(filter *sqlplugin.VisibilityFilter) ([]sqlpl
 	}
 	return rows, err
 }
+
+// CountByCloseStatusFromVisibility returns the number of closed workflows grouped by close status
+func (mdb *db) CountByCloseStatusFromVisibility(filter *sqlplugin.VisibilityFilter) (map[int32]int64, error) {
+	if filter.MinStartTime == nil || filter.MaxStartTime == nil {
+		return nil, fmt.Errorf("invalid query filter")
+	}
+	var rows []closeStatusCount
+	err := mdb.conn.Select(&rows,
+		templateCountClosedWorkflowExecutionsByStatus,
+		filter.DomainID,
+		mdb.converter.ToMySQLDateTime(*filter.MinStartTime),
+		mdb.converter.ToMySQLDateTime(*filter.MaxStartTime))
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[int32]int64, len(rows))
+	for _, row := range rows {
+		counts[row.CloseStatus] = row.Count
+	}
+	return counts, nil
+}
+
+// CountStartedByBucketFromVisibility returns the number of workflows started within each bucket of [min, max)
+func (mdb *db) CountStartedByBucketFromVisibility(
+	domainID string,
+	min time.Time,
+	max time.Time,
+	bucket time.Duration,
+) ([]sqlplugin.BucketCount, error) {
+	if bucket <= 0 || !max.After(min) {
+		return nil, fmt.Errorf("invalid bucket query")
+	}
+	minStartTime := mdb.converter.ToMySQLDateTime(min)
+	var rows []bucketCount
+	err := mdb.conn.Select(&rows,
+		templateCountStartedWorkflowExecutionsByBucket,
+		minStartTime,
+		bucket.Nanoseconds()/int64(time.Microsecond),
+		domainID,
+		minStartTime,
+		mdb.converter.ToMySQLDateTime(max))
+	if err != nil {
+		return nil, err
+	}
+	counts := make([]sqlplugin.BucketCount, len(rows))
+	for i, row := range rows {
+		counts[i] = sqlplugin.BucketCount{
+			BucketStart: min.Add(time.Duration(row.Bucket) * bucket),
+			Count:       row.Count,
+		}
+	}
+	return counts, nil
+}
+
+// ListWorkflowTypesFromVisibility returns up to limit distinct workflow types of the domain by frequency
+func (mdb *db) ListWorkflowTypesFromVisibility(domainID string, limit int) ([]string, error) {
+	if limit <= 0 {
+		return nil, fmt.Errorf("invalid limit: %v", limit)
+	}
+	var workflowTypes []string
+	err := mdb.conn.Select(&workflowTypes, templateListWorkflowTypes, domainID, limit)
+	return workflowTypes, err
+}
@@ -0,0 +1,93 @@
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package sqlplugin
+
+import (
+	"fmt"
+	"math"
+)
+
+// VerifyVisibilityPagination walks all the pages of the keyset paginated list query of filter with pageSize and
+// checks them against the whole result read as a single page: a run read twice or skipped by the pages is an
+// error. It's an audit of the keyset predicates of the plugins, e.g. for their tests, and reads the whole result
+// in memory. filter is the filter of the first page, i.e. with an empty RunID and Cursor
+func VerifyVisibilityPagination(db DB, filter VisibilityFilter, pageSize int) error {
+	if filter.MaxOpenAge == nil && (filter.MinStartTime == nil || filter.MaxStartTime == nil) {
+		return fmt.Errorf("filter is not a keyset paginated list query")
+	}
+	if pageSize <= 0 {
+		return fmt.Errorf("invalid page size %v", pageSize)
+	}
+	expected, err := db.SelectFromVisibility(pageFilter(filter, math.MaxInt32))
+	if err != nil {
+		return err
+	}
+	seen := make(map[string]int, len(expected))
+	for page := 0; ; page++ {
+		rows, err := db.SelectFromVisibility(pageFilter(filter, pageSize))
+		if err != nil {
+			return err
+		}
+		for _, row := range rows {
+			if previous, ok := seen[row.RunID]; ok {
+				return fmt.Errorf("run %v is read by page %v and again by page %v", row.RunID, previous, page)
+			}
+			seen[row.RunID] = page
+		}
+		if len(rows) < pageSize {
+			break
+		}
+		if filter.MaxOpenAge != nil {
+			filter.Cursor = NextVisibilityCursor(rows)
+		} else {
+			last := rows[len(rows)-1]
+			filter.MaxStartTime = &last.StartTime
+			filter.RunID = &last.RunID
+		}
+	}
+	for _, row := range expected {
+		if _, ok := seen[row.RunID]; !ok {
+			return fmt.Errorf("run %v is skipped by the pages", row.RunID)
+		}
+		delete(seen, row.RunID)
+	}
+	for runID, page := range seen {
+		return fmt.Errorf("run %v is read by page %v but isn't in the result", runID, page)
+	}
+	return nil
+}
+
+// pageFilter copies filter for a single query, the plugins may convert the times of the filter in place
+func pageFilter(filter VisibilityFilter, pageSize int) *VisibilityFilter {
+	if filter.MinStartTime != nil {
+		minStartTime := *filter.MinStartTime
+		filter.MinStartTime = &minStartTime
+	}
+	if filter.MaxStartTime != nil {
+		maxStartTime := *filter.MaxStartTime
+		filter.MaxStartTime = &maxStartTime
+	}
+	if filter.RunID == nil && filter.MaxOpenAge == nil {
+		filter.RunID = new(string)
+	}
+	filter.PageSize = &pageSize
+	return &filter
+}
@@ -0,0 +1,92 @@
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package sqlplugin
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// keysetDB serves the list queries from rows with the keyset predicate of the plugins, or with a broken one
+// ANDing the run ID and start time predicates
+type keysetDB struct {
+	DB
+	rows   []VisibilityRow
+	broken bool
+}
+
+func (db *keysetDB) SelectFromVisibility(filter *VisibilityFilter) ([]VisibilityRow, error) {
+	var result []VisibilityRow
+	for _, row := range db.rows {
+		if row.StartTime.Before(*filter.MinStartTime) || row.StartTime.After(*filter.MaxStartTime) {
+			continue
+		}
+		if db.broken && row.RunID <= *filter.RunID {
+			continue
+		}
+		if !db.broken && row.RunID <= *filter.RunID && !row.StartTime.Before(*filter.MaxStartTime) {
+			continue
+		}
+		result = append(result, row)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if !result[i].StartTime.Equal(result[j].StartTime) {
+			return result[i].StartTime.After(result[j].StartTime)
+		}
+		return result[i].RunID < result[j].RunID
+	})
+	if len(result) > *filter.PageSize {
+		result = result[:*filter.PageSize]
+	}
+	return result, nil
+}
+
+func newKeysetDB(broken bool) *keysetDB {
+	db := &keysetDB{broken: broken}
+	startTime := time.Unix(1000, 0)
+	for i := 0; i < 20; i++ {
+		// runs share their start times so that pages end in the middle of a start time
+		db.rows = append(db.rows, VisibilityRow{
+			RunID:     fmt.Sprintf("run-%02d", (i*7)%20),
+			StartTime: startTime.Add(time.Duration(i/3) * time.Second),
+		})
+	}
+	return db
+}
+
+func TestVerifyVisibilityPagination(t *testing.T) {
+	minStartTime := time.Unix(0, 0)
+	maxStartTime := time.Unix(2000, 0)
+	filter := VisibilityFilter{
+		DomainID:     "domain",
+		MinStartTime: &minStartTime,
+		MaxStartTime: &maxStartTime,
+	}
+	for _, pageSize := range []int{1, 2, 4, 7, 20, 50} {
+		require.NoError(t, VerifyVisibilityPagination(newKeysetDB(false), filter, pageSize), "page size %v", pageSize)
+	}
+	require.Error(t, VerifyVisibilityPagination(newKeysetDB(true), filter, 2))
+	require.Error(t, VerifyVisibilityPagination(newKeysetDB(false), VisibilityFilter{DomainID: "domain"}, 2))
+}
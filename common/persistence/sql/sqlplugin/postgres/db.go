@@ -21,6 +21,11 @@
 package postgres
 
 import (
+	"context"
+	"strings"
+	"sync/atomic"
+	"time"
+
 	"github.com/jmoiron/sqlx"
 	"github.com/lib/pq"
 
@@ -33,6 +38,25 @@ type db struct {
 	tx        *sqlx.Tx
 	conn      sqlplugin.Conn
 	converter DataConverter
+	// replica is the optional connection to a read replica, nil means no replica
+	replica *sqlx.DB
+	// readConn serves the read only visibility queries, it's the replica if there is one and conn otherwise.
+	// Reads from the replica are eventually consistent, so they may miss the latest writes to visibility.
+	readConn sqlplugin.Conn
+	// shardLockTimeout is the max time to wait on the write lock of a shard row, zero means no timeout
+	shardLockTimeout time.Duration
+	// queryObserver is notified of visibility queries, nil means no observer
+	queryObserver sqlplugin.QueryObserver
+	// slowQueryThreshold is the latency over which a visibility query is explained, zero means never
+	slowQueryThreshold time.Duration
+	// queryPlanObserver is notified of the plans of slow visibility queries, nil means no observer
+	queryPlanObserver sqlplugin.QueryPlanObserver
+	// explaining is set while a slow query is being explained, so that at most one EXPLAIN runs at a time
+	explaining int32
+	// connPoolReportInterval is how often the stats of the connection pool are reported to the observer
+	connPoolReportInterval time.Duration
+	// stopCh stops reporting the stats of the connection pool, nil means they are not being reported
+	stopCh chan struct{}
 }
 
 var _ sqlplugin.DB = (*db)(nil)
@@ -42,11 +66,26 @@ var _ sqlplugin.Tx = (*db)(nil)
 // check http://www.postgresql.org/docs/9.3/static/errcodes-appendix.html
 const ErrDupEntry = "23505"
 
+// explainAnalyzePrefix turns a query into the one returning its plan along with the actual execution stats,
+// every row of the result is a line of the plan
+const explainAnalyzePrefix = "EXPLAIN (ANALYZE, BUFFERS) "
+
+// defaultConnPoolReportInterval is how often the stats of the connection pool are reported by default
+const defaultConnPoolReportInterval = 10 * time.Second
+
+// ErrLockNotAvailable indicates a lock couldn't be acquired, e.g. when lock_timeout is exceeded
+const ErrLockNotAvailable = "55P03"
+
 func (pdb *db) IsDupEntryError(err error) bool {
 	sqlErr, ok := err.(*pq.Error)
 	return ok && sqlErr.Code == ErrDupEntry
 }
 
+func isLockNotAvailableError(err error) bool {
+	sqlErr, ok := err.(*pq.Error)
+	return ok && sqlErr.Code == ErrLockNotAvailable
+}
+
 // NewDB returns an instance of DB, which is a logical
 // connection to the underlying mysql database
 // Fixme we need to ignore this Lint warning
@@ -56,7 +95,9 @@ func NewDB(xdb *sqlx.DB, tx *sqlx.Tx) *db {
 	if tx != nil {
 		mdb.conn = tx
 	}
+	mdb.readConn = mdb.conn
 	mdb.converter = &converter{}
+	mdb.connPoolReportInterval = defaultConnPoolReportInterval
 	return mdb
 }
 
@@ -66,7 +107,10 @@ func (pdb *db) BeginTx() (sqlplugin.Tx, error) {
 	if err != nil {
 		return nil, err
 	}
-	return NewDB(pdb.db, xtx), nil
+	tx := NewDB(pdb.db, xtx)
+	tx.shardLockTimeout = pdb.shardLockTimeout
+	tx.queryObserver = pdb.queryObserver
+	return tx, nil
 }
 
 // Commit commits a previously started transaction
@@ -79,8 +123,85 @@ func (pdb *db) Rollback() error {
 	return pdb.tx.Rollback()
 }
 
+// PingContext verifies the connection to the database is alive, establishing one if necessary
+func (pdb *db) PingContext(ctx context.Context) error {
+	return pdb.db.PingContext(ctx)
+}
+
+// SetQueryObserver sets the observer notified of every visibility query
+func (pdb *db) SetQueryObserver(observer sqlplugin.QueryObserver) {
+	pdb.queryObserver = observer
+}
+
+// SetQueryPlanObserver sets the observer notified of the plans of slow visibility queries
+func (pdb *db) SetQueryPlanObserver(observer sqlplugin.QueryPlanObserver) {
+	pdb.queryPlanObserver = observer
+}
+
+// SetConnPoolObserver starts reporting the stats of the connection pool to the observer until the db is closed.
+// Only the pool of the primary is reported, which is the one serving all the writes.
+func (pdb *db) SetConnPoolObserver(observer sqlplugin.ConnPoolObserver) {
+	if pdb.tx != nil || pdb.stopCh != nil {
+		return
+	}
+	pdb.stopCh = make(chan struct{})
+	go pdb.reportConnPoolStats(observer, pdb.stopCh)
+}
+
+func (pdb *db) reportConnPoolStats(observer sqlplugin.ConnPoolObserver, stopCh <-chan struct{}) {
+	ticker := time.NewTicker(pdb.connPoolReportInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			observer(pdb.db.Stats())
+		case <-stopCh:
+			return
+		}
+	}
+}
+
+func (pdb *db) observeQuery(queryKind string, startTime time.Time, err error) {
+	if pdb.queryObserver != nil {
+		pdb.queryObserver(queryKind, time.Since(startTime), err)
+	}
+}
+
+// explainSlowQuery runs EXPLAIN ANALYZE on the query in the background if it took longer than slowQueryThreshold.
+// EXPLAIN ANALYZE executes the query once more, so the slow queries showing up while one is being explained
+// are not explained to avoid piling up the load on an already struggling database.
+func (pdb *db) explainSlowQuery(queryKind string, startTime time.Time, query string, args []interface{}) {
+	if pdb.slowQueryThreshold <= 0 || pdb.queryPlanObserver == nil {
+		return
+	}
+	latency := time.Since(startTime)
+	if latency < pdb.slowQueryThreshold {
+		return
+	}
+	if !atomic.CompareAndSwapInt32(&pdb.explaining, 0, 1) {
+		return
+	}
+
+	go func() {
+		defer atomic.StoreInt32(&pdb.explaining, 0)
+		var plan []string
+		err := pdb.readConn.Select(&plan, explainAnalyzePrefix+query, args...)
+		pdb.queryPlanObserver(queryKind, latency, strings.Join(plan, "\n"), err)
+	}()
+}
+
 // Close closes the connection to the mysql db
 func (pdb *db) Close() error {
+	if pdb.stopCh != nil {
+		close(pdb.stopCh)
+		pdb.stopCh = nil
+	}
+	if pdb.replica != nil {
+		if err := pdb.replica.Close(); err != nil {
+			pdb.db.Close()
+			return err
+		}
+	}
 	return pdb.db.Close()
 }
 
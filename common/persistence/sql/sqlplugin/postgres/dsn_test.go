@@ -0,0 +1,88 @@
+// Copyright (c) 2019 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package postgres
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/uber/cadence/common/auth"
+	"github.com/uber/cadence/common/service/config"
+)
+
+type StoreTestSuite struct {
+	suite.Suite
+}
+
+func TestStoreTestSuite(t *testing.T) {
+	suite.Run(t, new(StoreTestSuite))
+}
+
+func (s *StoreTestSuite) TestBuildDSN() {
+	testCases := []struct {
+		in  config.SQL
+		out string
+	}{
+		{
+			in: config.SQL{
+				User:     "test",
+				Password: "pass",
+			},
+			out: "user=test password=pass host=192.168.0.1 port=5432 dbname=db1 sslmode=disable ",
+		},
+		{
+			in: config.SQL{
+				User:     "test",
+				Password: "pass",
+				TLS:      &auth.TLS{Enabled: false, CaFile: "/ca.pem"},
+			},
+			out: "user=test password=pass host=192.168.0.1 port=5432 dbname=db1 sslmode=disable ",
+		},
+		{
+			in: config.SQL{
+				User:     "test",
+				Password: "pass",
+				TLS:      &auth.TLS{Enabled: true},
+			},
+			out: "user=test password=pass host=192.168.0.1 port=5432 dbname=db1 sslmode=require ",
+		},
+		{
+			in: config.SQL{
+				User:     "test",
+				Password: "pass",
+				TLS: &auth.TLS{
+					Enabled:                true,
+					EnableHostVerification: true,
+					CaFile:                 "/ca.pem",
+					CertFile:               "/cert.pem",
+					KeyFile:                "/key.pem",
+				},
+			},
+			out: "user=test password=pass host=192.168.0.1 port=5432 dbname=db1 sslmode=verify-full " +
+				"sslrootcert=/ca.pem sslcert=/cert.pem sslkey=/key.pem ",
+		},
+	}
+
+	for _, tc := range testCases {
+		s.Equal(tc.out, buildDSN(&tc.in, "192.168.0.1", "5432", "db1"))
+	}
+}
@@ -21,8 +21,10 @@
 package postgres
 
 import (
+	"context"
 	"fmt"
 	"net"
+	"time"
 
 	"github.com/iancoleman/strcase"
 	"github.com/jmoiron/sqlx"
@@ -35,7 +37,16 @@ import (
 const (
 	// PluginName is the name of the plugin
 	PluginName             = "postgres"
-	dataSourceNamePostgres = "user=%v password=%v host=%v port=%v dbname=%v sslmode=disable "
+	dataSourceNamePostgres = "user=%v password=%v host=%v port=%v dbname=%v sslmode=%v "
+
+	sslModeDisable    = "disable"
+	sslModeRequire    = "require"
+	sslModeVerifyFull = "verify-full"
+
+	// tlsPingTimeout is the timeout to verify a secured connection can be established on startup
+	tlsPingTimeout = 10 * time.Second
+	// defaultSlowVisibilityQueryThreshold is the default of SQL.SlowVisibilityQueryThreshold
+	defaultSlowVisibilityQueryThreshold = time.Second
 )
 
 type plugin struct{}
@@ -53,6 +64,33 @@ func (d *plugin) CreateDB(cfg *config.SQL) (sqlplugin.DB, error) {
 		return nil, err
 	}
 	db := NewDB(conn, nil)
+	db.shardLockTimeout = cfg.ShardLockTimeout
+	if cfg.ExplainSlowVisibilityQueries {
+		db.slowQueryThreshold = cfg.SlowVisibilityQueryThreshold
+		if db.slowQueryThreshold <= 0 {
+			db.slowQueryThreshold = defaultSlowVisibilityQueryThreshold
+		}
+	}
+	if cfg.ReadReplicaConnectAddr != "" {
+		replicaCfg := *cfg
+		replicaCfg.ConnectAddr = cfg.ReadReplicaConnectAddr
+		replica, err := d.createDBConnection(&replicaCfg)
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+		db.replica = replica
+		db.readConn = replica
+	}
+	if cfg.TLS != nil && cfg.TLS.Enabled {
+		// fail fast on startup rather than on serving traffic if TLS can't be established
+		ctx, cancel := context.WithTimeout(context.Background(), tlsPingTimeout)
+		defer cancel()
+		if err := db.PingContext(ctx); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("unable to establish TLS connection to %v, err: %v", cfg.ConnectAddr, err)
+		}
+	}
 	return db, nil
 }
 
@@ -81,7 +119,7 @@ func (d *plugin) createDBConnection(cfg *config.SQL) (*sqlx.DB, error) {
 	if dbName == "" {
 		dbName = "postgres"
 	}
-	db, err := sqlx.Connect(PluginName, fmt.Sprintf(dataSourceNamePostgres, cfg.User, cfg.Password, host, port, dbName))
+	db, err := sqlx.Connect(PluginName, buildDSN(cfg, host, port, dbName))
 
 	if err != nil {
 		return nil, err
@@ -91,3 +129,26 @@ func (d *plugin) createDBConnection(cfg *config.SQL) (*sqlx.DB, error) {
 	db.MapperFunc(strcase.ToSnake)
 	return db, nil
 }
+
+func buildDSN(cfg *config.SQL, host string, port string, dbName string) string {
+	sslMode := sslModeDisable
+	if cfg.TLS != nil && cfg.TLS.Enabled {
+		sslMode = sslModeRequire
+		if cfg.TLS.EnableHostVerification {
+			sslMode = sslModeVerifyFull
+		}
+	}
+	dsn := fmt.Sprintf(dataSourceNamePostgres, cfg.User, cfg.Password, host, port, dbName, sslMode)
+	if sslMode != sslModeDisable {
+		if cfg.TLS.CaFile != "" {
+			dsn += fmt.Sprintf("sslrootcert=%v ", cfg.TLS.CaFile)
+		}
+		if cfg.TLS.CertFile != "" {
+			dsn += fmt.Sprintf("sslcert=%v ", cfg.TLS.CertFile)
+		}
+		if cfg.TLS.KeyFile != "" {
+			dsn += fmt.Sprintf("sslkey=%v ", cfg.TLS.KeyFile)
+		}
+	}
+	return dsn
+}
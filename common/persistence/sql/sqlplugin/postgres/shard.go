@@ -22,6 +22,10 @@ package postgres
 
 import (
 	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/uber/cadence/common"
 
 	"github.com/uber/cadence/common/persistence/sql/sqlplugin"
 )
@@ -34,12 +38,16 @@ const (
  shard_id, range_id, data, data_encoding
  FROM shards WHERE shard_id = $1`
 
+	getShardRangeIDQry = `SELECT range_id FROM shards WHERE shard_id = $1`
+
 	updateShardQry = `UPDATE shards 
  SET range_id = $1, data = $2, data_encoding = $3 
- WHERE shard_id = $4`
+ WHERE shard_id = $4 AND range_id = $5`
 
-	lockShardQry     = `SELECT range_id FROM shards WHERE shard_id = $1 FOR UPDATE`
-	readLockShardQry = `SELECT range_id FROM shards WHERE shard_id = $1 FOR SHARE`
+	lockShardQry = `SELECT range_id FROM shards WHERE shard_id = $1 FOR UPDATE`
+	// SET doesn't support bind parameters, the value is in milliseconds
+	setLockTimeoutQry = `SET LOCAL lock_timeout = %d`
+	readLockShardQry  = `SELECT range_id FROM shards WHERE shard_id = $1 FOR SHARE`
 )
 
 // InsertIntoShards inserts one or more rows into shards table
@@ -47,9 +55,29 @@ func (pdb *db) InsertIntoShards(row *sqlplugin.ShardsRow) (sql.Result, error) {
 	return pdb.conn.Exec(createShardQry, row.ShardID, row.RangeID, row.Data, row.DataEncoding)
 }
 
-// UpdateShards updates one or more rows into shards table
-func (pdb *db) UpdateShards(row *sqlplugin.ShardsRow) (sql.Result, error) {
-	return pdb.conn.Exec(updateShardQry, row.RangeID, row.Data, row.DataEncoding, row.ShardID)
+// UpdateShards updates one or more rows into shards table if the current range_id matches the given rangeID,
+// otherwise it returns ShardOwnershipLostError along with the current range_id
+func (pdb *db) UpdateShards(row *sqlplugin.ShardsRow, rangeID int64) (sql.Result, error) {
+	result, err := pdb.conn.Exec(updateShardQry, row.RangeID, row.Data, row.DataEncoding, row.ShardID, rangeID)
+	if err != nil {
+		return nil, err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if rowsAffected == 0 {
+		var actualRangeID int64
+		if err := pdb.conn.Get(&actualRangeID, getShardRangeIDQry, row.ShardID); err != nil {
+			return nil, err
+		}
+		return nil, &sqlplugin.ShardOwnershipLostError{
+			ShardID:         row.ShardID,
+			ExpectedRangeID: rangeID,
+			ActualRangeID:   actualRangeID,
+		}
+	}
+	return result, nil
 }
 
 // SelectFromShards reads one or more rows from shards table
@@ -71,7 +99,17 @@ func (pdb *db) ReadLockShards(filter *sqlplugin.ShardsFilter) (int, error) {
 
 // WriteLockShards acquires a write lock on a single row in shards table
 func (pdb *db) WriteLockShards(filter *sqlplugin.ShardsFilter) (int, error) {
+	// lock_timeout is scoped to the current transaction by SET LOCAL
+	if pdb.tx != nil && pdb.shardLockTimeout > 0 {
+		timeoutInMillis := int64(pdb.shardLockTimeout / time.Millisecond)
+		if _, err := pdb.conn.Exec(fmt.Sprintf(setLockTimeoutQry, common.MaxInt64(timeoutInMillis, 1))); err != nil {
+			return 0, err
+		}
+	}
 	var rangeID int
 	err := pdb.conn.Get(&rangeID, lockShardQry, filter.ShardID)
+	if isLockNotAvailableError(err) {
+		return 0, &sqlplugin.ShardLockTimeoutError{ShardID: filter.ShardID, Timeout: pdb.shardLockTimeout}
+	}
 	return rangeID, err
 }
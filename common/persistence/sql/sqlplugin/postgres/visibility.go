@@ -25,16 +25,23 @@ import (
 	"errors"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/uber/cadence/common/persistence/sql/sqlplugin"
 )
 
 const (
+	// the start of a workflow may arrive after its close under replication, the conflict is always ignored rather
+	// than updated so that a late start never reopens a closed row nor nulls out its close fields
 	templateCreateWorkflowExecutionStarted = `INSERT INTO executions_visibility (` +
 		`domain_id, workflow_id, run_id, start_time, execution_time, workflow_type_name, memo, encoding) ` +
 		`VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
          ON CONFLICT (domain_id, run_id) DO NOTHING`
 
+	templateUpdateWorkflowExecutionClosed = `UPDATE executions_visibility ` +
+		`SET close_time = $3, close_status = $4, history_length = $5 ` +
+		`WHERE domain_id = $1 AND run_id = $2`
+
 	templateCreateWorkflowExecutionClosed = `INSERT INTO executions_visibility (` +
 		`domain_id, workflow_id, run_id, start_time, execution_time, workflow_type_name, close_time, close_status, history_length, memo, encoding) ` +
 		`VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
@@ -64,11 +71,19 @@ const (
          ORDER BY start_time DESC, run_id
          LIMIT $7`
 
+	templateConditions3 = ` AND domain_id = $3
+		 AND start_time >= $4
+		 AND start_time <= $5
+ 		 AND (run_id > $6 OR start_time < $7)
+         ORDER BY start_time DESC, run_id
+         LIMIT $8`
+
 	templateOpenFieldNames = `workflow_id, run_id, start_time, execution_time, workflow_type_name, memo, encoding`
 	templateOpenSelect     = `SELECT ` + templateOpenFieldNames + ` FROM executions_visibility WHERE close_status IS NULL `
 
-	templateClosedSelect = `SELECT ` + templateOpenFieldNames + `, close_time, close_status, history_length
-		 FROM executions_visibility WHERE close_status IS NOT NULL `
+	// every template reading closed rows selects templateClosedFieldNames, so that they can't drift apart
+	templateClosedFieldNames = templateOpenFieldNames + `, close_time, close_status, history_length`
+	templateClosedSelect     = `SELECT ` + templateClosedFieldNames + ` FROM executions_visibility WHERE close_status IS NOT NULL `
 
 	templateGetOpenWorkflowExecutions = templateOpenSelect + templateConditions1
 
@@ -84,21 +99,119 @@ const (
 
 	templateGetClosedWorkflowExecutionsByStatus = templateClosedSelect + `AND close_status = $1` + templateConditions2
 
-	templateGetClosedWorkflowExecution = `SELECT workflow_id, run_id, start_time, execution_time, memo, encoding, close_time, workflow_type_name, close_status, history_length 
+	templateGetClosedWorkflowExecutionsByTypeAndStatus = templateClosedSelect + `AND workflow_type_name = $1 AND close_status = $2` + templateConditions3
+
+	// the case insensitive by type templates can't use the index on workflow_type_name, a functional index such as
+	// CREATE INDEX ... ON executions_visibility (domain_id, LOWER(workflow_type_name), start_time DESC, run_id)
+	// keeps them from scanning every row of the domain in the time range
+	templateTypeCaseInsensitive = `AND LOWER(workflow_type_name) = LOWER($1)`
+
+	templateGetOpenWorkflowExecutionsByTypeCaseInsensitive = templateOpenSelect + templateTypeCaseInsensitive + templateConditions2
+
+	templateGetClosedWorkflowExecutionsByTypeCaseInsensitive = templateClosedSelect + templateTypeCaseInsensitive + templateConditions2
+
+	templateGetClosedWorkflowExecutionsByTypeAndStatusCaseInsensitive = templateClosedSelect + templateTypeCaseInsensitive + ` AND close_status = $2` + templateConditions3
+
+	// the row comparison paginates by (start_time, run_id) of the last row, so that the rows sharing the same
+	// start_time across the page boundary are neither skipped nor returned twice
+	templateOlderThanConditions = `AND domain_id = $1
+		 AND start_time < $2
+		 AND (start_time, run_id) > ($3, $4)
+		 ORDER BY start_time, run_id
+		 LIMIT $5`
+
+	templateGetOpenWorkflowExecutionsOlderThan = templateOpenSelect + templateOlderThanConditions
+
+	// the list templates of VisibilityColumnsMinimal select the same rows with fewer columns, see minimalTemplates
+	templateMinimalFieldNames   = `workflow_id, run_id, start_time, close_status`
+	templateMinimalOpenSelect   = `SELECT ` + templateMinimalFieldNames + ` FROM executions_visibility WHERE close_status IS NULL `
+	templateMinimalClosedSelect = `SELECT ` + templateMinimalFieldNames + ` FROM executions_visibility WHERE close_status IS NOT NULL `
+
+	templateGetWorkflowExecution = `SELECT ` + templateClosedFieldNames + `
+		 FROM executions_visibility
+		 WHERE domain_id = $1 AND run_id = $2`
+
+	templateGetClosedWorkflowExecution = `SELECT ` + templateClosedFieldNames + `
 		 FROM executions_visibility
 		 WHERE domain_id = $1 AND close_status IS NOT NULL
 		 AND run_id = $2`
 
+	templateCountClosedWorkflowExecutionsByStatus = `SELECT close_status, COUNT(*) AS count
+		 FROM executions_visibility
+		 WHERE domain_id = $1 AND close_status IS NOT NULL
+		 AND start_time >= $2
+		 AND start_time <= $3
+		 GROUP BY close_status`
+
+	// width_bucket numbers the buckets from 1, min is the lower bound of the first bucket
+	templateCountStartedWorkflowExecutionsByBucket = `SELECT width_bucket(EXTRACT(EPOCH FROM start_time - $2::timestamp)::float8, 0, $4::float8, $5::int) AS bucket, COUNT(*) AS count
+		 FROM executions_visibility
+		 WHERE domain_id = $1
+		 AND start_time >= $2
+		 AND start_time < $3
+		 GROUP BY bucket
+		 ORDER BY bucket`
+
+	// grouping returns the same rows as DISTINCT and counts them along the way, so ordering by frequency is cheap
+	templateListWorkflowTypes = `SELECT workflow_type_name
+		 FROM executions_visibility
+		 WHERE domain_id = $1
+		 GROUP BY workflow_type_name
+		 ORDER BY COUNT(*) DESC, workflow_type_name
+		 LIMIT $2`
+
 	templateDeleteWorkflowExecution = "DELETE FROM executions_visibility WHERE domain_id=$1 AND run_id=$2"
+
+	templateDeleteOldRunsOfWorkflowExecution = `DELETE FROM executions_visibility WHERE domain_id = $1 AND workflow_id = $2 AND run_id IN (
+		SELECT run_id FROM executions_visibility WHERE domain_id = $1 AND workflow_id = $2
+		ORDER BY start_time DESC, run_id OFFSET $3)`
+
+	// rows written before execution_time existed have it at either the zero time or the epoch
+	templateBackfillExecutionTime = `UPDATE executions_visibility SET execution_time = start_time WHERE domain_id = $1 AND run_id IN (
+		SELECT run_id FROM executions_visibility WHERE domain_id = $1 AND (execution_time IS NULL OR execution_time <= $2)
+		LIMIT $3)`
 )
 
 var errCloseParams = errors.New("missing one of {closeStatus, closeTime, historyLength} params")
 
+// minimalTemplates maps the list templates to their VisibilityColumnsMinimal counterparts
+var minimalTemplates = map[string]string{
+	templateGetOpenWorkflowExecutions:                  templateMinimalOpenSelect + templateConditions1,
+	templateGetClosedWorkflowExecutions:                templateMinimalClosedSelect + templateConditions1,
+	templateGetOpenWorkflowExecutionsByType:            templateMinimalOpenSelect + `AND workflow_type_name = $1` + templateConditions2,
+	templateGetClosedWorkflowExecutionsByType:          templateMinimalClosedSelect + `AND workflow_type_name = $1` + templateConditions2,
+	templateGetOpenWorkflowExecutionsByID:              templateMinimalOpenSelect + `AND workflow_id = $1` + templateConditions2,
+	templateGetClosedWorkflowExecutionsByID:            templateMinimalClosedSelect + `AND workflow_id = $1` + templateConditions2,
+	templateGetClosedWorkflowExecutionsByStatus:        templateMinimalClosedSelect + `AND close_status = $1` + templateConditions2,
+	templateGetClosedWorkflowExecutionsByTypeAndStatus: templateMinimalClosedSelect + `AND workflow_type_name = $1 AND close_status = $2` + templateConditions3,
+	templateGetOpenWorkflowExecutionsOlderThan:         templateMinimalOpenSelect + templateOlderThanConditions,
+
+	templateGetOpenWorkflowExecutionsByTypeCaseInsensitive:            templateMinimalOpenSelect + templateTypeCaseInsensitive + templateConditions2,
+	templateGetClosedWorkflowExecutionsByTypeCaseInsensitive:          templateMinimalClosedSelect + templateTypeCaseInsensitive + templateConditions2,
+	templateGetClosedWorkflowExecutionsByTypeAndStatusCaseInsensitive: templateMinimalClosedSelect + templateTypeCaseInsensitive + ` AND close_status = $2` + templateConditions3,
+}
+
+type closeStatusCount struct {
+	CloseStatus int32
+	Count       int64
+}
+
+type bucketCount struct {
+	Bucket int64
+	Count  int64
+}
+
 // InsertIntoVisibility inserts a row into visibility table. If an row already exist,
-// its left as such and no update will be made
+// its left as such and no update will be made, in particular a closed row stays closed
 func (pdb *db) InsertIntoVisibility(row *sqlplugin.VisibilityRow) (sql.Result, error) {
+	startTime := time.Now()
 	row.StartTime = pdb.converter.ToPostgresDateTime(row.StartTime)
-	return pdb.conn.Exec(templateCreateWorkflowExecutionStarted,
+	// non-delayed workflows may come without execution time, which should be same as start time
+	if row.ExecutionTime.IsZero() || row.ExecutionTime.UnixNano() == 0 {
+		row.ExecutionTime = row.StartTime
+	}
+	row.ExecutionTime = pdb.converter.ToPostgresDateTime(row.ExecutionTime)
+	result, err := pdb.conn.Exec(templateCreateWorkflowExecutionStarted,
 		row.DomainID,
 		row.WorkflowID,
 		row.RunID,
@@ -107,26 +220,44 @@ func (pdb *db) InsertIntoVisibility(row *sqlplugin.VisibilityRow) (sql.Result, e
 		row.WorkflowTypeName,
 		row.Memo,
 		row.Encoding)
+	pdb.observeQuery(sqlplugin.VisibilityQueryKindInsert, startTime, err)
+	return result, err
 }
 
-// ReplaceIntoVisibility replaces an existing row if it exist or creates a new row in visibility table
+// ReplaceIntoVisibility replaces an existing row if it exist or creates a new row in visibility table.
+// For an existing row only the close fields are updated, so the rest e.g. memo upserted after start are kept
 func (pdb *db) ReplaceIntoVisibility(row *sqlplugin.VisibilityRow) (sql.Result, error) {
 	switch {
 	case row.CloseStatus != nil && row.CloseTime != nil && row.HistoryLength != nil:
+		startTime := time.Now()
 		row.StartTime = pdb.converter.ToPostgresDateTime(row.StartTime)
 		closeTime := pdb.converter.ToPostgresDateTime(*row.CloseTime)
-		return pdb.conn.Exec(templateCreateWorkflowExecutionClosed,
+		result, err := pdb.conn.Exec(templateUpdateWorkflowExecutionClosed,
 			row.DomainID,
-			row.WorkflowID,
 			row.RunID,
-			row.StartTime,
-			row.ExecutionTime,
-			row.WorkflowTypeName,
 			closeTime,
 			*row.CloseStatus,
-			*row.HistoryLength,
-			row.Memo,
-			row.Encoding)
+			*row.HistoryLength)
+		if err == nil {
+			var rowsAffected int64
+			if rowsAffected, err = result.RowsAffected(); err == nil && rowsAffected == 0 {
+				// the open row doesn't exist, fall back to the full upsert
+				result, err = pdb.conn.Exec(templateCreateWorkflowExecutionClosed,
+					row.DomainID,
+					row.WorkflowID,
+					row.RunID,
+					row.StartTime,
+					row.ExecutionTime,
+					row.WorkflowTypeName,
+					closeTime,
+					*row.CloseStatus,
+					*row.HistoryLength,
+					row.Memo,
+					row.Encoding)
+			}
+		}
+		pdb.observeQuery(sqlplugin.VisibilityQueryKindReplace, startTime, err)
+		return result, err
 	default:
 		return nil, errCloseParams
 	}
@@ -137,6 +268,40 @@ func (pdb *db) DeleteFromVisibility(filter *sqlplugin.VisibilityFilter) (sql.Res
 	return pdb.conn.Exec(templateDeleteWorkflowExecution, filter.DomainID, filter.RunID)
 }
 
+// DeleteOldRunsFromVisibility deletes all runs of the workflowID except the newest keep ones
+func (pdb *db) DeleteOldRunsFromVisibility(domainID, workflowID string, keep int) (int64, error) {
+	if keep < 0 {
+		return 0, fmt.Errorf("invalid number of runs to keep: %v", keep)
+	}
+	result, err := pdb.conn.Exec(templateDeleteOldRunsOfWorkflowExecution, domainID, workflowID, keep)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// BackfillExecutionTime sets execution_time to start_time for the rows missing execution_time in batches
+func (pdb *db) BackfillExecutionTime(domainID string, batchSize int) (int64, error) {
+	if batchSize <= 0 {
+		return 0, fmt.Errorf("invalid batch size: %v", batchSize)
+	}
+	var total int64
+	for {
+		result, err := pdb.conn.Exec(templateBackfillExecutionTime, domainID, pdb.converter.ToPostgresDateTime(time.Unix(0, 0)), batchSize)
+		if err != nil {
+			return total, err
+		}
+		updated, err := result.RowsAffected()
+		if err != nil {
+			return total, err
+		}
+		total += updated
+		if updated < int64(batchSize) {
+			return total, nil
+		}
+	}
+}
+
 // SelectFromVisibility reads one or more rows from visibility table
 func (pdb *db) SelectFromVisibility(filter *sqlplugin.VisibilityFilter) ([]sqlplugin.VisibilityRow, error) {
 	var err error
@@ -147,69 +312,130 @@ func (pdb *db) SelectFromVisibility(filter *sqlplugin.VisibilityFilter) ([]sqlpl
 	if filter.MaxStartTime != nil {
 		*filter.MaxStartTime = pdb.converter.ToPostgresDateTime(*filter.MaxStartTime)
 	}
+	var queryKind string
+	var query string
+	var args []interface{}
 	switch {
-	case filter.MinStartTime == nil && filter.RunID != nil && filter.Closed:
-		var row sqlplugin.VisibilityRow
-		err = pdb.conn.Get(&row, templateGetClosedWorkflowExecution, filter.DomainID, *filter.RunID)
-		if err == nil {
-			rows = append(rows, row)
+	case filter.MaxOpenAge != nil:
+		queryKind = sqlplugin.VisibilityQueryKindOpenOlderThan
+		query = templateGetOpenWorkflowExecutionsOlderThan
+		lastStartTime, lastRunID := time.Unix(0, 0), ""
+		if filter.Cursor != "" {
+			if lastStartTime, lastRunID, err = sqlplugin.DecodeVisibilityCursor(filter.Cursor); err != nil {
+				return nil, err
+			}
+		}
+		args = []interface{}{
+			filter.DomainID,
+			pdb.converter.ToPostgresDateTime(time.Now().Add(-*filter.MaxOpenAge)),
+			pdb.converter.ToPostgresDateTime(lastStartTime),
+			lastRunID,
+			*filter.PageSize,
 		}
+	case filter.MinStartTime == nil && filter.RunID != nil && filter.Closed:
+		queryKind = sqlplugin.VisibilityQueryKindClosedByRunID
+		query = templateGetClosedWorkflowExecution
+		args = []interface{}{filter.DomainID, *filter.RunID}
+	case filter.MinStartTime == nil && filter.RunID != nil:
+		queryKind = sqlplugin.VisibilityQueryKindByRunID
+		query = templateGetWorkflowExecution
+		args = []interface{}{filter.DomainID, *filter.RunID}
 	case filter.MinStartTime != nil && filter.WorkflowID != nil:
-		qry := templateGetOpenWorkflowExecutionsByID
+		query = templateGetOpenWorkflowExecutionsByID
+		queryKind = sqlplugin.VisibilityQueryKindOpenByWorkflowID
 		if filter.Closed {
-			qry = templateGetClosedWorkflowExecutionsByID
+			query = templateGetClosedWorkflowExecutionsByID
+			queryKind = sqlplugin.VisibilityQueryKindClosedByWorkflowID
 		}
-		err = pdb.conn.Select(&rows,
-			qry,
+		args = []interface{}{
 			*filter.WorkflowID,
 			filter.DomainID,
 			pdb.converter.ToPostgresDateTime(*filter.MinStartTime),
 			pdb.converter.ToPostgresDateTime(*filter.MaxStartTime),
 			*filter.RunID,
 			*filter.MinStartTime,
-			*filter.PageSize)
+			*filter.PageSize,
+		}
+	case filter.MinStartTime != nil && filter.WorkflowTypeName != nil && filter.CloseStatus != nil:
+		queryKind = sqlplugin.VisibilityQueryKindClosedByTypeAndStatus
+		query = templateGetClosedWorkflowExecutionsByTypeAndStatus
+		if filter.WorkflowTypeCaseInsensitive {
+			query = templateGetClosedWorkflowExecutionsByTypeAndStatusCaseInsensitive
+		}
+		args = []interface{}{
+			*filter.WorkflowTypeName,
+			*filter.CloseStatus,
+			filter.DomainID,
+			pdb.converter.ToPostgresDateTime(*filter.MinStartTime),
+			pdb.converter.ToPostgresDateTime(*filter.MaxStartTime),
+			*filter.RunID,
+			pdb.converter.ToPostgresDateTime(*filter.MaxStartTime),
+			*filter.PageSize,
+		}
 	case filter.MinStartTime != nil && filter.WorkflowTypeName != nil:
-		qry := templateGetOpenWorkflowExecutionsByType
+		query = templateGetOpenWorkflowExecutionsByType
+		queryKind = sqlplugin.VisibilityQueryKindOpenByType
 		if filter.Closed {
-			qry = templateGetClosedWorkflowExecutionsByType
+			query = templateGetClosedWorkflowExecutionsByType
+			queryKind = sqlplugin.VisibilityQueryKindClosedByType
+		}
+		if filter.WorkflowTypeCaseInsensitive {
+			query = templateGetOpenWorkflowExecutionsByTypeCaseInsensitive
+			if filter.Closed {
+				query = templateGetClosedWorkflowExecutionsByTypeCaseInsensitive
+			}
 		}
-		err = pdb.conn.Select(&rows,
-			qry,
+		args = []interface{}{
 			*filter.WorkflowTypeName,
 			filter.DomainID,
 			pdb.converter.ToPostgresDateTime(*filter.MinStartTime),
 			pdb.converter.ToPostgresDateTime(*filter.MaxStartTime),
 			*filter.RunID,
 			*filter.MaxStartTime,
-			*filter.PageSize)
+			*filter.PageSize,
+		}
 	case filter.MinStartTime != nil && filter.CloseStatus != nil:
-		err = pdb.conn.Select(&rows,
-			templateGetClosedWorkflowExecutionsByStatus,
+		queryKind = sqlplugin.VisibilityQueryKindClosedByStatus
+		query = templateGetClosedWorkflowExecutionsByStatus
+		args = []interface{}{
 			*filter.CloseStatus,
 			filter.DomainID,
 			pdb.converter.ToPostgresDateTime(*filter.MinStartTime),
 			pdb.converter.ToPostgresDateTime(*filter.MaxStartTime),
 			*filter.RunID,
 			pdb.converter.ToPostgresDateTime(*filter.MaxStartTime),
-			*filter.PageSize)
+			*filter.PageSize,
+		}
 	case filter.MinStartTime != nil:
-		qry := templateGetOpenWorkflowExecutions
+		query = templateGetOpenWorkflowExecutions
+		queryKind = sqlplugin.VisibilityQueryKindOpen
 		if filter.Closed {
-			qry = templateGetClosedWorkflowExecutions
+			query = templateGetClosedWorkflowExecutions
+			queryKind = sqlplugin.VisibilityQueryKindClosed
 		}
 		minSt := pdb.converter.ToPostgresDateTime(*filter.MinStartTime)
 		maxSt := pdb.converter.ToPostgresDateTime(*filter.MaxStartTime)
-		err = pdb.conn.Select(&rows,
-			qry,
+		args = []interface{}{
 			filter.DomainID,
 			minSt,
 			maxSt,
 			*filter.RunID,
 			maxSt,
-			*filter.PageSize)
+			*filter.PageSize,
+		}
 	default:
 		return nil, fmt.Errorf("invalid query filter")
 	}
+	if minimalQuery, ok := minimalTemplates[query]; ok && filter.ColumnProjection == sqlplugin.VisibilityColumnsMinimal {
+		query = minimalQuery
+	}
+
+	startTime := time.Now()
+	rows, err = pdb.selectVisibilityRows(filter.ReadFromPrimary, queryKind, query, args)
+	pdb.observeQuery(queryKind, startTime, err)
+	if err == nil {
+		pdb.explainSlowQuery(queryKind, startTime, query, args)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -225,3 +451,91 @@ func (pdb *db) SelectFromVisibility(filter *sqlplugin.VisibilityFilter) ([]sqlpl
 	}
 	return rows, err
 }
+
+// selectVisibilityRows runs the query of SelectFromVisibility on the read connection, or on the primary for
+// ReadFromPrimary
+func (pdb *db) selectVisibilityRows(
+	readFromPrimary bool,
+	queryKind string,
+	query string,
+	args []interface{},
+) (rows []sqlplugin.VisibilityRow, err error) {
+	conn := pdb.readConn
+	if readFromPrimary {
+		conn = pdb.conn
+	}
+
+	if queryKind == sqlplugin.VisibilityQueryKindClosedByRunID || queryKind == sqlplugin.VisibilityQueryKindByRunID {
+		var row sqlplugin.VisibilityRow
+		err = conn.Get(&row, query, args...)
+		if err == nil {
+			rows = append(rows, row)
+		}
+		return rows, err
+	}
+	err = conn.Select(&rows, query, args...)
+	return rows, err
+}
+
+// CountByCloseStatusFromVisibility returns the number of closed workflows grouped by close status
+func (pdb *db) CountByCloseStatusFromVisibility(filter *sqlplugin.VisibilityFilter) (map[int32]int64, error) {
+	if filter.MinStartTime == nil || filter.MaxStartTime == nil {
+		return nil, fmt.Errorf("invalid query filter")
+	}
+	var rows []closeStatusCount
+	err := pdb.readConn.Select(&rows,
+		templateCountClosedWorkflowExecutionsByStatus,
+		filter.DomainID,
+		pdb.converter.ToPostgresDateTime(*filter.MinStartTime),
+		pdb.converter.ToPostgresDateTime(*filter.MaxStartTime))
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[int32]int64, len(rows))
+	for _, row := range rows {
+		counts[row.CloseStatus] = row.Count
+	}
+	return counts, nil
+}
+
+// CountStartedByBucketFromVisibility returns the number of workflows started within each bucket of [min, max)
+func (pdb *db) CountStartedByBucketFromVisibility(
+	domainID string,
+	min time.Time,
+	max time.Time,
+	bucket time.Duration,
+) ([]sqlplugin.BucketCount, error) {
+	if bucket <= 0 || !max.After(min) {
+		return nil, fmt.Errorf("invalid bucket query")
+	}
+	numBuckets := int64((max.Sub(min) + bucket - 1) / bucket)
+	var rows []bucketCount
+	err := pdb.readConn.Select(&rows,
+		templateCountStartedWorkflowExecutionsByBucket,
+		domainID,
+		pdb.converter.ToPostgresDateTime(min),
+		pdb.converter.ToPostgresDateTime(max),
+		float64(numBuckets)*bucket.Seconds(),
+		numBuckets)
+	if err != nil {
+		return nil, err
+	}
+	counts := make([]sqlplugin.BucketCount, len(rows))
+	for i, row := range rows {
+		counts[i] = sqlplugin.BucketCount{
+			BucketStart: min.Add(time.Duration(row.Bucket-1) * bucket),
+			Count:       row.Count,
+		}
+	}
+	return counts, nil
+}
+
+// ListWorkflowTypesFromVisibility returns up to limit distinct workflow types of the domain by frequency
+func (pdb *db) ListWorkflowTypesFromVisibility(domainID string, limit int) ([]string, error) {
+	if limit <= 0 {
+		return nil, fmt.Errorf("invalid limit: %v", limit)
+	}
+	var workflowTypes []string
+	err := pdb.readConn.Select(&workflowTypes, templateListWorkflowTypes, domainID, limit)
+	return workflowTypes, err
+}
@@ -0,0 +1,811 @@
+// Copyright (c) 2019 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package postgres
+
+import (
+	gosql "database/sql"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/pborman/uuid"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+
+	gen "github.com/uber/cadence/.gen/go/shared"
+	"github.com/uber/cadence/common"
+	pt "github.com/uber/cadence/common/persistence/persistence-tests"
+	"github.com/uber/cadence/common/persistence/sql"
+	"github.com/uber/cadence/common/persistence/sql/sqlplugin"
+)
+
+type (
+	visibilitySuite struct {
+		pt.TestBase
+		// override suite.Suite.Assertions with require.Assertions; this means that s.NotNil(nil) will stop the test,
+		// not merely log an error
+		*require.Assertions
+		db sqlplugin.DB
+	}
+)
+
+func TestVisibilitySuite(t *testing.T) {
+	s := new(visibilitySuite)
+	s.TestBase = pt.NewTestBaseWithSQL(getTestClusterOption())
+	s.TestBase.Setup()
+	suite.Run(t, s)
+}
+
+// SetupSuite implementation
+func (s *visibilitySuite) SetupSuite() {
+	cfg := s.DefaultTestCluster.Config()
+	db, err := sql.NewSQLDB(cfg.DataStores[cfg.VisibilityStore].SQL)
+	s.Require().NoError(err)
+	s.db = db
+}
+
+// SetupTest implementation
+func (s *visibilitySuite) SetupTest() {
+	s.Assertions = require.New(s.T())
+}
+
+// TearDownSuite implementation
+func (s *visibilitySuite) TearDownSuite() {
+	s.NoError(s.db.Close())
+	s.TearDownWorkflowStore()
+}
+
+func (s *visibilitySuite) TestSelectClosedByTypeAndStatus() {
+	domainID := uuid.New()
+	startTime := time.Now().Add(-time.Hour)
+	s.insertClosed(domainID, "type-a", gen.WorkflowExecutionCloseStatusFailed, startTime)
+	s.insertClosed(domainID, "type-a", gen.WorkflowExecutionCloseStatusCompleted, startTime.Add(time.Second))
+	s.insertClosed(domainID, "type-b", gen.WorkflowExecutionCloseStatusFailed, startTime.Add(2*time.Second))
+	expected := s.insertClosed(domainID, "type-a", gen.WorkflowExecutionCloseStatusFailed, startTime.Add(3*time.Second))
+
+	minStartTime := startTime.Add(-time.Minute)
+	maxStartTime := time.Now()
+	rows, err := s.db.SelectFromVisibility(&sqlplugin.VisibilityFilter{
+		DomainID:         domainID,
+		Closed:           true,
+		WorkflowTypeName: common.StringPtr("type-a"),
+		CloseStatus:      common.Int32Ptr(int32(gen.WorkflowExecutionCloseStatusFailed)),
+		MinStartTime:     &minStartTime,
+		MaxStartTime:     &maxStartTime,
+		RunID:            common.StringPtr(""),
+		PageSize:         common.IntPtr(1),
+	})
+	s.NoError(err)
+	s.Len(rows, 1)
+	s.Equal(expected.RunID, rows[0].RunID)
+	s.Equal("type-a", rows[0].WorkflowTypeName)
+	s.Equal(int32(gen.WorkflowExecutionCloseStatusFailed), *rows[0].CloseStatus)
+
+	// next page should only return the remaining failed type-a workflow
+	rows, err = s.db.SelectFromVisibility(&sqlplugin.VisibilityFilter{
+		DomainID:         domainID,
+		Closed:           true,
+		WorkflowTypeName: common.StringPtr("type-a"),
+		CloseStatus:      common.Int32Ptr(int32(gen.WorkflowExecutionCloseStatusFailed)),
+		MinStartTime:     &minStartTime,
+		MaxStartTime:     &rows[0].StartTime,
+		RunID:            common.StringPtr(rows[0].RunID),
+		PageSize:         common.IntPtr(10),
+	})
+	s.NoError(err)
+	s.Len(rows, 1)
+	s.Equal("type-a", rows[0].WorkflowTypeName)
+	s.Equal(int32(gen.WorkflowExecutionCloseStatusFailed), *rows[0].CloseStatus)
+}
+
+func (s *visibilitySuite) TestDeleteOldRunsFromVisibility() {
+	domainID := uuid.New()
+	workflowID := uuid.New()
+	startTime := time.Now().Add(-time.Hour)
+	var runIDs []string
+	for i := 0; i < 4; i++ {
+		row := &sqlplugin.VisibilityRow{
+			DomainID:         domainID,
+			WorkflowID:       workflowID,
+			RunID:            uuid.New(),
+			StartTime:        startTime.Add(time.Duration(i) * time.Second),
+			ExecutionTime:    startTime,
+			WorkflowTypeName: "type-a",
+			Encoding:         string(common.EncodingTypeThriftRW),
+		}
+		_, err := s.db.InsertIntoVisibility(row)
+		s.NoError(err)
+		runIDs = append(runIDs, row.RunID)
+	}
+
+	deleted, err := s.db.DeleteOldRunsFromVisibility(domainID, workflowID, 2)
+	s.NoError(err)
+	s.Equal(int64(2), deleted)
+
+	minStartTime := startTime.Add(-time.Minute)
+	maxStartTime := time.Now()
+	rows, err := s.db.SelectFromVisibility(&sqlplugin.VisibilityFilter{
+		DomainID:     domainID,
+		WorkflowID:   common.StringPtr(workflowID),
+		MinStartTime: &minStartTime,
+		MaxStartTime: &maxStartTime,
+		RunID:        common.StringPtr(""),
+		PageSize:     common.IntPtr(10),
+	})
+	s.NoError(err)
+	s.Len(rows, 2)
+	s.ElementsMatch(runIDs[2:], []string{rows[0].RunID, rows[1].RunID})
+
+	// nothing left to delete beyond the newest two runs
+	deleted, err = s.db.DeleteOldRunsFromVisibility(domainID, workflowID, 2)
+	s.NoError(err)
+	s.Equal(int64(0), deleted)
+}
+
+func (s *visibilitySuite) TestInsertWithZeroExecutionTime() {
+	domainID := uuid.New()
+	workflowID := uuid.New()
+	startTime := time.Now().Add(-time.Hour)
+	_, err := s.db.InsertIntoVisibility(&sqlplugin.VisibilityRow{
+		DomainID:         domainID,
+		WorkflowID:       workflowID,
+		RunID:            uuid.New(),
+		StartTime:        startTime,
+		ExecutionTime:    time.Unix(0, 0),
+		WorkflowTypeName: "type-a",
+		Encoding:         string(common.EncodingTypeThriftRW),
+	})
+	s.NoError(err)
+
+	minStartTime := startTime.Add(-time.Minute)
+	maxStartTime := time.Now()
+	rows, err := s.db.SelectFromVisibility(&sqlplugin.VisibilityFilter{
+		DomainID:     domainID,
+		WorkflowID:   common.StringPtr(workflowID),
+		MinStartTime: &minStartTime,
+		MaxStartTime: &maxStartTime,
+		RunID:        common.StringPtr(""),
+		PageSize:     common.IntPtr(10),
+	})
+	s.NoError(err)
+	s.Len(rows, 1)
+	s.True(rows[0].ExecutionTime.Equal(rows[0].StartTime))
+}
+
+func (s *visibilitySuite) TestBackfillExecutionTime() {
+	domainID := uuid.New()
+	startTime := time.Now().Add(-time.Hour)
+	for i := 0; i < 5; i++ {
+		runID := s.insertOpen(domainID, startTime.Add(time.Duration(i)*time.Second))
+		if i < 3 {
+			// the insert already defaults execution_time, so write the rows as they were before the column existed
+			_, err := s.db.(*db).conn.Exec(`UPDATE executions_visibility SET execution_time = $1 WHERE domain_id = $2 AND run_id = $3`,
+				time.Unix(0, 0), domainID, runID)
+			s.NoError(err)
+		}
+	}
+
+	updated, err := s.db.BackfillExecutionTime(domainID, 2)
+	s.NoError(err)
+	s.Equal(int64(3), updated)
+
+	minStartTime := startTime.Add(-time.Minute)
+	maxStartTime := time.Now()
+	rows, err := s.db.SelectFromVisibility(&sqlplugin.VisibilityFilter{
+		DomainID:     domainID,
+		MinStartTime: &minStartTime,
+		MaxStartTime: &maxStartTime,
+		RunID:        common.StringPtr(""),
+		PageSize:     common.IntPtr(10),
+	})
+	s.NoError(err)
+	s.Len(rows, 5)
+	for _, row := range rows {
+		s.True(row.ExecutionTime.Equal(row.StartTime))
+	}
+
+	updated, err = s.db.BackfillExecutionTime(domainID, 2)
+	s.NoError(err)
+	s.Equal(int64(0), updated)
+	_, err = s.db.BackfillExecutionTime(domainID, 0)
+	s.Error(err)
+}
+
+func (s *visibilitySuite) TestReplaceKeepsMemoOfOpenRow() {
+	domainID := uuid.New()
+	startTime := time.Now().Add(-time.Hour)
+	row := &sqlplugin.VisibilityRow{
+		DomainID:         domainID,
+		WorkflowID:       uuid.New(),
+		RunID:            uuid.New(),
+		StartTime:        startTime,
+		ExecutionTime:    startTime,
+		WorkflowTypeName: "type-a",
+		Memo:             []byte("memo before close"),
+		Encoding:         string(common.EncodingTypeThriftRW),
+	}
+	_, err := s.db.InsertIntoVisibility(row)
+	s.NoError(err)
+
+	closeTime := time.Now()
+	_, err = s.db.ReplaceIntoVisibility(&sqlplugin.VisibilityRow{
+		DomainID:         domainID,
+		WorkflowID:       row.WorkflowID,
+		RunID:            row.RunID,
+		StartTime:        startTime,
+		ExecutionTime:    startTime,
+		WorkflowTypeName: "type-a",
+		CloseTime:        &closeTime,
+		CloseStatus:      common.Int32Ptr(int32(gen.WorkflowExecutionCloseStatusCompleted)),
+		HistoryLength:    common.Int64Ptr(10),
+		Memo:             []byte("stale memo"),
+		Encoding:         string(common.EncodingTypeThriftRW),
+	})
+	s.NoError(err)
+
+	rows, err := s.db.SelectFromVisibility(&sqlplugin.VisibilityFilter{
+		DomainID: domainID,
+		RunID:    common.StringPtr(row.RunID),
+		Closed:   true,
+	})
+	s.NoError(err)
+	s.Len(rows, 1)
+	s.Equal([]byte("memo before close"), rows[0].Memo)
+	s.Equal(int32(gen.WorkflowExecutionCloseStatusCompleted), *rows[0].CloseStatus)
+	s.Equal(int64(10), *rows[0].HistoryLength)
+
+	// closing a workflow without open row still creates the row
+	closed := s.insertClosed(domainID, "type-a", gen.WorkflowExecutionCloseStatusFailed, startTime)
+	rows, err = s.db.SelectFromVisibility(&sqlplugin.VisibilityFilter{
+		DomainID: domainID,
+		RunID:    common.StringPtr(closed.RunID),
+		Closed:   true,
+	})
+	s.NoError(err)
+	s.Len(rows, 1)
+	s.Equal(int32(gen.WorkflowExecutionCloseStatusFailed), *rows[0].CloseStatus)
+}
+
+func (s *visibilitySuite) TestCountByCloseStatusFromVisibility() {
+	domainID := uuid.New()
+	startTime := time.Now().Add(-time.Hour)
+	s.insertClosed(domainID, "type-a", gen.WorkflowExecutionCloseStatusCompleted, startTime)
+	s.insertClosed(domainID, "type-a", gen.WorkflowExecutionCloseStatusCompleted, startTime.Add(time.Second))
+	s.insertClosed(domainID, "type-b", gen.WorkflowExecutionCloseStatusFailed, startTime.Add(2*time.Second))
+	s.insertClosed(domainID, "type-a", gen.WorkflowExecutionCloseStatusTerminated, startTime.Add(3*time.Second))
+	// open, out of the range, and of another domain
+	s.insertOpen(domainID, startTime)
+	s.insertClosed(domainID, "type-a", gen.WorkflowExecutionCloseStatusFailed, startTime.Add(-time.Hour))
+	s.insertClosed(uuid.New(), "type-a", gen.WorkflowExecutionCloseStatusFailed, startTime)
+
+	minStartTime := startTime.Add(-time.Minute)
+	maxStartTime := time.Now()
+	counts, err := s.db.CountByCloseStatusFromVisibility(&sqlplugin.VisibilityFilter{
+		DomainID:     domainID,
+		MinStartTime: &minStartTime,
+		MaxStartTime: &maxStartTime,
+	})
+	s.NoError(err)
+	s.Equal(map[int32]int64{
+		int32(gen.WorkflowExecutionCloseStatusCompleted):  2,
+		int32(gen.WorkflowExecutionCloseStatusFailed):     1,
+		int32(gen.WorkflowExecutionCloseStatusTerminated): 1,
+	}, counts)
+
+	_, err = s.db.CountByCloseStatusFromVisibility(&sqlplugin.VisibilityFilter{DomainID: domainID})
+	s.Error(err)
+}
+
+func (s *visibilitySuite) TestCountStartedByBucketFromVisibility() {
+	domainID := uuid.New()
+	minStartTime := time.Now().Truncate(time.Hour).Add(-3 * time.Hour)
+	maxStartTime := minStartTime.Add(3 * time.Hour)
+	// two in the first hour, none in the second, one in the last
+	s.insertClosed(domainID, "type-a", gen.WorkflowExecutionCloseStatusCompleted, minStartTime)
+	s.insertClosed(domainID, "type-a", gen.WorkflowExecutionCloseStatusCompleted, minStartTime.Add(59*time.Minute))
+	s.insertClosed(domainID, "type-a", gen.WorkflowExecutionCloseStatusCompleted, minStartTime.Add(150*time.Minute))
+	// out of the range
+	s.insertClosed(domainID, "type-a", gen.WorkflowExecutionCloseStatusCompleted, maxStartTime)
+
+	counts, err := s.db.CountStartedByBucketFromVisibility(domainID, minStartTime, maxStartTime, time.Hour)
+	s.NoError(err)
+	s.Len(counts, 2)
+	s.True(minStartTime.Equal(counts[0].BucketStart))
+	s.Equal(int64(2), counts[0].Count)
+	s.True(minStartTime.Add(2 * time.Hour).Equal(counts[1].BucketStart))
+	s.Equal(int64(1), counts[1].Count)
+
+	_, err = s.db.CountStartedByBucketFromVisibility(domainID, minStartTime, maxStartTime, 0)
+	s.Error(err)
+}
+
+func (s *visibilitySuite) TestListWorkflowTypesFromVisibility() {
+	domainID := uuid.New()
+	startTime := time.Now().Add(-time.Hour)
+	s.insertClosed(domainID, "type-b", gen.WorkflowExecutionCloseStatusCompleted, startTime)
+	s.insertClosed(domainID, "type-c", gen.WorkflowExecutionCloseStatusCompleted, startTime)
+	s.insertClosed(domainID, "type-c", gen.WorkflowExecutionCloseStatusFailed, startTime)
+	s.insertClosed(domainID, "type-a", gen.WorkflowExecutionCloseStatusCompleted, startTime)
+	// other domains are left out
+	s.insertClosed(uuid.New(), "type-d", gen.WorkflowExecutionCloseStatusCompleted, startTime)
+
+	workflowTypes, err := s.db.ListWorkflowTypesFromVisibility(domainID, 10)
+	s.NoError(err)
+	s.Equal([]string{"type-c", "type-a", "type-b"}, workflowTypes)
+
+	workflowTypes, err = s.db.ListWorkflowTypesFromVisibility(domainID, 1)
+	s.NoError(err)
+	s.Equal([]string{"type-c"}, workflowTypes)
+
+	_, err = s.db.ListWorkflowTypesFromVisibility(domainID, 0)
+	s.Error(err)
+}
+
+func (s *visibilitySuite) TestCloseBeforeStart() {
+	domainID := uuid.New()
+	startTime := time.Now().Add(-time.Hour)
+	closed := s.insertClosed(domainID, "type-a", gen.WorkflowExecutionCloseStatusCompleted, startTime)
+
+	// the late start finds the closed row
+	result, err := s.db.InsertIntoVisibility(&sqlplugin.VisibilityRow{
+		DomainID:         domainID,
+		WorkflowID:       closed.WorkflowID,
+		RunID:            closed.RunID,
+		StartTime:        startTime,
+		ExecutionTime:    startTime,
+		WorkflowTypeName: "type-a",
+		Memo:             []byte("memo of start"),
+		Encoding:         string(common.EncodingTypeThriftRW),
+	})
+	s.NoError(err)
+	rowsAffected, err := result.RowsAffected()
+	s.NoError(err)
+	s.Equal(int64(0), rowsAffected)
+
+	s.assertClosed(domainID, closed.RunID, gen.WorkflowExecutionCloseStatusCompleted)
+	minStartTime := startTime.Add(-time.Minute)
+	maxStartTime := time.Now()
+	rows, err := s.db.SelectFromVisibility(&sqlplugin.VisibilityFilter{
+		DomainID:     domainID,
+		WorkflowID:   common.StringPtr(closed.WorkflowID),
+		MinStartTime: &minStartTime,
+		MaxStartTime: &maxStartTime,
+		RunID:        common.StringPtr(""),
+		PageSize:     common.IntPtr(10),
+	})
+	s.NoError(err)
+	s.Empty(rows)
+}
+
+func (s *visibilitySuite) TestStartRedeliveredAfterClose() {
+	domainID := uuid.New()
+	startTime := time.Now().Add(-time.Hour)
+	start := func(runID, workflowID string) {
+		_, err := s.db.InsertIntoVisibility(&sqlplugin.VisibilityRow{
+			DomainID:         domainID,
+			WorkflowID:       workflowID,
+			RunID:            runID,
+			StartTime:        startTime,
+			ExecutionTime:    startTime,
+			WorkflowTypeName: "type-a",
+			Encoding:         string(common.EncodingTypeThriftRW),
+		})
+		s.NoError(err)
+	}
+
+	runID := uuid.New()
+	workflowID := uuid.New()
+	start(runID, workflowID)
+	closeTime := startTime.Add(time.Minute)
+	_, err := s.db.ReplaceIntoVisibility(&sqlplugin.VisibilityRow{
+		DomainID:         domainID,
+		WorkflowID:       workflowID,
+		RunID:            runID,
+		StartTime:        startTime,
+		ExecutionTime:    startTime,
+		WorkflowTypeName: "type-a",
+		CloseTime:        &closeTime,
+		CloseStatus:      common.Int32Ptr(int32(gen.WorkflowExecutionCloseStatusFailed)),
+		HistoryLength:    common.Int64Ptr(10),
+		Encoding:         string(common.EncodingTypeThriftRW),
+	})
+	s.NoError(err)
+	start(runID, workflowID)
+
+	s.assertClosed(domainID, runID, gen.WorkflowExecutionCloseStatusFailed)
+}
+
+func (s *visibilitySuite) TestSelectOpenOlderThan() {
+	domainID := uuid.New()
+	// postgres keeps microseconds, so that the start time of a row is read back as is for the next page
+	oldest := time.Now().Add(-3 * time.Hour).Truncate(time.Second)
+	older := oldest.Add(time.Hour)
+	oldestRunID := s.insertOpen(domainID, oldest)
+	// the two rows sharing the same start time straddle the page boundary
+	olderRunIDs := []string{s.insertOpen(domainID, older), s.insertOpen(domainID, older)}
+	sort.Strings(olderRunIDs)
+	s.insertOpen(domainID, time.Now().Add(-time.Minute))
+	s.insertClosed(domainID, "type-a", gen.WorkflowExecutionCloseStatusFailed, oldest)
+
+	maxOpenAge := time.Hour
+	filter := &sqlplugin.VisibilityFilter{
+		DomainID:   domainID,
+		MaxOpenAge: &maxOpenAge,
+		PageSize:   common.IntPtr(2),
+	}
+	rows, err := s.db.SelectFromVisibility(filter)
+	s.NoError(err)
+	s.Len(rows, 2)
+	s.Equal(oldestRunID, rows[0].RunID)
+	s.Equal(olderRunIDs[0], rows[1].RunID)
+
+	filter.Cursor = sqlplugin.NextVisibilityCursor(rows)
+	rows, err = s.db.SelectFromVisibility(filter)
+	s.NoError(err)
+	s.Len(rows, 1)
+	s.Equal(olderRunIDs[1], rows[0].RunID)
+
+	filter.Cursor = sqlplugin.NextVisibilityCursor(rows)
+	rows, err = s.db.SelectFromVisibility(filter)
+	s.NoError(err)
+	s.Empty(rows)
+}
+
+func (s *visibilitySuite) insertOpen(domainID string, startTime time.Time) string {
+	runID := uuid.New()
+	_, err := s.db.InsertIntoVisibility(&sqlplugin.VisibilityRow{
+		DomainID:         domainID,
+		WorkflowID:       uuid.New(),
+		RunID:            runID,
+		StartTime:        startTime,
+		ExecutionTime:    startTime,
+		WorkflowTypeName: "type-a",
+		Encoding:         string(common.EncodingTypeThriftRW),
+	})
+	s.NoError(err)
+	return runID
+}
+
+func (s *visibilitySuite) TestSelectReadFromPrimary() {
+	domainID := uuid.New()
+	startTime := time.Now().Add(-time.Hour)
+	openRunID := s.insertOpen(domainID, startTime)
+	closed := s.insertClosed(domainID, "type-a", gen.WorkflowExecutionCloseStatusFailed, startTime)
+
+	minStartTime := startTime.Add(-time.Minute)
+	maxStartTime := time.Now()
+	rows, err := s.db.SelectFromVisibility(&sqlplugin.VisibilityFilter{
+		DomainID:        domainID,
+		MinStartTime:    &minStartTime,
+		MaxStartTime:    &maxStartTime,
+		RunID:           common.StringPtr(""),
+		PageSize:        common.IntPtr(10),
+		ReadFromPrimary: true,
+	})
+	s.NoError(err)
+	s.Len(rows, 1)
+	s.Equal(openRunID, rows[0].RunID)
+
+	rows, err = s.db.SelectFromVisibility(&sqlplugin.VisibilityFilter{
+		DomainID:        domainID,
+		Closed:          true,
+		RunID:           common.StringPtr(closed.RunID),
+		ReadFromPrimary: true,
+	})
+	s.NoError(err)
+	s.Len(rows, 1)
+	s.Equal(closed.RunID, rows[0].RunID)
+}
+
+func (s *visibilitySuite) TestSelectByRunID() {
+	domainID := uuid.New()
+	openRunID := s.insertOpen(domainID, time.Now().Add(-time.Hour))
+	closed := s.insertClosed(domainID, "type-a", gen.WorkflowExecutionCloseStatusFailed, time.Now().Add(-time.Hour))
+
+	rows, err := s.db.SelectFromVisibility(&sqlplugin.VisibilityFilter{
+		DomainID: domainID,
+		RunID:    common.StringPtr(openRunID),
+	})
+	s.NoError(err)
+	s.Len(rows, 1)
+	s.Equal(openRunID, rows[0].RunID)
+	s.Nil(rows[0].CloseStatus)
+
+	rows, err = s.db.SelectFromVisibility(&sqlplugin.VisibilityFilter{
+		DomainID: domainID,
+		RunID:    common.StringPtr(closed.RunID),
+	})
+	s.NoError(err)
+	s.Len(rows, 1)
+	s.Equal(closed.RunID, rows[0].RunID)
+	s.Equal(int32(gen.WorkflowExecutionCloseStatusFailed), *rows[0].CloseStatus)
+
+	_, err = s.db.SelectFromVisibility(&sqlplugin.VisibilityFilter{
+		DomainID: domainID,
+		RunID:    common.StringPtr(uuid.New()),
+	})
+	s.Equal(gosql.ErrNoRows, err)
+}
+
+func (s *visibilitySuite) TestClosedTemplatesPopulateAllFields() {
+	domainID := uuid.New()
+	startTime := time.Now().Add(-time.Hour)
+	closeTime := startTime.Add(time.Minute)
+	expected := &sqlplugin.VisibilityRow{
+		DomainID:         domainID,
+		WorkflowID:       uuid.New(),
+		RunID:            uuid.New(),
+		StartTime:        startTime,
+		ExecutionTime:    startTime,
+		WorkflowTypeName: "type-a",
+		CloseTime:        &closeTime,
+		CloseStatus:      common.Int32Ptr(int32(gen.WorkflowExecutionCloseStatusFailed)),
+		HistoryLength:    common.Int64Ptr(10),
+		Memo:             []byte("memo"),
+		Encoding:         string(common.EncodingTypeThriftRW),
+	}
+	_, err := s.db.ReplaceIntoVisibility(expected)
+	s.NoError(err)
+
+	minStartTime := startTime.Add(-time.Minute)
+	rangeFilter := func() sqlplugin.VisibilityFilter {
+		maxStartTime := time.Now()
+		return sqlplugin.VisibilityFilter{
+			DomainID:     domainID,
+			Closed:       true,
+			MinStartTime: &minStartTime,
+			MaxStartTime: &maxStartTime,
+			RunID:        common.StringPtr(""),
+			PageSize:     common.IntPtr(10),
+		}
+	}
+	byID := rangeFilter()
+	byID.WorkflowID = common.StringPtr(expected.WorkflowID)
+	byType := rangeFilter()
+	byType.WorkflowTypeName = common.StringPtr("type-a")
+	byStatus := rangeFilter()
+	byStatus.CloseStatus = expected.CloseStatus
+	byTypeAndStatus := byType
+	byTypeAndStatus.CloseStatus = expected.CloseStatus
+	filters := map[string]sqlplugin.VisibilityFilter{
+		"closed":                  rangeFilter(),
+		"closed by ID":            byID,
+		"closed by type":          byType,
+		"closed by status":        byStatus,
+		"closed by type & status": byTypeAndStatus,
+		"closed by run ID":        {DomainID: domainID, RunID: common.StringPtr(expected.RunID), Closed: true},
+		"by run ID":               {DomainID: domainID, RunID: common.StringPtr(expected.RunID)},
+	}
+	for name, filter := range filters {
+		filter := filter
+		rows, err := s.db.SelectFromVisibility(&filter)
+		s.NoError(err, name)
+		s.Len(rows, 1, name)
+		row := rows[0]
+		s.Equal(expected.WorkflowID, row.WorkflowID, name)
+		s.Equal(expected.RunID, row.RunID, name)
+		s.WithinDuration(startTime, row.StartTime, time.Second, name)
+		s.WithinDuration(startTime, row.ExecutionTime, time.Second, name)
+		s.Equal(expected.WorkflowTypeName, row.WorkflowTypeName, name)
+		s.Equal(expected.Memo, row.Memo, name)
+		s.Equal(expected.Encoding, row.Encoding, name)
+		s.NotNil(row.CloseTime, name)
+		s.WithinDuration(closeTime, *row.CloseTime, time.Second, name)
+		s.Equal(*expected.CloseStatus, *row.CloseStatus, name)
+		s.Equal(*expected.HistoryLength, *row.HistoryLength, name)
+	}
+}
+
+func (s *visibilitySuite) TestSelectWithMinimalProjection() {
+	domainID := uuid.New()
+	startTime := time.Now().Add(-time.Hour)
+	expected := s.insertClosed(domainID, "type-a", gen.WorkflowExecutionCloseStatusFailed, startTime)
+
+	minStartTime := startTime.Add(-time.Minute)
+	maxStartTime := time.Now()
+	rows, err := s.db.SelectFromVisibility(&sqlplugin.VisibilityFilter{
+		DomainID:         domainID,
+		Closed:           true,
+		MinStartTime:     &minStartTime,
+		MaxStartTime:     &maxStartTime,
+		RunID:            common.StringPtr(""),
+		PageSize:         common.IntPtr(10),
+		ColumnProjection: sqlplugin.VisibilityColumnsMinimal,
+	})
+	s.NoError(err)
+	s.Len(rows, 1)
+	s.Equal(expected.RunID, rows[0].RunID)
+	s.Equal(expected.WorkflowID, rows[0].WorkflowID)
+	s.Equal(int32(gen.WorkflowExecutionCloseStatusFailed), *rows[0].CloseStatus)
+	s.Empty(rows[0].WorkflowTypeName)
+	s.Empty(rows[0].Encoding)
+	s.Nil(rows[0].CloseTime)
+}
+
+func (s *visibilitySuite) TestMinimalTemplatesLeaveOutMemo() {
+	for query, minimalQuery := range minimalTemplates {
+		s.Contains(query, "memo")
+		s.NotContains(minimalQuery, "memo")
+	}
+}
+
+func (s *visibilitySuite) TestSelectByTypeCaseInsensitive() {
+	domainID := uuid.New()
+	startTime := time.Now().Add(-time.Hour)
+	s.insertClosed(domainID, "Type-A", gen.WorkflowExecutionCloseStatusFailed, startTime)
+	s.insertClosed(domainID, "TYPE-A", gen.WorkflowExecutionCloseStatusCompleted, startTime.Add(time.Second))
+	s.insertClosed(domainID, "type-a", gen.WorkflowExecutionCloseStatusFailed, startTime.Add(2*time.Second))
+	s.insertClosed(domainID, "type-b", gen.WorkflowExecutionCloseStatusFailed, startTime.Add(3*time.Second))
+	_, err := s.db.InsertIntoVisibility(&sqlplugin.VisibilityRow{
+		DomainID:         domainID,
+		WorkflowID:       uuid.New(),
+		RunID:            uuid.New(),
+		StartTime:        startTime,
+		ExecutionTime:    startTime,
+		WorkflowTypeName: "tYpE-a",
+		Encoding:         string(common.EncodingTypeThriftRW),
+	})
+	s.NoError(err)
+
+	minStartTime := startTime.Add(-time.Minute)
+	maxStartTime := time.Now()
+	newFilter := func(closed bool, closeStatus *int32, caseInsensitive bool) *sqlplugin.VisibilityFilter {
+		return &sqlplugin.VisibilityFilter{
+			DomainID:                    domainID,
+			Closed:                      closed,
+			WorkflowTypeName:            common.StringPtr("type-A"),
+			CloseStatus:                 closeStatus,
+			MinStartTime:                &minStartTime,
+			MaxStartTime:                &maxStartTime,
+			RunID:                       common.StringPtr(""),
+			PageSize:                    common.IntPtr(10),
+			WorkflowTypeCaseInsensitive: caseInsensitive,
+		}
+	}
+	failed := common.Int32Ptr(int32(gen.WorkflowExecutionCloseStatusFailed))
+
+	rows, err := s.db.SelectFromVisibility(newFilter(true, nil, false))
+	s.NoError(err)
+	s.Empty(rows)
+	rows, err = s.db.SelectFromVisibility(newFilter(true, nil, true))
+	s.NoError(err)
+	s.Len(rows, 3)
+	var types []string
+	for _, row := range rows {
+		types = append(types, row.WorkflowTypeName)
+	}
+	s.ElementsMatch([]string{"Type-A", "TYPE-A", "type-a"}, types)
+
+	rows, err = s.db.SelectFromVisibility(newFilter(true, failed, false))
+	s.NoError(err)
+	s.Empty(rows)
+	rows, err = s.db.SelectFromVisibility(newFilter(true, failed, true))
+	s.NoError(err)
+	s.Len(rows, 2)
+	for _, row := range rows {
+		s.Equal(int32(gen.WorkflowExecutionCloseStatusFailed), *row.CloseStatus)
+	}
+
+	rows, err = s.db.SelectFromVisibility(newFilter(false, nil, true))
+	s.NoError(err)
+	s.Len(rows, 1)
+	s.Equal("tYpE-a", rows[0].WorkflowTypeName)
+
+	minimal := newFilter(true, nil, true)
+	minimal.ColumnProjection = sqlplugin.VisibilityColumnsMinimal
+	rows, err = s.db.SelectFromVisibility(minimal)
+	s.NoError(err)
+	s.Len(rows, 3)
+	s.Empty(rows[0].WorkflowTypeName)
+}
+
+func (s *visibilitySuite) assertClosed(domainID, runID string, status gen.WorkflowExecutionCloseStatus) {
+	rows, err := s.db.SelectFromVisibility(&sqlplugin.VisibilityFilter{
+		DomainID: domainID,
+		RunID:    common.StringPtr(runID),
+		Closed:   true,
+	})
+	s.NoError(err)
+	s.Len(rows, 1)
+	s.Equal(int32(status), *rows[0].CloseStatus)
+	s.NotNil(rows[0].CloseTime)
+	s.Equal(int64(10), *rows[0].HistoryLength)
+}
+
+func (s *visibilitySuite) insertClosed(
+	domainID string,
+	workflowType string,
+	status gen.WorkflowExecutionCloseStatus,
+	startTime time.Time,
+) *sqlplugin.VisibilityRow {
+	closeTime := startTime.Add(time.Minute)
+	row := &sqlplugin.VisibilityRow{
+		DomainID:         domainID,
+		WorkflowID:       uuid.New(),
+		RunID:            uuid.New(),
+		StartTime:        startTime,
+		ExecutionTime:    startTime,
+		WorkflowTypeName: workflowType,
+		CloseTime:        &closeTime,
+		CloseStatus:      common.Int32Ptr(int32(status)),
+		HistoryLength:    common.Int64Ptr(10),
+		Encoding:         string(common.EncodingTypeThriftRW),
+	}
+	_, err := s.db.ReplaceIntoVisibility(row)
+	s.NoError(err)
+	return row
+}
+
+func (s *visibilitySuite) TestExplainSlowQuery() {
+	cfg := *s.DefaultTestCluster.Config().DataStores[s.DefaultTestCluster.Config().VisibilityStore].SQL
+	cfg.ExplainSlowVisibilityQueries = true
+	cfg.SlowVisibilityQueryThreshold = time.Nanosecond
+	db, err := sql.NewSQLDB(&cfg)
+	s.NoError(err)
+	defer db.Close()
+	planCh := make(chan string, 1)
+	db.SetQueryPlanObserver(func(queryKind string, latency time.Duration, plan string, err error) {
+		s.Equal(sqlplugin.VisibilityQueryKindClosedByRunID, queryKind)
+		s.NoError(err)
+		planCh <- plan
+	})
+
+	closed := s.insertClosed(uuid.New(), "type-a", gen.WorkflowExecutionCloseStatusFailed, time.Now().Add(-time.Hour))
+	rows, err := db.SelectFromVisibility(&sqlplugin.VisibilityFilter{
+		DomainID: closed.DomainID,
+		RunID:    common.StringPtr(closed.RunID),
+		Closed:   true,
+	})
+	s.NoError(err)
+	s.Len(rows, 1)
+	select {
+	case plan := <-planCh:
+		s.Contains(plan, "Execution Time")
+	case <-time.After(10 * time.Second):
+		s.Fail("slow query is not explained")
+	}
+}
+
+func (s *visibilitySuite) TestConnPoolObserver() {
+	cfg := *s.DefaultTestCluster.Config().DataStores[s.DefaultTestCluster.Config().VisibilityStore].SQL
+	conn, err := sql.NewSQLDB(&cfg)
+	s.NoError(err)
+	conn.(*db).connPoolReportInterval = 10 * time.Millisecond
+	statsCh := make(chan struct{}, 1)
+	conn.SetConnPoolObserver(func(stats gosql.DBStats) {
+		select {
+		case statsCh <- struct{}{}:
+		default:
+		}
+	})
+
+	_, err = conn.SelectFromVisibility(&sqlplugin.VisibilityFilter{
+		DomainID: uuid.New(),
+		RunID:    common.StringPtr(uuid.New()),
+	})
+	s.NoError(err)
+	select {
+	case <-statsCh:
+	case <-time.After(10 * time.Second):
+		s.Fail("connection pool stats are not reported")
+	}
+	s.NoError(conn.Close())
+}
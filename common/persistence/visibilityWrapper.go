@@ -68,7 +68,7 @@ func (v *visibilityManagerWrapper) RecordWorkflowExecutionStarted(request *Recor
 	switch v.advancedVisWritingMode() {
 	case common.AdvancedVisibilityWritingModeOff:
 		return v.visibilityManager.RecordWorkflowExecutionStarted(request)
-	case common.AdvancedVisibilityWritingModeOn:
+	case common.AdvancedVisibilityWritingModeOn, common.AdvancedVisibilityWritingModeMirror:
 		return v.esVisibilityManager.RecordWorkflowExecutionStarted(request)
 	case common.AdvancedVisibilityWritingModeDual:
 		if err := v.esVisibilityManager.RecordWorkflowExecutionStarted(request); err != nil {
@@ -86,7 +86,7 @@ func (v *visibilityManagerWrapper) RecordWorkflowExecutionClosed(request *Record
 	switch v.advancedVisWritingMode() {
 	case common.AdvancedVisibilityWritingModeOff:
 		return v.visibilityManager.RecordWorkflowExecutionClosed(request)
-	case common.AdvancedVisibilityWritingModeOn:
+	case common.AdvancedVisibilityWritingModeOn, common.AdvancedVisibilityWritingModeMirror:
 		return v.esVisibilityManager.RecordWorkflowExecutionClosed(request)
 	case common.AdvancedVisibilityWritingModeDual:
 		if err := v.esVisibilityManager.RecordWorkflowExecutionClosed(request); err != nil {
@@ -219,6 +219,21 @@ type (
 		// NumShards is the number of storage shards to use for tables
 		// in a sharded sql database. The default value for this param is 1
 		NumShards int `yaml:"nShards"`
+		// ShardLockTimeout is the max time to wait for the lock on a shard row, zero means wait indefinitely.
+		// This is currently only honored by postgres plugin
+		ShardLockTimeout time.Duration `yaml:"shardLockTimeout"`
+		// ReadReplicaConnectAddr is the optional addr of a read replica to serve the visibility reads, the other
+		// queries always go to ConnectAddr. As the replica lags behind, a just started or closed workflow may not be
+		// listed for a short while. This is currently only honored by postgres plugin
+		ReadReplicaConnectAddr string `yaml:"readReplicaConnectAddr"`
+		// ExplainSlowVisibilityQueries logs the plan of every visibility query slower than SlowVisibilityQueryThreshold.
+		// It's only meant for debugging as EXPLAIN ANALYZE executes the query once more. This is currently only
+		// honored by postgres plugin
+		ExplainSlowVisibilityQueries bool `yaml:"explainSlowVisibilityQueries"`
+		// SlowVisibilityQueryThreshold is the latency over which a visibility query is explained, default to 1s
+		SlowVisibilityQueryThreshold time.Duration `yaml:"slowVisibilityQueryThreshold"`
+		// TLS is the configuration for TLS connections
+		TLS *auth.TLS `yaml:"tls"`
 	}
 
 	// Replicator describes the configuration of replicator
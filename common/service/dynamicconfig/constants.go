@@ -67,6 +67,15 @@ var keys = map[Key]string{
 	MaxDecisionStartToCloseSeconds:      "system.maxDecisionStartToCloseSeconds",
 	DisallowQuery:                       "system.disallowQuery",
 	EnableBatcher:                       "worker.enableBatcher",
+	EnableReplicator:                    "worker.enableReplicator",
+	WorkerBatcherMaxConcurrency:         "worker.batcherMaxConcurrency",
+	WorkerBatcherMaxProcessors:          "worker.batcherMaxProcessors",
+	WorkerBatcherRPS:                    "worker.batcherRPS",
+	WorkerBatcherTaskListShards:         "worker.batcherTaskListShards",
+	WorkerBatcherTaskListPerCluster:     "worker.batcherTaskListPerCluster",
+	WorkerBatcherNamedQueries:           "worker.batcherNamedQueries",
+	WorkerBatcherMaxActivities:          "worker.batcherMaxActivities",
+	WorkerBatcherMaxDecisions:           "worker.batcherMaxDecisions",
 	EnableParentClosePolicyWorker:       "system.enableParentClosePolicyWorker",
 	EnableStickyQuery:                   "system.enableStickyQuery",
 
@@ -137,6 +146,8 @@ var keys = map[Key]string{
 	EventsCacheTTL:                                        "history.eventsCacheTTL",
 	AcquireShardInterval:                                  "history.acquireShardInterval",
 	AcquireShardConcurrency:                               "history.acquireShardConcurrency",
+	ShardEngineInitSlowThreshold:                          "history.shardEngineInitSlowThreshold",
+	EnableStrictShardRangeIDCheck:                         "history.enableStrictShardRangeIDCheck",
 	StandbyClusterDelay:                                   "history.standbyClusterDelay",
 	StandbyTaskMissingEventsResendDelay:                   "history.standbyTaskMissingEventsResendDelay",
 	StandbyTaskMissingEventsDiscardDelay:                  "history.standbyTaskMissingEventsDiscardDelay",
@@ -233,6 +244,10 @@ var keys = map[Key]string{
 	WorkerTimeLimitPerArchivalIteration:             "worker.TimeLimitPerArchivalIteration",
 	WorkerThrottledLogRPS:                           "worker.throttledLogRPS",
 	ScannerPersistenceMaxQPS:                        "worker.scannerPersistenceMaxQPS",
+	ScannerExcludedDomains:                          "worker.scannerExcludedDomains",
+	ScannerVisibilityDriftScanEnabled:               "worker.scannerVisibilityDriftScanEnabled",
+	ScannerVisibilityDriftDomainSampleSize:          "worker.scannerVisibilityDriftDomainSampleSize",
+	ScannerVisibilityDriftMaxWorkflowsPerDomain:     "worker.scannerVisibilityDriftMaxWorkflowsPerDomain",
 }
 
 const (
@@ -417,6 +432,10 @@ const (
 	AcquireShardInterval
 	// AcquireShardConcurrency is number of goroutines that can be used to acquire shards in the shard controller.
 	AcquireShardConcurrency
+	// ShardEngineInitSlowThreshold is the shard engine initialization time above which a warning is logged
+	ShardEngineInitSlowThreshold
+	// EnableStrictShardRangeIDCheck refuses to acquire a shard whose range ID jumps unexpectedly since this host held it
+	EnableStrictShardRangeIDCheck
 	// StandbyClusterDelay is the artificial delay added to standby cluster's view of active cluster's time
 	StandbyClusterDelay
 	// StandbyTaskMissingEventsResendDelay is the amount of time standby cluster's will wait (if events are missing)
@@ -592,8 +611,45 @@ const (
 	WorkerThrottledLogRPS
 	// ScannerPersistenceMaxQPS is the maximum rate of persistence calls from worker.Scanner
 	ScannerPersistenceMaxQPS
+	// ScannerExcludedDomains is the list of domain names that the worker.Scanner leaves alone
+	ScannerExcludedDomains
+	// ScannerVisibilityDriftScanEnabled decides whether the worker.Scanner runs the visibility drift scan job,
+	// it is read when the worker starts
+	ScannerVisibilityDriftScanEnabled
+	// ScannerVisibilityDriftDomainSampleSize is the number of domains sampled by each run of the visibility drift scan job
+	ScannerVisibilityDriftDomainSampleSize
+	// ScannerVisibilityDriftMaxWorkflowsPerDomain is the max number of open visibility records checked per sampled domain
+	ScannerVisibilityDriftMaxWorkflowsPerDomain
 	// EnableBatcher decides whether start batcher in our worker
 	EnableBatcher
+	// EnableReplicator decides whether start replicator in our worker, it only takes effect when global domain is enabled
+	EnableReplicator
+	// WorkerBatcherMaxConcurrency is the max number of batch operation tasks processed concurrently across all batch jobs of a worker,
+	// it's read once when the worker starts so a change takes effect after a restart, zero means no limit
+	WorkerBatcherMaxConcurrency
+	// WorkerBatcherMaxProcessors is the max number of task processor goroutines across all batch jobs of a worker,
+	// the batch jobs run with fewer processors than their concurrency when there are too many of them at once,
+	// zero means no limit
+	WorkerBatcherMaxProcessors
+	// WorkerBatcherRPS is the max rate of batch operations across all batch jobs of a worker, zero means no limit
+	WorkerBatcherRPS
+	// WorkerBatcherTaskListShards is the number of batcher tasklists a worker polls batch activities from, batch jobs
+	// can then spread across them by BatchParams.TaskListShard. Raise it when a single tasklist becomes the bottleneck
+	// of batch throughput, and keep it same on all workers so that every shard has pollers
+	WorkerBatcherTaskListShards
+	// WorkerBatcherTaskListPerCluster makes the batcher workers poll a tasklist of their own cluster, so that the batch
+	// jobs of a cluster don't run on the workers of another one. Batch jobs then must be started on the tasklist of the
+	// cluster, e.g. with the cluster flag of CLI
+	WorkerBatcherTaskListPerCluster
+	// WorkerBatcherNamedQueries is the map of query name to query template that batch jobs can reference by
+	// BatchParams.QueryName, so that the vetted queries don't have to be typed in again for every batch job
+	WorkerBatcherNamedQueries
+	// WorkerBatcherMaxActivities is the max number of batch activities, i.e. batch jobs, a worker runs at a time on
+	// every batcher tasklist regardless of the concurrency of the batch jobs. Raise it when the batch activities wait
+	// long to be picked up on clusters running many batch jobs
+	WorkerBatcherMaxActivities
+	// WorkerBatcherMaxDecisions is the max number of decision tasks a worker runs at a time on every batcher tasklist
+	WorkerBatcherMaxDecisions
 	// EnableParentClosePolicyWorker decides whether or not enable system workers for processing parent close policy task
 	EnableParentClosePolicyWorker
 	// EnableStickyQuery indicates if sticky query should be enabled per domain
@@ -691,6 +691,7 @@ func (c *cadenceImpl) startWorkerIndexer(params *service.BootstrapParams, servic
 		c.messagingClient,
 		c.esClient,
 		c.esConfig,
+		nil, // onebox runs in dual mode, where history writes the db visibility records
 		c.logger,
 		service.GetMetricsClient())
 	if err := c.indexer.Start(); err != nil {
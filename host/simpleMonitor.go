@@ -74,3 +74,7 @@ func (s *simpleMonitor) RemoveListener(service string, name string) error {
 func (s *simpleMonitor) GetReachableMembers() ([]string, error) {
 	return nil, nil
 }
+
+func (s *simpleMonitor) EvictSelf() error {
+	return nil
+}
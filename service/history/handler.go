@@ -135,6 +135,11 @@ func (h *Handler) Start() {
 	h.startWG.Done()
 }
 
+// PrepareToStop hands off the shards of this host to the other hosts before it stops
+func (h *Handler) PrepareToStop() {
+	h.controller.PrepareToStop()
+}
+
 // Stop stops the handler
 func (h *Handler) Stop() {
 	h.replicationTaskFetchers.Stop()
@@ -657,13 +662,19 @@ func (h *Handler) RemoveTask(
 	return err
 }
 
-// CloseShard returns information about the internal states of a history host
+// CloseShard closes the engine of a shard, the shard is reacquired right away if this host owns it so that a shard
+// engine in a bad state is recovered without waiting for the next request of the shard
 func (h *Handler) CloseShard(
 	ctx context.Context,
 	request *gen.CloseShardRequest,
 ) (retError error) {
-	h.controller.removeEngineForShard(int(request.GetShardID()))
-	return nil
+	shardID := int(request.GetShardID())
+	err := h.controller.ReacquireShard(shardID)
+	if _, ok := err.(*hist.ShardOwnershipLostError); ok {
+		h.controller.removeEngineForShard(shardID)
+		return nil
+	}
+	return err
 }
 
 // DescribeMutableState - returns the internal analysis of workflow execution state
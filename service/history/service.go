@@ -68,9 +68,11 @@ type Config struct {
 	EventsCacheTTL         dynamicconfig.DurationPropertyFn
 
 	// ShardController settings
-	RangeSizeBits           uint
-	AcquireShardInterval    dynamicconfig.DurationPropertyFn
-	AcquireShardConcurrency dynamicconfig.IntPropertyFn
+	RangeSizeBits                 uint
+	AcquireShardInterval          dynamicconfig.DurationPropertyFn
+	AcquireShardConcurrency       dynamicconfig.IntPropertyFn
+	ShardEngineInitSlowThreshold  dynamicconfig.DurationPropertyFn
+	EnableStrictShardRangeIDCheck dynamicconfig.BoolPropertyFn
 
 	// the artificial delay added to standby cluster's view of active cluster's time
 	StandbyClusterDelay                  dynamicconfig.DurationPropertyFn
@@ -223,6 +225,8 @@ func NewConfig(dc *dynamicconfig.Collection, numberOfShards int, storeType strin
 		RangeSizeBits:                                         20, // 20 bits for sequencer, 2^20 sequence number for any range
 		AcquireShardInterval:                                  dc.GetDurationProperty(dynamicconfig.AcquireShardInterval, time.Minute),
 		AcquireShardConcurrency:                               dc.GetIntProperty(dynamicconfig.AcquireShardConcurrency, 1),
+		ShardEngineInitSlowThreshold:                          dc.GetDurationProperty(dynamicconfig.ShardEngineInitSlowThreshold, 10*time.Second),
+		EnableStrictShardRangeIDCheck:                         dc.GetBoolProperty(dynamicconfig.EnableStrictShardRangeIDCheck, false),
 		StandbyClusterDelay:                                   dc.GetDurationProperty(dynamicconfig.StandbyClusterDelay, 5*time.Minute),
 		StandbyTaskMissingEventsResendDelay:                   dc.GetDurationProperty(dynamicconfig.StandbyTaskMissingEventsResendDelay, 15*time.Minute),
 		StandbyTaskMissingEventsDiscardDelay:                  dc.GetDurationProperty(dynamicconfig.StandbyTaskMissingEventsDiscardDelay, 25*time.Minute),
@@ -422,6 +426,7 @@ func (s *Service) Stop() {
 
 	close(s.stopC)
 
+	s.handler.PrepareToStop()
 	s.handler.Stop()
 	s.Resource.Stop()
 
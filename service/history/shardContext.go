@@ -888,6 +888,9 @@ func (s *shardContextImpl) renewRangeLocked(isStealing bool) error {
 	s.transferMaxReadLevel = s.transferSequenceNumber - 1
 	atomic.StoreInt64(&s.rangeID, updatedShardInfo.RangeID)
 	s.shardInfo = updatedShardInfo
+	if s.shardItem != nil && s.shardItem.onRangeRenewed != nil {
+		s.shardItem.onRangeRenewed(updatedShardInfo.RangeID)
+	}
 
 	s.logger.Info("Range updated for shardID",
 		tag.ShardID(s.shardInfo.ShardID),
@@ -1148,6 +1151,9 @@ func acquireShard(shardItem *historyShardsItem, closeCh chan<- int) (ShardContex
 		shardItem.logger.Error("Fail to acquire shard.", tag.ShardID(shardItem.shardID), tag.Error(err))
 		return nil, err
 	}
+	if err := shardItem.checkRangeID(shardInfo); err != nil {
+		return nil, err
+	}
 
 	updatedShardInfo := copyShardInfo(shardInfo)
 	ownershipChanged := shardInfo.Owner != shardItem.GetHostInfo().Identity()
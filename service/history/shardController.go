@@ -26,6 +26,7 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/uber/cadence/.gen/go/shared"
 	"github.com/uber/cadence/common"
 	"github.com/uber/cadence/common/log"
 	"github.com/uber/cadence/common/log/tag"
@@ -53,9 +54,21 @@ type (
 		throttledLogger    log.Logger
 		config             *Config
 		metricsScope       metrics.Scope
+		// coldStart is set while the shards are acquired on Start, the later acquisitions are rebalances
+		coldStart int32
+		rangeIDs  shardRangeIDs
 
 		sync.RWMutex
 		historyShards map[int]*historyShardsItem
+		// releasingShards are the shards removed from historyShards whose engine is being stopped
+		releasingShards map[int]struct{}
+	}
+
+	// shardRangeIDs keeps the last range ID this host held for each shard, it outlives the shard items so that a
+	// reacquired shard can be checked against it
+	shardRangeIDs struct {
+		sync.Mutex
+		rangeIDs map[int]int64
 	}
 
 	historyShardsItemStatus int
@@ -69,10 +82,39 @@ type (
 		throttledLogger log.Logger
 		engineFactory   EngineFactory
 
+		// onAcquired is called with the latency of acquiring the shard and starting its engine
+		onAcquired func(latency time.Duration)
+		// onRangeRenewed is called with the range ID of the shard every time this host renews it
+		onRangeRenewed func(rangeID int64)
+		// lastRangeID is the last range ID of the shard held by this host, 0 if it has not held the shard
+		lastRangeID int64
+		// started is set once the engine is started, it's read without the lock which is held while acquiring
+		started int32
+
 		sync.RWMutex
 		status historyShardsItemStatus
 		engine Engine
 	}
+
+	// ShardState is the state of a shard on a history host
+	ShardState int
+)
+
+const (
+	// ShardStateNotOwned is a shard not loaded on this host
+	ShardStateNotOwned ShardState = iota
+	// ShardStateAcquiring is a shard assigned to this host whose range is not locked or engine not started yet,
+	// including a shard failing to be acquired which is retried on the next acquisition round
+	ShardStateAcquiring
+	// ShardStateOwned is a shard with its engine running on this host
+	ShardStateOwned
+	// ShardStateReleasing is a shard whose engine is being stopped on this host
+	ShardStateReleasing
+)
+
+const (
+	shardAcquisitionTypeColdStart = "cold_start"
+	shardAcquisitionTypeRebalance = "rebalance"
 )
 
 const (
@@ -93,6 +135,7 @@ func newShardController(
 		membershipUpdateCh: make(chan *membership.ChangedEvent, 10),
 		engineFactory:      factory,
 		historyShards:      make(map[int]*historyShardsItem),
+		releasingShards:    make(map[int]struct{}),
 		shardClosedCh:      make(chan int, config.NumberOfShards),
 		shutdownCh:         make(chan struct{}),
 		logger:             resource.GetLogger().WithTags(tag.ComponentShardController, tag.Address(hostIdentity)),
@@ -126,7 +169,9 @@ func (c *shardController) Start() {
 		return
 	}
 
+	atomic.StoreInt32(&c.coldStart, 1)
 	c.acquireShards()
+	atomic.StoreInt32(&c.coldStart, 0)
 	c.shutdownWG.Add(1)
 	go c.shardManagementPump()
 
@@ -140,6 +185,27 @@ func (c *shardController) Stop() {
 		return
 	}
 
+	c.stop()
+}
+
+// PrepareToStop evicts this host from membership and unloads all its shards right away, so that the other hosts
+// acquire them without waiting for this host to be detected as unreachable. The controller is stopped afterwards.
+func (c *shardController) PrepareToStop() {
+	if !atomic.CompareAndSwapInt32(&c.status, common.DaemonStatusStarted, common.DaemonStatusStopped) {
+		return
+	}
+
+	sw := c.metricsScope.StartTimer(metrics.ShardHandoffLatency)
+	defer sw.Stop()
+	c.logger.Info("Handing off shards before stopping.", tag.Number(int64(c.numShards())))
+	// evict first so that this host stops being the owner of its shards while they are unloaded
+	if err := c.GetMembershipMonitor().EvictSelf(); err != nil {
+		c.logger.Error("Error evicting self from membership", tag.Error(err), tag.OperationFailed)
+	}
+	c.stop()
+}
+
+func (c *shardController) stop() {
 	if err := c.GetHistoryServiceResolver().RemoveListener(shardControllerMembershipUpdateListenerName); err != nil {
 		c.logger.Error("Error removing membership update listener", tag.Error(err), tag.OperationFailed)
 	}
@@ -152,11 +218,32 @@ func (c *shardController) Stop() {
 	c.logger.Info("", tag.LifeCycleStopped)
 }
 
+// GetEngine returns the engine of the shard owning the workflow. The shard is derived from the workflowID
+// alone, the same way the history client routes requests and executions are persisted, so the domainID
+// must not take part in it or requests would land on a shard not holding the workflow
 func (c *shardController) GetEngine(workflowID string) (Engine, error) {
 	shardID := c.config.GetShardID(workflowID)
 	return c.getEngineForShard(shardID)
 }
 
+// ReacquireShard stops the engine of a shard owned by this host and recreates it right away,
+// so that a shard engine in a bad state can be recovered without restarting the host
+func (c *shardController) ReacquireShard(shardID int) error {
+	info, err := c.GetHistoryServiceResolver().Lookup(string(shardID))
+	if err != nil {
+		return err
+	}
+	if info.Identity() != c.GetHostInfo().Identity() {
+		return createShardOwnershipLostError(c.GetHostInfo().Identity(), info.GetAddress())
+	}
+
+	c.metricsScope.IncCounter(metrics.ShardItemReacquiredCounter)
+	c.logger.Info("Reacquiring shard.", tag.ShardID(shardID))
+	c.removeEngineForShard(shardID)
+	_, err = c.getEngineForShard(shardID)
+	return err
+}
+
 func (c *shardController) getEngineForShard(shardID int) (Engine, error) {
 	sw := c.metricsScope.StartTimer(metrics.GetEngineForShardLatency)
 	defer sw.Stop()
@@ -173,6 +260,9 @@ func (c *shardController) removeEngineForShard(shardID int) {
 	item, _ := c.removeHistoryShardItem(shardID)
 	if item != nil {
 		item.stopEngine()
+		c.Lock()
+		delete(c.releasingShards, shardID)
+		c.Unlock()
 	}
 }
 
@@ -215,6 +305,11 @@ func (c *shardController) getOrCreateHistoryShardItem(shardID int) (*historyShar
 		if err != nil {
 			return nil, err
 		}
+		shardItem.onAcquired = c.recordShardAcquisitionLatency
+		shardItem.lastRangeID = c.rangeIDs.get(shardID)
+		shardItem.onRangeRenewed = func(rangeID int64) {
+			c.rangeIDs.record(shardID, rangeID)
+		}
 		c.historyShards[shardID] = shardItem
 		c.metricsScope.IncCounter(metrics.ShardItemCreatedCounter)
 
@@ -234,6 +329,7 @@ func (c *shardController) removeHistoryShardItem(shardID int) (*historyShardsIte
 		return nil, fmt.Errorf("No item found to remove for shard: %v", shardID)
 	}
 	delete(c.historyShards, shardID)
+	c.releasingShards[shardID] = struct{}{}
 	nShards = len(c.historyShards)
 	c.Unlock()
 
@@ -247,9 +343,10 @@ func (c *shardController) removeHistoryShardItem(shardID int) (*historyShardsIte
 // shardController. It is responsible for acquiring /
 // releasing shards in response to any event that can
 // change the shard ownership. These events are
-//   a. Ring membership change
-//   b. Periodic ticker
-//   c. ShardOwnershipLostError and subsequent ShardClosedEvents from engine
+//
+//	a. Ring membership change
+//	b. Periodic ticker
+//	c. ShardOwnershipLostError and subsequent ShardClosedEvents from engine
 func (c *shardController) shardManagementPump() {
 
 	defer c.shutdownWG.Done()
@@ -330,6 +427,13 @@ func (c *shardController) acquireShards() {
 	wg.Wait()
 
 	c.metricsScope.UpdateGauge(metrics.NumShardsGauge, float64(c.numShards()))
+	acquiring := 0
+	for _, state := range c.ShardStates() {
+		if state == ShardStateAcquiring {
+			acquiring++
+		}
+	}
+	c.metricsScope.UpdateGauge(metrics.NumAcquiringShardsGauge, float64(acquiring))
 }
 
 func (c *shardController) doShutdown() {
@@ -355,6 +459,37 @@ func (c *shardController) processShardClosedEvents() {
 	}
 }
 
+func (c *shardController) recordShardAcquisitionLatency(latency time.Duration) {
+	acquisitionType := shardAcquisitionTypeRebalance
+	if atomic.LoadInt32(&c.coldStart) == 1 {
+		acquisitionType = shardAcquisitionTypeColdStart
+	}
+	c.metricsScope.Tagged(metrics.AcquisitionTypeTag(acquisitionType)).
+		RecordHistogramDuration(metrics.ShardAcquisitionLatency, latency)
+}
+
+// ShardStates returns the state of every shard on this host, so that the shards being acquired or released
+// during a rebalance can be told apart from the owned ones
+func (c *shardController) ShardStates() map[int]ShardState {
+	states := make(map[int]ShardState, c.config.NumberOfShards)
+	for shardID := 0; shardID < c.config.NumberOfShards; shardID++ {
+		states[shardID] = ShardStateNotOwned
+	}
+	c.RLock()
+	defer c.RUnlock()
+	for shardID, item := range c.historyShards {
+		if atomic.LoadInt32(&item.started) == 1 {
+			states[shardID] = ShardStateOwned
+		} else {
+			states[shardID] = ShardStateAcquiring
+		}
+	}
+	for shardID := range c.releasingShards {
+		states[shardID] = ShardStateReleasing
+	}
+	return states
+}
+
 func (c *shardController) numShards() int {
 	nShards := 0
 	c.RLock()
@@ -387,6 +522,7 @@ func (i *historyShardsItem) getOrCreateEngine(shardClosedCh chan<- int) (Engine,
 	switch i.status {
 	case historyShardsItemStatusInitialized:
 		i.logger.Info("", tag.LifeCycleStarting, tag.ComponentShardEngine)
+		startTime := time.Now()
 		context, err := acquireShard(i, shardClosedCh)
 		if err != nil {
 			return nil, err
@@ -397,8 +533,14 @@ func (i *historyShardsItem) getOrCreateEngine(shardClosedCh chan<- int) (Engine,
 		}
 		i.engine = i.engineFactory.CreateEngine(context)
 		i.engine.Start()
+		latency := time.Since(startTime)
+		i.recordEngineInitLatency(latency)
+		if i.onAcquired != nil {
+			i.onAcquired(latency)
+		}
 		i.logger.Info("", tag.LifeCycleStarted, tag.ComponentShardEngine)
 		i.status = historyShardsItemStatusStarted
+		atomic.StoreInt32(&i.started, 1)
 		return i.engine, nil
 	case historyShardsItemStatusStarted:
 		return i.engine, nil
@@ -409,6 +551,41 @@ func (i *historyShardsItem) getOrCreateEngine(shardClosedCh chan<- int) (Engine,
 	}
 }
 
+func (i *historyShardsItem) recordEngineInitLatency(latency time.Duration) {
+	i.GetMetricsClient().Scope(metrics.ShardInfoScope, metrics.ShardIDTag(i.shardID)).
+		RecordHistogramDuration(metrics.ShardItemEngineInitLatency, latency)
+	if threshold := i.config.ShardEngineInitSlowThreshold(); threshold > 0 && latency > threshold {
+		i.logger.Warn("Shard engine initialization is slow.", tag.ShardEngineInitLatency(latency))
+	}
+}
+
+// checkRangeID compares the range ID read on acquiring the shard with the last one held by this host. The range ID
+// only grows by one on every renewal of its owner, so going backwards, or growing by more than one while this host
+// is still the recorded owner, means the shard was restored from a backup or renewed by a host without taking its
+// ownership. The gap is reported, and the shard is not acquired if EnableStrictShardRangeIDCheck is set
+func (i *historyShardsItem) checkRangeID(shardInfo *persistence.ShardInfo) error {
+	if i.lastRangeID == 0 {
+		return nil
+	}
+	if shardInfo.RangeID >= i.lastRangeID &&
+		(shardInfo.RangeID-i.lastRangeID <= 1 || shardInfo.Owner != i.GetHostInfo().Identity()) {
+		return nil
+	}
+
+	i.GetMetricsClient().Scope(metrics.ShardInfoScope, metrics.ShardIDTag(i.shardID)).
+		IncCounter(metrics.ShardRangeIDGapCounter)
+	i.logger.Error("Unexpected range ID gap on acquiring shard.",
+		tag.ShardRangeID(shardInfo.RangeID),
+		tag.PreviousShardRangeID(i.lastRangeID))
+	if i.config.EnableStrictShardRangeIDCheck() {
+		return &shared.InternalServiceError{
+			Message: fmt.Sprintf("shard %v has range ID %v, inconsistent with the last held range ID %v",
+				i.shardID, shardInfo.RangeID, i.lastRangeID),
+		}
+	}
+	return nil
+}
+
 func (i *historyShardsItem) stopEngine() {
 	i.Lock()
 	defer i.Unlock()
@@ -450,6 +627,21 @@ func (i *historyShardsItem) logInvalidStatus() string {
 	return msg
 }
 
+func (s ShardState) String() string {
+	switch s {
+	case ShardStateNotOwned:
+		return "NotOwned"
+	case ShardStateAcquiring:
+		return "Acquiring"
+	case ShardStateOwned:
+		return "Owned"
+	case ShardStateReleasing:
+		return "Releasing"
+	default:
+		return fmt.Sprintf("ShardState(%d)", int(s))
+	}
+}
+
 func isShardOwnershiptLostError(err error) bool {
 	switch err.(type) {
 	case *persistence.ShardOwnershipLostError:
@@ -458,3 +650,18 @@ func isShardOwnershiptLostError(err error) bool {
 
 	return false
 }
+
+func (r *shardRangeIDs) record(shardID int, rangeID int64) {
+	r.Lock()
+	defer r.Unlock()
+	if r.rangeIDs == nil {
+		r.rangeIDs = make(map[int]int64)
+	}
+	r.rangeIDs[shardID] = rangeID
+}
+
+func (r *shardRangeIDs) get(shardID int) int64 {
+	r.Lock()
+	defer r.Unlock()
+	return r.rangeIDs[shardID]
+}
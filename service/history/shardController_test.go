@@ -21,6 +21,7 @@
 package history
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"sync"
@@ -31,7 +32,11 @@ import (
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
+	"github.com/uber-go/tally"
 
+	h "github.com/uber/cadence/.gen/go/history"
+	"github.com/uber/cadence/.gen/go/shared"
+	"github.com/uber/cadence/common"
 	"github.com/uber/cadence/common/cluster"
 	"github.com/uber/cadence/common/log"
 	"github.com/uber/cadence/common/log/tag"
@@ -340,9 +345,34 @@ func (s *shardControllerSuite) TestAcquireShardRenewSuccess() {
 
 	for shardID := 0; shardID < numShards; shardID++ {
 		s.NotNil(s.shardController.getEngineForShard(shardID))
+		s.Equal(int64(6), s.shardController.rangeIDs.get(shardID))
 	}
 }
 
+func (s *shardControllerSuite) TestCheckRangeID() {
+	item, err := newHistoryShardsItem(s.mockResource, 1, s.mockEngineFactory, s.config)
+	s.NoError(err)
+	otherHost := "other-host"
+
+	// the shard was never held by this host
+	s.NoError(item.checkRangeID(&persistence.ShardInfo{ShardID: 1, Owner: otherHost, RangeID: 100}))
+
+	item.lastRangeID = 10
+	s.NoError(item.checkRangeID(&persistence.ShardInfo{ShardID: 1, Owner: s.hostInfo.Identity(), RangeID: 10}))
+	s.NoError(item.checkRangeID(&persistence.ShardInfo{ShardID: 1, Owner: s.hostInfo.Identity(), RangeID: 11}))
+	// other hosts renewed the shard since
+	s.NoError(item.checkRangeID(&persistence.ShardInfo{ShardID: 1, Owner: otherHost, RangeID: 20}))
+
+	// gaps are only reported unless strict
+	s.NoError(item.checkRangeID(&persistence.ShardInfo{ShardID: 1, Owner: s.hostInfo.Identity(), RangeID: 12}))
+	s.NoError(item.checkRangeID(&persistence.ShardInfo{ShardID: 1, Owner: otherHost, RangeID: 9}))
+
+	s.config.EnableStrictShardRangeIDCheck = dynamicconfig.GetBoolPropertyFn(true)
+	s.Error(item.checkRangeID(&persistence.ShardInfo{ShardID: 1, Owner: s.hostInfo.Identity(), RangeID: 12}))
+	s.Error(item.checkRangeID(&persistence.ShardInfo{ShardID: 1, Owner: otherHost, RangeID: 9}))
+	s.NoError(item.checkRangeID(&persistence.ShardInfo{ShardID: 1, Owner: otherHost, RangeID: 20}))
+}
+
 func (s *shardControllerSuite) TestAcquireShardRenewLookupFailed() {
 	numShards := 2
 	s.config.NumberOfShards = numShards
@@ -505,6 +535,78 @@ func (s *shardControllerSuite) TestHistoryEngineClosed() {
 	s.shardController.Stop()
 }
 
+func (s *shardControllerSuite) TestReacquireShard() {
+	s.config.NumberOfShards = 1
+	shardID := 0
+	oldEngine := NewMockEngine(s.controller)
+	newEngine := NewMockEngine(s.controller)
+	s.setupMocksForAcquireShard(shardID, oldEngine, 5, 6)
+	s.setupMocksForAcquireShard(shardID, newEngine, 6, 7)
+	oldEngine.EXPECT().Stop().Return().Times(1)
+
+	// when shard is initialized, it will use the 2 mock function below to initialize the "current" time of each cluster
+	s.mockClusterMetadata.EXPECT().GetCurrentClusterName().Return(cluster.TestCurrentClusterName).AnyTimes()
+	s.mockClusterMetadata.EXPECT().GetAllClusterInfo().Return(cluster.TestSingleDCClusterInfo).AnyTimes()
+	s.shardController.acquireShards()
+
+	s.Equal(map[int]ShardState{shardID: ShardStateOwned}, s.shardController.ShardStates())
+
+	s.NoError(s.shardController.ReacquireShard(shardID))
+	engine, err := s.shardController.getEngineForShard(shardID)
+	s.NoError(err)
+	s.Equal(newEngine, engine)
+	s.Equal(map[int]ShardState{shardID: ShardStateOwned}, s.shardController.ShardStates())
+}
+
+func (s *shardControllerSuite) TestReacquireShardNotOwned() {
+	shardID := 1
+	s.mockServiceResolver.EXPECT().Lookup(string(shardID)).Return(membership.NewHostInfo("another-host", nil), nil).Times(1)
+
+	err := s.shardController.ReacquireShard(shardID)
+	s.IsType(&h.ShardOwnershipLostError{}, err)
+}
+
+func (s *shardControllerSuite) TestCloseShard() {
+	s.config.NumberOfShards = 1
+	handler := &Handler{Resource: s.mockResource, controller: s.shardController}
+	oldEngine := NewMockEngine(s.controller)
+	newEngine := NewMockEngine(s.controller)
+	s.setupMocksForAcquireShard(0, oldEngine, 5, 6)
+	s.setupMocksForAcquireShard(0, newEngine, 6, 7)
+	oldEngine.EXPECT().Stop().Return().Times(1)
+	s.mockClusterMetadata.EXPECT().GetCurrentClusterName().Return(cluster.TestCurrentClusterName).AnyTimes()
+	s.mockClusterMetadata.EXPECT().GetAllClusterInfo().Return(cluster.TestSingleDCClusterInfo).AnyTimes()
+	s.shardController.acquireShards()
+
+	// the shard owned by this host is reacquired right away
+	s.NoError(handler.CloseShard(context.Background(), &shared.CloseShardRequest{ShardID: common.Int32Ptr(0)}))
+	s.Equal(ShardStateOwned, s.shardController.ShardStates()[0])
+	engine, err := s.shardController.getEngineForShard(0)
+	s.NoError(err)
+	s.Equal(newEngine, engine)
+
+	// the shard owned by another host is only closed
+	s.mockServiceResolver.EXPECT().Lookup(string(1)).Return(membership.NewHostInfo("another-host", nil), nil).Times(1)
+	s.NoError(handler.CloseShard(context.Background(), &shared.CloseShardRequest{ShardID: common.Int32Ptr(1)}))
+	s.Equal(1, s.shardController.numShards())
+}
+
+func (s *shardControllerSuite) TestShardStates() {
+	s.config.NumberOfShards = 4
+	s.shardController = newShardController(s.mockResource, s.mockEngineFactory, s.config)
+	s.shardController.historyShards[0] = &historyShardsItem{shardID: 0, started: 1}
+	s.shardController.historyShards[1] = &historyShardsItem{shardID: 1}
+	s.shardController.releasingShards[2] = struct{}{}
+
+	s.Equal(map[int]ShardState{
+		0: ShardStateOwned,
+		1: ShardStateAcquiring,
+		2: ShardStateReleasing,
+		3: ShardStateNotOwned,
+	}, s.shardController.ShardStates())
+	s.Equal("Acquiring", ShardStateAcquiring.String())
+}
+
 func (s *shardControllerSuite) TestRingUpdated() {
 	numShards := 4
 	s.config.NumberOfShards = numShards
@@ -627,6 +729,63 @@ func (s *shardControllerSuite) TestShardControllerClosed() {
 	workerWG.Wait()
 }
 
+func (s *shardControllerSuite) TestPrepareToStop() {
+	numShards := 2
+	s.config.NumberOfShards = numShards
+	s.shardController = newShardController(s.mockResource, s.mockEngineFactory, s.config)
+	for shardID := 0; shardID < numShards; shardID++ {
+		mockEngine := NewMockEngine(s.controller)
+		s.setupMocksForAcquireShard(shardID, mockEngine, 5, 6)
+		mockEngine.EXPECT().Stop().Times(1)
+	}
+
+	s.mockServiceResolver.EXPECT().AddListener(shardControllerMembershipUpdateListenerName, gomock.Any()).Return(nil).Times(1)
+	s.mockServiceResolver.EXPECT().RemoveListener(shardControllerMembershipUpdateListenerName).Return(nil).Times(1)
+	s.mockResource.MembershipMonitor.EXPECT().EvictSelf().Return(nil).Times(1)
+	// when shard is initialized, it will use the 2 mock function below to initialize the "current" time of each cluster
+	s.mockClusterMetadata.EXPECT().GetCurrentClusterName().Return(cluster.TestCurrentClusterName).AnyTimes()
+	s.mockClusterMetadata.EXPECT().GetAllClusterInfo().Return(cluster.TestSingleDCClusterInfo).AnyTimes()
+	s.shardController.Start()
+	s.Equal(numShards, s.shardController.numShards())
+
+	s.shardController.PrepareToStop()
+	s.Equal(0, s.shardController.numShards())
+	_, err := s.shardController.getEngineForShard(0)
+	s.Error(err)
+	// stopping after the handoff is a no-op
+	s.shardController.Stop()
+}
+
+func (s *shardControllerSuite) TestShardAcquisitionLatencyRecordedOnStart() {
+	numShards := 2
+	s.config.NumberOfShards = numShards
+	scope := tally.NewTestScope("", nil)
+	s.mockResource.MetricsClient = metrics.NewClient(scope, metrics.History)
+	s.shardController = newShardController(s.mockResource, s.mockEngineFactory, s.config)
+	for shardID := 0; shardID < numShards; shardID++ {
+		mockEngine := NewMockEngine(s.controller)
+		s.setupMocksForAcquireShard(shardID, mockEngine, 5, 6)
+		mockEngine.EXPECT().Stop().Times(1)
+	}
+
+	s.mockServiceResolver.EXPECT().AddListener(shardControllerMembershipUpdateListenerName, gomock.Any()).Return(nil).Times(1)
+	s.mockServiceResolver.EXPECT().RemoveListener(shardControllerMembershipUpdateListenerName).Return(nil).Times(1)
+	s.mockClusterMetadata.EXPECT().GetCurrentClusterName().Return(cluster.TestCurrentClusterName).AnyTimes()
+	s.mockClusterMetadata.EXPECT().GetAllClusterInfo().Return(cluster.TestSingleDCClusterInfo).AnyTimes()
+	s.shardController.Start()
+	s.shardController.Stop()
+
+	acquisitions := int64(0)
+	for _, histogram := range scope.Snapshot().Histograms() {
+		if histogram.Name() == "shard_acquisition_latency" && histogram.Tags()["acquisition_type"] == shardAcquisitionTypeColdStart {
+			for _, count := range histogram.Durations() {
+				acquisitions += count
+			}
+		}
+	}
+	s.Equal(int64(numShards), acquisitions)
+}
+
 func (s *shardControllerSuite) setupMocksForAcquireShard(shardID int, mockEngine *MockEngine, currentRangeID,
 	newRangeID int64) {
 
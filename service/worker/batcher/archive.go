@@ -0,0 +1,142 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package batcher
+
+import (
+	"context"
+	"errors"
+
+	h "github.com/uber/cadence/.gen/go/history"
+	"github.com/uber/cadence/.gen/go/shared"
+	"github.com/uber/cadence/client/frontend"
+	"github.com/uber/cadence/common"
+	"github.com/uber/cadence/common/log/tag"
+	"github.com/uber/cadence/common/metrics"
+	"github.com/uber/cadence/common/persistence"
+	"github.com/uber/cadence/service/worker/archiver"
+)
+
+// errTaskArchived is sent over respCh for the terminated workflows which are also sent to archival
+var errTaskArchived = errors.New("task is archived after terminated")
+
+// prepareArchival looks up the history archival config of the target domain for TerminateParams.ArchiveAfter.
+// If archival is unavailable for the cluster or the domain, the batch goes on as terminate only.
+func prepareArchival(
+	ctx context.Context,
+	batchParams BatchParams,
+	client frontend.Client,
+) (BatchParams, error) {
+	if batchParams.BatchType != BatchTypeTerminate || !batchParams.TerminateParams.ArchiveAfter {
+		return batchParams, nil
+	}
+
+	batcher := ctx.Value(batcherContextKey).(*Batcher)
+	if batcher.archiverClient == nil {
+		getActivityLogger(ctx).Warn("Archival is not enabled for the cluster, workflows will be terminated only")
+		return batchParams, nil
+	}
+	resp, err := client.DescribeDomain(ctx, &shared.DescribeDomainRequest{
+		Name: common.StringPtr(batchParams.DomainName),
+	})
+	if err != nil {
+		return batchParams, err
+	}
+	if resp.Configuration.GetHistoryArchivalStatus() != shared.ArchivalStatusEnabled {
+		getActivityLogger(ctx).Warn("Archival is not enabled for the domain, workflows will be terminated only")
+		return batchParams, nil
+	}
+	batchParams._archivalDomainID = resp.DomainInfo.GetUUID()
+	batchParams._historyArchivalURI = resp.Configuration.GetHistoryArchivalURI()
+	return batchParams, nil
+}
+
+// archiveExecution sends the history of a terminated workflow to archival right away instead of waiting for the
+// retention timer. It returns nil if archival has been turned off by prepareArchival, and errTaskArchived otherwise.
+// Children are not archived here, they are still archived when their retention is reached.
+func archiveExecution(
+	ctx context.Context,
+	batchParams BatchParams,
+	execution shared.WorkflowExecution,
+) error {
+	if len(batchParams._historyArchivalURI) == 0 {
+		return nil
+	}
+
+	batcher := ctx.Value(batcherContextKey).(*Batcher)
+	resp, err := batcher.clientBean.GetHistoryClient().GetMutableState(ctx, &h.GetMutableStateRequest{
+		DomainUUID: common.StringPtr(batchParams._archivalDomainID),
+		Execution:  &execution,
+	})
+	if err != nil {
+		return err
+	}
+	closeFailoverVersion, err := getCloseFailoverVersion(resp)
+	if err != nil {
+		return err
+	}
+
+	_, err = batcher.archiverClient.Archive(ctx, &archiver.ClientRequest{
+		ArchiveRequest: &archiver.ArchiveRequest{
+			DomainID:             batchParams._archivalDomainID,
+			DomainName:           batchParams.DomainName,
+			WorkflowID:           execution.GetWorkflowId(),
+			RunID:                execution.GetRunId(),
+			ShardID:              common.WorkflowIDToHistoryShard(execution.GetWorkflowId(), batcher.cfg.NumHistoryShards),
+			BranchToken:          resp.CurrentBranchToken,
+			NextEventID:          resp.GetNextEventId(),
+			CloseFailoverVersion: closeFailoverVersion,
+			URI:                  batchParams._historyArchivalURI,
+			Targets:              []archiver.ArchivalTarget{archiver.ArchiveTargetHistory},
+		},
+		CallerService:        common.WorkerServiceName,
+		AttemptArchiveInline: false, // leave it to the archival workflow so that the batch is not slowed down
+	})
+	if err != nil {
+		getActivityLogger(ctx).Error("Failed to archive terminated workflow",
+			tag.WorkflowID(execution.GetWorkflowId()), tag.WorkflowRunID(execution.GetRunId()), tag.Error(err))
+		return err
+	}
+	batcher.metricsClient.IncCounter(metrics.BatcherScope, metrics.BatcherProcessorArchived)
+	return errTaskArchived
+}
+
+func getCloseFailoverVersion(resp *h.GetMutableStateResponse) (int64, error) {
+	if resp.VersionHistories != nil {
+		versionHistory, err := persistence.NewVersionHistoriesFromThrift(resp.VersionHistories).GetCurrentVersionHistory()
+		if err != nil {
+			return 0, err
+		}
+		lastItem, err := versionHistory.GetLastItem()
+		if err != nil {
+			return 0, err
+		}
+		return lastItem.GetVersion(), nil
+	}
+
+	// workflows without version histories only carry the versions of each cluster
+	closeFailoverVersion := common.EmptyVersion
+	for _, info := range resp.ReplicationInfo {
+		if info.GetVersion() > closeFailoverVersion {
+			closeFailoverVersion = info.GetVersion()
+		}
+	}
+	return closeFailoverVersion, nil
+}
@@ -22,10 +22,12 @@ package batcher
 
 import (
 	"context"
+	"fmt"
 
 	"github.com/opentracing/opentracing-go"
 	"github.com/uber-go/tally"
 	"go.uber.org/cadence/.gen/go/cadence/workflowserviceclient"
+	"go.uber.org/cadence/encoded"
 	"go.uber.org/cadence/worker"
 
 	"github.com/uber/cadence/client"
@@ -34,7 +36,10 @@ import (
 	"github.com/uber/cadence/common/log"
 	"github.com/uber/cadence/common/log/tag"
 	"github.com/uber/cadence/common/metrics"
+	"github.com/uber/cadence/common/persistence"
+	"github.com/uber/cadence/common/quotas"
 	"github.com/uber/cadence/common/service/dynamicconfig"
+	"github.com/uber/cadence/service/worker/archiver"
 )
 
 type (
@@ -43,6 +48,34 @@ type (
 		AdminOperationToken dynamicconfig.StringPropertyFn
 		// ClusterMetadata contains the metadata for this cluster
 		ClusterMetadata cluster.Metadata
+		// MaxConcurrency is the max number of tasks processed concurrently across all batch jobs, it's read once on
+		// startup so a change takes effect after the worker restarts. Default to no limit, same as zero
+		MaxConcurrency dynamicconfig.IntPropertyFn
+		// MaxProcessors is the max number of task processor goroutines across all batch jobs, a batch job starts
+		// fewer processors than its Concurrency when they run out, it's read once on startup. Default to no limit,
+		// same as zero
+		MaxProcessors dynamicconfig.IntPropertyFn
+		// RPS is the max rate of operations across all batch jobs, it's read on every operation. Default to no limit,
+		// same as zero
+		RPS dynamicconfig.IntPropertyFn
+		// TaskListShards is the number of batcher tasklists to poll batch activities from, it's read once on startup
+		TaskListShards dynamicconfig.IntPropertyFn
+		// TaskListPerCluster polls the tasklist of the current cluster from GetBatcherTaskListName instead of
+		// BatcherTaskListName, it's read once on startup
+		TaskListPerCluster dynamicconfig.BoolPropertyFn
+		// NumHistoryShards is the number of history shards of the cluster, used for archiving terminated workflows
+		NumHistoryShards int
+		// NumArchiveSystemWorkflows and ArchiveRequestRPS are used to send archival requests, the same as history
+		NumArchiveSystemWorkflows dynamicconfig.IntPropertyFn
+		ArchiveRequestRPS         dynamicconfig.IntPropertyFn
+		// NamedQueries is the map of query name to query template for BatchParams.QueryName, it's read on every use
+		NamedQueries dynamicconfig.MapPropertyFn
+		// MaxConcurrentActivities and MaxConcurrentDecisions are the max number of activities and decision tasks a
+		// worker runs at a time on every batcher tasklist, they are read once on startup and the client defaults are
+		// used if nil or not positive. A batch job runs a single activity, so MaxConcurrentActivities caps the batch
+		// jobs of a worker and is unrelated to the Concurrency of the batch jobs
+		MaxConcurrentActivities dynamicconfig.IntPropertyFn
+		MaxConcurrentDecisions  dynamicconfig.IntPropertyFn
 	}
 
 	// BootstrapParams contains the set of params needed to bootstrap
@@ -59,30 +92,114 @@ type (
 		TallyScope tally.Scope
 		// ClientBean is an instance of client.Bean for a collection of clients
 		ClientBean client.Bean
+		// ArchiverClient is used for TerminateParams.ArchiveAfter, nil if the cluster is not configured for archival
+		ArchiverClient archiver.Client
+		// ExecutionManagerFn, HistoryManager and VisibilityManager are used to hard delete workflows for
+		// BatchTypeDeleteClosed, which fails if any of them is nil
+		ExecutionManagerFn func(shardID int) (persistence.ExecutionManager, error)
+		HistoryManager     persistence.HistoryManager
+		VisibilityManager  persistence.VisibilityManager
+		// DataConverters are the data converters of the domains with custom encoding keyed by domain name, the
+		// signal input of BatchTypeSignal is encoded by them, and sent as raw bytes to the other domains
+		DataConverters map[string]encoded.DataConverter
+		// HostIdentity is the identity of the worker host, it's recorded along with the reason of every
+		// terminated/canceled/reset workflow and tagged in the logs, so that an operation can be traced to the host
+		HostIdentity string
+		// FormatHostIdentity customizes how HostIdentity is recorded in the reasons. Default to DefaultFormatHostIdentity
+		FormatHostIdentity func(hostIdentity string) string
+		// ResultSink records the result of every completed batch job, the result is not recorded anywhere if it's nil
+		ResultSink ResultSink
+		// Filters are the filters that BatchParams.FilterName can reference keyed by name, they must be the same
+		// on all the batcher workers as the batch activity may run on any of them
+		Filters map[string]FilterFn
 	}
 
 	// Batcher is the background sub-system that execute workflow for batch operations
 	// It is also the context object that get's passed around within the scanner workflows / activities
 	Batcher struct {
-		cfg           Config
-		svcClient     workflowserviceclient.Interface
-		clientBean    client.Bean
-		metricsClient metrics.Client
-		tallyScope    tally.Scope
-		logger        log.Logger
+		cfg            Config
+		svcClient      workflowserviceclient.Interface
+		clientBean     client.Bean
+		archiverClient archiver.Client
+		metricsClient  metrics.Client
+		tallyScope     tally.Scope
+		logger         log.Logger
+		// rateLimiter and concurrencySem are shared by all batch jobs running on this worker,
+		// per job RPS and Concurrency still apply underneath. They are nil if there is no limit
+		rateLimiter    quotas.Limiter
+		concurrencySem chan struct{}
+		// processorSem bounds the task processor goroutines of all batch jobs, nil if there is no limit
+		processorSem chan struct{}
+		// persistence for BatchTypeDeleteClosed
+		executionManagerFn func(shardID int) (persistence.ExecutionManager, error)
+		historyManager     persistence.HistoryManager
+		visibilityManager  persistence.VisibilityManager
+		// dataConverters are keyed by domain name
+		dataConverters map[string]encoded.DataConverter
+		// workerIdentity is the formatted HostIdentity, empty if HostIdentity is not provided
+		workerIdentity string
+		resultSink     ResultSink
+		// filters are keyed by name
+		filters map[string]FilterFn
 	}
 )
 
+// DefaultFormatHostIdentity is the default format of the host identity recorded in the reasons of batch operations
+func DefaultFormatHostIdentity(hostIdentity string) string {
+	return fmt.Sprintf("worker: %v", hostIdentity)
+}
+
 // New returns a new instance of batcher daemon Batcher
 func New(params *BootstrapParams) *Batcher {
 	cfg := params.Config
+	logger := params.Logger.WithTags(tag.ComponentBatcher)
+	workerIdentity := ""
+	if params.HostIdentity != "" {
+		logger = logger.WithTags(tag.Address(params.HostIdentity))
+		formatHostIdentity := params.FormatHostIdentity
+		if formatHostIdentity == nil {
+			formatHostIdentity = DefaultFormatHostIdentity
+		}
+		workerIdentity = formatHostIdentity(params.HostIdentity)
+	}
+	resultSink := params.ResultSink
+	if resultSink == nil {
+		resultSink = &noopResultSink{}
+	}
+	var rateLimiter quotas.Limiter
+	if cfg.RPS != nil {
+		rateLimiter = quotas.NewDynamicRateLimiter(func() float64 {
+			return float64(cfg.RPS())
+		})
+	}
+	var concurrencySem chan struct{}
+	if maxConcurrency := readPositive(cfg.MaxConcurrency); maxConcurrency > 0 {
+		concurrencySem = make(chan struct{}, maxConcurrency)
+	}
+	var processorSem chan struct{}
+	if maxProcessors := readPositive(cfg.MaxProcessors); maxProcessors > 0 {
+		processorSem = make(chan struct{}, maxProcessors)
+	}
 	return &Batcher{
-		cfg:           cfg,
-		svcClient:     params.ServiceClient,
-		metricsClient: params.MetricsClient,
-		tallyScope:    params.TallyScope,
-		logger:        params.Logger.WithTags(tag.ComponentBatcher),
-		clientBean:    params.ClientBean,
+		cfg:            cfg,
+		svcClient:      params.ServiceClient,
+		metricsClient:  params.MetricsClient,
+		tallyScope:     params.TallyScope,
+		logger:         logger,
+		workerIdentity: workerIdentity,
+		resultSink:     resultSink,
+		clientBean:     params.ClientBean,
+		archiverClient: params.ArchiverClient,
+
+		executionManagerFn: params.ExecutionManagerFn,
+		historyManager:     params.HistoryManager,
+		visibilityManager:  params.VisibilityManager,
+		dataConverters:     params.DataConverters,
+		filters:            params.Filters,
+
+		rateLimiter:    rateLimiter,
+		concurrencySem: concurrencySem,
+		processorSem:   processorSem,
 	}
 }
 
@@ -94,7 +211,128 @@ func (s *Batcher) Start() error {
 		MetricsScope:              s.tallyScope,
 		BackgroundActivityContext: ctx,
 		Tracer:                    opentracing.GlobalTracer(),
+
+		MaxConcurrentActivityExecutionSize:     readPositive(s.cfg.MaxConcurrentActivities),
+		MaxConcurrentDecisionTaskExecutionSize: readPositive(s.cfg.MaxConcurrentDecisions),
+	}
+	taskList := s.getTaskListName()
+	batchWorker := worker.New(s.svcClient, common.SystemLocalDomainName, taskList, workerOpts)
+	if err := batchWorker.Start(); err != nil {
+		return err
+	}
+
+	// batch workflows always run on shard 0, the other shards only serve batch activities
+	workerOpts.DisableWorkflowWorker = true
+	for shard := 1; shard < s.getTaskListShards(); shard++ {
+		shardWorker := worker.New(s.svcClient, common.SystemLocalDomainName, getBatcherTaskListName(taskList, shard), workerOpts)
+		if err := shardWorker.Start(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readPositive reads an option from fn, zero leaves the option to the default
+func readPositive(fn dynamicconfig.IntPropertyFn) int {
+	if fn == nil || fn() <= 0 {
+		return 0
+	}
+	return fn()
+}
+
+func (s *Batcher) getTaskListName() string {
+	if s.cfg.TaskListPerCluster == nil || !s.cfg.TaskListPerCluster() {
+		return BatcherTaskListName
+	}
+	return GetBatcherTaskListName(s.cfg.ClusterMetadata.GetCurrentClusterName())
+}
+
+func (s *Batcher) getTaskListShards() int {
+	if s.cfg.TaskListShards == nil {
+		return 1
+	}
+	return common.MaxInt(s.cfg.TaskListShards(), 1)
+}
+
+// getReason returns the reason recorded in the workflows of the batch operation, along with the operator and worker
+func (s *Batcher) getReason(batchParams BatchParams) string {
+	reason := batchParams.Reason
+	if batchParams.OperatorIdentity != "" {
+		reason = withOperatorIdentity(reason, batchParams.OperatorIdentity)
+	}
+	if s.workerIdentity == "" {
+		return reason
+	}
+	return fmt.Sprintf("%v, %v", reason, s.workerIdentity)
+}
+
+// encodeSignalInput encodes the signal input with the data converter of the domain, if there is one
+func (s *Batcher) encodeSignalInput(domainName string, input string) ([]byte, error) {
+	dataConverter, ok := s.dataConverters[domainName]
+	if !ok {
+		return []byte(input), nil
+	}
+	return dataConverter.ToData(input)
+}
+
+func (s *Batcher) waitRateLimit(ctx context.Context) error {
+	if s.rateLimiter == nil || s.cfg.RPS() <= 0 {
+		return nil
+	}
+	return s.rateLimiter.Wait(ctx)
+}
+
+func (s *Batcher) acquireConcurrency(ctx context.Context) error {
+	if s.concurrencySem == nil {
+		return nil
+	}
+	select {
+	case s.concurrencySem <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *Batcher) releaseConcurrency() {
+	if s.concurrencySem != nil {
+		<-s.concurrencySem
+	}
+}
+
+// startProcessors starts processors by processorFn until there are concurrency of them or the processor slots run
+// out, and returns the number of running processors. The first processor of a batch job runs even without a slot,
+// so that every batch job makes progress however many of them are running
+func (s *Batcher) startProcessors(running, concurrency int, processorFn func()) int {
+	for ; running < concurrency; running++ {
+		slot := s.tryAcquireProcessor()
+		if !slot && running > 0 {
+			break
+		}
+		go func() {
+			if slot {
+				defer s.releaseProcessor()
+			}
+			processorFn()
+		}()
+	}
+	return running
+}
+
+func (s *Batcher) tryAcquireProcessor() bool {
+	if s.processorSem == nil {
+		return true
+	}
+	select {
+	case s.processorSem <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *Batcher) releaseProcessor() {
+	if s.processorSem != nil {
+		<-s.processorSem
 	}
-	batchWorker := worker.New(s.svcClient, common.SystemLocalDomainName, BatcherTaskListName, workerOpts)
-	return batchWorker.Start()
 }
@@ -0,0 +1,105 @@
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package batcher
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/uber/cadence/common/log/tag"
+	"github.com/uber/cadence/common/metrics"
+)
+
+const (
+	// errorRateWindowSize is the number of the most recent task attempts the error rate is over
+	errorRateWindowSize = 100
+)
+
+// errErrorRateExceeded fails the batch activity so that it's retried with backoff from the current page
+var errErrorRateExceeded = errors.New("batch operation is stopped as its error rate exceeds MaxErrorRate")
+
+type (
+	// errorRateBreaker trips once the error rate of the recent task attempts exceeds BatchParams.MaxErrorRate,
+	// every failed attempt is counted including the ones to be retried
+	errorRateBreaker struct {
+		sync.Mutex
+		maxErrorRate float64
+		// failed is a ring buffer of the outcomes of the recent attempts
+		failed   []bool
+		next     int
+		failures int
+	}
+)
+
+func newErrorRateBreaker(maxErrorRate float64) *errorRateBreaker {
+	return &errorRateBreaker{
+		maxErrorRate: maxErrorRate,
+		failed:       make([]bool, 0, errorRateWindowSize),
+	}
+}
+
+func (b *errorRateBreaker) record(failed bool) {
+	if b.maxErrorRate <= 0 {
+		return
+	}
+	b.Lock()
+	defer b.Unlock()
+	if len(b.failed) < errorRateWindowSize {
+		b.failed = append(b.failed, failed)
+	} else {
+		if b.failed[b.next] {
+			b.failures--
+		}
+		b.failed[b.next] = failed
+		b.next = (b.next + 1) % errorRateWindowSize
+	}
+	if failed {
+		b.failures++
+	}
+}
+
+// tripped tells whether the error rate exceeds the threshold, it never trips before a full window of attempts
+func (b *errorRateBreaker) tripped() bool {
+	if b.maxErrorRate <= 0 {
+		return false
+	}
+	b.Lock()
+	defer b.Unlock()
+	return len(b.failed) == errorRateWindowSize && float64(b.failures)/float64(errorRateWindowSize) > b.maxErrorRate
+}
+
+// stopOnErrorRate records the trip in the heartbeat, the page token stays at the current page for the retry
+func stopOnErrorRate(
+	ctx context.Context,
+	hbd *HeartBeatDetails,
+	inFlight *int64,
+	taskCh chan taskDetail,
+	retryQueue *taskRetryQueue,
+	gate *pauseGate,
+) error {
+	batcher := ctx.Value(batcherContextKey).(*Batcher)
+	batcher.metricsClient.IncCounter(metrics.BatcherScope, metrics.BatcherErrorRateExceeded)
+	getActivityLogger(ctx).Warn("Stopping batch operation as the error rate is too high",
+		tag.Counter(hbd.ErrorRateExceededCount+1))
+	hbd.ErrorRateExceededCount++
+	recordProgressHeartbeat(ctx, hbd, inFlight, taskCh, retryQueue, gate)
+	return errErrorRateExceeded
+}
@@ -0,0 +1,143 @@
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package batcher
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/uuid"
+	"go.uber.org/yarpc"
+
+	"github.com/uber/cadence/.gen/go/shared"
+	"github.com/uber/cadence/client/frontend"
+	"github.com/uber/cadence/common"
+)
+
+const (
+	// ChildPolicyAbandon leaves the pending child workflows alone
+	ChildPolicyAbandon = "abandon"
+	// ChildPolicyRequestCancel requests cancellation of the pending child workflows, recursively
+	ChildPolicyRequestCancel = "request-cancel"
+	// ChildPolicyTerminate terminates the pending child workflows, recursively
+	ChildPolicyTerminate = "terminate"
+	// ChildPolicyParentClosePolicy applies the ParentClosePolicy that every pending child workflow is started with
+	ChildPolicyParentClosePolicy = "parent-close-policy"
+)
+
+// AllChildPolicies is the child policies we supported for CancelParams.ChildPolicy
+var AllChildPolicies = []string{
+	ChildPolicyAbandon,
+	ChildPolicyRequestCancel,
+	ChildPolicyTerminate,
+	ChildPolicyParentClosePolicy,
+}
+
+type (
+	// pendingExecution is a workflow that processTask is to apply the operation on, policy is
+	// empty for the workflow of the task itself and the resolved child policy for a child workflow
+	pendingExecution struct {
+		execution shared.WorkflowExecution
+		policy    string
+	}
+)
+
+func validateChildPolicy(policy string) error {
+	if policy == "" {
+		return nil
+	}
+	for _, p := range AllChildPolicies {
+		if policy == p {
+			return nil
+		}
+	}
+	return fmt.Errorf("not supported child policy: %v", policy)
+}
+
+// getCancelChildPolicy returns CancelParams.ChildPolicy, or the one CancelChildren stands for if it's not set
+func getCancelChildPolicy(params CancelParams) string {
+	if params.ChildPolicy != "" {
+		return params.ChildPolicy
+	}
+	if params.CancelChildren != nil && *params.CancelChildren {
+		return ChildPolicyRequestCancel
+	}
+	return ChildPolicyAbandon
+}
+
+func getTerminateChildPolicy(params TerminateParams) string {
+	if params.TerminateChildren != nil && *params.TerminateChildren {
+		return ChildPolicyTerminate
+	}
+	return ChildPolicyAbandon
+}
+
+// resolveChildPolicy returns the policy to apply on the pending child workflow, ChildPolicyParentClosePolicy
+// is resolved to the ParentClosePolicy of the child
+func resolveChildPolicy(policy string, child *shared.PendingChildExecutionInfo) string {
+	if policy != ChildPolicyParentClosePolicy {
+		return policy
+	}
+	switch child.GetParentClosePolicy() {
+	case shared.ParentClosePolicyRequestCancel:
+		return ChildPolicyRequestCancel
+	case shared.ParentClosePolicyTerminate:
+		return ChildPolicyTerminate
+	default:
+		return ChildPolicyAbandon
+	}
+}
+
+// applyChildPolicy requests cancellation of or terminates the child workflow according to the resolved policy
+func applyChildPolicy(
+	ctx context.Context,
+	batchParams BatchParams,
+	client frontend.Client,
+	policy string,
+	workflowID string,
+	runID string,
+) error {
+	batcher := ctx.Value(batcherContextKey).(*Batcher)
+	yarpcCallOptions := []yarpc.CallOption{
+		yarpc.WithHeader(common.EnforceDCRedirection, "true"),
+	}
+	execution := &shared.WorkflowExecution{
+		WorkflowId: common.StringPtr(workflowID),
+		RunId:      common.StringPtr(runID),
+	}
+	switch policy {
+	case ChildPolicyRequestCancel:
+		return client.RequestCancelWorkflowExecution(ctx, &shared.RequestCancelWorkflowExecutionRequest{
+			Domain:            common.StringPtr(batchParams.DomainName),
+			WorkflowExecution: execution,
+			Identity:          common.StringPtr(withCancelReason(BatchWFTypeName, batcher.getReason(batchParams))),
+			RequestId:         common.StringPtr(uuid.New().String()),
+		}, yarpcCallOptions...)
+	case ChildPolicyTerminate:
+		return client.TerminateWorkflowExecution(ctx, &shared.TerminateWorkflowExecutionRequest{
+			Domain:            common.StringPtr(batchParams.DomainName),
+			WorkflowExecution: execution,
+			Reason:            common.StringPtr(batcher.getReason(batchParams)),
+			Identity:          common.StringPtr(BatchWFTypeName),
+		}, yarpcCallOptions...)
+	default:
+		return nil
+	}
+}
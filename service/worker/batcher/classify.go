@@ -0,0 +1,108 @@
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package batcher
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/uber/cadence/.gen/go/shared"
+)
+
+// Error types of BatchParams.NonRetryableErrorTypes
+const (
+	ErrorTypeBadRequest                   = "BadRequest"
+	ErrorTypeDomainNotActive              = "DomainNotActive"
+	ErrorTypeEntityNotExists              = "EntityNotExists"
+	ErrorTypeLimitExceeded                = "LimitExceeded"
+	ErrorTypeServiceBusy                  = "ServiceBusy"
+	ErrorTypeInternalService              = "InternalService"
+	ErrorTypeAccessDenied                 = "AccessDenied"
+	ErrorTypeCancellationAlreadyRequested = "CancellationAlreadyRequested"
+)
+
+// errorTypeMatchers tell whether an error returned by frontend is of an error type
+var errorTypeMatchers = map[string]func(error) bool{
+	ErrorTypeBadRequest: func(err error) bool {
+		_, ok := err.(*shared.BadRequestError)
+		return ok
+	},
+	ErrorTypeDomainNotActive: func(err error) bool {
+		_, ok := err.(*shared.DomainNotActiveError)
+		return ok
+	},
+	ErrorTypeEntityNotExists: func(err error) bool {
+		_, ok := err.(*shared.EntityNotExistsError)
+		return ok
+	},
+	ErrorTypeLimitExceeded: func(err error) bool {
+		_, ok := err.(*shared.LimitExceededError)
+		return ok
+	},
+	ErrorTypeServiceBusy: func(err error) bool {
+		_, ok := err.(*shared.ServiceBusyError)
+		return ok
+	},
+	ErrorTypeInternalService: func(err error) bool {
+		_, ok := err.(*shared.InternalServiceError)
+		return ok
+	},
+	ErrorTypeAccessDenied: func(err error) bool {
+		_, ok := err.(*shared.AccessDeniedError)
+		return ok
+	},
+	ErrorTypeCancellationAlreadyRequested: func(err error) bool {
+		_, ok := err.(*shared.CancellationAlreadyRequestedError)
+		return ok
+	},
+}
+
+func validateErrorTypes(errorTypes []string) error {
+	for _, errorType := range errorTypes {
+		if _, ok := errorTypeMatchers[errorType]; !ok {
+			return fmt.Errorf("unknown error type %q, supported: %v", errorType, getErrorTypes())
+		}
+	}
+	return nil
+}
+
+func getErrorTypes() []string {
+	var errorTypes []string
+	for errorType := range errorTypeMatchers {
+		errorTypes = append(errorTypes, errorType)
+	}
+	sort.Strings(errorTypes)
+	return errorTypes
+}
+
+// isNonRetryableError tells whether the error of a task fails it without retrying, by either its text in
+// NonRetryableErrors or its type in NonRetryableErrorTypes
+func isNonRetryableError(batchParams BatchParams, err error) bool {
+	if _, ok := batchParams._nonRetryableErrors[err.Error()]; ok {
+		return true
+	}
+	for _, errorType := range batchParams.NonRetryableErrorTypes {
+		if match, ok := errorTypeMatchers[errorType]; ok && match(err) {
+			return true
+		}
+	}
+	return false
+}
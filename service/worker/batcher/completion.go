@@ -0,0 +1,76 @@
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package batcher
+
+import (
+	"time"
+
+	"go.uber.org/cadence/workflow"
+	"go.uber.org/zap"
+)
+
+const (
+	// a failed completion signal is retried with backoff from completionSignalInitialInterval, the batch still
+	// completes if it's never delivered
+	completionSignalAttempts        = 5
+	completionSignalInitialInterval = time.Second
+
+	batchWorkflowCompletionSignalFailedCounter = "batcher_workflow_completion_signal_failed"
+)
+
+type (
+	// CompletionSignal is the workflow signaled with the final HeartBeatDetails once the batch completes, e.g. an
+	// orchestration workflow waiting for the batch it started. The workflow must be in the domain of the batch
+	// workflow, and it's not signaled if the batch fails or is canceled
+	CompletionSignal struct {
+		WorkflowID string
+		SignalName string
+	}
+)
+
+// sendCompletionSignal signals BatchParams.CompletionSignal with the result of the completed batch
+func sendCompletionSignal(ctx workflow.Context, batchParams BatchParams, result HeartBeatDetails) {
+	completion := batchParams.CompletionSignal
+	if completion.WorkflowID == "" {
+		return
+	}
+
+	logger := workflow.GetLogger(ctx)
+	interval := completionSignalInitialInterval
+	for attempt := 1; ; attempt++ {
+		err := workflow.SignalExternalWorkflow(ctx, completion.WorkflowID, "", completion.SignalName, result).Get(ctx, nil)
+		if err == nil {
+			return
+		}
+		if attempt >= completionSignalAttempts {
+			logger.Error("Failed to send completion signal of batch operation",
+				zap.String("workflowID", completion.WorkflowID), zap.Error(err))
+			workflow.GetMetricsScope(ctx).Counter(batchWorkflowCompletionSignalFailedCounter).Inc(1)
+			return
+		}
+		logger.Warn("Failed to send completion signal of batch operation, retrying",
+			zap.String("workflowID", completion.WorkflowID), zap.Int("attempt", attempt), zap.Error(err))
+		if err := workflow.Sleep(ctx, interval); err != nil {
+			return
+		}
+		interval *= 2
+	}
+}
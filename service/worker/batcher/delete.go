@@ -0,0 +1,191 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package batcher
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"sort"
+
+	h "github.com/uber/cadence/.gen/go/history"
+	"github.com/uber/cadence/.gen/go/shared"
+	"github.com/uber/cadence/client/frontend"
+	"github.com/uber/cadence/common"
+	"github.com/uber/cadence/common/log/tag"
+	"github.com/uber/cadence/common/metrics"
+	"github.com/uber/cadence/common/persistence"
+)
+
+const (
+	// errReasonInvalidAdminOperationToken fails the batch activity without retrying it
+	errReasonInvalidAdminOperationToken = "batcher:InvalidAdminOperationToken"
+)
+
+var errMissingDeletePersistence = errors.New("batcher is not configured with the persistence for hard deletes")
+
+// SignAdminOperation returns the hex encoded HMAC-SHA256 of a batch job of the admin batch types keyed by the admin
+// operation token, which is what their params carry instead of the token. It covers the ID of the batch job and the
+// params telling what the job operates on, so the signature recorded in the history of a job can't authorize any
+// other operation. An empty token signs to empty
+func SignAdminOperation(token string, jobID string, params BatchParams) string {
+	if token == "" {
+		return ""
+	}
+	fields := []string{
+		jobID,
+		params.BatchType,
+		params.DomainName,
+		params.Query,
+		params.QueryName,
+		params.FilterName,
+		params.FailoverDomainsParams.ActiveClusterName,
+	}
+	queryParams := make([]string, 0, len(params.QueryParams))
+	for key, value := range params.QueryParams {
+		queryParams = append(queryParams, key+"="+value)
+	}
+	sort.Strings(queryParams)
+	fields = append(fields, queryParams...)
+	fields = append(fields, params.FailoverDomainsParams.Domains...)
+
+	mac := hmac.New(sha256.New, []byte(token))
+	for _, field := range fields {
+		// the fields are terminated so that they can't be shifted into each other
+		mac.Write([]byte(field))
+		mac.Write([]byte{0})
+	}
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// prepareDeletion looks up the ID of the target domain of BatchTypeDeleteClosed
+func prepareDeletion(
+	ctx context.Context,
+	batchParams BatchParams,
+	client frontend.Client,
+) (BatchParams, error) {
+	if batchParams.BatchType != BatchTypeDeleteClosed {
+		return batchParams, nil
+	}
+
+	batcher := ctx.Value(batcherContextKey).(*Batcher)
+	if batcher.executionManagerFn == nil || batcher.historyManager == nil || batcher.visibilityManager == nil {
+		return batchParams, errMissingDeletePersistence
+	}
+	resp, err := client.DescribeDomain(ctx, &shared.DescribeDomainRequest{
+		Name: common.StringPtr(batchParams.DomainName),
+	})
+	if err != nil {
+		return batchParams, err
+	}
+	batchParams._deleteDomainID = resp.DomainInfo.GetUUID()
+	return batchParams, nil
+}
+
+// deleteClosedExecution hard deletes the history, mutable state and visibility records of a closed workflow.
+// It refuses to delete a running workflow by returning errTaskSkipped.
+func deleteClosedExecution(
+	ctx context.Context,
+	batchParams BatchParams,
+	workflowID string,
+	runID string,
+) error {
+	batcher := ctx.Value(batcherContextKey).(*Batcher)
+	domainID := batchParams._deleteDomainID
+	execution := shared.WorkflowExecution{
+		WorkflowId: common.StringPtr(workflowID),
+		RunId:      common.StringPtr(runID),
+	}
+	logger := batcher.logger.WithTags(
+		tag.WorkflowDomainName(batchParams.DomainName),
+		tag.WorkflowID(workflowID),
+		tag.WorkflowRunID(runID),
+		tag.OperatorIdentity(batchParams.OperatorIdentity),
+	)
+
+	resp, err := batcher.clientBean.GetHistoryClient().GetMutableState(ctx, &h.GetMutableStateRequest{
+		DomainUUID: common.StringPtr(domainID),
+		Execution:  &execution,
+	})
+	if _, ok := err.(*shared.EntityNotExistsError); ok {
+		// the mutable state is already gone, e.g. after retention, only the visibility records may be left
+		resp = nil
+	} else if err != nil {
+		return err
+	}
+	if resp.GetIsWorkflowRunning() {
+		logger.Warn("Refused to delete running workflow")
+		return errTaskSkipped
+	}
+
+	if resp != nil {
+		shardID := common.WorkflowIDToHistoryShard(workflowID, batcher.cfg.NumHistoryShards)
+		branchTokens := [][]byte{resp.CurrentBranchToken}
+		if resp.VersionHistories != nil {
+			// if VersionHistories is set, then all branch infos are stored in VersionHistories
+			branchTokens = nil
+			for _, versionHistory := range resp.VersionHistories.Histories {
+				branchTokens = append(branchTokens, versionHistory.BranchToken)
+			}
+		}
+		for _, branchToken := range branchTokens {
+			if err := batcher.historyManager.DeleteHistoryBranch(&persistence.DeleteHistoryBranchRequest{
+				BranchToken: branchToken,
+				ShardID:     common.IntPtr(shardID),
+			}); err != nil {
+				return err
+			}
+		}
+
+		executionManager, err := batcher.executionManagerFn(shardID)
+		if err != nil {
+			return err
+		}
+		if err := executionManager.DeleteWorkflowExecution(&persistence.DeleteWorkflowExecutionRequest{
+			DomainID:   domainID,
+			WorkflowID: workflowID,
+			RunID:      runID,
+		}); err != nil {
+			return err
+		}
+		// the current row is only deleted if it still points to this run
+		if err := executionManager.DeleteCurrentWorkflowExecution(&persistence.DeleteCurrentWorkflowExecutionRequest{
+			DomainID:   domainID,
+			WorkflowID: workflowID,
+			RunID:      runID,
+		}); err != nil {
+			return err
+		}
+	}
+
+	if err := batcher.visibilityManager.DeleteWorkflowExecution(&persistence.VisibilityDeleteWorkflowExecutionRequest{
+		DomainID:   domainID,
+		WorkflowID: workflowID,
+		RunID:      runID,
+	}); err != nil {
+		return err
+	}
+	batcher.metricsClient.IncCounter(metrics.BatcherScope, metrics.BatcherProcessorDeleted)
+	logger.Info("Deleted closed workflow")
+	return nil
+}
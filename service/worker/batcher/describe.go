@@ -0,0 +1,77 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package batcher
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/uber/cadence/.gen/go/shared"
+	"github.com/uber/cadence/client/frontend"
+	"github.com/uber/cadence/common"
+)
+
+type (
+	// BatchOperationProgress is the progress of a batch operation job as of the last heartbeat of its activity
+	BatchOperationProgress struct {
+		JobID string
+		RunID string
+		// Running is false once the batch job is closed, CloseStatus tells how it's closed then
+		Running     bool
+		CloseStatus *shared.WorkflowExecutionCloseStatus
+		// HeartbeatRecorded is false if the batch activity of the running job hasn't heartbeated yet,
+		// Details is empty then
+		HeartbeatRecorded bool
+		Details           HeartBeatDetails
+	}
+)
+
+// DescribeBatchOperation returns the progress of a batch operation job from the heartbeat details of its pending
+// activity. Unlike querying the batch workflow, it only takes DescribeWorkflowExecution which works across clusters
+func DescribeBatchOperation(ctx context.Context, client frontend.Client, jobID string) (BatchOperationProgress, error) {
+	resp, err := client.DescribeWorkflowExecution(ctx, &shared.DescribeWorkflowExecutionRequest{
+		Domain: common.StringPtr(common.SystemLocalDomainName),
+		Execution: &shared.WorkflowExecution{
+			WorkflowId: common.StringPtr(jobID),
+		},
+	})
+	if err != nil {
+		return BatchOperationProgress{}, err
+	}
+
+	info := resp.GetWorkflowExecutionInfo()
+	progress := BatchOperationProgress{
+		JobID:       jobID,
+		RunID:       info.GetExecution().GetRunId(),
+		Running:     info.CloseStatus == nil,
+		CloseStatus: info.CloseStatus,
+	}
+	for _, pa := range resp.PendingActivities {
+		if pa.GetActivityType().GetName() != batchActivityName || len(pa.HeartbeatDetails) == 0 {
+			continue
+		}
+		if err := json.Unmarshal(pa.HeartbeatDetails, &progress.Details); err != nil {
+			return BatchOperationProgress{}, fmt.Errorf("failed to decode heartbeat details of batch job %v: %v", jobID, err)
+		}
+		progress.HeartbeatRecorded = true
+	}
+	return progress, nil
+}
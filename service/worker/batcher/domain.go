@@ -0,0 +1,99 @@
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package batcher
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"go.uber.org/cadence"
+
+	"github.com/uber/cadence/.gen/go/shared"
+	"github.com/uber/cadence/client/frontend"
+	"github.com/uber/cadence/common"
+	"github.com/uber/cadence/common/log/tag"
+)
+
+const (
+	// errReasonTargetDomainGone fails the batch activity without retrying it once the target domain is deleted
+	// or deprecated, the details of the error are the name of the domain
+	errReasonTargetDomainGone = "batcher:TargetDomainGone"
+	// targetDomainCheckInterval is how often the target domain is described at most while the tasks are failing
+	targetDomainCheckInterval = 5 * time.Second
+)
+
+// errTaskDomainGone is sent over respCh instead of the error of a task once the target domain is gone
+var errTaskDomainGone = errors.New("target domain is gone")
+
+type (
+	// targetDomainCheck tells whether the target domain of the batch is gone, so that the failing tasks abort the
+	// whole batch instead of being retried one by one
+	targetDomainCheck struct {
+		sync.Mutex
+		client     frontend.Client
+		domainName string
+		checkedAt  time.Time
+		gone       bool
+	}
+)
+
+func newTargetDomainCheck(client frontend.Client, domainName string) *targetDomainCheck {
+	return &targetDomainCheck{
+		client:     client,
+		domainName: domainName,
+	}
+}
+
+// isGone tells whether the target domain is deleted or deprecated, the result of a check is reused for
+// targetDomainCheckInterval and a check which fails for any other reason counts as not gone
+func (c *targetDomainCheck) isGone(ctx context.Context) bool {
+	c.Lock()
+	defer c.Unlock()
+	if c.gone || time.Since(c.checkedAt) < targetDomainCheckInterval {
+		return c.gone
+	}
+	c.checkedAt = time.Now()
+	var resp *shared.DescribeDomainResponse
+	err := callWithOperationDeadline(ctx, func(ctx context.Context) error {
+		var err error
+		resp, err = c.client.DescribeDomain(ctx, &shared.DescribeDomainRequest{
+			Name: common.StringPtr(c.domainName),
+		})
+		return err
+	})
+	switch err.(type) {
+	case nil:
+		status := resp.GetDomainInfo().GetStatus()
+		c.gone = status == shared.DomainStatusDeprecated || status == shared.DomainStatusDeleted
+	case *shared.EntityNotExistsError:
+		c.gone = true
+	}
+	return c.gone
+}
+
+// stopOnDomainGone fails the batch activity without retrying it, the target domain can't come back
+func stopOnDomainGone(ctx context.Context, batchParams BatchParams) error {
+	getActivityLogger(ctx).Error("Stopping batch operation as the target domain is deleted or deprecated",
+		tag.WorkflowDomainName(batchParams.DomainName))
+	return cadence.NewCustomError(errReasonTargetDomainGone, batchParams.DomainName)
+}
@@ -0,0 +1,83 @@
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package batcher
+
+import (
+	"time"
+)
+
+// estimatedRPCLatency is the latency assumed by EstimateBatchDuration for every RPC made to process a workflow
+const estimatedRPCLatency = 50 * time.Millisecond
+
+// EstimateBatchDuration estimates how long a batch job of params takes to process count workflows, e.g. for CLI to
+// warn about a long running job before starting it. The workflows are processed at most at RPS, and by Concurrency
+// processors each spending estimatedRPCLatency on every RPC a workflow takes. The children of the workflows,
+// retries, pauses, scans and the limits of the batcher workers are not accounted for
+func EstimateBatchDuration(count int64, params BatchParams) time.Duration {
+	if count <= 0 {
+		return 0
+	}
+	params = setDefaultParams(params)
+	perWorkflow := time.Duration(getRPCsPerWorkflow(params)) * estimatedRPCLatency
+	if params.VerifyEffect {
+		perWorkflow += params.VerifyDelay
+	}
+	byRPS := time.Duration(float64(count) / float64(params.RPS) * float64(time.Second))
+	byConcurrency := time.Duration(count) * perWorkflow / time.Duration(params.Concurrency)
+	if byRPS > byConcurrency {
+		return byRPS
+	}
+	return byConcurrency
+}
+
+// getRPCsPerWorkflow returns the number of RPCs made to process a workflow without any child, at least
+func getRPCsPerWorkflow(params BatchParams) int {
+	if params.BatchType == BatchTypeFailoverDomains {
+		return 1
+	}
+	// the operation and the describe looking for the children of the workflow
+	rpcs := 2
+	if len(params.ExcludeWorkflowTypes) > 0 || params.FilterName != "" {
+		// the describe deciding whether to skip the workflow
+		rpcs++
+	}
+	switch params.BatchType {
+	case BatchTypeReset:
+		if params.ResetParams.ResetType != "" {
+			// the history read for the reset point, unless the workflow has its event ID
+			rpcs++
+		}
+	case BatchTypeDeleteClosed:
+		// the mutable state read, and the deletes of history, mutable state and visibility
+		rpcs += 3
+	case BatchTypeTerminate:
+		if params.TerminateParams.ArchiveAfter {
+			rpcs += 2
+		}
+		if params.TerminateParams.TagSearchAttribute != "" {
+			rpcs += 2
+		}
+		if params.VerifyEffect {
+			rpcs++
+		}
+	}
+	return rpcs
+}
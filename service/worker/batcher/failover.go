@@ -0,0 +1,182 @@
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package batcher
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.uber.org/cadence"
+	"go.uber.org/cadence/activity"
+	"golang.org/x/time/rate"
+
+	"github.com/uber/cadence/.gen/go/shared"
+	"github.com/uber/cadence/client/frontend"
+	"github.com/uber/cadence/common"
+	"github.com/uber/cadence/common/backoff"
+	"github.com/uber/cadence/common/log/tag"
+	"github.com/uber/cadence/common/metrics"
+)
+
+const (
+	failoverRetryInitialInterval = 100 * time.Millisecond
+	failoverRetryMaxInterval     = 10 * time.Second
+)
+
+// validateFailoverDomainsParams validates the params of BatchTypeFailoverDomains, which operates on the listed
+// domains instead of the workflows of a query
+func validateFailoverDomainsParams(params BatchParams) error {
+	if params.Query != "" || params.QueryName != "" || params.PostOperationQuery != "" {
+		return fmt.Errorf("must not provide a query for batch type %v", BatchTypeFailoverDomains)
+	}
+	if len(params.FailoverDomainsParams.Domains) == 0 {
+		return fmt.Errorf("must provide the domains to failover")
+	}
+	if params.FailoverDomainsParams.ActiveClusterName == "" {
+		return fmt.Errorf("must provide the active cluster to failover to")
+	}
+	if params.FailoverDomainsParams.AdminOperationSignature == "" {
+		return fmt.Errorf("must provide admin operation signature")
+	}
+	return nil
+}
+
+// failoverDomains updates the active cluster of every domain of FailoverDomainsParams. The domains are failed
+// over page by page of Concurrency domains, and a page is only heartbeated once all of its domains are done, so
+// that a retried activity resumes from the first unfinished page
+func failoverDomains(
+	ctx context.Context,
+	batchParams BatchParams,
+	client frontend.Client,
+	bm *batchMetrics,
+	hbd HeartBeatDetails,
+) (HeartBeatDetails, error) {
+	batcher := ctx.Value(batcherContextKey).(*Batcher)
+	domains := batchParams.FailoverDomainsParams.Domains
+	hbd.TotalEstimate = int64(len(domains))
+	limiter := rate.NewLimiter(rate.Limit(batchParams.RPS), batchParams.RPS)
+	for next := hbd.SuccessCount + hbd.ErrorCount; next < len(domains); next = hbd.SuccessCount + hbd.ErrorCount {
+		end := next + batchParams.Concurrency
+		if end > len(domains) {
+			end = len(domains)
+		}
+		page := domains[next:end]
+		errs := make([]error, len(page))
+		var wg sync.WaitGroup
+		for i, domain := range page {
+			wg.Add(1)
+			go func(i int, domain string) {
+				defer wg.Done()
+				errs[i] = failoverDomain(ctx, batchParams, client, limiter, domain)
+			}(i, domain)
+		}
+		wg.Wait()
+		if err := ctx.Err(); err != nil {
+			return HeartBeatDetails{}, err
+		}
+
+		for i, err := range errs {
+			if err != nil {
+				bm.scope.IncCounter(metrics.BatcherProcessorFailures)
+				bm.logger.Error("Failed to failover domain", tag.WorkflowDomainName(page[i]), tag.Error(err))
+				hbd.ErrorCount++
+				hbd.FailedDomains = append(hbd.FailedDomains, page[i])
+			} else {
+				bm.scope.IncCounter(metrics.BatcherProcessorSuccess)
+				hbd.SuccessCount++
+			}
+		}
+		hbd.CurrentPage++
+		hbd.ProgressPercent = float64(hbd.SuccessCount+hbd.ErrorCount) * 100 / float64(len(domains))
+		activity.RecordHeartbeat(ctx, hbd)
+	}
+
+	hbd.Completed = true
+	hbd.ProgressPercent = 100
+	hbd.EstimatedCompletion = time.Now()
+	activity.RecordHeartbeat(ctx, hbd)
+	if err := batcher.recordBatchResult(ctx, batchParams, hbd); err != nil {
+		return HeartBeatDetails{}, err
+	}
+	return hbd, nil
+}
+
+// failoverDomain sets the active cluster of the domain, retrying with backoff AttemptsOnRetryableError times
+// unless the error is non retryable, like a workflow of the other batch types
+func failoverDomain(
+	ctx context.Context,
+	batchParams BatchParams,
+	client frontend.Client,
+	limiter *rate.Limiter,
+	domain string,
+) error {
+	batcher := ctx.Value(batcherContextKey).(*Batcher)
+	policy := backoff.NewExponentialRetryPolicy(failoverRetryInitialInterval)
+	policy.SetMaximumInterval(failoverRetryMaxInterval)
+	policy.SetExpirationInterval(backoff.NoInterval)
+	policy.SetMaximumAttempts(batchParams.AttemptsOnRetryableError)
+	isRetryable := func(err error) bool {
+		return ctx.Err() == nil && !isNonRetryableError(batchParams, err)
+	}
+	return backoff.Retry(func() error {
+		if err := limiter.Wait(ctx); err != nil {
+			return err
+		}
+		if err := batcher.waitRateLimit(ctx); err != nil {
+			return err
+		}
+		return callWithOperationTimeout(ctx, batchParams.OperationTimeout, func(ctx context.Context) error {
+			_, err := client.UpdateDomain(ctx, &shared.UpdateDomainRequest{
+				Name: common.StringPtr(domain),
+				ReplicationConfiguration: &shared.DomainReplicationConfiguration{
+					ActiveClusterName: common.StringPtr(batchParams.FailoverDomainsParams.ActiveClusterName),
+				},
+				// the signature of the batch is checked against the token of the worker before any update
+				SecurityToken: common.StringPtr(batcher.cfg.AdminOperationToken()),
+			})
+			return err
+		})
+	}, policy, isRetryable)
+}
+
+// checkAdminOperationToken fails the batch activity without retrying it if the params of an admin batch type aren't
+// signed for the batch job with the admin operation token of the batcher workers
+func checkAdminOperationToken(batcher *Batcher, jobID string, batchParams BatchParams) error {
+	var signature string
+	switch batchParams.BatchType {
+	case BatchTypeDeleteClosed:
+		signature = batchParams.DeleteClosedParams.AdminOperationSignature
+	case BatchTypeFailoverDomains:
+		signature = batchParams.FailoverDomainsParams.AdminOperationSignature
+	default:
+		return nil
+	}
+	if batcher.cfg.AdminOperationToken == nil || signature == "" ||
+		subtle.ConstantTimeCompare(
+			[]byte(signature),
+			[]byte(SignAdminOperation(batcher.cfg.AdminOperationToken(), jobID, batchParams)),
+		) != 1 {
+		return cadence.NewCustomError(errReasonInvalidAdminOperationToken)
+	}
+	return nil
+}
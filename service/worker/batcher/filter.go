@@ -0,0 +1,61 @@
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package batcher
+
+import (
+	"context"
+	"fmt"
+
+	"go.uber.org/cadence"
+
+	"github.com/uber/cadence/.gen/go/shared"
+	"github.com/uber/cadence/common/log/tag"
+)
+
+const (
+	// errReasonUnknownFilter fails the batch activity without retrying it, if BatchParams.FilterName is not in
+	// BootstrapParams.Filters of the batcher workers
+	errReasonUnknownFilter = "batcher:UnknownFilter"
+)
+
+type (
+	// FilterFn selects the workflows to process by what the visibility query can't express, e.g. the number of
+	// pending activities, it's given the describe response of a workflow matching the query and returns false
+	// to skip it
+	FilterFn func(*shared.DescribeWorkflowExecutionResponse) bool
+)
+
+// resolveFilter looks up BatchParams.FilterName in the filters of the batcher
+func resolveFilter(ctx context.Context, batchParams BatchParams) (BatchParams, error) {
+	if batchParams.FilterName == "" {
+		return batchParams, nil
+	}
+
+	batcher := ctx.Value(batcherContextKey).(*Batcher)
+	filter, ok := batcher.filters[batchParams.FilterName]
+	if !ok || filter == nil {
+		err := fmt.Errorf("unknown filter: %v", batchParams.FilterName)
+		getActivityLogger(ctx).Error("Failed to resolve filter of batch operation", tag.Error(err))
+		return batchParams, cadence.NewCustomError(errReasonUnknownFilter, err.Error())
+	}
+	batchParams._filter = filter
+	return batchParams, nil
+}
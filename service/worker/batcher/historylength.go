@@ -0,0 +1,70 @@
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package batcher
+
+import (
+	"context"
+	"errors"
+
+	"github.com/uber/cadence/.gen/go/shared"
+	"github.com/uber/cadence/client/frontend"
+	"github.com/uber/cadence/common"
+)
+
+// errTaskSkippedOversized is sent over respCh for the tasks whose workflow has a history longer than MaxHistoryLength
+var errTaskSkippedOversized = errors.New("task is skipped because workflow history is too long")
+
+// isOversized tells whether the history of the workflow of a task is longer than MaxHistoryLength. The history length
+// of the scan result is used if there is one, i.e. for the closed workflows, and the workflow is described otherwise.
+// A workflow which is gone is left to the operation to find out
+func isOversized(
+	ctx context.Context,
+	batchParams BatchParams,
+	task taskDetail,
+	client frontend.Client,
+) (bool, error) {
+	if batchParams.MaxHistoryLength <= 0 {
+		return false, nil
+	}
+	historyLength := task.historyLength
+	if historyLength <= 0 {
+		var resp *shared.DescribeWorkflowExecutionResponse
+		err := callWithOperationTimeout(ctx, batchParams.OperationTimeout, func(ctx context.Context) error {
+			var err error
+			resp, err = client.DescribeWorkflowExecution(ctx, &shared.DescribeWorkflowExecutionRequest{
+				Domain: common.StringPtr(batchParams.DomainName),
+				Execution: &shared.WorkflowExecution{
+					WorkflowId: task.execution.WorkflowId,
+					RunId:      task.execution.RunId,
+				},
+			})
+			return err
+		})
+		if _, ok := err.(*shared.EntityNotExistsError); ok {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		historyLength = resp.GetWorkflowExecutionInfo().GetHistoryLength()
+	}
+	return historyLength > int64(batchParams.MaxHistoryLength), nil
+}
@@ -0,0 +1,113 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package batcher
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/uber/cadence/.gen/go/shared"
+	"github.com/uber/cadence/client/frontend"
+	"github.com/uber/cadence/common"
+)
+
+type (
+	// BatchJobInfo is the info of a running batch operation job
+	BatchJobInfo struct {
+		JobID     string
+		RunID     string
+		StartTime time.Time
+		// Params are the parameters the batch job was started with
+		Params BatchParams
+	}
+)
+
+const listBatchOperationsPageSize = 100
+
+// ListBatchOperations returns the running batch operation jobs targeting the given domain,
+// or all of the running ones if domain is empty
+func ListBatchOperations(ctx context.Context, client frontend.Client, domain string) ([]BatchJobInfo, error) {
+	var jobs []BatchJobInfo
+	var nextPageToken []byte
+	for {
+		resp, err := client.ListOpenWorkflowExecutions(ctx, &shared.ListOpenWorkflowExecutionsRequest{
+			Domain:          common.StringPtr(common.SystemLocalDomainName),
+			MaximumPageSize: common.Int32Ptr(listBatchOperationsPageSize),
+			NextPageToken:   nextPageToken,
+			StartTimeFilter: &shared.StartTimeFilter{
+				EarliestTime: common.Int64Ptr(0),
+				LatestTime:   common.Int64Ptr(time.Now().UnixNano()),
+			},
+			TypeFilter: &shared.WorkflowTypeFilter{
+				Name: common.StringPtr(BatchWFTypeName),
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, wf := range resp.Executions {
+			params, err := getBatchParams(ctx, client, wf.Execution)
+			if err != nil {
+				return nil, err
+			}
+			if domain != "" && params.DomainName != domain {
+				continue
+			}
+			jobs = append(jobs, BatchJobInfo{
+				JobID:     wf.Execution.GetWorkflowId(),
+				RunID:     wf.Execution.GetRunId(),
+				StartTime: time.Unix(0, wf.GetStartTime()),
+				Params:    params,
+			})
+		}
+
+		nextPageToken = resp.NextPageToken
+		if len(nextPageToken) == 0 {
+			return jobs, nil
+		}
+	}
+}
+
+// getBatchParams recovers the BatchParams of a batch job from the input of its started event
+func getBatchParams(ctx context.Context, client frontend.Client, execution *shared.WorkflowExecution) (BatchParams, error) {
+	resp, err := client.GetWorkflowExecutionHistory(ctx, &shared.GetWorkflowExecutionHistoryRequest{
+		Domain:          common.StringPtr(common.SystemLocalDomainName),
+		Execution:       execution,
+		MaximumPageSize: common.Int32Ptr(1),
+	})
+	if err != nil {
+		return BatchParams{}, err
+	}
+	events := resp.GetHistory().GetEvents()
+	if len(events) == 0 || events[0].GetEventType() != shared.EventTypeWorkflowExecutionStarted {
+		return BatchParams{}, fmt.Errorf("missing started event of batch job %v", execution.GetWorkflowId())
+	}
+
+	var params BatchParams
+	input := events[0].WorkflowExecutionStartedEventAttributes.Input
+	if err := json.Unmarshal(input, &params); err != nil {
+		return BatchParams{}, fmt.Errorf("failed to decode params of batch job %v: %v", execution.GetWorkflowId(), err)
+	}
+	return params, nil
+}
@@ -0,0 +1,49 @@
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package batcher
+
+import (
+	"context"
+
+	"github.com/uber/cadence/common/log"
+	"github.com/uber/cadence/common/metrics"
+)
+
+type (
+	// batchMetrics is the metrics scope and the logger of a batch activity, tagged with the domain and the batch
+	// type of the job. It's created once per activity and passed to the task processors, so that neither the
+	// lookup of the batcher from the context nor the tags are repeated for every processed workflow
+	batchMetrics struct {
+		scope  metrics.Scope
+		logger log.Logger
+	}
+)
+
+func newBatchMetrics(ctx context.Context, batchParams BatchParams) *batchMetrics {
+	batcher := ctx.Value(batcherContextKey).(*Batcher)
+	return &batchMetrics{
+		scope: batcher.metricsClient.Scope(
+			metrics.BatcherScope,
+			metrics.DomainTag(batchParams.DomainName),
+			metrics.BatchTypeTag(batchParams.BatchType),
+		),
+		logger: getActivityLogger(ctx),
+	}
+}
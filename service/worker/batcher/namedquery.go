@@ -0,0 +1,74 @@
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package batcher
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"text/template"
+
+	"go.uber.org/cadence"
+
+	"github.com/uber/cadence/common/log/tag"
+	"github.com/uber/cadence/common/service/dynamicconfig"
+)
+
+const (
+	// errReasonInvalidNamedQuery fails the batch activity without retrying it, if BatchParams.QueryName is not
+	// in Config.NamedQueries or its template can't be rendered with BatchParams.QueryParams
+	errReasonInvalidNamedQuery = "batcher:InvalidNamedQuery"
+)
+
+// resolveNamedQuery renders the named query template of BatchParams.QueryName into BatchParams.Query
+func resolveNamedQuery(ctx context.Context, batchParams BatchParams) (BatchParams, error) {
+	if batchParams.QueryName == "" {
+		return batchParams, nil
+	}
+
+	batcher := ctx.Value(batcherContextKey).(*Batcher)
+	query, err := renderNamedQuery(batcher.cfg.NamedQueries, batchParams.QueryName, batchParams.QueryParams)
+	if err != nil {
+		getActivityLogger(ctx).Error("Failed to resolve named query of batch operation", tag.Error(err))
+		return batchParams, cadence.NewCustomError(errReasonInvalidNamedQuery, err.Error())
+	}
+	batchParams.Query = query
+	return batchParams, nil
+}
+
+func renderNamedQuery(namedQueries dynamicconfig.MapPropertyFn, name string, params map[string]string) (string, error) {
+	if namedQueries == nil {
+		return "", fmt.Errorf("unknown named query: %v", name)
+	}
+	queryTemplate, ok := namedQueries()[name].(string)
+	if !ok {
+		return "", fmt.Errorf("unknown named query: %v", name)
+	}
+	// a missing parameter fails the rendering rather than silently leaving a hole in the query
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(queryTemplate)
+	if err != nil {
+		return "", fmt.Errorf("named query %v is not a valid template: %v", name, err)
+	}
+	var query strings.Builder
+	if err := tmpl.Execute(&query, params); err != nil {
+		return "", fmt.Errorf("failed to render named query %v: %v", name, err)
+	}
+	return query.String(), nil
+}
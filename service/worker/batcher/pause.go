@@ -0,0 +1,172 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package batcher
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"go.uber.org/cadence/activity"
+	"go.uber.org/cadence/workflow"
+
+	"github.com/uber/cadence/.gen/go/shared"
+	"github.com/uber/cadence/client/frontend"
+	"github.com/uber/cadence/common"
+	"github.com/uber/cadence/common/log/tag"
+)
+
+const (
+	// BatchPauseSignalName pauses a running batch job, the tasks being processed are finished but no new task starts
+	BatchPauseSignalName = "pause"
+	// BatchResumeSignalName resumes a paused batch job
+	BatchResumeSignalName = "resume"
+	// batchPausedQueryType is how the batch activity learns whether its workflow is paused
+	batchPausedQueryType = "cadence-sys-batch-paused"
+)
+
+type (
+	// pauseGate blocks the task processors of a batch activity while the batch is paused
+	pauseGate struct {
+		sync.Mutex
+		cond           *sync.Cond
+		paused         bool
+		pausedSince    time.Time
+		pausedDuration time.Duration
+	}
+)
+
+// handlePauseSignals keeps track of the pause/resume signals of the batch workflow and serves it as a query
+func handlePauseSignals(ctx workflow.Context, paused bool) error {
+	if err := workflow.SetQueryHandler(ctx, batchPausedQueryType, func() (bool, error) {
+		return paused, nil
+	}); err != nil {
+		return err
+	}
+	pauseCh := workflow.GetSignalChannel(ctx, BatchPauseSignalName)
+	resumeCh := workflow.GetSignalChannel(ctx, BatchResumeSignalName)
+	workflow.Go(ctx, func(ctx workflow.Context) {
+		for {
+			selector := workflow.NewSelector(ctx)
+			selector.AddReceive(pauseCh, func(c workflow.Channel, more bool) {
+				c.Receive(ctx, nil)
+				paused = true
+			})
+			selector.AddReceive(resumeCh, func(c workflow.Channel, more bool) {
+				c.Receive(ctx, nil)
+				paused = false
+			})
+			selector.Select(ctx)
+		}
+	})
+	return nil
+}
+
+// queryPaused asks the batch workflow of the activity whether it is paused
+func queryPaused(ctx context.Context, client frontend.Client) (bool, error) {
+	info := activity.GetInfo(ctx)
+	var resp *shared.QueryWorkflowResponse
+	err := callWithOperationDeadline(ctx, func(ctx context.Context) error {
+		var err error
+		resp, err = client.QueryWorkflow(ctx, &shared.QueryWorkflowRequest{
+			Domain: common.StringPtr(info.WorkflowDomain),
+			Execution: &shared.WorkflowExecution{
+				WorkflowId: common.StringPtr(info.WorkflowExecution.ID),
+				RunId:      common.StringPtr(info.WorkflowExecution.RunID),
+			},
+			Query: &shared.WorkflowQuery{QueryType: common.StringPtr(batchPausedQueryType)},
+		})
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+	var paused bool
+	err = json.Unmarshal(resp.QueryResult, &paused)
+	return paused, err
+}
+
+// refreshPaused updates the gate with the paused state of the workflow, the gate is left as is if it can't be told
+func refreshPaused(ctx context.Context, client frontend.Client, gate *pauseGate) {
+	paused, err := queryPaused(ctx, client)
+	if err != nil {
+		getActivityLogger(ctx).Warn("Failed to query whether batch operation is paused", tag.Error(err))
+		return
+	}
+	gate.set(paused)
+}
+
+func newPauseGate(paused bool, pausedDuration time.Duration) *pauseGate {
+	gate := &pauseGate{pausedDuration: pausedDuration}
+	gate.cond = sync.NewCond(gate)
+	gate.set(paused)
+	return gate
+}
+
+func (g *pauseGate) set(paused bool) {
+	g.Lock()
+	defer g.Unlock()
+	if paused == g.paused {
+		return
+	}
+	g.paused = paused
+	if paused {
+		g.pausedSince = time.Now()
+		return
+	}
+	g.pausedDuration += time.Since(g.pausedSince)
+	g.cond.Broadcast()
+}
+
+// wait blocks until the gate is not paused or ctx is done, it returns false if ctx is done
+func (g *pauseGate) wait(ctx context.Context) bool {
+	g.Lock()
+	defer g.Unlock()
+	if g.paused {
+		// wake up the waiters on cancellation, as a condition variable can't wait on ctx
+		stop := make(chan struct{})
+		defer close(stop)
+		go func() {
+			select {
+			case <-ctx.Done():
+				g.Lock()
+				g.cond.Broadcast()
+				g.Unlock()
+			case <-stop:
+			}
+		}()
+	}
+	for g.paused && ctx.Err() == nil {
+		g.cond.Wait()
+	}
+	return ctx.Err() == nil
+}
+
+// state returns whether the gate is paused and the total paused duration including the ongoing pause
+func (g *pauseGate) state() (bool, time.Duration) {
+	g.Lock()
+	defer g.Unlock()
+	if g.paused {
+		return true, g.pausedDuration + time.Since(g.pausedSince)
+	}
+	return false, g.pausedDuration
+}
@@ -0,0 +1,104 @@
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package batcher
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.uber.org/cadence"
+	"go.uber.org/cadence/workflow"
+
+	"github.com/uber/cadence/.gen/go/shared"
+	"github.com/uber/cadence/client/frontend"
+	"github.com/uber/cadence/common/log/tag"
+)
+
+const (
+	batchPreflightActivityName = "cadence-sys-batch-preflight-activity"
+	// errReasonInvalidQuery fails the batch workflow before the batch activity starts if the visibility backend
+	// rejects a query of the batch job, the details are the error text of the backend
+	errReasonInvalidQuery = "batcher:InvalidQuery"
+)
+
+var (
+	batchPreflightActivityOptions = workflow.ActivityOptions{
+		StartToCloseTimeout: time.Minute,
+		RetryPolicy: &cadence.RetryPolicy{
+			InitialInterval:    time.Second,
+			BackoffCoefficient: 2,
+			MaximumInterval:    time.Minute,
+			ExpirationInterval: 10 * time.Minute,
+			NonRetriableErrorReasons: []string{
+				errReasonInvalidNamedQuery,
+				errReasonInvalidQuery,
+				errReasonUnknownFilter,
+			},
+		},
+	}
+)
+
+// runBatchPreflight runs BatchPreflightActivity on the tasklist of the batch activity, only the first run of
+// a batch job checks its queries as the continued runs go on with the same ones
+func runBatchPreflight(ctx workflow.Context, batchParams BatchParams, taskList string) error {
+	if batchParams.ContinuedDetails != nil || batchParams.BatchType == BatchTypeFailoverDomains {
+		return nil
+	}
+	activityOptions := batchPreflightActivityOptions
+	activityOptions.ScheduleToStartTimeout = batchParams.ActivityScheduleToStartTimeout
+	activityOptions.TaskList = taskList
+	opt := workflow.WithActivityOptions(ctx, activityOptions)
+	return workflow.ExecuteActivity(opt, batchPreflightActivityName, batchParams).Get(ctx, nil)
+}
+
+// BatchPreflightActivity reads a single workflow with each query of the batch job from the visibility backend,
+// so that a query the backend can't run, or an unknown filter, is rejected before the long running batch activity starts
+func BatchPreflightActivity(ctx context.Context, batchParams BatchParams) error {
+	batchParams = setDefaultParams(batchParams)
+	batcher := ctx.Value(batcherContextKey).(*Batcher)
+	client := batcher.clientBean.GetFrontendClient()
+	batchParams, err := resolveNamedQuery(ctx, batchParams)
+	if err != nil {
+		return err
+	}
+	if _, err := resolveFilter(ctx, batchParams); err != nil {
+		return err
+	}
+	for _, query := range []string{batchParams.Query, batchParams.PostOperationQuery} {
+		if query == "" {
+			continue
+		}
+		if err := preflightQuery(ctx, client, batchParams, query); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func preflightQuery(ctx context.Context, client frontend.Client, batchParams BatchParams, query string) error {
+	_, err := scanWorkflows(ctx, client, batchParams, query, 1, nil)
+	if badRequest, ok := err.(*shared.BadRequestError); ok {
+		getActivityLogger(ctx).Error("Query of batch operation is rejected by visibility", tag.Error(err))
+		return cadence.NewCustomError(errReasonInvalidQuery, fmt.Sprintf("invalid query %q: %v", query, badRequest.Message))
+	}
+	return err
+}
@@ -0,0 +1,65 @@
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package batcher
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/uber/cadence/.gen/go/shared"
+)
+
+// prioritizeExecutions moves the workflows of the page whose PrioritySearchAttribute is PriorityValue ahead of the
+// rest, keeping the order of the page otherwise. The tasks are taken off taskCh in the order they are dispatched,
+// so the priority ones are processed first. It returns the number of them
+func prioritizeExecutions(
+	batchParams BatchParams,
+	executions []*shared.WorkflowExecutionInfo,
+) ([]*shared.WorkflowExecutionInfo, int) {
+	if batchParams.PrioritySearchAttribute == "" {
+		return executions, 0
+	}
+	prioritized := make([]*shared.WorkflowExecutionInfo, 0, len(executions))
+	var rest []*shared.WorkflowExecutionInfo
+	for _, wf := range executions {
+		if isPriorityExecution(batchParams, wf) {
+			prioritized = append(prioritized, wf)
+		} else {
+			rest = append(rest, wf)
+		}
+	}
+	priorityCount := len(prioritized)
+	return append(prioritized, rest...), priorityCount
+}
+
+// isPriorityExecution compares the search attribute with PriorityValue in its string form, e.g. 1 for an int
+// attribute, a workflow without the attribute is not a priority one
+func isPriorityExecution(batchParams BatchParams, wf *shared.WorkflowExecutionInfo) bool {
+	raw, ok := wf.GetSearchAttributes().GetIndexedFields()[batchParams.PrioritySearchAttribute]
+	if !ok {
+		return false
+	}
+	var value interface{}
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return false
+	}
+	return fmt.Sprint(value) == batchParams.PriorityValue
+}
@@ -0,0 +1,116 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package batcher
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/uber/cadence/.gen/go/shared"
+	"github.com/uber/cadence/client/frontend"
+	"github.com/uber/cadence/common"
+)
+
+const (
+	// ResetTypeFirstDecisionCompleted resets a workflow to its first DecisionTaskCompleted event
+	ResetTypeFirstDecisionCompleted = "FirstDecisionCompleted"
+	// ResetTypeLastDecisionCompleted resets a workflow to its last DecisionTaskCompleted event
+	ResetTypeLastDecisionCompleted = "LastDecisionCompleted"
+
+	resetHistoryPageSize = 1000
+)
+
+// AllResetTypes is the reset types we supported for BatchTypeReset
+var AllResetTypes = []string{ResetTypeFirstDecisionCompleted, ResetTypeLastDecisionCompleted}
+
+func validateResetParams(params ResetParams) error {
+	if params.ResetType == "" {
+		if params.DecisionFinishEventID == nil && len(params.DecisionFinishEventIDs) == 0 {
+			return &paramsError{
+				kind: ErrMissingResetType,
+				msg:  ErrMissingResetType.Error(),
+			}
+		}
+		return nil
+	}
+	for _, resetType := range AllResetTypes {
+		if params.ResetType == resetType {
+			return nil
+		}
+	}
+	return fmt.Errorf("not supported reset type: %v", params.ResetType)
+}
+
+// getResetEventID returns the DecisionTaskCompleted event to reset the workflow to, it prefers the event ID of the
+// workflow in DecisionFinishEventIDs, then DecisionFinishEventID, then the one found by ResetType. It returns
+// errTaskSkipped if none of them is given for the workflow.
+func getResetEventID(
+	ctx context.Context,
+	batchParams BatchParams,
+	client frontend.Client,
+	workflowID string,
+	runID string,
+) (int64, error) {
+	params := batchParams.ResetParams
+	if eventID, ok := params.DecisionFinishEventIDs[workflowID]; ok {
+		return eventID, nil
+	}
+	if params.DecisionFinishEventID != nil {
+		return *params.DecisionFinishEventID, nil
+	}
+	if params.ResetType == "" {
+		return 0, errTaskSkipped
+	}
+
+	var eventID int64
+	var nextPageToken []byte
+	for {
+		resp, err := client.GetWorkflowExecutionHistory(ctx, &shared.GetWorkflowExecutionHistoryRequest{
+			Domain: common.StringPtr(batchParams.DomainName),
+			Execution: &shared.WorkflowExecution{
+				WorkflowId: common.StringPtr(workflowID),
+				RunId:      common.StringPtr(runID),
+			},
+			MaximumPageSize: common.Int32Ptr(resetHistoryPageSize),
+			NextPageToken:   nextPageToken,
+		})
+		if err != nil {
+			return 0, err
+		}
+		for _, e := range resp.GetHistory().GetEvents() {
+			if e.GetEventType() != shared.EventTypeDecisionTaskCompleted {
+				continue
+			}
+			eventID = e.GetEventId()
+			if params.ResetType == ResetTypeFirstDecisionCompleted {
+				return eventID, nil
+			}
+		}
+		nextPageToken = resp.NextPageToken
+		if len(nextPageToken) == 0 {
+			break
+		}
+	}
+	if eventID == 0 {
+		return 0, fmt.Errorf("no DecisionTaskCompleted event to reset workflow %v to", workflowID)
+	}
+	return eventID, nil
+}
@@ -0,0 +1,54 @@
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package batcher
+
+import (
+	"context"
+
+	"github.com/uber/cadence/common/log/tag"
+	"github.com/uber/cadence/common/metrics"
+)
+
+type (
+	// ResultSink records the result of every completed batch job to an external system, e.g. an audit log
+	// backed by Kafka, a database or an HTTP endpoint. RecordBatchResult may be called more than once for
+	// the same batch job as BatchActivity retries on its error, so it should be idempotent.
+	ResultSink interface {
+		RecordBatchResult(ctx context.Context, params BatchParams, result HeartBeatDetails) error
+	}
+
+	noopResultSink struct{}
+)
+
+// RecordBatchResult does nothing
+func (s *noopResultSink) RecordBatchResult(ctx context.Context, params BatchParams, result HeartBeatDetails) error {
+	return nil
+}
+
+// recordBatchResult sends the result of the completed batch job to the result sink, the activity fails
+// on its error so that the result gets recorded again by the retry
+func (s *Batcher) recordBatchResult(ctx context.Context, batchParams BatchParams, hbd HeartBeatDetails) error {
+	if err := s.resultSink.RecordBatchResult(ctx, batchParams, hbd); err != nil {
+		s.metricsClient.IncCounter(metrics.BatcherScope, metrics.BatcherResultSinkFailures)
+		getActivityLogger(ctx).Error("Failed to record the result of batch operation", tag.Error(err))
+		return err
+	}
+	return nil
+}
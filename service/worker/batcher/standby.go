@@ -0,0 +1,90 @@
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package batcher
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/uber/cadence/.gen/go/shared"
+	"github.com/uber/cadence/client/frontend"
+	"github.com/uber/cadence/common"
+	"github.com/uber/cadence/common/log/tag"
+	"github.com/uber/cadence/common/metrics"
+)
+
+// getStandbyCluster returns the active cluster of the target domain if the domain is global and not active in the
+// current cluster, and empty otherwise, or if the batcher is not configured with the cluster metadata
+func getStandbyCluster(ctx context.Context, batchParams BatchParams, client frontend.Client) (string, error) {
+	batcher := ctx.Value(batcherContextKey).(*Batcher)
+	if batcher.cfg.ClusterMetadata == nil {
+		return "", nil
+	}
+	var resp *shared.DescribeDomainResponse
+	err := callWithOperationTimeout(ctx, batchParams.OperationTimeout, func(ctx context.Context) error {
+		var err error
+		resp, err = client.DescribeDomain(ctx, &shared.DescribeDomainRequest{
+			Name: common.StringPtr(batchParams.DomainName),
+		})
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	activeCluster := resp.GetReplicationConfiguration().GetActiveClusterName()
+	if !resp.GetIsGlobalDomain() || activeCluster == batcher.cfg.ClusterMetadata.GetCurrentClusterName() {
+		return "", nil
+	}
+	return activeCluster, nil
+}
+
+// suppressOnStandby completes the batch as a dry run on a standby cluster of the target domain, the workflows are
+// only counted so that the batch can still be planned before a failover. The batch is not picked up again if the
+// domain fails over later, the workflows left are counted in TotalEstimate but processed by none of the counters
+func suppressOnStandby(
+	ctx context.Context,
+	batchParams BatchParams,
+	client frontend.Client,
+	bm *batchMetrics,
+	hbd HeartBeatDetails,
+	activeCluster string,
+) (HeartBeatDetails, error) {
+	batcher := ctx.Value(batcherContextKey).(*Batcher)
+	resp, err := client.CountWorkflowExecutions(ctx, &shared.CountWorkflowExecutionsRequest{
+		Domain: common.StringPtr(batchParams.DomainName),
+		Query:  common.StringPtr(batchParams.Query),
+	})
+	if err != nil {
+		return HeartBeatDetails{}, err
+	}
+	hbd.TotalEstimate = resp.GetCount()
+	hbd.SuppressedReason = fmt.Sprintf("%v is not run on a standby cluster, domain %v is active in cluster %v",
+		batchParams.BatchType, batchParams.DomainName, activeCluster)
+	hbd.PageToken = nil
+	hbd.Completed = true
+	bm.scope.IncCounter(metrics.BatcherSuppressedOnStandby)
+	bm.logger.Warn("Batch operation is suppressed on standby cluster, workflows are only counted",
+		tag.ClusterName(activeCluster), tag.Counter(int(hbd.TotalEstimate)))
+	if err := batcher.recordBatchResult(ctx, batchParams, hbd); err != nil {
+		return HeartBeatDetails{}, err
+	}
+	return hbd, nil
+}
@@ -0,0 +1,99 @@
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package batcher
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"go.uber.org/cadence/activity"
+	"go.uber.org/yarpc"
+
+	"github.com/uber/cadence/.gen/go/shared"
+	"github.com/uber/cadence/client/frontend"
+	"github.com/uber/cadence/common"
+)
+
+const (
+	// DefaultTagTimeout is the default value for TerminateParams.TagTimeout
+	DefaultTagTimeout = 10 * time.Second
+	// tagPollInterval is how often a workflow is described while waiting for it to upsert the tag
+	tagPollInterval = time.Second
+)
+
+var errTagTimeout = errors.New("workflow didn't upsert the tag in time")
+
+// tagExecution asks a workflow to upsert TerminateParams.TagSearchAttribute with the run ID of the batch workflow by
+// UpsertSearchAttributesSignalName, and waits up to TerminateParams.TagTimeout for the tag to show up in its search
+// attributes. Once terminated the workflow can't upsert anything, so the tag must be in place before the
+// termination for the closed visibility record to carry it.
+func tagExecution(
+	ctx context.Context,
+	batchParams BatchParams,
+	client frontend.Client,
+	workflowID string,
+	runID string,
+	yarpcCallOptions ...yarpc.CallOption,
+) error {
+	attribute := batchParams.TerminateParams.TagSearchAttribute
+	tag := activity.GetInfo(ctx).WorkflowExecution.RunID
+	input, _ := json.Marshal(map[string]interface{}{attribute: tag})
+	expected, _ := json.Marshal(tag)
+	execution := &shared.WorkflowExecution{
+		WorkflowId: common.StringPtr(workflowID),
+		RunId:      common.StringPtr(runID),
+	}
+	if err := client.SignalWorkflowExecution(ctx, &shared.SignalWorkflowExecutionRequest{
+		Domain:            common.StringPtr(batchParams.DomainName),
+		WorkflowExecution: execution,
+		Identity:          common.StringPtr(BatchWFTypeName),
+		SignalName:        common.StringPtr(UpsertSearchAttributesSignalName),
+		Input:             input,
+	}, yarpcCallOptions...); err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(batchParams.TerminateParams.TagTimeout)
+	for {
+		resp, err := client.DescribeWorkflowExecution(ctx, &shared.DescribeWorkflowExecutionRequest{
+			Domain:    common.StringPtr(batchParams.DomainName),
+			Execution: execution,
+		})
+		if err != nil {
+			return err
+		}
+		value := resp.GetWorkflowExecutionInfo().GetSearchAttributes().GetIndexedFields()[attribute]
+		if bytes.Equal(value, expected) {
+			return nil
+		}
+		if !time.Now().Add(tagPollInterval).Before(deadline) {
+			return errTagTimeout
+		}
+		select {
+		case <-time.After(tagPollInterval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,111 @@
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package batcher
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/uber/cadence/.gen/go/shared"
+	"github.com/uber/cadence/client/frontend"
+	"github.com/uber/cadence/common"
+	"github.com/uber/cadence/common/log/tag"
+	"github.com/uber/cadence/common/metrics"
+)
+
+const (
+	// DefaultVerifyDelay is the default value for VerifyDelay
+	DefaultVerifyDelay = 2 * time.Second
+	// verifyEffectAttempts is the number of times a workflow is terminated and verified before giving up
+	verifyEffectAttempts = 3
+)
+
+// errEffectNotVerified fails a task without retrying it, as the operation is already retried on the open run
+var errEffectNotVerified = errors.New("workflow is still open after the operation")
+
+// withEffectVerification wraps the terminate operation of VerifyEffect. After the operation succeeds, the current
+// run of the workflow is described once VerifyDelay has passed, and the operation is retried on the current run if
+// it's still open, e.g. as the terminated run continued as new or the workflow was restarted. Every open run found
+// is counted by verifyFailed, and errEffectNotVerified is returned once verifyEffectAttempts are used up.
+func withEffectVerification(
+	batchParams BatchParams,
+	client frontend.Client,
+	bm *batchMetrics,
+	verifyFailed *int64,
+	op func(context.Context, string, string) error,
+) func(context.Context, string, string) error {
+	if !batchParams.VerifyEffect {
+		return op
+	}
+	return func(ctx context.Context, workflowID, runID string) error {
+		for attempt := 1; ; attempt++ {
+			if err := op(ctx, workflowID, runID); err != nil {
+				return err
+			}
+			select {
+			case <-time.After(batchParams.VerifyDelay):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			openRunID, err := getOpenRunID(ctx, batchParams, client, workflowID)
+			if err != nil || openRunID == "" {
+				return err
+			}
+
+			atomic.AddInt64(verifyFailed, 1)
+			bm.scope.IncCounter(metrics.BatcherVerificationFailures)
+			bm.logger.Warn("Workflow is still open after batch operation",
+				tag.WorkflowID(workflowID), tag.WorkflowRunID(runID), tag.Attempt(int32(attempt)))
+			if attempt >= verifyEffectAttempts {
+				return errEffectNotVerified
+			}
+			runID = openRunID
+		}
+	}
+}
+
+// getOpenRunID returns the run ID of the current run of the workflow if it's open, and empty if it's closed or gone
+func getOpenRunID(
+	ctx context.Context,
+	batchParams BatchParams,
+	client frontend.Client,
+	workflowID string,
+) (string, error) {
+	resp, err := client.DescribeWorkflowExecution(ctx, &shared.DescribeWorkflowExecutionRequest{
+		Domain: common.StringPtr(batchParams.DomainName),
+		Execution: &shared.WorkflowExecution{
+			WorkflowId: common.StringPtr(workflowID),
+		},
+	})
+	if _, ok := err.(*shared.EntityNotExistsError); ok {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	info := resp.GetWorkflowExecutionInfo()
+	if info.CloseStatus != nil {
+		return "", nil
+	}
+	return info.GetExecution().GetRunId(), nil
+}
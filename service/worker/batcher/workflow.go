@@ -22,7 +22,14 @@ package batcher
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/template"
 	"time"
 
 	"github.com/google/uuid"
@@ -35,6 +42,7 @@ import (
 	"github.com/uber/cadence/.gen/go/shared"
 	"github.com/uber/cadence/client/frontend"
 	"github.com/uber/cadence/common"
+	"github.com/uber/cadence/common/backoff"
 	"github.com/uber/cadence/common/log"
 	"github.com/uber/cadence/common/log/tag"
 	"github.com/uber/cadence/common/metrics"
@@ -47,18 +55,37 @@ const (
 	// BatchWFTypeName is the workflow type
 	BatchWFTypeName   = "cadence-sys-batch-workflow"
 	batchActivityName = "cadence-sys-batch-activity"
+	// systemWorkflowTypePrefix is the prefix of the workflow types of cadence system workflows, e.g. BatchWFTypeName
+	systemWorkflowTypePrefix = "cadence-sys-"
 	// InfiniteDuration is a long duration(20 yrs) we used for infinite workflow running
 	InfiniteDuration = 20 * 365 * 24 * time.Hour
 	pageSize         = 1000
 
+	maxProgressPercentBeforeDone = 99
+	// the task processors start after a random delay from 0 to 2*processorStartDelay,
+	// so that they don't hit the frontend in lockstep
+	processorStartDelay = 500 * time.Millisecond
+
 	// DefaultRPS is the default RPS
 	DefaultRPS = 50
 	// DefaultConcurrency is the default concurrency
 	DefaultConcurrency = 5
 	// DefaultAttemptsOnRetryableError is the default value for AttemptsOnRetryableError
 	DefaultAttemptsOnRetryableError = 50
+	// DefaultHeartBeatEveryProcessed is the default value for HeartBeatEveryProcessed
+	DefaultHeartBeatEveryProcessed = 100
 	// DefaultActivityHeartBeatTimeout is the default value for ActivityHeartBeatTimeout
 	DefaultActivityHeartBeatTimeout = time.Second * 10
+	// DefaultOperationTimeout is the default value for OperationTimeout
+	DefaultOperationTimeout = 30 * time.Second
+	// DefaultMaxDescendants is the default value for MaxDescendants
+	DefaultMaxDescendants = 1000
+	// DefaultActivityScheduleToStartTimeout is the default value for ActivityScheduleToStartTimeout
+	DefaultActivityScheduleToStartTimeout = 5 * time.Minute
+	// DefaultActivityStartToCloseTimeout is the default value for ActivityStartToCloseTimeout
+	DefaultActivityStartToCloseTimeout = 6 * time.Hour
+	// MaxTerminateDetailsSize is the max size of TerminateParams.Details, same as the default blob size limit of frontend
+	MaxTerminateDetailsSize = 2 * 1024 * 1024
 )
 
 const (
@@ -68,10 +95,62 @@ const (
 	BatchTypeCancel = "cancel"
 	// BatchTypeSignal is batch type for signaling workflows
 	BatchTypeSignal = "signal"
+	// BatchTypeUpsertSearchAttributes is batch type for upserting search attributes of workflows
+	BatchTypeUpsertSearchAttributes = "upsert_search_attributes"
+	// BatchTypeReset is batch type for resetting workflows
+	BatchTypeReset = "reset"
+	// BatchTypeDeleteClosed is batch type for hard deleting closed workflows along with their visibility records,
+	// running workflows are left alone
+	BatchTypeDeleteClosed = "delete_closed"
+	// BatchTypeFailoverDomains is batch type for failing over the listed domains to another cluster, it operates
+	// on FailoverDomainsParams.Domains rather than the workflows of a query
+	BatchTypeFailoverDomains = "failover_domains"
+)
+
+// metrics emitted by BatchWorkflow through the workflow metrics scope, as the metrics client can only be used in activities
+const (
+	batchWorkflowCompletedCounter  = "batcher_workflow_completed"
+	batchWorkflowSuccessCountGauge = "batcher_workflow_success_count"
+	batchWorkflowErrorCountGauge   = "batcher_workflow_error_count"
+	batchWorkflowDispositionTag    = "disposition"
+
+	batchWorkflowDispositionCompleted = "completed"
+	batchWorkflowDispositionFailed    = "failed"
+	batchWorkflowDispositionCancelled = "cancelled"
+)
+
+// change IDs of workflow.GetVersion gating the changes to the control flow of BatchWorkflow, so that the batches
+// started by an older worker replay with the old path during a rolling deploy. They are named after the change as
+// "batcher-<change>" and must never be renamed or reused, bump the max version of the same change ID instead.
+const (
+	// continueAsNewChangeID gates continuing as new on ContinueAsNewPageThreshold
+	continueAsNewChangeID = "batcher-continue-as-new"
+	// preflightChangeID gates checking the queries with BatchPreflightActivity before the batch activity
+	preflightChangeID = "batcher-preflight"
+)
+
+// UpsertSearchAttributesSignalName is the system signal sent to each workflow of BatchTypeUpsertSearchAttributes,
+// its input is the JSON encoded attribute map which the workflow is expected to upsert
+const UpsertSearchAttributesSignalName = "_cadence_sys_upsert_search_attributes"
+
+// the orders of BatchParams.OrderBy
+const (
+	// OrderByStartTimeAsc processes the workflows with the earliest start time first
+	OrderByStartTimeAsc = "StartTimeAsc"
+	// OrderByStartTimeDesc processes the workflows with the latest start time first
+	OrderByStartTimeDesc = "StartTimeDesc"
 )
 
 // AllBatchTypes is the batch types we supported
-var AllBatchTypes = []string{BatchTypeTerminate, BatchTypeCancel, BatchTypeSignal}
+var AllBatchTypes = []string{
+	BatchTypeTerminate,
+	BatchTypeCancel,
+	BatchTypeSignal,
+	BatchTypeUpsertSearchAttributes,
+	BatchTypeReset,
+	BatchTypeDeleteClosed,
+	BatchTypeFailoverDomains,
+}
 
 type (
 	// TerminateParams is the parameters for terminating workflow
@@ -80,20 +159,72 @@ type (
 		// TODO https://github.com/uber/cadence/issues/2159
 		// Ideally default should be childPolicy of the workflow. But it's currently totally broken.
 		TerminateChildren *bool
+		// Details is recorded in the termination event of every terminated workflow, e.g. a JSON blob for provenance
+		Details []byte
+		// ArchiveAfter sends the history of every terminated workflow to archival right away instead of waiting
+		// for the retention of the domain. It is ignored if history archival is not enabled for the domain.
+		ArchiveAfter bool
+		// TagSearchAttribute tags every workflow with the run ID of the batch workflow under this keyword search
+		// attribute before terminating it, so that the workflows terminated by a batch can be listed later. The
+		// workflow is asked to upsert the tag by UpsertSearchAttributesSignalName, the same as
+		// BatchTypeUpsertSearchAttributes, and it's terminated once the tag shows up or after TagTimeout anyway.
+		// Workflows failing to be tagged are counted by TagFailedCount
+		TagSearchAttribute string
+		// TagTimeout is how long to wait for a workflow to upsert TagSearchAttribute, it must be shorter than
+		// OperationTimeout. Default to DefaultTagTimeout
+		TagTimeout time.Duration
 	}
 
 	// CancelParams is the parameters for canceling workflow
 	CancelParams struct {
-		// this indicates whether to cancel children workflow. Default to true.
-		// TODO https://github.com/uber/cadence/issues/2159
-		// Ideally default should be childPolicy of the workflow. But it's currently totally broken.
+		// this indicates whether to request cancellation of the pending children workflow, recursively.
+		// It's only used when ChildPolicy is not set.
 		CancelChildren *bool
+		// ChildPolicy is one of AllChildPolicies, it tells what to do with the pending children workflow
+		// found by describing the canceled workflow, recursively. Default to what CancelChildren stands for.
+		ChildPolicy string
 	}
 
 	// SignalParams is the parameters for signaling workflow
 	SignalParams struct {
 		SignalName string
-		Input      string
+		// Input is rendered as a Go template for every workflow, with access to {{.WorkflowID}} and {{.RunID}}.
+		// A plain string without any template actions is sent as is.
+		Input string
+	}
+
+	// UpsertSearchAttributesParams is the parameters for upserting search attributes of workflow
+	UpsertSearchAttributesParams struct {
+		SearchAttributes map[string]interface{}
+	}
+
+	// DeleteClosedParams is the parameters for hard deleting closed workflow
+	DeleteClosedParams struct {
+		// AdminOperationSignature is SignAdminOperation of the batch job with the admin operation token of the batcher
+		// workers. The token itself is not carried as the params are recorded in the history of the batch workflow
+		AdminOperationSignature string
+	}
+
+	// FailoverDomainsParams is the parameters for failing over domains
+	FailoverDomainsParams struct {
+		// Domains to update the active cluster of
+		Domains []string
+		// ActiveClusterName is the cluster to failover the domains to
+		ActiveClusterName string
+		// AdminOperationSignature is SignAdminOperation of the batch job with the admin operation token of the batcher
+		// workers, the same as DeleteClosedParams
+		AdminOperationSignature string
+	}
+
+	// ResetParams is the parameters for resetting workflow
+	ResetParams struct {
+		// ResetType is one of AllResetTypes, it's the reset point of the workflows without a decision finish event ID
+		ResetType string
+		// DecisionFinishEventID is the DecisionTaskCompleted event to reset every workflow to
+		DecisionFinishEventID *int64
+		// DecisionFinishEventIDs are the DecisionTaskCompleted events to reset to keyed by WorkflowID, they take
+		// precedence over DecisionFinishEventID. A workflow without any reset point is skipped
+		DecisionFinishEventIDs map[string]int64
 	}
 
 	// BatchParams is the parameters for batch operation workflow
@@ -102,8 +233,24 @@ type (
 		DomainName string
 		// To get the target workflows for processing
 		Query string
+		// QueryName references a query template of Config.NamedQueries instead of Query, the template is rendered
+		// with QueryParams by the batch activity, e.g. {{.WorkflowType}} is replaced by QueryParams["WorkflowType"]
+		QueryName   string
+		QueryParams map[string]string
+		// OrderBy is one of OrderByStartTimeAsc/OrderByStartTimeDesc to process the workflows in that order, default
+		// to the order of the visibility backend. The ordered pages are read with the list API, which unlike the scan
+		// API doesn't read from a snapshot of the results: once the operation makes the processed workflows stop
+		// matching the query, e.g. terminating the workflows of "CloseTime = missing", the later pages shift and some
+		// workflows are missed, use PostOperationQuery with the same query to pick them up
+		OrderBy string
+		// PostOperationQuery re-scans the workflows with a second query once the operation is done on Query, and
+		// applies the same operation to them once more, e.g. to re-signal the workflows which didn't ack the signal
+		PostOperationQuery string
 		// Reason for the operation
 		Reason string
+		// OperatorIdentity is who launched the batch operation, it's recorded along with the reason of every
+		// terminated/canceled workflow for auditing
+		OperatorIdentity string
 		// Supporting: reset,terminate
 		BatchType string
 
@@ -114,6 +261,14 @@ type (
 		CancelParams CancelParams
 		// SignalParams is params only for BatchTypeSignal
 		SignalParams SignalParams
+		// UpsertSearchAttributesParams is params only for BatchTypeUpsertSearchAttributes
+		UpsertSearchAttributesParams UpsertSearchAttributesParams
+		// ResetParams is params only for BatchTypeReset
+		ResetParams ResetParams
+		// DeleteClosedParams is params only for BatchTypeDeleteClosed
+		DeleteClosedParams DeleteClosedParams
+		// FailoverDomainsParams is params only for BatchTypeFailoverDomains
+		FailoverDomainsParams FailoverDomainsParams
 		// RPS of processing. Default to DefaultRPS
 		// TODO we will implement smarter way than this static rate limiter: https://github.com/uber/cadence/issues/2138
 		RPS int
@@ -121,12 +276,99 @@ type (
 		Concurrency int
 		// Number of attempts for each workflow to process in case of retryable error before giving up
 		AttemptsOnRetryableError int
+		// MaxErrorRate stops the batch activity once more than this ratio of the recent operations fail, e.g. 0.5,
+		// so that a systemic failure makes the activity back off and retry instead of retrying every workflow.
+		// Default to 0 which never stops
+		MaxErrorRate float64
 		// timeout for activity heartbeat
 		ActivityHeartBeatTimeout time.Duration
+		// OperationTimeout bounds each RPC made to process a workflow or domain, e.g. terminating a workflow, a timed
+		// out RPC fails the task which is retried as any other retryable error. Default to DefaultOperationTimeout
+		OperationTimeout time.Duration
+		// MaxDescendants is the max number of descendants of a workflow that the child policy is applied on, the
+		// pending children found beyond it are left alone, so that a huge tree of workflows can't blow up the
+		// memory of a task. Default to DefaultMaxDescendants
+		MaxDescendants int
+		// MaxHistoryLength skips the workflows with a history longer than it, so that the workflows with enormous
+		// histories are not re-driven in bulk. The children of the workflows are not checked. Default to 0 which
+		// has no limit
+		MaxHistoryLength int
+		// VerifyEffect describes the current run of every terminated workflow after VerifyDelay, and terminates it
+		// again if it's still open, e.g. as it continued as new, up to a few times. Only supported by
+		// BatchTypeTerminate, the workflows still open at last fail their tasks
+		VerifyEffect bool
+		// VerifyDelay is how long to wait before verifying the effect of an operation, it must leave enough of
+		// OperationTimeout for the retries of VerifyEffect. Default to DefaultVerifyDelay
+		VerifyDelay time.Duration
+		// HeartBeatEveryProcessed is the number of processed workflows of a page after which the progress is
+		// heartbeated, so that a slow page doesn't go without heartbeat. Default to DefaultHeartBeatEveryProcessed
+		HeartBeatEveryProcessed int
+		// timeout for the batch activity to wait in the batcher tasklist before getting picked up by a worker
+		ActivityScheduleToStartTimeout time.Duration
+		// ActivityStartToCloseTimeout is the timeout of an attempt of the batch activity. Default to
+		// DefaultActivityStartToCloseTimeout. A timed out attempt is retried and resumes from its last heartbeat,
+		// so this only bounds how long a stuck attempt goes unnoticed. With ContinueAsNewPageThreshold every run
+		// has its own activity, so the timeout should leave enough room to process the pages of a run.
+		ActivityStartToCloseTimeout time.Duration
+		// TaskListShard is the shard of batcher tasklist to run the batch activity on, default to 0 which is
+		// the tasklist of the batch workflow itself. It must be less than the worker.batcherTaskListShards of the batcher workers,
+		// otherwise the activity will never be picked up and times out on ActivityScheduleToStartTimeout
+		TaskListShard int
+		// ExcludeWorkflowTypes are the workflow types to skip even if they match the query, e.g. cron workflows
+		ExcludeWorkflowTypes []string
+		// ExcludeWorkflowIDs and ExcludeRunIDs are the workflows to skip even if they match the query, they
+		// are checked before dispatching so that a broad query can be overridden without narrowing it
+		ExcludeWorkflowIDs []string
+		ExcludeRunIDs      []string
+		// ExcludeSystemDomain skips all the workflows if DomainName is the cadence system domain
+		ExcludeSystemDomain bool
+		// IncludeSystemWorkflows processes the cadence system workflows matching the query, which are skipped by
+		// default. The batch workflow running the operation is always skipped
+		IncludeSystemWorkflows bool
+		// PrioritySearchAttribute and PriorityValue make the workflows whose search attribute has the value, e.g.
+		// the production-critical ones, processed ahead of the others. The visibility backend has to return the
+		// search attribute along with the workflows, and the priority only applies within each page of PageSize
+		PrioritySearchAttribute string
+		PriorityValue           string
+		// CompletionSignal is the workflow to signal with the final HeartBeatDetails once the batch completes,
+		// default to empty which signals nothing
+		CompletionSignal CompletionSignal
+		// FilterName references a FilterFn of BootstrapParams.Filters, the workflows matching the query are described
+		// before the operation and skipped unless the filter accepts them. Default to empty which processes them all
+		FilterName string
 		// errors that will not retry which consumes AttemptsOnRetryableError. Default to empty
 		NonRetryableErrors []string
+		// NonRetryableErrorTypes are the types of errors that will not retry, e.g. ErrorTypeBadRequest, matched
+		// regardless of the error messages which may change across versions. Default to empty, except for
+		// BatchTypeFailoverDomains which defaults to ErrorTypeBadRequest and ErrorTypeEntityNotExists
+		NonRetryableErrorTypes []string
+		// StartPageToken is the page token to resume a previous batch from, must come with the same query of that batch
+		StartPageToken []byte
+		// InitialSuccessCount and InitialErrorCount are carried over counters of the previous batch, only used along with StartPageToken
+		InitialSuccessCount int
+		InitialErrorCount   int
+		// ContinueAsNewPageThreshold is the number of pages for a run of the batch workflow to process before it
+		// continues as new, so that the history stays bounded for a very long batch. Default to 0 which never continues
+		ContinueAsNewPageThreshold int
+		// ContinuedDetails is set by the batch workflow when it continues as new, to carry over the page token
+		// and the counters of the previous runs
+		ContinuedDetails *HeartBeatDetails
 		// internal conversion for NonRetryableErrors
 		_nonRetryableErrors map[string]struct{}
+		// internal conversion for ExcludeWorkflowTypes
+		_excludeWorkflowTypes map[string]struct{}
+		// internal conversion for ExcludeWorkflowIDs and ExcludeRunIDs
+		_excludeWorkflowIDs map[string]struct{}
+		_excludeRunIDs      map[string]struct{}
+		// internal lookups of the domain for TerminateParams.ArchiveAfter, empty if archival is not enabled
+		_archivalDomainID   string
+		_historyArchivalURI string
+		// internal lookup of the domain for BatchTypeDeleteClosed
+		_deleteDomainID string
+		// internal conversion for SignalParams.Input
+		_signalInputTemplate *template.Template
+		// internal lookup of FilterName
+		_filter FilterFn
 	}
 
 	// HeartBeatDetails is the struct for heartbeat details
@@ -139,34 +381,134 @@ type (
 		SuccessCount int
 		// Number of workflows that give up due to errors.
 		ErrorCount int
+		// PostOperationPass is set once the workflows of Query are done and the ones of PostOperationQuery are
+		// being processed, they are counted in PostOperationSuccessCount/PostOperationErrorCount instead
+		PostOperationPass         bool
+		PostOperationSuccessCount int
+		PostOperationErrorCount   int
+		// Number of child workflows that the operation is applied on by the child policy, they are not
+		// counted in SuccessCount
+		ChildrenProcessedCount int
+		// Number of workflows that are skipped due to ExcludeWorkflowTypes/ExcludeWorkflowIDs/ExcludeRunIDs/
+		// ExcludeSystemDomain/IncludeSystemWorkflows/FilterName
+		SkippedCount int
+		// Number of workflows that are not signaled because they are already closed
+		SkippedClosedCount int
+		// Number of workflows that are skipped because their history is longer than MaxHistoryLength
+		SkippedOversizedCount int
+		// Number of dispatched workflows matching PrioritySearchAttribute/PriorityValue and the rest of them, only
+		// counted along with PrioritySearchAttribute
+		PriorityCount int
+		NormalCount   int
+		// Number of workflows that are gone by the time they are processed, i.e. completed or deleted after the scan
+		NotFoundCount int
+		// Number of terminated workflows that are sent to archival, only for TerminateParams.ArchiveAfter
+		ArchivedCount int
+		// Number of terminated workflows that are not sent to archival, only for TerminateParams.ArchiveAfter
+		TerminatedOnlyCount int
+		// Number of workflows that are terminated without the tag of TerminateParams.TagSearchAttribute
+		TagFailedCount int
+		// Number of times a workflow is found still open after terminated, only for VerifyEffect
+		VerificationFailedCount int
+		// CurrentPageProcessedCount is the number of finished workflows of the current page. They are only added
+		// to the other counters once the page is done, as a retried activity processes the page from its start
+		CurrentPageProcessedCount int
+		// FailedDomains are the domains that failed to failover, only for BatchTypeFailoverDomains
+		FailedDomains []string
+		// Number of tasks being processed at the time of heartbeat
+		InFlight int
+		// Number of tasks waiting to be processed at the time of heartbeat
+		QueueDepth int
+		// ProgressPercent is the percentage of the finished workflows over TotalEstimate, it stays at most
+		// maxProgressPercentBeforeDone until the last page is processed as TotalEstimate is only an estimation
+		ProgressPercent float64
+		// EstimatedCompletion is derived from the processing rate of the current activity attempt,
+		// zero if it can't be estimated yet
+		EstimatedCompletion time.Time
+		// Completed is set once all the pages are processed, a retried activity returns right away then
+		Completed bool
+		// SuppressedReason tells why the batch is completed without processing any workflow, e.g. as the cluster
+		// is standby for the target domain. Only TotalEstimate is counted then
+		SuppressedReason string
+		// ErrorRateExceededCount is the number of times the batch activity is stopped by MaxErrorRate
+		ErrorRateExceededCount int
+		// Paused is set while the batch is paused by BatchPauseSignalName, PausedDuration is the total time paused
+		Paused         bool
+		PausedDuration time.Duration
+	}
+
+	// signalInputData is what the template of SignalParams.Input is rendered with
+	signalInputData struct {
+		WorkflowID string
+		RunID      string
 	}
 
 	taskDetail struct {
 		execution shared.WorkflowExecution
-		attempts  int
+		// workflowType is from the scan result, it can be empty
+		workflowType string
+		// historyLength is from the scan result, it's 0 for the open workflows
+		historyLength int64
+		attempts      int
 		// passing along the current heartbeat details to make heartbeat within a task so that it won't timeout
 		hbd HeartBeatDetails
 	}
+
+	// paramsError carries the details of a validation failure in its message, while errors.Is tells its kind
+	paramsError struct {
+		kind error
+		msg  string
+	}
+
+	// taskRetryQueue is an unbounded queue of the tasks to retry, shared by all the task processors of a batch
+	taskRetryQueue struct {
+		sync.Mutex
+		tasks []taskDetail
+		// notifyCh wakes up an idle task processor when a task is pushed
+		notifyCh chan struct{}
+	}
 )
 
 var (
+	// ErrMissingRequiredParams is returned if any of BatchType/Reason/OperatorIdentity/DomainName/Query is missing,
+	// QueryName can be provided instead of Query
+	ErrMissingRequiredParams = errors.New("must provide required parameters: BatchType/Reason/OperatorIdentity/DomainName/Query")
+	// ErrMissingSignalName is returned if the signal name is missing for BatchTypeSignal
+	ErrMissingSignalName = errors.New("must provide signal name")
+	// ErrUnsupportedBatchType is returned if BatchType is not one of AllBatchTypes
+	ErrUnsupportedBatchType = errors.New("not supported batch type")
+	// ErrMissingResetType is returned if neither a reset type nor a decision finish event ID is given for BatchTypeReset
+	ErrMissingResetType = errors.New("must provide reset type or decision finish event ID")
+
+	// errTaskSkipped is sent over respCh for the tasks skipped by shouldSkipTask, or without any reset point
+	errTaskSkipped = errors.New("task is skipped")
+	// errTaskSkippedClosed is sent over respCh for the signal tasks whose workflow is already closed
+	errTaskSkippedClosed = errors.New("task is skipped because workflow is closed")
+	// errTaskNotFound is sent over respCh for the tasks whose workflow doesn't exist any more when processed
+	errTaskNotFound = errors.New("task is skipped because workflow is not found")
+
 	batchActivityRetryPolicy = cadence.RetryPolicy{
 		InitialInterval:    10 * time.Second,
 		BackoffCoefficient: 1.7,
 		MaximumInterval:    5 * time.Minute,
 		ExpirationInterval: InfiniteDuration,
+		NonRetriableErrorReasons: []string{
+			errReasonInvalidAdminOperationToken,
+			errReasonInvalidNamedQuery,
+			errReasonTargetDomainGone,
+			errReasonUnknownFilter,
+		},
 	}
 
 	batchActivityOptions = workflow.ActivityOptions{
-		ScheduleToStartTimeout: 5 * time.Minute,
-		StartToCloseTimeout:    InfiniteDuration,
-		RetryPolicy:            &batchActivityRetryPolicy,
+		RetryPolicy: &batchActivityRetryPolicy,
 	}
 )
 
 func init() {
 	workflow.RegisterWithOptions(BatchWorkflow, workflow.RegisterOptions{Name: BatchWFTypeName})
 	activity.RegisterWithOptions(BatchActivity, activity.RegisterOptions{Name: batchActivityName})
+	activity.RegisterWithOptions(BatchPreflightActivity, activity.RegisterOptions{Name: batchPreflightActivityName})
 }
 
 // BatchWorkflow is the workflow that runs a batch job of resetting workflows
@@ -174,34 +516,172 @@ func BatchWorkflow(ctx workflow.Context, batchParams BatchParams) (HeartBeatDeta
 	batchParams = setDefaultParams(batchParams)
 	err := validateParams(batchParams)
 	if err != nil {
+		emitBatchWorkflowMetrics(ctx, HeartBeatDetails{}, err)
+		return HeartBeatDetails{}, err
+	}
+	paused := batchParams.ContinuedDetails != nil && batchParams.ContinuedDetails.Paused
+	if err := handlePauseSignals(ctx, paused); err != nil {
 		return HeartBeatDetails{}, err
 	}
-	batchActivityOptions.HeartbeatTimeout = batchParams.ActivityHeartBeatTimeout
-	opt := workflow.WithActivityOptions(ctx, batchActivityOptions)
+	continueAsNewVersion := workflow.GetVersion(ctx, continueAsNewChangeID, workflow.DefaultVersion, 1)
+	if continueAsNewVersion == workflow.DefaultVersion {
+		// the batch started before continuing as new was supported, it has to process all the pages in one run
+		batchParams.ContinueAsNewPageThreshold = 0
+	}
+	activityOptions := batchActivityOptions
+	activityOptions.HeartbeatTimeout = batchParams.ActivityHeartBeatTimeout
+	activityOptions.ScheduleToStartTimeout = batchParams.ActivityScheduleToStartTimeout
+	activityOptions.StartToCloseTimeout = batchParams.ActivityStartToCloseTimeout
+	activityOptions.TaskList = getBatcherTaskListName(workflow.GetInfo(ctx).TaskListName, batchParams.TaskListShard)
+	if workflow.GetVersion(ctx, preflightChangeID, workflow.DefaultVersion, 1) != workflow.DefaultVersion {
+		if err := runBatchPreflight(ctx, batchParams, activityOptions.TaskList); err != nil {
+			emitBatchWorkflowMetrics(ctx, HeartBeatDetails{}, err)
+			return HeartBeatDetails{}, err
+		}
+	}
+	opt := workflow.WithActivityOptions(ctx, activityOptions)
 	var result HeartBeatDetails
 	err = workflow.ExecuteActivity(opt, batchActivityName, batchParams).Get(ctx, &result)
-	return result, err
+	if err != nil {
+		emitBatchWorkflowMetrics(ctx, result, err)
+		return result, err
+	}
+	// the activity only stops with more pages to process when ContinueAsNewPageThreshold is reached
+	if continueAsNewVersion != workflow.DefaultVersion && len(result.PageToken) > 0 {
+		result.InFlight = 0
+		result.QueueDepth = 0
+		batchParams.ContinuedDetails = &result
+		return HeartBeatDetails{}, workflow.NewContinueAsNewError(ctx, BatchWFTypeName, batchParams)
+	}
+	emitBatchWorkflowMetrics(ctx, result, nil)
+	sendCompletionSignal(ctx, batchParams, result)
+	return result, nil
+}
+
+// emitBatchWorkflowMetrics records the disposition of a finished batch, along with the final counters if it completed
+func emitBatchWorkflowMetrics(ctx workflow.Context, result HeartBeatDetails, err error) {
+	disposition := batchWorkflowDispositionCompleted
+	if cadence.IsCanceledError(err) {
+		disposition = batchWorkflowDispositionCancelled
+	} else if err != nil {
+		disposition = batchWorkflowDispositionFailed
+	}
+	scope := workflow.GetMetricsScope(ctx).Tagged(map[string]string{batchWorkflowDispositionTag: disposition})
+	scope.Counter(batchWorkflowCompletedCounter).Inc(1)
+	if err == nil {
+		scope.Gauge(batchWorkflowSuccessCountGauge).Update(float64(result.SuccessCount))
+		scope.Gauge(batchWorkflowErrorCountGauge).Update(float64(result.ErrorCount))
+	}
+}
+
+// ValidateParams validates the params of a batch job the same way as BatchWorkflow does, so that callers can
+// reject invalid params before starting a batch job. It also requires OperatorIdentity, which BatchWorkflow
+// doesn't so that the batch jobs started without it keep running. The errors can be told apart with errors.Is
+// against ErrMissingRequiredParams, ErrMissingSignalName, ErrMissingResetType and ErrUnsupportedBatchType.
+func ValidateParams(params BatchParams) error {
+	if params.OperatorIdentity == "" {
+		return ErrMissingRequiredParams
+	}
+	return validateParams(setDefaultParams(params))
 }
 
 func validateParams(params BatchParams) error {
 	if params.BatchType == "" ||
 		params.Reason == "" ||
 		params.DomainName == "" ||
-		params.Query == "" {
-		return fmt.Errorf("must provide required parameters: BatchType/Reason/DomainName/Query")
+		(params.Query == "" && params.QueryName == "" && params.BatchType != BatchTypeFailoverDomains) {
+		return ErrMissingRequiredParams
+	}
+	if params.Query != "" && params.QueryName != "" {
+		return fmt.Errorf("must not provide both Query and QueryName")
+	}
+	if len(params.StartPageToken) == 0 && (params.InitialSuccessCount != 0 || params.InitialErrorCount != 0) {
+		return fmt.Errorf("must provide StartPageToken along with InitialSuccessCount/InitialErrorCount")
+	}
+	if params.InitialSuccessCount < 0 || params.InitialErrorCount < 0 {
+		return fmt.Errorf("InitialSuccessCount/InitialErrorCount must not be negative")
+	}
+	if params.ActivityScheduleToStartTimeout <= 0 {
+		return fmt.Errorf("ActivityScheduleToStartTimeout must be positive")
+	}
+	if params.ActivityStartToCloseTimeout <= 0 {
+		return fmt.Errorf("ActivityStartToCloseTimeout must be positive")
+	}
+	if params.TaskListShard < 0 {
+		return fmt.Errorf("TaskListShard must not be negative")
+	}
+	if params.ContinueAsNewPageThreshold < 0 {
+		return fmt.Errorf("ContinueAsNewPageThreshold must not be negative")
+	}
+	if params.MaxErrorRate < 0 || params.MaxErrorRate >= 1 {
+		return fmt.Errorf("MaxErrorRate must be in [0, 1)")
+	}
+	if err := validateOrderBy(params); err != nil {
+		return err
+	}
+	if params.FilterName != "" && params.BatchType == BatchTypeFailoverDomains {
+		return fmt.Errorf("FilterName is not supported by %v", BatchTypeFailoverDomains)
+	}
+	if params.PriorityValue != "" && params.PrioritySearchAttribute == "" {
+		return fmt.Errorf("must provide PrioritySearchAttribute along with PriorityValue")
+	}
+	if params.PrioritySearchAttribute != "" && params.BatchType == BatchTypeFailoverDomains {
+		return fmt.Errorf("PrioritySearchAttribute is not supported by %v", BatchTypeFailoverDomains)
+	}
+	if params.VerifyEffect && params.BatchType != BatchTypeTerminate {
+		return fmt.Errorf("VerifyEffect is only supported by %v", BatchTypeTerminate)
+	}
+	if params.VerifyEffect && params.VerifyDelay*verifyEffectAttempts >= params.OperationTimeout {
+		return fmt.Errorf("VerifyDelay must be shorter than OperationTimeout/%v", verifyEffectAttempts)
+	}
+	if err := validateErrorTypes(params.NonRetryableErrorTypes); err != nil {
+		return err
+	}
+	if (params.CompletionSignal.WorkflowID == "") != (params.CompletionSignal.SignalName == "") {
+		return fmt.Errorf("must provide both WorkflowID and SignalName of CompletionSignal")
 	}
 	switch params.BatchType {
 	case BatchTypeSignal:
 		if params.SignalParams.SignalName == "" {
-			return fmt.Errorf("must provide signal name")
+			return ErrMissingSignalName
+		}
+		if _, err := parseSignalInput(params.SignalParams.Input); err != nil {
+			return fmt.Errorf("signal input is not a valid template: %v", err)
 		}
 		return nil
-	case BatchTypeCancel:
-		fallthrough
 	case BatchTypeTerminate:
+		if len(params.TerminateParams.Details) > MaxTerminateDetailsSize {
+			return fmt.Errorf("terminate details exceeds size limit: %v bytes", MaxTerminateDetailsSize)
+		}
+		// the tagging and the termination of a workflow share the operation timeout
+		if params.TerminateParams.TagSearchAttribute != "" && params.TerminateParams.TagTimeout >= params.OperationTimeout {
+			return fmt.Errorf("TagTimeout must be shorter than OperationTimeout")
+		}
 		return nil
+	case BatchTypeCancel:
+		return validateChildPolicy(params.CancelParams.ChildPolicy)
+	case BatchTypeUpsertSearchAttributes:
+		if len(params.UpsertSearchAttributesParams.SearchAttributes) == 0 {
+			return fmt.Errorf("must provide search attributes")
+		}
+		if _, err := json.Marshal(params.UpsertSearchAttributesParams.SearchAttributes); err != nil {
+			return fmt.Errorf("search attributes are not JSON serializable: %v", err)
+		}
+		return nil
+	case BatchTypeReset:
+		return validateResetParams(params.ResetParams)
+	case BatchTypeDeleteClosed:
+		if params.DeleteClosedParams.AdminOperationSignature == "" {
+			return fmt.Errorf("must provide admin operation signature")
+		}
+		return nil
+	case BatchTypeFailoverDomains:
+		return validateFailoverDomainsParams(params)
 	default:
-		return fmt.Errorf("not supported batch type: %v", params.BatchType)
+		return &paramsError{
+			kind: ErrUnsupportedBatchType,
+			msg:  fmt.Sprintf("%v: %v", ErrUnsupportedBatchType, params.BatchType),
+		}
 	}
 }
 
@@ -218,12 +698,61 @@ func setDefaultParams(params BatchParams) BatchParams {
 	if params.ActivityHeartBeatTimeout <= 0 {
 		params.ActivityHeartBeatTimeout = DefaultActivityHeartBeatTimeout
 	}
+	if params.OperationTimeout <= 0 {
+		params.OperationTimeout = DefaultOperationTimeout
+	}
+	if params.MaxDescendants <= 0 {
+		params.MaxDescendants = DefaultMaxDescendants
+	}
+	if params.VerifyDelay <= 0 {
+		params.VerifyDelay = DefaultVerifyDelay
+	}
+	if params.TerminateParams.TagTimeout <= 0 {
+		params.TerminateParams.TagTimeout = DefaultTagTimeout
+	}
+	if params.HeartBeatEveryProcessed <= 0 {
+		params.HeartBeatEveryProcessed = DefaultHeartBeatEveryProcessed
+	}
+	if params.ActivityScheduleToStartTimeout == 0 {
+		params.ActivityScheduleToStartTimeout = DefaultActivityScheduleToStartTimeout
+	}
+	if params.ActivityStartToCloseTimeout == 0 {
+		params.ActivityStartToCloseTimeout = DefaultActivityStartToCloseTimeout
+	}
+	if params.BatchType == BatchTypeFailoverDomains && len(params.NonRetryableErrorTypes) == 0 {
+		// a domain that doesn't exist or can't be failed over fails the same way on every attempt
+		params.NonRetryableErrorTypes = []string{ErrorTypeBadRequest, ErrorTypeEntityNotExists}
+	}
 	if len(params.NonRetryableErrors) > 0 {
 		params._nonRetryableErrors = make(map[string]struct{}, len(params.NonRetryableErrors))
 		for _, estr := range params.NonRetryableErrors {
 			params._nonRetryableErrors[estr] = struct{}{}
 		}
 	}
+	if params.VerifyEffect {
+		if params._nonRetryableErrors == nil {
+			params._nonRetryableErrors = make(map[string]struct{}, 1)
+		}
+		params._nonRetryableErrors[errEffectNotVerified.Error()] = struct{}{}
+	}
+	if len(params.ExcludeWorkflowTypes) > 0 {
+		params._excludeWorkflowTypes = make(map[string]struct{}, len(params.ExcludeWorkflowTypes))
+		for _, wfType := range params.ExcludeWorkflowTypes {
+			params._excludeWorkflowTypes[wfType] = struct{}{}
+		}
+	}
+	if len(params.ExcludeWorkflowIDs) > 0 {
+		params._excludeWorkflowIDs = make(map[string]struct{}, len(params.ExcludeWorkflowIDs))
+		for _, workflowID := range params.ExcludeWorkflowIDs {
+			params._excludeWorkflowIDs[workflowID] = struct{}{}
+		}
+	}
+	if len(params.ExcludeRunIDs) > 0 {
+		params._excludeRunIDs = make(map[string]struct{}, len(params.ExcludeRunIDs))
+		for _, runID := range params.ExcludeRunIDs {
+			params._excludeRunIDs[runID] = struct{}{}
+		}
+	}
 	if params.TerminateParams.TerminateChildren == nil {
 		params.TerminateParams.TerminateChildren = common.BoolPtr(true)
 	}
@@ -232,22 +761,81 @@ func setDefaultParams(params BatchParams) BatchParams {
 
 // BatchActivity is activity for processing batch operation
 func BatchActivity(ctx context.Context, batchParams BatchParams) (HeartBeatDetails, error) {
+	// internal conversions are not serialized into activity input, build them again
+	batchParams = setDefaultParams(batchParams)
 	batcher := ctx.Value(batcherContextKey).(*Batcher)
 	client := batcher.clientBean.GetFrontendClient()
+	bm := newBatchMetrics(ctx, batchParams)
+	// the signature covers the params as they are started, so it's checked before they are resolved
+	if err := checkAdminOperationToken(batcher, activity.GetInfo(ctx).WorkflowExecution.ID, batchParams); err != nil {
+		return HeartBeatDetails{}, err
+	}
+	batchParams, err := resolveNamedQuery(ctx, batchParams)
+	if err != nil {
+		return HeartBeatDetails{}, err
+	}
+	batchParams, err = resolveFilter(ctx, batchParams)
+	if err != nil {
+		return HeartBeatDetails{}, err
+	}
+	batchParams, err = prepareArchival(ctx, batchParams, client)
+	if err != nil {
+		return HeartBeatDetails{}, err
+	}
+	batchParams, err = prepareDeletion(ctx, batchParams, client)
+	if err != nil {
+		return HeartBeatDetails{}, err
+	}
+	if batchParams.BatchType == BatchTypeSignal {
+		batchParams._signalInputTemplate, err = parseSignalInput(batchParams.SignalParams.Input)
+		if err != nil {
+			return HeartBeatDetails{}, err
+		}
+	}
 
 	hbd := HeartBeatDetails{}
 	startOver := true
 	if activity.HasHeartbeatDetails(ctx) {
 		if err := activity.GetHeartbeatDetails(ctx, &hbd); err == nil {
 			startOver = false
+			// the current page is processed again from its start
+			hbd.CurrentPageProcessedCount = 0
 		} else {
-			batcher := ctx.Value(batcherContextKey).(*Batcher)
-			batcher.metricsClient.IncCounter(metrics.BatcherScope, metrics.BatcherProcessorFailures)
-			getActivityLogger(ctx).Error("Failed to recover from last heartbeat, start over from beginning", tag.Error(err))
+			bm.scope.IncCounter(metrics.BatcherProcessorFailures)
+			bm.logger.Error("Failed to recover from last heartbeat, start over from beginning", tag.Error(err))
 		}
 	}
+	if hbd.Completed {
+		// the batch job is done, only the result may be left to record if the last attempt failed to
+		if err := batcher.recordBatchResult(ctx, batchParams, hbd); err != nil {
+			return HeartBeatDetails{}, err
+		}
+		return hbd, nil
+	}
+	if batchParams.BatchType == BatchTypeFailoverDomains {
+		return failoverDomains(ctx, batchParams, client, bm, hbd)
+	}
+	// every other batch type mutates the workflows of the target domain, which is only done where it's active
+	activeCluster, err := getStandbyCluster(ctx, batchParams, client)
+	if err != nil {
+		return HeartBeatDetails{}, err
+	}
+	if activeCluster != "" {
+		return suppressOnStandby(ctx, batchParams, client, bm, hbd, activeCluster)
+	}
 
-	if startOver {
+	startPage := 0
+	if batchParams.ContinuedDetails != nil {
+		startPage = batchParams.ContinuedDetails.CurrentPage
+	}
+	if startOver && batchParams.ContinuedDetails != nil {
+		// continued from the previous run of the same batch, keep its estimation so that the progress adds up
+		hbd = *batchParams.ContinuedDetails
+	} else if startOver {
+		// seed from the previous batch if it is resuming from where that batch left off
+		hbd.PageToken = batchParams.StartPageToken
+		hbd.SuccessCount = batchParams.InitialSuccessCount
+		hbd.ErrorCount = batchParams.InitialErrorCount
 		resp, err := client.CountWorkflowExecutions(ctx, &shared.CountWorkflowExecutionsRequest{
 			Domain: common.StringPtr(batchParams.DomainName),
 			Query:  common.StringPtr(batchParams.Query),
@@ -256,56 +844,130 @@ func BatchActivity(ctx context.Context, batchParams BatchParams) (HeartBeatDetai
 			return HeartBeatDetails{}, err
 		}
 		hbd.TotalEstimate = resp.GetCount()
+		bm.scope.IncCounter(metrics.BatcherJobStarted)
 	}
+	gate := newPauseGate(hbd.Paused, hbd.PausedDuration)
 	rateLimiter := rate.NewLimiter(rate.Limit(batchParams.RPS), batchParams.RPS)
+	dispatchLimiter := rate.NewLimiter(rate.Limit(batchParams.RPS), 1)
 	taskCh := make(chan taskDetail, pageSize)
+	retryQueue := newTaskRetryQueue()
 	respCh := make(chan error, pageSize)
-	for i := 0; i < batchParams.Concurrency; i++ {
-		go startTaskProcessor(ctx, batchParams, taskCh, respCh, rateLimiter, client)
+	var inFlight int64
+	var childrenProcessed int64
+	startChildrenProcessed := hbd.ChildrenProcessedCount
+	var tagFailed int64
+	startTagFailed := hbd.TagFailedCount
+	var verifyFailed int64
+	startVerifyFailed := hbd.VerificationFailedCount
+	breaker := newErrorRateBreaker(batchParams.MaxErrorRate)
+	domainCheck := newTargetDomainCheck(client, batchParams.DomainName)
+	processors := 0
+	processorFn := func() {
+		startTaskProcessor(ctx, batchParams, taskCh, retryQueue, respCh, rateLimiter, client, &inFlight, &childrenProcessed, &tagFailed, &verifyFailed, gate, breaker, domainCheck, bm)
 	}
+	progressStartTime := time.Now()
+	progressStartCount := hbd.finishedCount()
+	// heartbeat within a page as well so that progress is fresh even for slow pages
+	heartbeatTicker := time.NewTicker(batchParams.ActivityHeartBeatTimeout / 2)
+	defer heartbeatTicker.Stop()
 
 	for {
+		// the processors are short of Concurrency when the processors of the worker run out, top them up every page
+		processors = batcher.startProcessors(processors, batchParams.Concurrency, processorFn)
 		// TODO https://github.com/uber/cadence/issues/2154
 		//  Need to improve scan concurrency because it will hold an ES resource until the workflow finishes.
-		//  And we can't use list API because terminate / reset will mutate the result.
-		resp, err := client.ScanWorkflowExecutions(ctx, &shared.ListWorkflowExecutionsRequest{
-			Domain:        common.StringPtr(batchParams.DomainName),
-			PageSize:      common.Int32Ptr(int32(pageSize)),
-			NextPageToken: hbd.PageToken,
-			Query:         common.StringPtr(batchParams.Query),
-		})
+		//  And we can't use list API because terminate / reset will mutate the result, unless OrderBy asks for it.
+		resp, err := scanWorkflows(ctx, client, batchParams, getScanQuery(hbd, batchParams), pageSize, hbd.PageToken)
 		if err != nil {
 			return HeartBeatDetails{}, err
 		}
 		batchCount := len(resp.Executions)
 		if batchCount <= 0 {
+			if startPostOperationPass(&hbd, batchParams) {
+				continue
+			}
 			break
 		}
 
-		// send all tasks
-		for _, wf := range resp.Executions {
+		succCount := 0
+		errCount := 0
+		skipCount := 0
+		skipClosedCount := 0
+		skipOversizedCount := 0
+		notFoundCount := 0
+		archivedCount := 0
+		terminatedOnlyCount := 0
+		priorityDispatched := 0
+		normalDispatched := 0
+		executions, priorityCount := prioritizeExecutions(batchParams, resp.Executions)
+		// send all tasks, paced by RPS so that they spread across the page instead of bursting at the beginning
+		for i, wf := range executions {
+			if isExcludedExecution(batchParams, wf.Execution) {
+				skipCount++
+				continue
+			}
+			if err := dispatchLimiter.Wait(ctx); err != nil {
+				return HeartBeatDetails{}, err
+			}
+			if breaker.tripped() {
+				return HeartBeatDetails{}, stopOnErrorRate(ctx, &hbd, &inFlight, taskCh, retryQueue, gate)
+			}
 			taskCh <- taskDetail{
-				execution: *wf.Execution,
-				attempts:  0,
-				hbd:       hbd,
+				execution:     *wf.Execution,
+				workflowType:  wf.GetType().GetName(),
+				historyLength: wf.GetHistoryLength(),
+				attempts:      0,
+				hbd:           hbd,
+			}
+			if i < priorityCount {
+				priorityDispatched++
+			} else {
+				normalDispatched++
 			}
 		}
 
-		succCount := 0
-		errCount := 0
-		// wait for counters indicate this batch is done
+		// wait for counters indicate this batch is done, there is nothing to wait for if all of them are excluded
+		processed := skipCount
 	Loop:
-		for {
+		for processed < batchCount {
 			select {
 			case err := <-respCh:
-				if err == nil {
+				switch err {
+				case nil:
+					succCount++
+					if batchParams.TerminateParams.ArchiveAfter {
+						terminatedOnlyCount++
+					}
+				case errTaskArchived:
 					succCount++
-				} else {
+					archivedCount++
+				case errTaskSkipped:
+					skipCount++
+				case errTaskSkippedClosed:
+					skipClosedCount++
+				case errTaskSkippedOversized:
+					skipOversizedCount++
+				case errTaskNotFound:
+					notFoundCount++
+				case errTaskDomainGone:
+					return HeartBeatDetails{}, stopOnDomainGone(ctx, batchParams)
+				default:
 					errCount++
 				}
-				if succCount+errCount == batchCount {
+				processed = succCount + errCount + skipCount + skipClosedCount + skipOversizedCount + notFoundCount
+				if processed == batchCount {
 					break Loop
 				}
+				if breaker.tripped() {
+					return HeartBeatDetails{}, stopOnErrorRate(ctx, &hbd, &inFlight, taskCh, retryQueue, gate)
+				}
+				if processed%batchParams.HeartBeatEveryProcessed == 0 {
+					hbd.CurrentPageProcessedCount = processed
+					recordProgressHeartbeat(ctx, &hbd, &inFlight, taskCh, retryQueue, gate)
+				}
+			case <-heartbeatTicker.C:
+				refreshPaused(ctx, client, gate)
+				recordProgressHeartbeat(ctx, &hbd, &inFlight, taskCh, retryQueue, gate)
 			case <-ctx.Done():
 				return HeartBeatDetails{}, ctx.Err()
 			}
@@ -313,140 +975,539 @@ func BatchActivity(ctx context.Context, batchParams BatchParams) (HeartBeatDetai
 
 		hbd.CurrentPage++
 		hbd.PageToken = resp.NextPageToken
-		hbd.SuccessCount += succCount
-		hbd.ErrorCount += errCount
-		activity.RecordHeartbeat(ctx, hbd)
+		hbd.CurrentPageProcessedCount = 0
+		if hbd.PostOperationPass {
+			hbd.PostOperationSuccessCount += succCount
+			hbd.PostOperationErrorCount += errCount
+		} else {
+			hbd.SuccessCount += succCount
+			hbd.ErrorCount += errCount
+		}
+		hbd.SkippedCount += skipCount
+		hbd.SkippedClosedCount += skipClosedCount
+		hbd.SkippedOversizedCount += skipOversizedCount
+		if batchParams.PrioritySearchAttribute != "" {
+			hbd.PriorityCount += priorityDispatched
+			hbd.NormalCount += normalDispatched
+		}
+		hbd.NotFoundCount += notFoundCount
+		hbd.ArchivedCount += archivedCount
+		hbd.TerminatedOnlyCount += terminatedOnlyCount
+		hbd.ChildrenProcessedCount = startChildrenProcessed + int(atomic.LoadInt64(&childrenProcessed))
+		hbd.TagFailedCount = startTagFailed + int(atomic.LoadInt64(&tagFailed))
+		hbd.VerificationFailedCount = startVerifyFailed + int(atomic.LoadInt64(&verifyFailed))
+		if len(hbd.PageToken) == 0 && startPostOperationPass(&hbd, batchParams) {
+			recordProgressHeartbeat(ctx, &hbd, &inFlight, taskCh, retryQueue, gate)
+			continue
+		}
+		updateProgress(&hbd, progressStartTime, progressStartCount)
+		recordProgressHeartbeat(ctx, &hbd, &inFlight, taskCh, retryQueue, gate)
 
 		if len(hbd.PageToken) == 0 {
 			break
 		}
+		if batchParams.ContinueAsNewPageThreshold > 0 && hbd.CurrentPage-startPage >= batchParams.ContinueAsNewPageThreshold {
+			// return with the page token so that the workflow continues as new from there
+			return hbd, nil
+		}
 	}
 
+	// the page token is dropped as the last page may be empty, so that it doesn't look like there are more pages
+	hbd.PageToken = nil
+	hbd.Completed = true
+	hbd.ProgressPercent = 100
+	hbd.EstimatedCompletion = time.Now()
+	recordProgressHeartbeat(ctx, &hbd, &inFlight, taskCh, retryQueue, gate)
+	if err := batcher.recordBatchResult(ctx, batchParams, hbd); err != nil {
+		return HeartBeatDetails{}, err
+	}
 	return hbd, nil
 }
 
+// startPostOperationPass switches to the pass of PostOperationQuery once the workflows of Query are done,
+// it returns false if there is no such pass or it's already done
+func startPostOperationPass(hbd *HeartBeatDetails, batchParams BatchParams) bool {
+	if batchParams.PostOperationQuery == "" || hbd.PostOperationPass {
+		return false
+	}
+	hbd.PostOperationPass = true
+	hbd.PageToken = nil
+	return true
+}
+
+func validateOrderBy(params BatchParams) error {
+	switch params.OrderBy {
+	case "":
+		return nil
+	case OrderByStartTimeAsc, OrderByStartTimeDesc:
+	default:
+		return fmt.Errorf("OrderBy must be one of %v, %v", OrderByStartTimeAsc, OrderByStartTimeDesc)
+	}
+	for _, query := range []string{params.Query, params.PostOperationQuery} {
+		if strings.Contains(strings.ToLower(query), "order by") {
+			return fmt.Errorf("must not provide both OrderBy and an order by clause in the query")
+		}
+	}
+	return nil
+}
+
+func getScanQuery(hbd HeartBeatDetails, batchParams BatchParams) string {
+	if hbd.PostOperationPass {
+		return batchParams.PostOperationQuery
+	}
+	return batchParams.Query
+}
+
+// scanWorkflows reads a page of the workflows matching the query, with the scan API in the order of the visibility
+// backend, or with the list API in BatchParams.OrderBy
+func scanWorkflows(
+	ctx context.Context,
+	client frontend.Client,
+	batchParams BatchParams,
+	query string,
+	pageSize int,
+	pageToken []byte,
+) (*shared.ListWorkflowExecutionsResponse, error) {
+	request := &shared.ListWorkflowExecutionsRequest{
+		Domain:        common.StringPtr(batchParams.DomainName),
+		PageSize:      common.Int32Ptr(int32(pageSize)),
+		NextPageToken: pageToken,
+		Query:         common.StringPtr(query),
+	}
+	switch batchParams.OrderBy {
+	case OrderByStartTimeAsc:
+		request.Query = common.StringPtr(query + " order by StartTime asc")
+		return client.ListWorkflowExecutions(ctx, request)
+	case OrderByStartTimeDesc:
+		request.Query = common.StringPtr(query + " order by StartTime desc")
+		return client.ListWorkflowExecutions(ctx, request)
+	default:
+		return client.ScanWorkflowExecutions(ctx, request)
+	}
+}
+
+func (hbd HeartBeatDetails) finishedCount() int {
+	return hbd.SuccessCount + hbd.ErrorCount + hbd.SkippedCount + hbd.SkippedClosedCount + hbd.SkippedOversizedCount +
+		hbd.NotFoundCount
+}
+
+func updateProgress(hbd *HeartBeatDetails, startTime time.Time, startCount int) {
+	hbd.EstimatedCompletion = time.Time{}
+	if len(hbd.PageToken) == 0 {
+		hbd.ProgressPercent = 100
+		hbd.EstimatedCompletion = time.Now()
+		return
+	}
+	if hbd.TotalEstimate <= 0 {
+		hbd.ProgressPercent = 0
+		return
+	}
+
+	finished := hbd.finishedCount()
+	hbd.ProgressPercent = math.Min(float64(finished)*100/float64(hbd.TotalEstimate), maxProgressPercentBeforeDone)
+	remaining := hbd.TotalEstimate - int64(finished)
+	finishedSinceStart := finished - startCount
+	if remaining > 0 && finishedSinceStart > 0 {
+		perWorkflow := time.Since(startTime) / time.Duration(finishedSinceStart)
+		hbd.EstimatedCompletion = time.Now().Add(perWorkflow * time.Duration(remaining))
+	}
+}
+
+// recordProgressHeartbeat records hbd along with the paused state, which is kept in hbd for the return value
+func recordProgressHeartbeat(
+	ctx context.Context,
+	hbd *HeartBeatDetails,
+	inFlight *int64,
+	taskCh chan taskDetail,
+	retryQueue *taskRetryQueue,
+	gate *pauseGate,
+) {
+	hbd.Paused, hbd.PausedDuration = gate.state()
+	details := *hbd
+	details.InFlight = int(atomic.LoadInt64(inFlight))
+	details.QueueDepth = len(taskCh) + retryQueue.len()
+	activity.RecordHeartbeat(ctx, details)
+}
+
 func startTaskProcessor(
 	ctx context.Context,
 	batchParams BatchParams,
 	taskCh chan taskDetail,
+	retryQueue *taskRetryQueue,
 	respCh chan error,
 	limiter *rate.Limiter,
 	client frontend.Client,
+	inFlight *int64,
+	childrenProcessed *int64,
+	tagFailed *int64,
+	verifyFailed *int64,
+	gate *pauseGate,
+	breaker *errorRateBreaker,
+	domainCheck *targetDomainCheck,
+	bm *batchMetrics,
 ) {
 	batcher := ctx.Value(batcherContextKey).(*Batcher)
+	select {
+	case <-time.After(backoff.JitDuration(processorStartDelay, 1)):
+	case <-ctx.Done():
+		return
+	}
 	for {
-		select {
-		case <-ctx.Done():
+		task, ok := nextTask(ctx, taskCh, retryQueue)
+		if !ok {
 			return
-		case task := <-taskCh:
-			if isDone(ctx) {
-				return
-			}
-			var err error
-			requestID := uuid.New().String()
-			yarpcCallOptions := []yarpc.CallOption{
-				yarpc.WithHeader(common.EnforceDCRedirection, "true"),
-			}
+		}
+		if !gate.wait(ctx) {
+			return
+		}
+		if isDone(ctx) {
+			return
+		}
+		if err := batcher.acquireConcurrency(ctx); err != nil {
+			return
+		}
+		var err error
+		requestID := uuid.New().String()
+		yarpcCallOptions := []yarpc.CallOption{
+			yarpc.WithHeader(common.EnforceDCRedirection, "true"),
+		}
 
-			switch batchParams.BatchType {
-			case BatchTypeTerminate:
-				err = processTask(ctx, limiter, task, batchParams, client,
-					batchParams.TerminateParams.TerminateChildren,
-					func(workflowID, runID string) error {
-						return client.TerminateWorkflowExecution(ctx, &shared.TerminateWorkflowExecutionRequest{
-							Domain: common.StringPtr(batchParams.DomainName),
-							WorkflowExecution: &shared.WorkflowExecution{
-								WorkflowId: common.StringPtr(workflowID),
-								RunId:      common.StringPtr(runID),
-							},
-							Reason:   common.StringPtr(batchParams.Reason),
-							Identity: common.StringPtr(BatchWFTypeName),
-						}, yarpcCallOptions...)
-					})
-			case BatchTypeCancel:
-				err = processTask(ctx, limiter, task, batchParams, client,
-					batchParams.CancelParams.CancelChildren,
-					func(workflowID, runID string) error {
-						return client.RequestCancelWorkflowExecution(ctx, &shared.RequestCancelWorkflowExecutionRequest{
-							Domain: common.StringPtr(batchParams.DomainName),
-							WorkflowExecution: &shared.WorkflowExecution{
-								WorkflowId: common.StringPtr(workflowID),
-								RunId:      common.StringPtr(runID),
-							},
-							Identity:  common.StringPtr(BatchWFTypeName),
-							RequestId: common.StringPtr(requestID),
-						}, yarpcCallOptions...)
-					})
-			case BatchTypeSignal:
-				err = processTask(ctx, limiter, task, batchParams, client, common.BoolPtr(false),
-					func(workflowID, runID string) error {
-						return client.SignalWorkflowExecution(ctx, &shared.SignalWorkflowExecutionRequest{
-							Domain: common.StringPtr(batchParams.DomainName),
-							WorkflowExecution: &shared.WorkflowExecution{
-								WorkflowId: common.StringPtr(workflowID),
-								RunId:      common.StringPtr(runID),
-							},
-							Identity:   common.StringPtr(BatchWFTypeName),
-							RequestId:  common.StringPtr(requestID),
-							SignalName: common.StringPtr(batchParams.SignalParams.SignalName),
-							Input:      []byte(batchParams.SignalParams.Input),
-						}, yarpcCallOptions...)
-					})
+		atomic.AddInt64(inFlight, 1)
+		switch batchParams.BatchType {
+		case BatchTypeTerminate:
+			err = processTask(ctx, limiter, task, batchParams, client, bm,
+				getTerminateChildPolicy(batchParams.TerminateParams), childrenProcessed,
+				withEffectVerification(batchParams, client, bm, verifyFailed, func(ctx context.Context, workflowID, runID string) error {
+					if batchParams.TerminateParams.TagSearchAttribute != "" {
+						err := tagExecution(ctx, batchParams, client, workflowID, runID, yarpcCallOptions...)
+						// a workflow that is gone fails the termination the same way
+						if _, ok := err.(*shared.EntityNotExistsError); err != nil && !ok {
+							// the workflow is terminated anyway, only the tag is missing
+							atomic.AddInt64(tagFailed, 1)
+							bm.scope.IncCounter(metrics.BatcherTerminateTagFailures)
+							bm.logger.Warn("Failed to tag workflow before terminating it",
+								tag.WorkflowID(workflowID), tag.WorkflowRunID(runID), tag.Error(err))
+						}
+					}
+					return client.TerminateWorkflowExecution(ctx, &shared.TerminateWorkflowExecutionRequest{
+						Domain: common.StringPtr(batchParams.DomainName),
+						WorkflowExecution: &shared.WorkflowExecution{
+							WorkflowId: common.StringPtr(workflowID),
+							RunId:      common.StringPtr(runID),
+						},
+						Reason:   common.StringPtr(batcher.getReason(batchParams)),
+						Details:  batchParams.TerminateParams.Details,
+						Identity: common.StringPtr(BatchWFTypeName),
+					}, yarpcCallOptions...)
+				}))
+			if err == nil && batchParams.TerminateParams.ArchiveAfter {
+				err = archiveExecution(ctx, batchParams, task.execution)
 			}
-			if err != nil {
-				batcher.metricsClient.IncCounter(metrics.BatcherScope, metrics.BatcherProcessorFailures)
-				getActivityLogger(ctx).Error("Failed to process batch operation task", tag.Error(err))
-
-				_, ok := batchParams._nonRetryableErrors[err.Error()]
-				if ok || task.attempts >= batchParams.AttemptsOnRetryableError {
-					respCh <- err
-				} else {
-					// put back to the channel if less than attemptsOnError
-					task.attempts++
-					taskCh <- task
-				}
+		case BatchTypeCancel:
+			err = processTask(ctx, limiter, task, batchParams, client, bm,
+				getCancelChildPolicy(batchParams.CancelParams), childrenProcessed,
+				func(ctx context.Context, workflowID, runID string) error {
+					return client.RequestCancelWorkflowExecution(ctx, &shared.RequestCancelWorkflowExecutionRequest{
+						Domain: common.StringPtr(batchParams.DomainName),
+						WorkflowExecution: &shared.WorkflowExecution{
+							WorkflowId: common.StringPtr(workflowID),
+							RunId:      common.StringPtr(runID),
+						},
+						// cancel request doesn't take a reason, so record it as part of the identity
+						// which gets persisted in the WorkflowExecutionCancelRequested event
+						Identity:  common.StringPtr(withCancelReason(BatchWFTypeName, batcher.getReason(batchParams))),
+						RequestId: common.StringPtr(requestID),
+					}, yarpcCallOptions...)
+				})
+		case BatchTypeSignal:
+			err = processTask(ctx, limiter, task, batchParams, client, bm, ChildPolicyAbandon, childrenProcessed,
+				func(ctx context.Context, workflowID, runID string) error {
+					input, err := renderSignalInput(batchParams._signalInputTemplate, workflowID, runID)
+					if err != nil {
+						return err
+					}
+					encodedInput, err := batcher.encodeSignalInput(batchParams.DomainName, input)
+					if err != nil {
+						return err
+					}
+					err = client.SignalWorkflowExecution(ctx, &shared.SignalWorkflowExecutionRequest{
+						Domain: common.StringPtr(batchParams.DomainName),
+						WorkflowExecution: &shared.WorkflowExecution{
+							WorkflowId: common.StringPtr(workflowID),
+							RunId:      common.StringPtr(runID),
+						},
+						Identity:   common.StringPtr(BatchWFTypeName),
+						RequestId:  common.StringPtr(requestID),
+						SignalName: common.StringPtr(batchParams.SignalParams.SignalName),
+						Input:      encodedInput,
+					}, yarpcCallOptions...)
+					// EntityNotExistsError means wf is already closed, so the signal is not delivered
+					if _, ok := err.(*shared.EntityNotExistsError); ok {
+						return errTaskSkippedClosed
+					}
+					return err
+				})
+		case BatchTypeUpsertSearchAttributes:
+			// already validated to be serializable
+			input, _ := json.Marshal(batchParams.UpsertSearchAttributesParams.SearchAttributes)
+			err = processTask(ctx, limiter, task, batchParams, client, bm, ChildPolicyAbandon, childrenProcessed,
+				func(ctx context.Context, workflowID, runID string) error {
+					err := client.SignalWorkflowExecution(ctx, &shared.SignalWorkflowExecutionRequest{
+						Domain: common.StringPtr(batchParams.DomainName),
+						WorkflowExecution: &shared.WorkflowExecution{
+							WorkflowId: common.StringPtr(workflowID),
+							RunId:      common.StringPtr(runID),
+						},
+						Identity:   common.StringPtr(BatchWFTypeName),
+						RequestId:  common.StringPtr(requestID),
+						SignalName: common.StringPtr(UpsertSearchAttributesSignalName),
+						Input:      input,
+					}, yarpcCallOptions...)
+					if err == nil {
+						bm.scope.IncCounter(metrics.BatcherUpsertSearchAttributesSignals)
+					}
+					return err
+				})
+		case BatchTypeDeleteClosed:
+			err = processTask(ctx, limiter, task, batchParams, client, bm, ChildPolicyAbandon, childrenProcessed,
+				func(ctx context.Context, workflowID, runID string) error {
+					return deleteClosedExecution(ctx, batchParams, workflowID, runID)
+				})
+		case BatchTypeReset:
+			err = processTask(ctx, limiter, task, batchParams, client, bm, ChildPolicyAbandon, childrenProcessed,
+				func(ctx context.Context, workflowID, runID string) error {
+					eventID, err := getResetEventID(ctx, batchParams, client, workflowID, runID)
+					if err != nil {
+						return err
+					}
+					_, err = client.ResetWorkflowExecution(ctx, &shared.ResetWorkflowExecutionRequest{
+						Domain: common.StringPtr(batchParams.DomainName),
+						WorkflowExecution: &shared.WorkflowExecution{
+							WorkflowId: common.StringPtr(workflowID),
+							RunId:      common.StringPtr(runID),
+						},
+						Reason:                common.StringPtr(batcher.getReason(batchParams)),
+						DecisionFinishEventId: common.Int64Ptr(eventID),
+						RequestId:             common.StringPtr(requestID),
+					}, yarpcCallOptions...)
+					return err
+				})
+		}
+		atomic.AddInt64(inFlight, -1)
+		batcher.releaseConcurrency()
+		// a task of a deleted domain fails in one way or another, e.g. as not found, but it's only worth telling
+		// once it happens
+		if err != nil && err != errTaskSkipped && err != errTaskSkippedOversized && err != errTaskArchived &&
+			domainCheck.isGone(ctx) {
+			respCh <- errTaskDomainGone
+			continue
+		}
+		breaker.record(err != nil && err != errTaskSkipped && err != errTaskArchived &&
+			err != errTaskSkippedClosed && err != errTaskSkippedOversized && err != errTaskNotFound)
+		if err == errTaskSkipped {
+			bm.scope.IncCounter(metrics.BatcherProcessorSkipped)
+			respCh <- err
+		} else if err == errTaskArchived {
+			bm.scope.IncCounter(metrics.BatcherProcessorSuccess)
+			respCh <- err
+		} else if err == errTaskSkippedClosed {
+			bm.scope.IncCounter(metrics.BatcherProcessorSkippedClosed)
+			respCh <- err
+		} else if err == errTaskSkippedOversized {
+			bm.scope.IncCounter(metrics.BatcherProcessorSkippedOversized)
+			respCh <- err
+		} else if err == errTaskNotFound {
+			bm.scope.IncCounter(metrics.BatcherProcessorNotFound)
+			respCh <- err
+		} else if err != nil {
+			bm.scope.IncCounter(metrics.BatcherProcessorFailures)
+			bm.logger.Error("Failed to process batch operation task", tag.Error(err))
+
+			if isNonRetryableError(batchParams, err) || task.attempts >= batchParams.AttemptsOnRetryableError {
+				respCh <- err
 			} else {
-				batcher.metricsClient.IncCounter(metrics.BatcherScope, metrics.BatcherProcessorSuccess)
-				respCh <- nil
+				// put back to retry if less than attemptsOnError, it never blocks so that the processors
+				// can't be stuck on each other with a full taskCh
+				task.attempts++
+				retryQueue.push(task)
 			}
+		} else {
+			bm.scope.IncCounter(metrics.BatcherProcessorSuccess)
+			respCh <- nil
+		}
+	}
+}
+
+func parseSignalInput(input string) (*template.Template, error) {
+	return template.New("signal-input").Parse(input)
+}
+
+func renderSignalInput(tmpl *template.Template, workflowID, runID string) (string, error) {
+	var input strings.Builder
+	if err := tmpl.Execute(&input, signalInputData{WorkflowID: workflowID, RunID: runID}); err != nil {
+		return "", err
+	}
+	return input.String(), nil
+}
+
+// nextTask prefers the tasks to retry over the new tasks of the page, it returns false if ctx is done
+func nextTask(ctx context.Context, taskCh chan taskDetail, retryQueue *taskRetryQueue) (taskDetail, bool) {
+	for {
+		if task, ok := retryQueue.pop(); ok {
+			return task, true
+		}
+		select {
+		case <-ctx.Done():
+			return taskDetail{}, false
+		case <-retryQueue.notifyCh:
+		case task := <-taskCh:
+			return task, true
 		}
 	}
 }
 
+// shouldSkipTask tells whether the workflow of the task is excluded by ExcludeWorkflowTypes/ExcludeSystemDomain,
+// is rejected by the filter of FilterName, or is a system workflow which is never processed unless
+// IncludeSystemWorkflows is set
+func shouldSkipTask(
+	ctx context.Context,
+	batchParams BatchParams,
+	task taskDetail,
+	client frontend.Client,
+) (bool, error) {
+	if batchParams.ExcludeSystemDomain && batchParams.DomainName == common.SystemLocalDomainName {
+		return true, nil
+	}
+	if isOwnBatchWorkflow(ctx, batchParams, task) {
+		return true, nil
+	}
+	if !batchParams.IncludeSystemWorkflows && strings.HasPrefix(task.workflowType, systemWorkflowTypePrefix) {
+		return true, nil
+	}
+	if len(batchParams._excludeWorkflowTypes) == 0 && batchParams._filter == nil {
+		return false, nil
+	}
+
+	var resp *shared.DescribeWorkflowExecutionResponse
+	err := callWithOperationTimeout(ctx, batchParams.OperationTimeout, func(ctx context.Context) error {
+		var err error
+		resp, err = client.DescribeWorkflowExecution(ctx, &shared.DescribeWorkflowExecutionRequest{
+			Domain:    common.StringPtr(batchParams.DomainName),
+			Execution: &task.execution,
+		})
+		return err
+	})
+	if err != nil {
+		// EntityNotExistsError means wf is deleted, leave it to the processing which tolerates that
+		if _, ok := err.(*shared.EntityNotExistsError); ok {
+			return false, nil
+		}
+		return false, err
+	}
+	if _, ok := batchParams._excludeWorkflowTypes[resp.WorkflowExecutionInfo.GetType().GetName()]; ok {
+		return true, nil
+	}
+	return batchParams._filter != nil && !batchParams._filter(resp), nil
+}
+
+// isExcludedExecution tells whether the workflow is excluded by ExcludeWorkflowIDs/ExcludeRunIDs
+func isExcludedExecution(batchParams BatchParams, execution *shared.WorkflowExecution) bool {
+	if _, ok := batchParams._excludeWorkflowIDs[execution.GetWorkflowId()]; ok {
+		return true
+	}
+	_, ok := batchParams._excludeRunIDs[execution.GetRunId()]
+	return ok
+}
+
+// isOwnBatchWorkflow tells whether the task is the batch workflow running the operation, any run of it is
+// skipped as it continues as new with the same workflow ID
+func isOwnBatchWorkflow(ctx context.Context, batchParams BatchParams, task taskDetail) bool {
+	info := activity.GetInfo(ctx)
+	return info.WorkflowDomain == batchParams.DomainName && info.WorkflowExecution.ID == task.execution.GetWorkflowId()
+}
+
 func processTask(
 	ctx context.Context,
 	limiter *rate.Limiter,
 	task taskDetail,
 	batchParams BatchParams,
 	client frontend.Client,
-	applyOnChild *bool,
-	procFn func(string, string) error,
+	bm *batchMetrics,
+	childPolicy string,
+	childrenProcessed *int64,
+	procFn func(context.Context, string, string) error,
 ) error {
-	wfs := []shared.WorkflowExecution{task.execution}
-	for len(wfs) > 0 {
-		wf := wfs[0]
+	batcher := ctx.Value(batcherContextKey).(*Batcher)
+	skip, err := shouldSkipTask(ctx, batchParams, task, client)
+	if err != nil {
+		return err
+	}
+	if skip {
+		return errTaskSkipped
+	}
+	oversized, err := isOversized(ctx, batchParams, task, client)
+	if err != nil {
+		return err
+	}
+	if oversized {
+		return errTaskSkippedOversized
+	}
 
-		err := limiter.Wait(ctx)
+	// notFound tells whether the workflow of the task itself is gone, the children are not counted on their own
+	notFound := false
+	// children are only counted once the whole task succeeds, so that a retried task doesn't count them twice
+	children := 0
+	// descendants are the children queued up to MaxDescendants, no more are queued once truncated
+	descendants := 0
+	truncated := false
+	wfs := []pendingExecution{{execution: task.execution}}
+	for i := 0; len(wfs) > 0; i++ {
+		wf := wfs[0].execution
+		policy := wfs[0].policy
+
+		err = limiter.Wait(ctx)
+		if err != nil {
+			return err
+		}
+		err = batcher.waitRateLimit(ctx)
 		if err != nil {
 			return err
 		}
 		activity.RecordHeartbeat(ctx, task.hbd)
 
-		err = procFn(wf.GetWorkflowId(), wf.GetRunId())
+		err = callWithOperationTimeout(ctx, batchParams.OperationTimeout, func(ctx context.Context) error {
+			if i == 0 {
+				return procFn(ctx, wf.GetWorkflowId(), wf.GetRunId())
+			}
+			return applyChildPolicy(ctx, batchParams, client, policy, wf.GetWorkflowId(), wf.GetRunId())
+		})
+		if err == errTaskSkippedClosed {
+			return err
+		}
 		if err != nil {
 			// EntityNotExistsError means wf is not running or deleted
 			_, ok := err.(*shared.EntityNotExistsError)
 			if !ok {
 				return err
 			}
+			bm.logger.Debug("Workflow is not found when processing batch operation task",
+				tag.WorkflowID(wf.GetWorkflowId()), tag.WorkflowRunID(wf.GetRunId()))
+			notFound = notFound || i == 0
+		} else if i > 0 {
+			children++
 		}
 		wfs = wfs[1:]
-		resp, err := client.DescribeWorkflowExecution(ctx, &shared.DescribeWorkflowExecutionRequest{
-			Domain: common.StringPtr(batchParams.DomainName),
-			Execution: &shared.WorkflowExecution{
-				WorkflowId: common.StringPtr(wf.GetWorkflowId()),
-				RunId:      common.StringPtr(wf.GetRunId()),
-			},
+		var resp *shared.DescribeWorkflowExecutionResponse
+		err = callWithOperationTimeout(ctx, batchParams.OperationTimeout, func(ctx context.Context) error {
+			var err error
+			resp, err = client.DescribeWorkflowExecution(ctx, &shared.DescribeWorkflowExecutionRequest{
+				Domain: common.StringPtr(batchParams.DomainName),
+				Execution: &shared.WorkflowExecution{
+					WorkflowId: common.StringPtr(wf.GetWorkflowId()),
+					RunId:      common.StringPtr(wf.GetRunId()),
+				},
+			})
+			return err
 		})
 		if err != nil {
 			// EntityNotExistsError means wf is deleted
@@ -454,25 +1515,164 @@ func processTask(
 			if !ok {
 				return err
 			}
+			bm.logger.Debug("Workflow is not found when describing it after batch operation",
+				tag.WorkflowID(wf.GetWorkflowId()), tag.WorkflowRunID(wf.GetRunId()))
+			notFound = notFound || i == 0
 			continue
 		}
 
-		// TODO https://github.com/uber/cadence/issues/2159
-		// By default should use ChildPolicy, but it is totally broken in Cadence, we need to fix it before using
-		if applyOnChild != nil && *applyOnChild && len(resp.PendingChildren) > 0 {
-			getActivityLogger(ctx).Info("Found more child workflows to process", tag.Number(int64(len(resp.PendingChildren))))
+		if childPolicy != ChildPolicyAbandon && len(resp.PendingChildren) > 0 && !truncated {
+			bm.logger.Info("Found more child workflows to process", tag.Number(int64(len(resp.PendingChildren))))
 			for _, ch := range resp.PendingChildren {
-				wfs = append(wfs, shared.WorkflowExecution{
-					WorkflowId: ch.WorkflowID,
-					RunId:      ch.RunID,
+				policy := resolveChildPolicy(childPolicy, ch)
+				if policy == ChildPolicyAbandon {
+					continue
+				}
+				if descendants >= batchParams.MaxDescendants {
+					truncated = true
+					bm.scope.IncCounter(metrics.BatcherDescendantsTruncated)
+					bm.logger.Warn("Too many descendants of workflow, the rest of them are left alone",
+						tag.WorkflowID(task.execution.GetWorkflowId()), tag.WorkflowRunID(task.execution.GetRunId()),
+						tag.Number(int64(descendants)))
+					break
+				}
+				descendants++
+				wfs = append(wfs, pendingExecution{
+					execution: shared.WorkflowExecution{
+						WorkflowId: ch.WorkflowID,
+						RunId:      ch.RunID,
+					},
+					policy: policy,
 				})
 			}
 		}
 	}
 
+	atomic.AddInt64(childrenProcessed, int64(children))
+	bm.scope.AddCounter(metrics.BatcherChildrenProcessed, int64(children))
+	bm.scope.AddCounter(metrics.BatcherDescendantsDiscovered, int64(descendants))
+	if notFound {
+		return errTaskNotFound
+	}
 	return nil
 }
 
+// callWithOperationDeadline runs a single RPC of the batch activity with a context bounded by the remaining time
+// of the activity and its heartbeat timeout, so that a stuck RPC fails fast and the task gets retried rather than
+// blocking the task processor until the whole activity times out
+func callWithOperationDeadline(ctx context.Context, op func(context.Context) error) error {
+	return callWithOperationTimeout(ctx, 0, op)
+}
+
+// callWithOperationTimeout is callWithOperationDeadline with the given timeout in place of the heartbeat timeout,
+// it's used for the RPCs processing the workflows which have BatchParams.OperationTimeout of their own
+func callWithOperationTimeout(ctx context.Context, timeout time.Duration, op func(context.Context) error) error {
+	batcher := ctx.Value(batcherContextKey).(*Batcher)
+	opCtx, cancel := withOperationDeadline(ctx, timeout)
+	defer cancel()
+
+	err := op(opCtx)
+	if err != nil && opCtx.Err() == context.DeadlineExceeded && ctx.Err() == nil {
+		batcher.metricsClient.IncCounter(metrics.BatcherScope, metrics.BatcherOperationDeadlineExceeded)
+	}
+	return err
+}
+
+func withOperationDeadline(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	info := activity.GetInfo(ctx)
+	if timeout <= 0 {
+		timeout = info.HeartbeatTimeout
+	}
+	if !info.Deadline.IsZero() {
+		if remaining := time.Until(info.Deadline); timeout <= 0 || remaining < timeout {
+			timeout = remaining
+		}
+	}
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+func (e *paramsError) Error() string {
+	return e.msg
+}
+
+// Unwrap returns the sentinel error of the kind of the failure
+func (e *paramsError) Unwrap() error {
+	return e.kind
+}
+
+func newTaskRetryQueue() *taskRetryQueue {
+	return &taskRetryQueue{
+		notifyCh: make(chan struct{}, 1),
+	}
+}
+
+func (q *taskRetryQueue) push(task taskDetail) {
+	q.Lock()
+	q.tasks = append(q.tasks, task)
+	q.Unlock()
+	q.notify()
+}
+
+func (q *taskRetryQueue) pop() (taskDetail, bool) {
+	q.Lock()
+	if len(q.tasks) == 0 {
+		q.Unlock()
+		return taskDetail{}, false
+	}
+	task := q.tasks[0]
+	q.tasks = q.tasks[1:]
+	remaining := len(q.tasks)
+	q.Unlock()
+
+	if remaining > 0 {
+		// pass the wakeup on so that the other idle processors pick up the rest
+		q.notify()
+	}
+	return task, true
+}
+
+func (q *taskRetryQueue) len() int {
+	q.Lock()
+	defer q.Unlock()
+	return len(q.tasks)
+}
+
+func (q *taskRetryQueue) notify() {
+	select {
+	case q.notifyCh <- struct{}{}:
+	default:
+	}
+}
+
+// GetBatcherTaskListName returns the tasklist to start batch workflows on for the batcher workers of the cluster.
+// It's BatcherTaskListName if clusterName is empty, which is for the workers not polling a tasklist per cluster.
+func GetBatcherTaskListName(clusterName string) string {
+	if clusterName == "" {
+		return BatcherTaskListName
+	}
+	return fmt.Sprintf("%v-%v", BatcherTaskListName, clusterName)
+}
+
+// getBatcherTaskListName returns the name of the tasklist shard of the batch workflow tasklist, shard 0 is the
+// workflow tasklist itself to stay compatible with the batch jobs started before sharding
+func getBatcherTaskListName(workflowTaskList string, shard int) string {
+	if shard == 0 {
+		return workflowTaskList
+	}
+	return fmt.Sprintf("%v-%v", workflowTaskList, shard)
+}
+
+func withCancelReason(identity, reason string) string {
+	return fmt.Sprintf("%v, reason: %v", identity, reason)
+}
+
+func withOperatorIdentity(reason, operatorIdentity string) string {
+	return fmt.Sprintf("%v, operator: %v", reason, operatorIdentity)
+}
+
 func isDone(ctx context.Context) bool {
 	select {
 	case <-ctx.Done():
@@ -0,0 +1,1978 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package batcher
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/suite"
+	"github.com/uber-go/tally"
+	"go.uber.org/cadence"
+	"go.uber.org/cadence/encoded"
+	"go.uber.org/cadence/testsuite"
+	"go.uber.org/cadence/worker"
+	"go.uber.org/cadence/workflow"
+
+	"github.com/uber/cadence/.gen/go/cadence/workflowservicetest"
+	h "github.com/uber/cadence/.gen/go/history"
+	"github.com/uber/cadence/.gen/go/shared"
+	"github.com/uber/cadence/common"
+	"github.com/uber/cadence/common/cluster"
+	"github.com/uber/cadence/common/log"
+	"github.com/uber/cadence/common/metrics"
+	"github.com/uber/cadence/common/persistence"
+	"github.com/uber/cadence/common/resource"
+	"github.com/uber/cadence/common/service/dynamicconfig"
+)
+
+type (
+	batcherWorkflowTestSuite struct {
+		suite.Suite
+		testsuite.WorkflowTestSuite
+	}
+
+	// batchActivityTestEnv runs the batch activity of a batcher on a mocked frontend client
+	batchActivityTestEnv struct {
+		*testsuite.TestActivityEnvironment
+		frontendClient *workflowservicetest.MockClient
+		scope          tally.TestScope
+		finish         func()
+	}
+
+	testResultSink struct {
+		err     error
+		params  BatchParams
+		results []HeartBeatDetails
+	}
+)
+
+func TestBatcherWorkflowTestSuite(t *testing.T) {
+	suite.Run(t, new(batcherWorkflowTestSuite))
+}
+
+// BenchmarkTaskMetricsFromContext measures the metrics of a processed workflow when the batcher is looked up from
+// the context and the job tags are built for every workflow, see BenchmarkTaskMetricsFromBatchMetrics
+func BenchmarkTaskMetricsFromContext(b *testing.B) {
+	ctx := context.WithValue(context.Background(), batcherContextKey, &Batcher{
+		metricsClient: metrics.NewClient(tally.NoopScope, metrics.Worker),
+	})
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		batcher := ctx.Value(batcherContextKey).(*Batcher)
+		batcher.metricsClient.Scope(
+			metrics.BatcherScope,
+			metrics.DomainTag("test-domain"),
+			metrics.BatchTypeTag(BatchTypeTerminate),
+		).IncCounter(metrics.BatcherProcessorSuccess)
+	}
+}
+
+// BenchmarkTaskMetricsFromBatchMetrics measures the metrics of a processed workflow with the batchMetrics of the activity
+func BenchmarkTaskMetricsFromBatchMetrics(b *testing.B) {
+	bm := &batchMetrics{
+		scope: metrics.NewClient(tally.NoopScope, metrics.Worker).Scope(
+			metrics.BatcherScope,
+			metrics.DomainTag("test-domain"),
+			metrics.BatchTypeTag(BatchTypeTerminate),
+		),
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		bm.scope.IncCounter(metrics.BatcherProcessorSuccess)
+	}
+}
+
+// testActivityJobID is the ID of the batch job the activities of the test activity environment run for
+const testActivityJobID = "default-test-workflow-id"
+
+// newTestBatcherActivityEnv creates the environment of a batch activity test with the batcher of params, the clients
+// and the limits it leaves out are filled in. The executions, if any, are counted and returned by a single scan, a test
+// listing other pages sets up the frontend client itself. The batch is never paused
+func (s *batcherWorkflowTestSuite) newTestBatcherActivityEnv(
+	executions []*shared.WorkflowExecutionInfo,
+	params BootstrapParams,
+) *batchActivityTestEnv {
+	controller := gomock.NewController(s.T())
+	mockResource := resource.NewTest(controller, metrics.Worker)
+
+	if len(executions) > 0 {
+		mockResource.FrontendClient.EXPECT().CountWorkflowExecutions(gomock.Any(), gomock.Any()).
+			Return(&shared.CountWorkflowExecutionsResponse{Count: common.Int64Ptr(int64(len(executions)))}, nil)
+		mockResource.FrontendClient.EXPECT().ScanWorkflowExecutions(gomock.Any(), gomock.Any()).
+			Return(&shared.ListWorkflowExecutionsResponse{Executions: executions}, nil)
+	}
+	// the batch may run long enough to check whether it's paused
+	mockResource.FrontendClient.EXPECT().QueryWorkflow(gomock.Any(), gomock.Any()).
+		Return(&shared.QueryWorkflowResponse{QueryResult: []byte("false")}, nil).AnyTimes()
+
+	if params.Config.MaxConcurrency == nil {
+		params.Config.MaxConcurrency = dynamicconfig.GetIntPropertyFn(4)
+	}
+	if params.Config.RPS == nil {
+		params.Config.RPS = dynamicconfig.GetIntPropertyFn(100000)
+	}
+	scope := tally.NewTestScope("", nil)
+	params.MetricsClient = metrics.NewClient(scope, metrics.Worker)
+	params.Logger = mockResource.Logger
+	params.ClientBean = mockResource.ClientBean
+	batcher := New(&params)
+
+	env := s.NewTestActivityEnvironment()
+	env.SetTestTimeout(time.Second * 10)
+	// the test environment runs activities with a fixed timeout of 10 minutes, so that a stuck batch fails
+	// the test instead of hanging the package, the activity context ends with the test timeout
+	ctx, cancel := context.WithTimeout(context.WithValue(context.Background(), batcherContextKey, batcher), 10*time.Second)
+	env.SetWorkerOptions(worker.Options{
+		BackgroundActivityContext: ctx,
+	})
+	return &batchActivityTestEnv{
+		TestActivityEnvironment: env,
+		frontendClient:          mockResource.FrontendClient,
+		scope:                   scope,
+		finish: func() {
+			cancel()
+			mockResource.Finish(s.T())
+			controller.Finish()
+		},
+	}
+}
+
+// expectDomainRegistered describes the target domain as registered, it's checked once tasks fail
+func (e *batchActivityTestEnv) expectDomainRegistered() {
+	e.frontendClient.EXPECT().DescribeDomain(gomock.Any(), gomock.Any()).
+		Return(&shared.DescribeDomainResponse{
+			DomainInfo: &shared.DomainInfo{Status: shared.DomainStatusRegistered.Ptr()},
+		}, nil).AnyTimes()
+}
+
+// counters sums up the counters emitted by the batcher by name
+func (e *batchActivityTestEnv) counters() map[string]int64 {
+	counters := map[string]int64{}
+	for _, counter := range e.scope.Snapshot().Counters() {
+		counters[counter.Name()] += counter.Value()
+	}
+	return counters
+}
+
+func newTestExecutionInfo(workflowID, runID string) *shared.WorkflowExecutionInfo {
+	return &shared.WorkflowExecutionInfo{
+		Execution: &shared.WorkflowExecution{WorkflowId: common.StringPtr(workflowID), RunId: common.StringPtr(runID)},
+	}
+}
+
+func (s *batcherWorkflowTestSuite) TestBatchActivityFullPageOfRetries() {
+	attemptsBeforeSuccess := 3
+	executions := make([]*shared.WorkflowExecutionInfo, pageSize)
+	for i := range executions {
+		executions[i] = newTestExecutionInfo(fmt.Sprintf("wid-%v", i), fmt.Sprintf("rid-%v", i))
+	}
+	env := s.newTestBatcherActivityEnv(executions, BootstrapParams{})
+	defer env.finish()
+
+	var lock sync.Mutex
+	attempts := make(map[string]int)
+	env.frontendClient.EXPECT().TerminateWorkflowExecution(gomock.Any(), gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, request *shared.TerminateWorkflowExecutionRequest, _ ...interface{}) error {
+			lock.Lock()
+			defer lock.Unlock()
+			workflowID := request.WorkflowExecution.GetWorkflowId()
+			attempts[workflowID]++
+			if attempts[workflowID] <= attemptsBeforeSuccess {
+				return &shared.InternalServiceError{Message: "retryable"}
+			}
+			return nil
+		}).Times(pageSize * (attemptsBeforeSuccess + 1))
+	env.frontendClient.EXPECT().DescribeWorkflowExecution(gomock.Any(), gomock.Any()).
+		Return(&shared.DescribeWorkflowExecutionResponse{}, nil).Times(pageSize)
+	env.expectDomainRegistered()
+
+	val, err := env.ExecuteActivity(batchActivityName, BatchParams{
+		DomainName:               "test-domain",
+		Query:                    "CloseTime = missing",
+		Reason:                   "test",
+		OperatorIdentity:         "test-operator",
+		BatchType:                BatchTypeTerminate,
+		RPS:                      100000,
+		Concurrency:              4,
+		AttemptsOnRetryableError: attemptsBeforeSuccess + 1,
+		ActivityHeartBeatTimeout: time.Second,
+	})
+	s.NoError(err)
+	hbd := HeartBeatDetails{}
+	s.NoError(val.Get(&hbd))
+	s.Equal(pageSize, hbd.SuccessCount)
+	s.Equal(0, hbd.ErrorCount)
+}
+
+func (s *batcherWorkflowTestSuite) TestBatchActivityPostOperationQuery() {
+	env := s.newTestBatcherActivityEnv(nil, BootstrapParams{})
+	defer env.finish()
+
+	newExecutions := func(workflowIDs ...string) []*shared.WorkflowExecutionInfo {
+		var executions []*shared.WorkflowExecutionInfo
+		for _, workflowID := range workflowIDs {
+			executions = append(executions, newTestExecutionInfo(workflowID, workflowID+"-run"))
+		}
+		return executions
+	}
+	env.frontendClient.EXPECT().CountWorkflowExecutions(gomock.Any(), gomock.Any()).
+		Return(&shared.CountWorkflowExecutionsResponse{Count: common.Int64Ptr(2)}, nil)
+	env.frontendClient.EXPECT().ScanWorkflowExecutions(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, request *shared.ListWorkflowExecutionsRequest, _ ...interface{}) (*shared.ListWorkflowExecutionsResponse, error) {
+			if request.GetQuery() == "Acked = false" {
+				return &shared.ListWorkflowExecutionsResponse{Executions: newExecutions("wid-1")}, nil
+			}
+			return &shared.ListWorkflowExecutionsResponse{Executions: newExecutions("wid-1", "wid-2")}, nil
+		}).Times(2)
+	var lock sync.Mutex
+	signals := make(map[string]int)
+	env.frontendClient.EXPECT().SignalWorkflowExecution(gomock.Any(), gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, request *shared.SignalWorkflowExecutionRequest, _ ...interface{}) error {
+			lock.Lock()
+			defer lock.Unlock()
+			signals[request.WorkflowExecution.GetWorkflowId()]++
+			return nil
+		}).Times(3)
+	env.frontendClient.EXPECT().DescribeWorkflowExecution(gomock.Any(), gomock.Any()).
+		Return(&shared.DescribeWorkflowExecutionResponse{}, nil).Times(3)
+
+	val, err := env.ExecuteActivity(batchActivityName, BatchParams{
+		DomainName:               "test-domain",
+		Query:                    "CloseTime = missing",
+		PostOperationQuery:       "Acked = false",
+		Reason:                   "test",
+		OperatorIdentity:         "test-operator",
+		BatchType:                BatchTypeSignal,
+		SignalParams:             SignalParams{SignalName: "test-signal"},
+		RPS:                      100000,
+		ActivityHeartBeatTimeout: time.Second,
+	})
+	s.NoError(err)
+	hbd := HeartBeatDetails{}
+	s.NoError(val.Get(&hbd))
+	s.True(hbd.PostOperationPass)
+	s.Equal(2, hbd.SuccessCount)
+	s.Equal(1, hbd.PostOperationSuccessCount)
+	s.Equal(float64(100), hbd.ProgressPercent)
+	s.Equal(map[string]int{"wid-1": 2, "wid-2": 1}, signals)
+}
+
+func (s *batcherWorkflowTestSuite) TestBatchActivitySkipSystemWorkflows() {
+	// the test activity environment runs the activity of workflow default-test-workflow-id in default-test-domain-name
+	executions := []*shared.WorkflowExecutionInfo{
+		newTestExecutionInfo("default-test-workflow-id", "rid-0"),
+		newTestExecutionInfo("wid-1", "rid-1"),
+		newTestExecutionInfo("wid-2", "rid-2"),
+	}
+	executions[0].Type = &shared.WorkflowType{Name: common.StringPtr(BatchWFTypeName)}
+	executions[1].Type = &shared.WorkflowType{Name: common.StringPtr("cadence-sys-tl-scanner-workflow")}
+	executions[2].Type = &shared.WorkflowType{Name: common.StringPtr("test-workflow-type")}
+	env := s.newTestBatcherActivityEnv(executions, BootstrapParams{})
+	defer env.finish()
+
+	env.frontendClient.EXPECT().TerminateWorkflowExecution(gomock.Any(), gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, request *shared.TerminateWorkflowExecutionRequest, _ ...interface{}) error {
+			s.Equal("wid-2", request.WorkflowExecution.GetWorkflowId())
+			return nil
+		}).Times(1)
+	env.frontendClient.EXPECT().DescribeWorkflowExecution(gomock.Any(), gomock.Any()).
+		Return(&shared.DescribeWorkflowExecutionResponse{}, nil).Times(1)
+
+	val, err := env.ExecuteActivity(batchActivityName, BatchParams{
+		DomainName:               "default-test-domain-name",
+		Query:                    "CloseTime = missing",
+		Reason:                   "test",
+		OperatorIdentity:         "test-operator",
+		BatchType:                BatchTypeTerminate,
+		RPS:                      100000,
+		ActivityHeartBeatTimeout: time.Second,
+	})
+	s.NoError(err)
+	hbd := HeartBeatDetails{}
+	s.NoError(val.Get(&hbd))
+	s.Equal(1, hbd.SuccessCount)
+	s.Equal(2, hbd.SkippedCount)
+}
+
+func (s *batcherWorkflowTestSuite) TestBatchActivityExcludeExecutions() {
+	env := s.newTestBatcherActivityEnv(nil, BootstrapParams{})
+	defer env.finish()
+
+	env.frontendClient.EXPECT().CountWorkflowExecutions(gomock.Any(), gomock.Any()).
+		Return(&shared.CountWorkflowExecutionsResponse{Count: common.Int64Ptr(4)}, nil)
+	// the first page is excluded altogether
+	env.frontendClient.EXPECT().ScanWorkflowExecutions(gomock.Any(), gomock.Any()).
+		Return(&shared.ListWorkflowExecutionsResponse{
+			Executions:    []*shared.WorkflowExecutionInfo{newTestExecutionInfo("wid-1", "rid-1")},
+			NextPageToken: []byte("next"),
+		}, nil)
+	env.frontendClient.EXPECT().ScanWorkflowExecutions(gomock.Any(), gomock.Any()).
+		Return(&shared.ListWorkflowExecutionsResponse{
+			Executions: []*shared.WorkflowExecutionInfo{
+				newTestExecutionInfo("wid-1", "rid-11"),
+				newTestExecutionInfo("wid-2", "rid-2"),
+				newTestExecutionInfo("wid-3", "rid-3"),
+			},
+		}, nil)
+	env.frontendClient.EXPECT().TerminateWorkflowExecution(gomock.Any(), gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, request *shared.TerminateWorkflowExecutionRequest, _ ...interface{}) error {
+			s.Equal("wid-3", request.WorkflowExecution.GetWorkflowId())
+			return nil
+		}).Times(1)
+	env.frontendClient.EXPECT().DescribeWorkflowExecution(gomock.Any(), gomock.Any()).
+		Return(&shared.DescribeWorkflowExecutionResponse{}, nil).Times(1)
+
+	val, err := env.ExecuteActivity(batchActivityName, BatchParams{
+		DomainName:               "test-domain",
+		Query:                    "CloseTime = missing",
+		Reason:                   "test",
+		OperatorIdentity:         "test-operator",
+		BatchType:                BatchTypeTerminate,
+		ExcludeWorkflowIDs:       []string{"wid-1"},
+		ExcludeRunIDs:            []string{"rid-2"},
+		RPS:                      100000,
+		ActivityHeartBeatTimeout: time.Second,
+	})
+	s.NoError(err)
+	hbd := HeartBeatDetails{}
+	s.NoError(val.Get(&hbd))
+	s.Equal(1, hbd.SuccessCount)
+	s.Equal(3, hbd.SkippedCount)
+	s.Equal(2, hbd.CurrentPage)
+}
+
+func (s *batcherWorkflowTestSuite) TestBatchActivityPriority() {
+	newExecution := func(workflowID string, tier []byte) *shared.WorkflowExecutionInfo {
+		info := newTestExecutionInfo(workflowID, "rid")
+		if tier != nil {
+			info.SearchAttributes = &shared.SearchAttributes{IndexedFields: map[string][]byte{"CustomKeywordField": tier}}
+		}
+		return info
+	}
+	env := s.newTestBatcherActivityEnv([]*shared.WorkflowExecutionInfo{
+		newExecution("wid-1", nil),
+		newExecution("wid-2", []byte(`"batch"`)),
+		newExecution("wid-3", []byte(`"critical"`)),
+		newExecution("wid-4", []byte(`"critical"`)),
+	}, BootstrapParams{})
+	defer env.finish()
+
+	var lock sync.Mutex
+	var terminated []string
+	env.frontendClient.EXPECT().TerminateWorkflowExecution(gomock.Any(), gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, request *shared.TerminateWorkflowExecutionRequest, _ ...interface{}) error {
+			lock.Lock()
+			defer lock.Unlock()
+			terminated = append(terminated, request.WorkflowExecution.GetWorkflowId())
+			return nil
+		}).Times(4)
+	env.frontendClient.EXPECT().DescribeWorkflowExecution(gomock.Any(), gomock.Any()).
+		Return(&shared.DescribeWorkflowExecutionResponse{}, nil).Times(4)
+
+	val, err := env.ExecuteActivity(batchActivityName, BatchParams{
+		DomainName:               "test-domain",
+		Query:                    "CloseTime = missing",
+		Reason:                   "test",
+		OperatorIdentity:         "test-operator",
+		BatchType:                BatchTypeTerminate,
+		PrioritySearchAttribute:  "CustomKeywordField",
+		PriorityValue:            "critical",
+		RPS:                      100000,
+		Concurrency:              1,
+		ActivityHeartBeatTimeout: time.Second,
+	})
+	s.NoError(err)
+	hbd := HeartBeatDetails{}
+	s.NoError(val.Get(&hbd))
+	s.Equal(4, hbd.SuccessCount)
+	s.Equal(2, hbd.PriorityCount)
+	s.Equal(2, hbd.NormalCount)
+	s.Equal([]string{"wid-3", "wid-4", "wid-1", "wid-2"}, terminated)
+}
+
+func (s *batcherWorkflowTestSuite) TestIsPriorityExecution() {
+	batchParams := BatchParams{PrioritySearchAttribute: "CustomIntField", PriorityValue: "1"}
+	newExecution := func(fields map[string][]byte) *shared.WorkflowExecutionInfo {
+		return &shared.WorkflowExecutionInfo{SearchAttributes: &shared.SearchAttributes{IndexedFields: fields}}
+	}
+	s.True(isPriorityExecution(batchParams, newExecution(map[string][]byte{"CustomIntField": []byte("1")})))
+	s.False(isPriorityExecution(batchParams, newExecution(map[string][]byte{"CustomIntField": []byte("2")})))
+	s.False(isPriorityExecution(batchParams, newExecution(map[string][]byte{"CustomIntField": []byte("{")})))
+	s.False(isPriorityExecution(batchParams, newExecution(nil)))
+	s.False(isPriorityExecution(batchParams, &shared.WorkflowExecutionInfo{}))
+}
+
+func (s *batcherWorkflowTestSuite) TestBatchActivityOrderBy() {
+	env := s.newTestBatcherActivityEnv(nil, BootstrapParams{})
+	defer env.finish()
+
+	env.frontendClient.EXPECT().CountWorkflowExecutions(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, request *shared.CountWorkflowExecutionsRequest, _ ...interface{}) (*shared.CountWorkflowExecutionsResponse, error) {
+			s.Equal("CloseTime = missing", request.GetQuery())
+			return &shared.CountWorkflowExecutionsResponse{Count: common.Int64Ptr(1)}, nil
+		})
+	// the ordered pages are listed instead of scanned
+	env.frontendClient.EXPECT().ListWorkflowExecutions(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, request *shared.ListWorkflowExecutionsRequest, _ ...interface{}) (*shared.ListWorkflowExecutionsResponse, error) {
+			s.Equal("CloseTime = missing order by StartTime asc", request.GetQuery())
+			return &shared.ListWorkflowExecutionsResponse{
+				Executions: []*shared.WorkflowExecutionInfo{newTestExecutionInfo("wid", "rid")},
+			}, nil
+		})
+	env.frontendClient.EXPECT().TerminateWorkflowExecution(gomock.Any(), gomock.Any(), gomock.Any()).
+		Return(nil).Times(1)
+	env.frontendClient.EXPECT().DescribeWorkflowExecution(gomock.Any(), gomock.Any()).
+		Return(&shared.DescribeWorkflowExecutionResponse{}, nil).Times(1)
+
+	val, err := env.ExecuteActivity(batchActivityName, BatchParams{
+		DomainName:               "test-domain",
+		Query:                    "CloseTime = missing",
+		OrderBy:                  OrderByStartTimeAsc,
+		Reason:                   "test",
+		OperatorIdentity:         "test-operator",
+		BatchType:                BatchTypeTerminate,
+		RPS:                      100000,
+		ActivityHeartBeatTimeout: time.Second,
+	})
+	s.NoError(err)
+	hbd := HeartBeatDetails{}
+	s.NoError(val.Get(&hbd))
+	s.Equal(1, hbd.SuccessCount)
+}
+
+func (s *batcherWorkflowTestSuite) TestBatchActivityCompletedOnEmptyPage() {
+	env := s.newTestBatcherActivityEnv(nil, BootstrapParams{})
+	defer env.finish()
+
+	env.frontendClient.EXPECT().ScanWorkflowExecutions(gomock.Any(), gomock.Any()).
+		Return(&shared.ListWorkflowExecutionsResponse{NextPageToken: []byte("next-page")}, nil).Times(1)
+
+	params := BatchParams{
+		DomainName:               "test-domain",
+		Query:                    "CloseTime = missing",
+		Reason:                   "test",
+		OperatorIdentity:         "test-operator",
+		BatchType:                BatchTypeTerminate,
+		ActivityHeartBeatTimeout: time.Second,
+	}
+	env.SetHeartbeatDetails(HeartBeatDetails{
+		PageToken:    []byte("last-page"),
+		CurrentPage:  3,
+		SuccessCount: 10,
+	})
+
+	val, err := env.ExecuteActivity(batchActivityName, params)
+	s.NoError(err)
+	hbd := HeartBeatDetails{}
+	s.NoError(val.Get(&hbd))
+	s.True(hbd.Completed)
+	s.Empty(hbd.PageToken)
+	s.Equal(10, hbd.SuccessCount)
+
+	// a retried attempt of a completed activity returns right away without scanning again
+	env.SetHeartbeatDetails(hbd)
+	val, err = env.ExecuteActivity(batchActivityName, params)
+	s.NoError(err)
+	s.NoError(val.Get(&hbd))
+	s.True(hbd.Completed)
+	s.Equal(10, hbd.SuccessCount)
+}
+
+func (s *batcherWorkflowTestSuite) TestBatchActivityResultSink() {
+	sink := &testResultSink{err: errors.New("sink is unavailable")}
+	env := s.newTestBatcherActivityEnv(nil, BootstrapParams{ResultSink: sink})
+	defer env.finish()
+
+	env.frontendClient.EXPECT().ScanWorkflowExecutions(gomock.Any(), gomock.Any()).
+		Return(&shared.ListWorkflowExecutionsResponse{}, nil).Times(1)
+
+	params := BatchParams{
+		DomainName:               "test-domain",
+		Query:                    "CloseTime = missing",
+		Reason:                   "test",
+		OperatorIdentity:         "test-operator",
+		BatchType:                BatchTypeTerminate,
+		ActivityHeartBeatTimeout: time.Second,
+	}
+	env.SetHeartbeatDetails(HeartBeatDetails{PageToken: []byte("last-page"), SuccessCount: 10})
+
+	_, err := env.ExecuteActivity(batchActivityName, params)
+	s.Error(err)
+	s.Len(sink.results, 1)
+	s.True(sink.results[0].Completed)
+
+	// the retried attempt records the result again without scanning
+	sink.err = nil
+	env.SetHeartbeatDetails(sink.results[0])
+	_, err = env.ExecuteActivity(batchActivityName, params)
+	s.NoError(err)
+	s.Len(sink.results, 2)
+	s.Equal(10, sink.results[1].SuccessCount)
+	s.Equal("test-domain", sink.params.DomainName)
+}
+
+func (s *testResultSink) RecordBatchResult(ctx context.Context, params BatchParams, result HeartBeatDetails) error {
+	s.params = params
+	s.results = append(s.results, result)
+	return s.err
+}
+
+func (s *batcherWorkflowTestSuite) TestBatchActivityNotFound() {
+	env := s.newTestBatcherActivityEnv([]*shared.WorkflowExecutionInfo{
+		newTestExecutionInfo("wid-closed", "rid"),
+		newTestExecutionInfo("wid-deleted", "rid"),
+		newTestExecutionInfo("wid", "rid"),
+	}, BootstrapParams{})
+	defer env.finish()
+
+	env.frontendClient.EXPECT().TerminateWorkflowExecution(gomock.Any(), gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, request *shared.TerminateWorkflowExecutionRequest, _ ...interface{}) error {
+			if request.WorkflowExecution.GetWorkflowId() == "wid-closed" {
+				return &shared.EntityNotExistsError{}
+			}
+			return nil
+		}).Times(3)
+	env.frontendClient.EXPECT().DescribeWorkflowExecution(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, request *shared.DescribeWorkflowExecutionRequest, _ ...interface{}) (*shared.DescribeWorkflowExecutionResponse, error) {
+			if request.Execution.GetWorkflowId() == "wid" {
+				return &shared.DescribeWorkflowExecutionResponse{}, nil
+			}
+			return nil, &shared.EntityNotExistsError{}
+		}).Times(3)
+	env.expectDomainRegistered()
+
+	val, err := env.ExecuteActivity(batchActivityName, BatchParams{
+		DomainName:               "test-domain",
+		Query:                    "CloseTime = missing",
+		Reason:                   "test",
+		OperatorIdentity:         "test-operator",
+		BatchType:                BatchTypeTerminate,
+		RPS:                      100000,
+		ActivityHeartBeatTimeout: time.Second,
+	})
+	s.NoError(err)
+	hbd := HeartBeatDetails{}
+	s.NoError(val.Get(&hbd))
+	s.Equal(1, hbd.SuccessCount)
+	s.Equal(2, hbd.NotFoundCount)
+	s.Equal(0, hbd.ErrorCount)
+}
+
+func (s *batcherWorkflowTestSuite) TestBatchActivityTargetDomainGone() {
+	env := s.newTestBatcherActivityEnv([]*shared.WorkflowExecutionInfo{
+		newTestExecutionInfo("wid-1", "rid"),
+		newTestExecutionInfo("wid-2", "rid"),
+	}, BootstrapParams{})
+	defer env.finish()
+
+	// the tasks are not retried once the domain is found to be gone
+	env.frontendClient.EXPECT().TerminateWorkflowExecution(gomock.Any(), gomock.Any(), gomock.Any()).
+		Return(&shared.InternalServiceError{Message: "retryable"}).MinTimes(1).MaxTimes(2)
+	env.frontendClient.EXPECT().DescribeDomain(gomock.Any(), gomock.Any()).
+		Return(nil, &shared.EntityNotExistsError{}).Times(1)
+
+	_, err := env.ExecuteActivity(batchActivityName, BatchParams{
+		DomainName:               "test-domain",
+		Query:                    "CloseTime = missing",
+		Reason:                   "test",
+		OperatorIdentity:         "test-operator",
+		BatchType:                BatchTypeTerminate,
+		RPS:                      100000,
+		Concurrency:              1,
+		AttemptsOnRetryableError: 10,
+		ActivityHeartBeatTimeout: time.Second,
+	})
+	s.Error(err)
+	customErr, ok := err.(*cadence.CustomError)
+	s.True(ok)
+	s.Equal(errReasonTargetDomainGone, customErr.Reason())
+	var domainName string
+	s.NoError(customErr.Details(&domainName))
+	s.Equal("test-domain", domainName)
+}
+
+func (s *batcherWorkflowTestSuite) TestBatchActivityFilter() {
+	env := s.newTestBatcherActivityEnv([]*shared.WorkflowExecutionInfo{
+		newTestExecutionInfo("wid-busy", "rid"),
+		newTestExecutionInfo("wid-idle", "rid"),
+	}, BootstrapParams{
+		Filters: map[string]FilterFn{
+			"many-pending-activities": func(resp *shared.DescribeWorkflowExecutionResponse) bool {
+				return len(resp.PendingActivities) > 5
+			},
+		},
+	})
+	defer env.finish()
+
+	// both are described by the filter, and the accepted one again after the operation
+	env.frontendClient.EXPECT().DescribeWorkflowExecution(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, request *shared.DescribeWorkflowExecutionRequest, _ ...interface{}) (*shared.DescribeWorkflowExecutionResponse, error) {
+			resp := &shared.DescribeWorkflowExecutionResponse{}
+			if request.Execution.GetWorkflowId() == "wid-busy" {
+				resp.PendingActivities = make([]*shared.PendingActivityInfo, 6)
+			}
+			return resp, nil
+		}).Times(3)
+	env.frontendClient.EXPECT().TerminateWorkflowExecution(gomock.Any(), gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, request *shared.TerminateWorkflowExecutionRequest, _ ...interface{}) error {
+			s.Equal("wid-busy", request.WorkflowExecution.GetWorkflowId())
+			return nil
+		}).Times(1)
+
+	params := BatchParams{
+		DomainName:               "test-domain",
+		Query:                    "CloseTime = missing",
+		Reason:                   "test",
+		OperatorIdentity:         "test-operator",
+		BatchType:                BatchTypeTerminate,
+		RPS:                      100000,
+		ActivityHeartBeatTimeout: time.Second,
+		FilterName:               "many-pending-activities",
+	}
+	val, err := env.ExecuteActivity(batchActivityName, params)
+	s.NoError(err)
+	hbd := HeartBeatDetails{}
+	s.NoError(val.Get(&hbd))
+	s.Equal(1, hbd.SuccessCount)
+	s.Equal(1, hbd.SkippedCount)
+	s.Equal(0, hbd.ErrorCount)
+
+	params.FilterName = "unknown"
+	_, err = env.ExecuteActivity(batchActivityName, params)
+	customErr, ok := err.(*cadence.CustomError)
+	s.True(ok)
+	s.Equal(errReasonUnknownFilter, customErr.Reason())
+}
+
+func (s *batcherWorkflowTestSuite) TestBatchActivityOperationTimeout() {
+	env := s.newTestBatcherActivityEnv([]*shared.WorkflowExecutionInfo{newTestExecutionInfo("wid", "rid")}, BootstrapParams{})
+	defer env.finish()
+
+	// the first attempt hangs until it times out, then the task is retried
+	var attempts int32
+	env.frontendClient.EXPECT().TerminateWorkflowExecution(gomock.Any(), gomock.Any(), gomock.Any()).
+		DoAndReturn(func(ctx context.Context, _ *shared.TerminateWorkflowExecutionRequest, _ ...interface{}) error {
+			if atomic.AddInt32(&attempts, 1) == 1 {
+				<-ctx.Done()
+				return ctx.Err()
+			}
+			return nil
+		}).Times(2)
+	env.frontendClient.EXPECT().DescribeWorkflowExecution(gomock.Any(), gomock.Any()).
+		Return(&shared.DescribeWorkflowExecutionResponse{}, nil)
+	env.expectDomainRegistered()
+
+	val, err := env.ExecuteActivity(batchActivityName, BatchParams{
+		DomainName:               "test-domain",
+		Query:                    "CloseTime = missing",
+		Reason:                   "test",
+		OperatorIdentity:         "test-operator",
+		BatchType:                BatchTypeTerminate,
+		RPS:                      100000,
+		ActivityHeartBeatTimeout: 5 * time.Second,
+		OperationTimeout:         100 * time.Millisecond,
+	})
+	s.NoError(err)
+	hbd := HeartBeatDetails{}
+	s.NoError(val.Get(&hbd))
+	s.Equal(1, hbd.SuccessCount)
+	s.Equal(0, hbd.ErrorCount)
+	s.Equal(int64(1), env.counters()["batcher_operation_deadline_exceeded"])
+}
+
+func (s *batcherWorkflowTestSuite) TestBatchActivityFailoverDomains() {
+	env := s.newTestBatcherActivityEnv(nil, BootstrapParams{
+		Config: Config{AdminOperationToken: dynamicconfig.GetStringPropertyFn("admin-token")},
+	})
+	defer env.finish()
+
+	var lock sync.Mutex
+	attempts := map[string]int{}
+	env.frontendClient.EXPECT().UpdateDomain(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, request *shared.UpdateDomainRequest, _ ...interface{}) (*shared.UpdateDomainResponse, error) {
+			s.Equal("cluster-b", request.ReplicationConfiguration.GetActiveClusterName())
+			s.Equal("admin-token", request.GetSecurityToken())
+			lock.Lock()
+			defer lock.Unlock()
+			attempts[request.GetName()]++
+			switch {
+			case request.GetName() == "domain-unknown":
+				return nil, &shared.EntityNotExistsError{}
+			case request.GetName() == "domain-flaky" && attempts[request.GetName()] == 1:
+				return nil, &shared.InternalServiceError{}
+			}
+			return &shared.UpdateDomainResponse{}, nil
+		}).Times(4)
+
+	params := BatchParams{
+		DomainName:       "test-domain",
+		Reason:           "test",
+		OperatorIdentity: "test-operator",
+		BatchType:        BatchTypeFailoverDomains,
+		FailoverDomainsParams: FailoverDomainsParams{
+			Domains:           []string{"domain-a", "domain-unknown", "domain-flaky"},
+			ActiveClusterName: "cluster-b",
+		},
+		RPS:                      100000,
+		Concurrency:              2,
+		ActivityHeartBeatTimeout: time.Second,
+	}
+	params.FailoverDomainsParams.AdminOperationSignature = SignAdminOperation("admin-token", testActivityJobID, params)
+	s.NoError(ValidateParams(params))
+	val, err := env.ExecuteActivity(batchActivityName, params)
+	s.NoError(err)
+	hbd := HeartBeatDetails{}
+	s.NoError(val.Get(&hbd))
+	s.True(hbd.Completed)
+	s.Equal(2, hbd.SuccessCount)
+	s.Equal(1, hbd.ErrorCount)
+	s.Equal([]string{"domain-unknown"}, hbd.FailedDomains)
+	s.Equal(2, hbd.CurrentPage)
+
+	params.FailoverDomainsParams.AdminOperationSignature = SignAdminOperation("wrong-token", testActivityJobID, params)
+	_, err = env.ExecuteActivity(batchActivityName, params)
+	s.Error(err)
+	// the signature of another batch job doesn't authorize this one
+	params.FailoverDomainsParams.AdminOperationSignature = SignAdminOperation("admin-token", "another-job", params)
+	_, err = env.ExecuteActivity(batchActivityName, params)
+	s.Error(err)
+}
+
+func (s *batcherWorkflowTestSuite) TestBatchActivityCancelChildPolicy() {
+	env := s.newTestBatcherActivityEnv([]*shared.WorkflowExecutionInfo{newTestExecutionInfo("wid", "rid")}, BootstrapParams{})
+	defer env.finish()
+
+	var lock sync.Mutex
+	var canceled, terminated []string
+	env.frontendClient.EXPECT().RequestCancelWorkflowExecution(gomock.Any(), gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, request *shared.RequestCancelWorkflowExecutionRequest, _ ...interface{}) error {
+			lock.Lock()
+			defer lock.Unlock()
+			canceled = append(canceled, request.WorkflowExecution.GetWorkflowId())
+			return nil
+		}).Times(2)
+	env.frontendClient.EXPECT().TerminateWorkflowExecution(gomock.Any(), gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, request *shared.TerminateWorkflowExecutionRequest, _ ...interface{}) error {
+			lock.Lock()
+			defer lock.Unlock()
+			terminated = append(terminated, request.WorkflowExecution.GetWorkflowId())
+			return nil
+		}).Times(1)
+	env.frontendClient.EXPECT().DescribeWorkflowExecution(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, request *shared.DescribeWorkflowExecutionRequest, _ ...interface{}) (*shared.DescribeWorkflowExecutionResponse, error) {
+			if request.Execution.GetWorkflowId() != "wid" {
+				return &shared.DescribeWorkflowExecutionResponse{}, nil
+			}
+			return &shared.DescribeWorkflowExecutionResponse{
+				PendingChildren: []*shared.PendingChildExecutionInfo{
+					{WorkflowID: common.StringPtr("child-terminate"), RunID: common.StringPtr("rid"),
+						ParentClosePolicy: shared.ParentClosePolicyTerminate.Ptr()},
+					{WorkflowID: common.StringPtr("child-cancel"), RunID: common.StringPtr("rid"),
+						ParentClosePolicy: shared.ParentClosePolicyRequestCancel.Ptr()},
+					{WorkflowID: common.StringPtr("child-abandon"), RunID: common.StringPtr("rid")},
+				},
+			}, nil
+		}).Times(3)
+
+	val, err := env.ExecuteActivity(batchActivityName, BatchParams{
+		DomainName:               "test-domain",
+		Query:                    "CloseTime = missing",
+		Reason:                   "test",
+		OperatorIdentity:         "test-operator",
+		BatchType:                BatchTypeCancel,
+		CancelParams:             CancelParams{ChildPolicy: ChildPolicyParentClosePolicy},
+		RPS:                      100000,
+		ActivityHeartBeatTimeout: time.Second,
+	})
+	s.NoError(err)
+	hbd := HeartBeatDetails{}
+	s.NoError(val.Get(&hbd))
+	s.Equal(1, hbd.SuccessCount)
+	s.Equal(2, hbd.ChildrenProcessedCount)
+	s.Equal([]string{"wid", "child-cancel"}, canceled)
+	s.Equal([]string{"child-terminate"}, terminated)
+}
+
+func (s *batcherWorkflowTestSuite) TestBatchActivityCancelReason() {
+	env := s.newTestBatcherActivityEnv([]*shared.WorkflowExecutionInfo{newTestExecutionInfo("wid", "rid")}, BootstrapParams{
+		HostIdentity: "test-host",
+	})
+	defer env.finish()
+
+	// the cancel requests of the workflow and of its child carry the reason in the identity
+	var lock sync.Mutex
+	identities := map[string]string{}
+	env.frontendClient.EXPECT().RequestCancelWorkflowExecution(gomock.Any(), gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, request *shared.RequestCancelWorkflowExecutionRequest, _ ...interface{}) error {
+			lock.Lock()
+			defer lock.Unlock()
+			identities[request.WorkflowExecution.GetWorkflowId()] = request.GetIdentity()
+			return nil
+		}).Times(2)
+	env.frontendClient.EXPECT().DescribeWorkflowExecution(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, request *shared.DescribeWorkflowExecutionRequest, _ ...interface{}) (*shared.DescribeWorkflowExecutionResponse, error) {
+			if request.Execution.GetWorkflowId() != "wid" {
+				return &shared.DescribeWorkflowExecutionResponse{}, nil
+			}
+			return &shared.DescribeWorkflowExecutionResponse{
+				PendingChildren: []*shared.PendingChildExecutionInfo{
+					{WorkflowID: common.StringPtr("child"), RunID: common.StringPtr("rid")},
+				},
+			}, nil
+		}).Times(2)
+
+	val, err := env.ExecuteActivity(batchActivityName, BatchParams{
+		DomainName:               "test-domain",
+		Query:                    "CloseTime = missing",
+		Reason:                   "test",
+		OperatorIdentity:         "test-operator",
+		BatchType:                BatchTypeCancel,
+		CancelParams:             CancelParams{ChildPolicy: ChildPolicyRequestCancel},
+		RPS:                      100000,
+		ActivityHeartBeatTimeout: time.Second,
+	})
+	s.NoError(err)
+	hbd := HeartBeatDetails{}
+	s.NoError(val.Get(&hbd))
+	s.Equal(1, hbd.SuccessCount)
+	identity := BatchWFTypeName + ", reason: test, operator: test-operator, worker: test-host"
+	s.Equal(map[string]string{"wid": identity, "child": identity}, identities)
+}
+
+func (s *batcherWorkflowTestSuite) TestBatchActivityMaxDescendants() {
+	env := s.newTestBatcherActivityEnv([]*shared.WorkflowExecutionInfo{newTestExecutionInfo("wid", "rid")}, BootstrapParams{})
+	defer env.finish()
+
+	var lock sync.Mutex
+	var terminated []string
+	env.frontendClient.EXPECT().TerminateWorkflowExecution(gomock.Any(), gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, request *shared.TerminateWorkflowExecutionRequest, _ ...interface{}) error {
+			lock.Lock()
+			defer lock.Unlock()
+			terminated = append(terminated, request.WorkflowExecution.GetWorkflowId())
+			return nil
+		}).Times(3)
+	// every workflow of the tree has two children, only the first two descendants are processed
+	env.frontendClient.EXPECT().DescribeWorkflowExecution(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, request *shared.DescribeWorkflowExecutionRequest, _ ...interface{}) (*shared.DescribeWorkflowExecutionResponse, error) {
+			workflowID := request.Execution.GetWorkflowId()
+			return &shared.DescribeWorkflowExecutionResponse{
+				PendingChildren: []*shared.PendingChildExecutionInfo{
+					{WorkflowID: common.StringPtr(workflowID + "-0"), RunID: common.StringPtr("rid")},
+					{WorkflowID: common.StringPtr(workflowID + "-1"), RunID: common.StringPtr("rid")},
+				},
+			}, nil
+		}).Times(3)
+
+	val, err := env.ExecuteActivity(batchActivityName, BatchParams{
+		DomainName:               "test-domain",
+		Query:                    "CloseTime = missing",
+		Reason:                   "test",
+		OperatorIdentity:         "test-operator",
+		BatchType:                BatchTypeTerminate,
+		MaxDescendants:           2,
+		RPS:                      100000,
+		ActivityHeartBeatTimeout: time.Second,
+	})
+	s.NoError(err)
+	hbd := HeartBeatDetails{}
+	s.NoError(val.Get(&hbd))
+	s.Equal(1, hbd.SuccessCount)
+	s.Equal(2, hbd.ChildrenProcessedCount)
+	s.Equal([]string{"wid", "wid-0", "wid-1"}, terminated)
+
+	counters := env.counters()
+	s.Equal(int64(2), counters["batcher_descendants_discovered"])
+	s.Equal(int64(1), counters["batcher_descendants_truncated"])
+}
+
+func (s *batcherWorkflowTestSuite) TestBatchActivityTerminateTag() {
+	env := s.newTestBatcherActivityEnv([]*shared.WorkflowExecutionInfo{
+		newTestExecutionInfo("tagged", "rid"),
+		newTestExecutionInfo("untagged", "rid"),
+	}, BootstrapParams{})
+	defer env.finish()
+
+	var lock sync.Mutex
+	tags := make(map[string][]byte)
+	env.frontendClient.EXPECT().SignalWorkflowExecution(gomock.Any(), gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, request *shared.SignalWorkflowExecutionRequest, _ ...interface{}) error {
+			s.Equal(UpsertSearchAttributesSignalName, request.GetSignalName())
+			var attributes map[string]json.RawMessage
+			s.NoError(json.Unmarshal(request.Input, &attributes))
+			lock.Lock()
+			defer lock.Unlock()
+			tags[request.WorkflowExecution.GetWorkflowId()] = attributes["TerminatedByBatch"]
+			return nil
+		}).Times(2)
+	// only the tagged workflow upserts the tag it's signaled
+	env.frontendClient.EXPECT().DescribeWorkflowExecution(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, request *shared.DescribeWorkflowExecutionRequest, _ ...interface{}) (*shared.DescribeWorkflowExecutionResponse, error) {
+			lock.Lock()
+			defer lock.Unlock()
+			fields := map[string][]byte{}
+			if request.Execution.GetWorkflowId() == "tagged" {
+				fields["TerminatedByBatch"] = tags["tagged"]
+			}
+			return &shared.DescribeWorkflowExecutionResponse{
+				WorkflowExecutionInfo: &shared.WorkflowExecutionInfo{
+					SearchAttributes: &shared.SearchAttributes{IndexedFields: fields},
+				},
+			}, nil
+		}).MinTimes(3)
+	// both are terminated, with or without the tag
+	env.frontendClient.EXPECT().TerminateWorkflowExecution(gomock.Any(), gomock.Any(), gomock.Any()).
+		Return(nil).Times(2)
+
+	val, err := env.ExecuteActivity(batchActivityName, BatchParams{
+		DomainName:       "test-domain",
+		Query:            "CloseTime = missing",
+		Reason:           "test",
+		OperatorIdentity: "test-operator",
+		BatchType:        BatchTypeTerminate,
+		TerminateParams: TerminateParams{
+			TerminateChildren:  common.BoolPtr(false),
+			TagSearchAttribute: "TerminatedByBatch",
+			TagTimeout:         1500 * time.Millisecond,
+		},
+		RPS:                      100000,
+		ActivityHeartBeatTimeout: 5 * time.Second,
+	})
+	s.NoError(err)
+	hbd := HeartBeatDetails{}
+	s.NoError(val.Get(&hbd))
+	s.Equal(2, hbd.SuccessCount)
+	s.Equal(1, hbd.TagFailedCount)
+	s.NotEmpty(tags["tagged"])
+	s.Equal(int64(1), env.counters()["batcher_terminate_tag_failures"])
+}
+
+func (s *batcherWorkflowTestSuite) TestBatchActivityVerifyEffect() {
+	env := s.newTestBatcherActivityEnv([]*shared.WorkflowExecutionInfo{
+		newTestExecutionInfo("continued", "rid"),
+		newTestExecutionInfo("stuck", "rid"),
+	}, BootstrapParams{})
+	defer env.finish()
+
+	// the continued workflow is closed once its new run is terminated, the stuck one never closes. The tasks run
+	// concurrently, so the state is kept per workflow, and the calls are counted once the activity is done rather
+	// than by the mock, which would fail from a task processor and leave the activity hanging
+	var lock sync.Mutex
+	var terminated []string
+	closed := map[string]bool{}
+	env.frontendClient.EXPECT().TerminateWorkflowExecution(gomock.Any(), gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, request *shared.TerminateWorkflowExecutionRequest, _ ...interface{}) error {
+			lock.Lock()
+			defer lock.Unlock()
+			execution := request.WorkflowExecution
+			terminated = append(terminated, execution.GetWorkflowId()+"/"+execution.GetRunId())
+			if execution.GetWorkflowId() == "continued" && execution.GetRunId() == "rid-2" {
+				closed[execution.GetWorkflowId()] = true
+			}
+			return nil
+		}).AnyTimes()
+	env.frontendClient.EXPECT().DescribeWorkflowExecution(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, request *shared.DescribeWorkflowExecutionRequest, _ ...interface{}) (*shared.DescribeWorkflowExecutionResponse, error) {
+			lock.Lock()
+			defer lock.Unlock()
+			info := &shared.WorkflowExecutionInfo{
+				Execution: &shared.WorkflowExecution{RunId: common.StringPtr("rid-2")},
+			}
+			if request.Execution.RunId == nil && closed[request.Execution.GetWorkflowId()] {
+				info.CloseStatus = shared.WorkflowExecutionCloseStatusTerminated.Ptr()
+			}
+			return &shared.DescribeWorkflowExecutionResponse{WorkflowExecutionInfo: info}, nil
+		}).AnyTimes()
+	env.expectDomainRegistered()
+
+	val, err := env.ExecuteActivity(batchActivityName, BatchParams{
+		DomainName:               "test-domain",
+		Query:                    "CloseTime = missing",
+		Reason:                   "test",
+		OperatorIdentity:         "test-operator",
+		BatchType:                BatchTypeTerminate,
+		VerifyEffect:             true,
+		VerifyDelay:              10 * time.Millisecond,
+		RPS:                      100000,
+		ActivityHeartBeatTimeout: time.Second,
+	})
+	s.NoError(err)
+	hbd := HeartBeatDetails{}
+	s.NoError(val.Get(&hbd))
+	s.Equal(1, hbd.SuccessCount)
+	s.Equal(1, hbd.ErrorCount)
+	s.Equal(4, hbd.VerificationFailedCount)
+	lock.Lock()
+	s.ElementsMatch([]string{"continued/rid", "continued/rid-2", "stuck/rid", "stuck/rid-2", "stuck/rid-2"}, terminated)
+	lock.Unlock()
+	s.Equal(int64(4), env.counters()["batcher_verification_failures"])
+}
+
+func (s *batcherWorkflowTestSuite) TestBatchActivitySuppressedOnStandby() {
+	env := s.newTestBatcherActivityEnv(nil, BootstrapParams{
+		Config: Config{ClusterMetadata: cluster.GetTestClusterMetadata(true, true)},
+	})
+	defer env.finish()
+
+	// the workflows are only counted, none is scanned nor terminated
+	env.frontendClient.EXPECT().DescribeDomain(gomock.Any(), gomock.Any()).
+		Return(&shared.DescribeDomainResponse{
+			IsGlobalDomain: common.BoolPtr(true),
+			ReplicationConfiguration: &shared.DomainReplicationConfiguration{
+				ActiveClusterName: common.StringPtr(cluster.TestAlternativeClusterName),
+			},
+		}, nil)
+	env.frontendClient.EXPECT().CountWorkflowExecutions(gomock.Any(), gomock.Any()).
+		Return(&shared.CountWorkflowExecutionsResponse{Count: common.Int64Ptr(42)}, nil)
+
+	val, err := env.ExecuteActivity(batchActivityName, BatchParams{
+		DomainName:       "test-domain",
+		Query:            "CloseTime = missing",
+		Reason:           "test",
+		OperatorIdentity: "test-operator",
+		BatchType:        BatchTypeTerminate,
+	})
+	s.NoError(err)
+	hbd := HeartBeatDetails{}
+	s.NoError(val.Get(&hbd))
+	s.True(hbd.Completed)
+	s.Equal(int64(42), hbd.TotalEstimate)
+	s.Equal(0, hbd.SuccessCount)
+	s.Contains(hbd.SuppressedReason, cluster.TestAlternativeClusterName)
+}
+
+func (s *batcherWorkflowTestSuite) TestBatchActivityMaxHistoryLength() {
+	// the history length of the closed workflow comes with the scan, the open one is described for it
+	oversized := newTestExecutionInfo("oversized", "rid")
+	oversized.HistoryLength = common.Int64Ptr(500)
+	env := s.newTestBatcherActivityEnv([]*shared.WorkflowExecutionInfo{
+		oversized,
+		newTestExecutionInfo("open", "rid"),
+	}, BootstrapParams{})
+	defer env.finish()
+
+	env.frontendClient.EXPECT().DescribeWorkflowExecution(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, request *shared.DescribeWorkflowExecutionRequest, _ ...interface{}) (*shared.DescribeWorkflowExecutionResponse, error) {
+			s.Equal("open", request.Execution.GetWorkflowId())
+			return &shared.DescribeWorkflowExecutionResponse{
+				WorkflowExecutionInfo: &shared.WorkflowExecutionInfo{HistoryLength: common.Int64Ptr(50)},
+			}, nil
+		}).Times(2)
+	env.frontendClient.EXPECT().TerminateWorkflowExecution(gomock.Any(), gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, request *shared.TerminateWorkflowExecutionRequest, _ ...interface{}) error {
+			s.Equal("open", request.WorkflowExecution.GetWorkflowId())
+			return nil
+		}).Times(1)
+
+	val, err := env.ExecuteActivity(batchActivityName, BatchParams{
+		DomainName:               "test-domain",
+		Query:                    "WorkflowType = 'test'",
+		Reason:                   "test",
+		OperatorIdentity:         "test-operator",
+		BatchType:                BatchTypeTerminate,
+		MaxHistoryLength:         100,
+		RPS:                      100000,
+		ActivityHeartBeatTimeout: time.Second,
+	})
+	s.NoError(err)
+	hbd := HeartBeatDetails{}
+	s.NoError(val.Get(&hbd))
+	s.Equal(1, hbd.SuccessCount)
+	s.Equal(1, hbd.SkippedOversizedCount)
+}
+
+func (s *batcherWorkflowTestSuite) TestEstimateBatchDuration() {
+	s.Equal(time.Duration(0), EstimateBatchDuration(0, BatchParams{BatchType: BatchTypeTerminate}))
+	// bounded by RPS: 3600 workflows at 1 RPS
+	s.Equal(time.Hour, EstimateBatchDuration(3600, BatchParams{BatchType: BatchTypeSignal, RPS: 1, Concurrency: 10}))
+	// bounded by concurrency: 2 RPCs per workflow on a single processor
+	s.Equal(1000*2*estimatedRPCLatency, EstimateBatchDuration(1000, BatchParams{BatchType: BatchTypeSignal, RPS: 1000, Concurrency: 1}))
+	// another describe for the filter
+	s.Equal(1000*3*estimatedRPCLatency, EstimateBatchDuration(1000, BatchParams{
+		BatchType:            BatchTypeSignal,
+		ExcludeWorkflowTypes: []string{"type"},
+		RPS:                  1000,
+		Concurrency:          1,
+	}))
+	s.Equal(1000*(3*estimatedRPCLatency+DefaultVerifyDelay), EstimateBatchDuration(1000, BatchParams{
+		BatchType:    BatchTypeTerminate,
+		VerifyEffect: true,
+		RPS:          1000,
+		Concurrency:  1,
+	}))
+}
+
+func (s *batcherWorkflowTestSuite) TestIsNonRetryableError() {
+	params := setDefaultParams(BatchParams{
+		NonRetryableErrors:     []string{"permanent"},
+		NonRetryableErrorTypes: []string{ErrorTypeBadRequest},
+	})
+	s.True(isNonRetryableError(params, errors.New("permanent")))
+	s.True(isNonRetryableError(params, &shared.BadRequestError{Message: "any wording"}))
+	s.False(isNonRetryableError(params, &shared.ServiceBusyError{Message: "permanent?"}))
+	s.False(isNonRetryableError(params, errors.New("transient")))
+
+	// a domain that doesn't exist isn't failed over on retries either
+	params = setDefaultParams(BatchParams{BatchType: BatchTypeFailoverDomains})
+	s.True(isNonRetryableError(params, &shared.EntityNotExistsError{}))
+	s.False(isNonRetryableError(params, &shared.InternalServiceError{}))
+
+	s.NoError(validateErrorTypes([]string{ErrorTypeDomainNotActive, ErrorTypeLimitExceeded}))
+	s.Error(validateErrorTypes([]string{"BadRequestError"}))
+}
+
+func (s *batcherWorkflowTestSuite) TestGetCancelChildPolicy() {
+	s.Equal(ChildPolicyAbandon, getCancelChildPolicy(CancelParams{}))
+	s.Equal(ChildPolicyRequestCancel, getCancelChildPolicy(CancelParams{CancelChildren: common.BoolPtr(true)}))
+	s.Equal(ChildPolicyTerminate, getCancelChildPolicy(CancelParams{CancelChildren: common.BoolPtr(true), ChildPolicy: ChildPolicyTerminate}))
+	s.Error(validateChildPolicy("unknown"))
+}
+
+func (s *batcherWorkflowTestSuite) TestBatchActivityContinuedFromPreviousRun() {
+	env := s.newTestBatcherActivityEnv(nil, BootstrapParams{})
+	defer env.finish()
+
+	// no CountWorkflowExecutions as the estimation is carried over
+	env.frontendClient.EXPECT().ScanWorkflowExecutions(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, request *shared.ListWorkflowExecutionsRequest, _ ...interface{}) (*shared.ListWorkflowExecutionsResponse, error) {
+			s.Equal([]byte("page-3"), request.NextPageToken)
+			return &shared.ListWorkflowExecutionsResponse{
+				Executions:    []*shared.WorkflowExecutionInfo{newTestExecutionInfo("wid", "rid")},
+				NextPageToken: []byte("page-4"),
+			}, nil
+		})
+	env.frontendClient.EXPECT().TerminateWorkflowExecution(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil)
+	env.frontendClient.EXPECT().DescribeWorkflowExecution(gomock.Any(), gomock.Any()).
+		Return(&shared.DescribeWorkflowExecutionResponse{}, nil)
+
+	val, err := env.ExecuteActivity(batchActivityName, BatchParams{
+		DomainName:                 "test-domain",
+		Query:                      "CloseTime = missing",
+		Reason:                     "test",
+		OperatorIdentity:           "test-operator",
+		BatchType:                  BatchTypeTerminate,
+		RPS:                        100000,
+		ActivityHeartBeatTimeout:   time.Second,
+		ContinueAsNewPageThreshold: 1,
+		ContinuedDetails: &HeartBeatDetails{
+			PageToken:     []byte("page-3"),
+			CurrentPage:   3,
+			TotalEstimate: 100,
+			SuccessCount:  10,
+			ErrorCount:    2,
+		},
+	})
+	s.NoError(err)
+	hbd := HeartBeatDetails{}
+	s.NoError(val.Get(&hbd))
+	s.Equal([]byte("page-4"), hbd.PageToken)
+	s.Equal(4, hbd.CurrentPage)
+	s.Equal(int64(100), hbd.TotalEstimate)
+	s.Equal(11, hbd.SuccessCount)
+	s.Equal(2, hbd.ErrorCount)
+}
+
+func (s *batcherWorkflowTestSuite) TestBatchActivityResumedWithinPage() {
+	env := s.newTestBatcherActivityEnv(nil, BootstrapParams{})
+	defer env.finish()
+
+	var executions []*shared.WorkflowExecutionInfo
+	for i := 0; i < 3; i++ {
+		executions = append(executions, newTestExecutionInfo(fmt.Sprintf("wid-%v", i), "rid"))
+	}
+	env.frontendClient.EXPECT().ScanWorkflowExecutions(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, request *shared.ListWorkflowExecutionsRequest, _ ...interface{}) (*shared.ListWorkflowExecutionsResponse, error) {
+			s.Equal([]byte("page-3"), request.NextPageToken)
+			return &shared.ListWorkflowExecutionsResponse{Executions: executions}, nil
+		})
+	env.frontendClient.EXPECT().TerminateWorkflowExecution(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil).Times(3)
+	env.frontendClient.EXPECT().DescribeWorkflowExecution(gomock.Any(), gomock.Any()).
+		Return(&shared.DescribeWorkflowExecutionResponse{}, nil).Times(3)
+
+	// the last attempt heartbeated in the middle of page 3, its processed workflows are not counted yet
+	env.SetHeartbeatDetails(HeartBeatDetails{
+		PageToken:                 []byte("page-3"),
+		CurrentPage:               3,
+		TotalEstimate:             100,
+		SuccessCount:              10,
+		CurrentPageProcessedCount: 2,
+	})
+
+	val, err := env.ExecuteActivity(batchActivityName, BatchParams{
+		DomainName:               "test-domain",
+		Query:                    "CloseTime = missing",
+		Reason:                   "test",
+		OperatorIdentity:         "test-operator",
+		BatchType:                BatchTypeTerminate,
+		RPS:                      100000,
+		ActivityHeartBeatTimeout: time.Second,
+		HeartBeatEveryProcessed:  1,
+	})
+	s.NoError(err)
+	hbd := HeartBeatDetails{}
+	s.NoError(val.Get(&hbd))
+	s.True(hbd.Completed)
+	s.Equal(13, hbd.SuccessCount)
+	s.Equal(0, hbd.CurrentPageProcessedCount)
+}
+
+func (s *batcherWorkflowTestSuite) TestBatchWorkflowContinueAsNew() {
+	env := s.NewTestWorkflowEnvironment()
+	env.OnActivity(batchPreflightActivityName, mock.Anything, mock.Anything).Return(nil)
+	env.OnActivity(batchActivityName, mock.Anything, mock.Anything).Return(HeartBeatDetails{
+		PageToken:    []byte("next-page"),
+		CurrentPage:  2,
+		SuccessCount: 10,
+		InFlight:     1,
+	}, nil)
+
+	env.ExecuteWorkflow(BatchWorkflow, BatchParams{
+		DomainName:                 "test-domain",
+		Query:                      "CloseTime = missing",
+		Reason:                     "test",
+		OperatorIdentity:           "test-operator",
+		BatchType:                  BatchTypeTerminate,
+		ContinueAsNewPageThreshold: 2,
+	})
+	s.True(env.IsWorkflowCompleted())
+	_, ok := env.GetWorkflowError().(*workflow.ContinueAsNewError)
+	s.True(ok)
+}
+
+func (s *batcherWorkflowTestSuite) TestBatchWorkflowContinueAsNewBeforeVersioned() {
+	env := s.NewTestWorkflowEnvironment()
+	env.OnActivity(batchPreflightActivityName, mock.Anything, mock.Anything).Return(nil)
+	env.OnGetVersion(continueAsNewChangeID, workflow.DefaultVersion, 1).Return(workflow.DefaultVersion)
+	env.OnActivity(batchActivityName, mock.Anything, mock.Anything).Return(
+		func(ctx context.Context, params BatchParams) (HeartBeatDetails, error) {
+			s.Equal(0, params.ContinueAsNewPageThreshold)
+			return HeartBeatDetails{SuccessCount: 10}, nil
+		})
+
+	env.ExecuteWorkflow(BatchWorkflow, BatchParams{
+		DomainName:                 "test-domain",
+		Query:                      "CloseTime = missing",
+		Reason:                     "test",
+		OperatorIdentity:           "test-operator",
+		BatchType:                  BatchTypeTerminate,
+		ContinueAsNewPageThreshold: 2,
+	})
+	s.True(env.IsWorkflowCompleted())
+	s.NoError(env.GetWorkflowError())
+	var result HeartBeatDetails
+	s.NoError(env.GetWorkflowResult(&result))
+	s.Equal(10, result.SuccessCount)
+}
+
+func (s *batcherWorkflowTestSuite) TestBatchWorkflowPauseAndResume() {
+	env := s.NewTestWorkflowEnvironment()
+	env.OnActivity(batchPreflightActivityName, mock.Anything, mock.Anything).Return(nil)
+	env.OnActivity(batchActivityName, mock.Anything, mock.Anything).After(time.Hour).Return(HeartBeatDetails{}, nil)
+	assertPaused := func(expected bool) {
+		val, err := env.QueryWorkflow(batchPausedQueryType)
+		s.NoError(err)
+		var paused bool
+		s.NoError(val.Get(&paused))
+		s.Equal(expected, paused)
+	}
+	env.RegisterDelayedCallback(func() {
+		assertPaused(false)
+		env.SignalWorkflow(BatchPauseSignalName, nil)
+	}, time.Minute)
+	env.RegisterDelayedCallback(func() {
+		assertPaused(true)
+		env.SignalWorkflow(BatchResumeSignalName, nil)
+	}, 2*time.Minute)
+	env.RegisterDelayedCallback(func() {
+		assertPaused(false)
+	}, 3*time.Minute)
+
+	env.ExecuteWorkflow(BatchWorkflow, BatchParams{
+		DomainName:       "test-domain",
+		Query:            "CloseTime = missing",
+		Reason:           "test",
+		OperatorIdentity: "test-operator",
+		BatchType:        BatchTypeTerminate,
+	})
+	s.True(env.IsWorkflowCompleted())
+	s.NoError(env.GetWorkflowError())
+}
+
+func (s *batcherWorkflowTestSuite) TestStartProcessors() {
+	batcher := New(&BootstrapParams{
+		Config: Config{
+			MaxConcurrency: dynamicconfig.GetIntPropertyFn(4),
+			MaxProcessors:  dynamicconfig.GetIntPropertyFn(3),
+			RPS:            dynamicconfig.GetIntPropertyFn(100),
+		},
+		MetricsClient: metrics.NewClient(tally.NoopScope, metrics.Worker),
+		Logger:        log.NewNoop(),
+	})
+	doneCh := make(chan struct{})
+	var wg sync.WaitGroup
+	processorFn := func() {
+		defer wg.Done()
+		<-doneCh
+	}
+	wg.Add(2)
+	s.Equal(2, batcher.startProcessors(0, 2, processorFn))
+	// only one slot is left
+	wg.Add(1)
+	s.Equal(1, batcher.startProcessors(0, 4, processorFn))
+	// the first processor runs without a slot
+	wg.Add(1)
+	running := batcher.startProcessors(0, 4, processorFn)
+	s.Equal(1, running)
+	s.Equal(running, batcher.startProcessors(running, 4, processorFn))
+
+	close(doneCh)
+	wg.Wait()
+	// the slots are released once the processors are done
+	doneCh = make(chan struct{})
+	wg.Add(3)
+	s.Equal(4, batcher.startProcessors(running, 4, processorFn))
+	close(doneCh)
+	wg.Wait()
+}
+
+func (s *batcherWorkflowTestSuite) TestNewWithoutWorkerLimits() {
+	batcher := New(&BootstrapParams{
+		MetricsClient: metrics.NewClient(tally.NoopScope, metrics.Worker),
+		Logger:        log.NewNoop(),
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	for i := 0; i < 10; i++ {
+		s.NoError(batcher.waitRateLimit(ctx))
+		s.NoError(batcher.acquireConcurrency(ctx))
+	}
+	for i := 0; i < 10; i++ {
+		batcher.releaseConcurrency()
+	}
+
+	// zero is no limit either, as the worker config defaults to
+	batcher = New(&BootstrapParams{
+		Config: Config{
+			MaxConcurrency: dynamicconfig.GetIntPropertyFn(0),
+			MaxProcessors:  dynamicconfig.GetIntPropertyFn(0),
+			RPS:            dynamicconfig.GetIntPropertyFn(0),
+		},
+		MetricsClient: metrics.NewClient(tally.NoopScope, metrics.Worker),
+		Logger:        log.NewNoop(),
+	})
+	for i := 0; i < 10; i++ {
+		s.NoError(batcher.waitRateLimit(ctx))
+		s.NoError(batcher.acquireConcurrency(ctx))
+		s.True(batcher.tryAcquireProcessor())
+	}
+
+	batcher = New(&BootstrapParams{
+		Config: Config{
+			MaxConcurrency: dynamicconfig.GetIntPropertyFn(1),
+		},
+		MetricsClient: metrics.NewClient(tally.NoopScope, metrics.Worker),
+		Logger:        log.NewNoop(),
+	})
+	s.NoError(batcher.acquireConcurrency(ctx))
+	blockedCtx, blockedCancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer blockedCancel()
+	s.Error(batcher.acquireConcurrency(blockedCtx))
+	batcher.releaseConcurrency()
+	s.NoError(batcher.acquireConcurrency(ctx))
+}
+
+func (s *batcherWorkflowTestSuite) TestPauseGate() {
+	gate := newPauseGate(true, time.Minute)
+	paused, pausedDuration := gate.state()
+	s.True(paused)
+	s.True(pausedDuration >= time.Minute)
+
+	resumed := make(chan bool)
+	go func() {
+		resumed <- gate.wait(context.Background())
+	}()
+	select {
+	case <-resumed:
+		s.Fail("gate is not paused")
+	case <-time.After(50 * time.Millisecond):
+	}
+	gate.set(false)
+	s.True(<-resumed)
+	paused, pausedDuration = gate.state()
+	s.False(paused)
+	s.True(pausedDuration >= time.Minute+50*time.Millisecond)
+
+	gate.set(true)
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		resumed <- gate.wait(ctx)
+	}()
+	cancel()
+	s.False(<-resumed)
+}
+
+func (s *batcherWorkflowTestSuite) TestErrorRateBreaker() {
+	breaker := newErrorRateBreaker(0.5)
+	for i := 0; i < errorRateWindowSize-1; i++ {
+		breaker.record(true)
+	}
+	s.False(breaker.tripped())
+	breaker.record(true)
+	s.True(breaker.tripped())
+	for i := 0; i < errorRateWindowSize/2; i++ {
+		breaker.record(false)
+	}
+	s.False(breaker.tripped())
+	for i := 0; i < errorRateWindowSize/2; i++ {
+		breaker.record(false)
+	}
+	for i := 0; i <= errorRateWindowSize/2; i++ {
+		breaker.record(true)
+	}
+	s.True(breaker.tripped())
+
+	breaker = newErrorRateBreaker(0)
+	for i := 0; i < errorRateWindowSize; i++ {
+		breaker.record(true)
+	}
+	s.False(breaker.tripped())
+}
+
+func (s *batcherWorkflowTestSuite) TestBatchWorkflowCompletionMetrics() {
+	testScope := tally.NewTestScope("", nil)
+	env := s.NewTestWorkflowEnvironment()
+	env.SetWorkerOptions(worker.Options{MetricsScope: testScope})
+	env.OnActivity(batchPreflightActivityName, mock.Anything, mock.Anything).Return(nil)
+	env.OnActivity(batchActivityName, mock.Anything, mock.Anything).Return(HeartBeatDetails{
+		SuccessCount: 10,
+		ErrorCount:   2,
+	}, nil)
+
+	env.ExecuteWorkflow(BatchWorkflow, BatchParams{
+		DomainName:       "test-domain",
+		Query:            "CloseTime = missing",
+		Reason:           "test",
+		OperatorIdentity: "test-operator",
+		BatchType:        BatchTypeTerminate,
+	})
+	s.True(env.IsWorkflowCompleted())
+	s.NoError(env.GetWorkflowError())
+
+	snapshot := testScope.Snapshot()
+	s.Equal(int64(1), snapshot.Counters()[batchWorkflowCompletedCounter+"+disposition=completed"].Value())
+	s.Equal(float64(10), snapshot.Gauges()[batchWorkflowSuccessCountGauge+"+disposition=completed"].Value())
+	s.Equal(float64(2), snapshot.Gauges()[batchWorkflowErrorCountGauge+"+disposition=completed"].Value())
+}
+
+func (s *batcherWorkflowTestSuite) TestBatchWorkflowCompletionSignal() {
+	env := s.NewTestWorkflowEnvironment()
+	env.OnActivity(batchPreflightActivityName, mock.Anything, mock.Anything).Return(nil)
+	result := HeartBeatDetails{SuccessCount: 10, ErrorCount: 2}
+	env.OnActivity(batchActivityName, mock.Anything, mock.Anything).Return(result, nil)
+	// the first delivery fails and is retried
+	env.OnSignalExternalWorkflow(mock.Anything, "orchestrator", "", "batch-done", result).
+		Return(errors.New("signal failed")).Once()
+	env.OnSignalExternalWorkflow(mock.Anything, "orchestrator", "", "batch-done", result).
+		Return(nil).Once()
+
+	env.ExecuteWorkflow(BatchWorkflow, BatchParams{
+		DomainName:       "test-domain",
+		Query:            "CloseTime = missing",
+		Reason:           "test",
+		OperatorIdentity: "test-operator",
+		BatchType:        BatchTypeTerminate,
+		CompletionSignal: CompletionSignal{WorkflowID: "orchestrator", SignalName: "batch-done"},
+	})
+	s.True(env.IsWorkflowCompleted())
+	s.NoError(env.GetWorkflowError())
+	env.AssertExpectations(s.T())
+}
+
+func (s *batcherWorkflowTestSuite) TestBatchWorkflowInvalidQuery() {
+	env := s.NewTestWorkflowEnvironment()
+	env.OnActivity(batchPreflightActivityName, mock.Anything, mock.Anything).
+		Return(cadence.NewCustomError(errReasonInvalidQuery, "invalid query"))
+
+	env.ExecuteWorkflow(BatchWorkflow, BatchParams{
+		DomainName:       "test-domain",
+		Query:            "CloseTime = bad",
+		Reason:           "test",
+		OperatorIdentity: "test-operator",
+		BatchType:        BatchTypeTerminate,
+	})
+	s.True(env.IsWorkflowCompleted())
+	customErr, ok := env.GetWorkflowError().(*cadence.CustomError)
+	s.True(ok)
+	s.Equal(errReasonInvalidQuery, customErr.Reason())
+	// the batch activity is never started
+	env.AssertNotCalled(s.T(), batchActivityName, mock.Anything, mock.Anything)
+}
+
+func (s *batcherWorkflowTestSuite) TestBatchPreflightActivity() {
+	env := s.newTestBatcherActivityEnv(nil, BootstrapParams{})
+	defer env.finish()
+
+	env.frontendClient.EXPECT().ScanWorkflowExecutions(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, request *shared.ListWorkflowExecutionsRequest, _ ...interface{}) (*shared.ListWorkflowExecutionsResponse, error) {
+			s.Equal(int32(1), request.GetPageSize())
+			if request.GetQuery() == "Acked = bad" {
+				return nil, &shared.BadRequestError{Message: "unknown field Acked"}
+			}
+			return &shared.ListWorkflowExecutionsResponse{}, nil
+		}).Times(3)
+
+	params := BatchParams{
+		DomainName:       "test-domain",
+		Query:            "CloseTime = missing",
+		Reason:           "test",
+		OperatorIdentity: "test-operator",
+		BatchType:        BatchTypeTerminate,
+	}
+	_, err := env.ExecuteActivity(batchPreflightActivityName, params)
+	s.NoError(err)
+
+	// the post operation query is checked as well
+	params.PostOperationQuery = "Acked = bad"
+	_, err = env.ExecuteActivity(batchPreflightActivityName, params)
+	customErr, ok := err.(*cadence.CustomError)
+	s.True(ok)
+	s.Equal(errReasonInvalidQuery, customErr.Reason())
+	var details string
+	s.NoError(customErr.Details(&details))
+	s.Contains(details, "unknown field Acked")
+}
+
+func (s *batcherWorkflowTestSuite) TestUpdateProgress() {
+	startTime := time.Now().Add(-time.Minute)
+	hbd := HeartBeatDetails{
+		PageToken:     []byte("next-page"),
+		TotalEstimate: 100,
+		SuccessCount:  30,
+		ErrorCount:    10,
+	}
+	updateProgress(&hbd, startTime, 20)
+	s.Equal(float64(40), hbd.ProgressPercent)
+	// 20 workflows per minute for the remaining 60 workflows
+	s.WithinDuration(time.Now().Add(3*time.Minute), hbd.EstimatedCompletion, time.Second)
+
+	// clamped as there is more to process than estimated
+	hbd.SuccessCount = 100
+	updateProgress(&hbd, startTime, 20)
+	s.Equal(float64(maxProgressPercentBeforeDone), hbd.ProgressPercent)
+	s.True(hbd.EstimatedCompletion.IsZero())
+
+	hbd.TotalEstimate = 0
+	updateProgress(&hbd, startTime, 20)
+	s.Equal(float64(0), hbd.ProgressPercent)
+	s.True(hbd.EstimatedCompletion.IsZero())
+
+	hbd.PageToken = nil
+	updateProgress(&hbd, startTime, 20)
+	s.Equal(float64(100), hbd.ProgressPercent)
+}
+
+func (s *batcherWorkflowTestSuite) TestValidateParams() {
+	params := BatchParams{
+		DomainName:       "test-domain",
+		Query:            "CloseTime = missing",
+		Reason:           "test",
+		OperatorIdentity: "test-operator",
+		BatchType:        BatchTypeSignal,
+	}
+	s.True(errors.Is(ValidateParams(params), ErrMissingSignalName))
+
+	params.BatchType = "unknown"
+	err := ValidateParams(params)
+	s.True(errors.Is(err, ErrUnsupportedBatchType))
+	s.Equal("not supported batch type: unknown", err.Error())
+
+	params.OperatorIdentity = ""
+	s.True(errors.Is(ValidateParams(params), ErrMissingRequiredParams))
+	// the batch jobs started without the operator identity are still accepted by the workflow
+	s.NoError(validateParams(setDefaultParams(BatchParams{
+		DomainName: "test-domain",
+		Query:      "CloseTime = missing",
+		Reason:     "test",
+		BatchType:  BatchTypeTerminate,
+	})))
+
+	params.OperatorIdentity = "test-operator"
+	params.BatchType = BatchTypeTerminate
+	s.NoError(ValidateParams(params))
+	params.ActivityStartToCloseTimeout = -time.Second
+	s.Error(ValidateParams(params))
+	params.ActivityStartToCloseTimeout = 0
+	params.TerminateParams.TagSearchAttribute = "TerminatedByBatch"
+	s.NoError(ValidateParams(params))
+	params.TerminateParams.TagTimeout = DefaultOperationTimeout
+	s.Error(ValidateParams(params))
+	params.TerminateParams = TerminateParams{Details: make([]byte, MaxTerminateDetailsSize)}
+	s.NoError(ValidateParams(params))
+	params.TerminateParams.Details = make([]byte, MaxTerminateDetailsSize+1)
+	s.Error(ValidateParams(params))
+	params.TerminateParams = TerminateParams{}
+	params.VerifyEffect = true
+	s.NoError(ValidateParams(params))
+	params.VerifyDelay = DefaultOperationTimeout / 2
+	s.Error(ValidateParams(params))
+	params.VerifyDelay = 0
+	params.BatchType = BatchTypeCancel
+	s.Error(ValidateParams(params))
+	params.VerifyEffect = false
+
+	params.BatchType = BatchTypeSignal
+	params.SignalParams = SignalParams{SignalName: "test-signal", Input: `{"workflowID": "{{.WorkflowID}}"`}
+	s.NoError(ValidateParams(params))
+	params.SignalParams.Input = `{{.WorkflowID`
+	s.Error(ValidateParams(params))
+
+	params.BatchType = BatchTypeReset
+	s.True(errors.Is(ValidateParams(params), ErrMissingResetType))
+	params.ResetParams = ResetParams{ResetType: "unknown"}
+	err = ValidateParams(params)
+	s.Error(err)
+	s.False(errors.Is(err, ErrMissingResetType))
+	params.ResetParams = ResetParams{ResetType: ResetTypeLastDecisionCompleted}
+	s.NoError(ValidateParams(params))
+	params.ResetParams = ResetParams{DecisionFinishEventIDs: map[string]int64{"wid": 4}}
+	s.NoError(ValidateParams(params))
+
+	params.BatchType = BatchTypeTerminate
+	params.QueryName = "stale-workflows"
+	s.Error(ValidateParams(params))
+	params.Query = ""
+	s.NoError(ValidateParams(params))
+	params.MaxErrorRate = 1
+	s.Error(ValidateParams(params))
+	params.MaxErrorRate = 0.5
+	s.NoError(ValidateParams(params))
+	params.OrderBy = "CloseTime"
+	s.Error(ValidateParams(params))
+	params.OrderBy = OrderByStartTimeAsc
+	s.NoError(ValidateParams(params))
+	params.PostOperationQuery = "CloseTime = missing order by StartTime"
+	s.Error(ValidateParams(params))
+	params.PostOperationQuery = ""
+	params.OrderBy = ""
+
+	params.BatchType = BatchTypeFailoverDomains
+	params.FailoverDomainsParams = FailoverDomainsParams{
+		Domains:                 []string{"domain-a"},
+		ActiveClusterName:       "cluster-b",
+		AdminOperationSignature: "signature",
+	}
+	s.Error(ValidateParams(params))
+	params.QueryName = ""
+	s.NoError(ValidateParams(params))
+	params.FilterName = "many-pending-activities"
+	s.Error(ValidateParams(params))
+	params.FilterName = ""
+	params.CompletionSignal = CompletionSignal{WorkflowID: "orchestrator"}
+	s.Error(ValidateParams(params))
+	params.CompletionSignal.SignalName = "batch-done"
+	s.NoError(ValidateParams(params))
+	params.CompletionSignal = CompletionSignal{}
+	params.PriorityValue = "critical"
+	s.Error(ValidateParams(params))
+	params.PriorityValue = ""
+	params.FailoverDomainsParams.Domains = nil
+	s.Error(ValidateParams(params))
+}
+
+func (s *batcherWorkflowTestSuite) TestRenderNamedQuery() {
+	namedQueries := dynamicconfig.GetMapPropertyFn(map[string]interface{}{
+		"stale-workflows": "WorkflowType = '{{.WorkflowType}}' and CloseTime = missing",
+	})
+	query, err := renderNamedQuery(namedQueries, "stale-workflows", map[string]string{"WorkflowType": "test-type"})
+	s.NoError(err)
+	s.Equal("WorkflowType = 'test-type' and CloseTime = missing", query)
+
+	_, err = renderNamedQuery(namedQueries, "stale-workflows", nil)
+	s.Error(err)
+	_, err = renderNamedQuery(namedQueries, "unknown", nil)
+	s.Error(err)
+	_, err = renderNamedQuery(nil, "stale-workflows", nil)
+	s.Error(err)
+}
+
+func (s *batcherWorkflowTestSuite) TestGetResetEventID() {
+	controller := gomock.NewController(s.T())
+	defer controller.Finish()
+	mockResource := resource.NewTest(controller, metrics.Worker)
+	defer mockResource.Finish(s.T())
+
+	params := BatchParams{
+		DomainName: "test-domain",
+		ResetParams: ResetParams{
+			DecisionFinishEventID:  common.Int64Ptr(10),
+			DecisionFinishEventIDs: map[string]int64{"wid-1": 4},
+		},
+	}
+	eventID, err := getResetEventID(context.Background(), params, mockResource.FrontendClient, "wid-1", "rid")
+	s.NoError(err)
+	s.Equal(int64(4), eventID)
+	eventID, err = getResetEventID(context.Background(), params, mockResource.FrontendClient, "wid-2", "rid")
+	s.NoError(err)
+	s.Equal(int64(10), eventID)
+
+	params.ResetParams.DecisionFinishEventID = nil
+	_, err = getResetEventID(context.Background(), params, mockResource.FrontendClient, "wid-2", "rid")
+	s.Equal(errTaskSkipped, err)
+
+	decisionCompleted := shared.EventTypeDecisionTaskCompleted
+	signaled := shared.EventTypeWorkflowExecutionSignaled
+	mockResource.FrontendClient.EXPECT().GetWorkflowExecutionHistory(gomock.Any(), gomock.Any()).
+		Return(&shared.GetWorkflowExecutionHistoryResponse{
+			History: &shared.History{
+				Events: []*shared.HistoryEvent{
+					{EventId: common.Int64Ptr(4), EventType: &decisionCompleted},
+					{EventId: common.Int64Ptr(5), EventType: &signaled},
+				},
+			},
+			NextPageToken: []byte("next-page"),
+		}, nil).Times(2)
+	mockResource.FrontendClient.EXPECT().GetWorkflowExecutionHistory(gomock.Any(), gomock.Any()).
+		Return(&shared.GetWorkflowExecutionHistoryResponse{
+			History: &shared.History{
+				Events: []*shared.HistoryEvent{
+					{EventId: common.Int64Ptr(9), EventType: &decisionCompleted},
+				},
+			},
+		}, nil).Times(1)
+	params.ResetParams.ResetType = ResetTypeFirstDecisionCompleted
+	eventID, err = getResetEventID(context.Background(), params, mockResource.FrontendClient, "wid-2", "rid")
+	s.NoError(err)
+	s.Equal(int64(4), eventID)
+	params.ResetParams.ResetType = ResetTypeLastDecisionCompleted
+	eventID, err = getResetEventID(context.Background(), params, mockResource.FrontendClient, "wid-2", "rid")
+	s.NoError(err)
+	s.Equal(int64(9), eventID)
+}
+
+func (s *batcherWorkflowTestSuite) TestRenderSignalInput() {
+	tmpl, err := parseSignalInput(`{"workflowID": "{{.WorkflowID}}", "runID": "{{.RunID}}"}`)
+	s.NoError(err)
+	input, err := renderSignalInput(tmpl, "wid", "rid")
+	s.NoError(err)
+	s.Equal(`{"workflowID": "wid", "runID": "rid"}`, input)
+
+	tmpl, err = parseSignalInput(`{"literal": true}`)
+	s.NoError(err)
+	input, err = renderSignalInput(tmpl, "wid", "rid")
+	s.NoError(err)
+	s.Equal(`{"literal": true}`, input)
+
+	tmpl, err = parseSignalInput(`{{.Unknown}}`)
+	s.NoError(err)
+	_, err = renderSignalInput(tmpl, "wid", "rid")
+	s.Error(err)
+}
+
+func (s *batcherWorkflowTestSuite) TestEncodeSignalInput() {
+	batcher := &Batcher{
+		dataConverters: map[string]encoded.DataConverter{"encoded-domain": encoded.GetDefaultDataConverter()},
+	}
+	input, err := batcher.encodeSignalInput("encoded-domain", "value")
+	s.NoError(err)
+	s.Equal("\"value\"\n", string(input))
+
+	input, err = batcher.encodeSignalInput("test-domain", "value")
+	s.NoError(err)
+	s.Equal("value", string(input))
+}
+
+func (s *batcherWorkflowTestSuite) TestGetReason() {
+	params := BatchParams{Reason: "test", OperatorIdentity: "test-operator"}
+	controller := gomock.NewController(s.T())
+	defer controller.Finish()
+	mockResource := resource.NewTest(controller, metrics.Worker)
+	defer mockResource.Finish(s.T())
+	bootstrapParams := &BootstrapParams{
+		Config: Config{
+			MaxConcurrency: dynamicconfig.GetIntPropertyFn(1),
+			RPS:            dynamicconfig.GetIntPropertyFn(1),
+		},
+		MetricsClient: mockResource.MetricsClient,
+		Logger:        mockResource.Logger,
+	}
+	s.Equal("test, operator: test-operator", New(bootstrapParams).getReason(params))
+	s.Equal("test", New(bootstrapParams).getReason(BatchParams{Reason: "test"}))
+
+	bootstrapParams.HostIdentity = "test-host"
+	s.Equal("test, operator: test-operator, worker: test-host", New(bootstrapParams).getReason(params))
+
+	bootstrapParams.FormatHostIdentity = func(hostIdentity string) string {
+		return "host=" + hostIdentity
+	}
+	s.Equal("test, operator: test-operator, host=test-host", New(bootstrapParams).getReason(params))
+}
+
+func (s *batcherWorkflowTestSuite) TestDescribeBatchOperation() {
+	controller := gomock.NewController(s.T())
+	defer controller.Finish()
+	mockResource := resource.NewTest(controller, metrics.Worker)
+	defer mockResource.Finish(s.T())
+
+	details, err := encoded.GetDefaultDataConverter().ToData(HeartBeatDetails{
+		CurrentPage:  2,
+		SuccessCount: 10,
+		ErrorCount:   1,
+	})
+	s.NoError(err)
+	execution := &shared.WorkflowExecution{WorkflowId: common.StringPtr("job-id"), RunId: common.StringPtr("run-id")}
+	mockResource.FrontendClient.EXPECT().DescribeWorkflowExecution(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, request *shared.DescribeWorkflowExecutionRequest, _ ...interface{}) (*shared.DescribeWorkflowExecutionResponse, error) {
+			s.Equal(common.SystemLocalDomainName, request.GetDomain())
+			s.Equal("job-id", request.Execution.GetWorkflowId())
+			return &shared.DescribeWorkflowExecutionResponse{
+				WorkflowExecutionInfo: &shared.WorkflowExecutionInfo{Execution: execution},
+				PendingActivities: []*shared.PendingActivityInfo{{
+					ActivityType:     &shared.ActivityType{Name: common.StringPtr(batchActivityName)},
+					HeartbeatDetails: details,
+				}},
+			}, nil
+		})
+	progress, err := DescribeBatchOperation(context.Background(), mockResource.FrontendClient, "job-id")
+	s.NoError(err)
+	s.Equal("run-id", progress.RunID)
+	s.True(progress.Running)
+	s.True(progress.HeartbeatRecorded)
+	s.Equal(2, progress.Details.CurrentPage)
+	s.Equal(10, progress.Details.SuccessCount)
+	s.Equal(1, progress.Details.ErrorCount)
+
+	// the activity hasn't heartbeated yet
+	mockResource.FrontendClient.EXPECT().DescribeWorkflowExecution(gomock.Any(), gomock.Any()).
+		Return(&shared.DescribeWorkflowExecutionResponse{
+			WorkflowExecutionInfo: &shared.WorkflowExecutionInfo{Execution: execution},
+			PendingActivities: []*shared.PendingActivityInfo{{
+				ActivityType: &shared.ActivityType{Name: common.StringPtr(batchActivityName)},
+			}},
+		}, nil)
+	progress, err = DescribeBatchOperation(context.Background(), mockResource.FrontendClient, "job-id")
+	s.NoError(err)
+	s.True(progress.Running)
+	s.False(progress.HeartbeatRecorded)
+	s.Equal(HeartBeatDetails{}, progress.Details)
+
+	closeStatus := shared.WorkflowExecutionCloseStatusCompleted
+	mockResource.FrontendClient.EXPECT().DescribeWorkflowExecution(gomock.Any(), gomock.Any()).
+		Return(&shared.DescribeWorkflowExecutionResponse{
+			WorkflowExecutionInfo: &shared.WorkflowExecutionInfo{Execution: execution, CloseStatus: &closeStatus},
+		}, nil)
+	progress, err = DescribeBatchOperation(context.Background(), mockResource.FrontendClient, "job-id")
+	s.NoError(err)
+	s.False(progress.Running)
+	s.Equal(closeStatus, *progress.CloseStatus)
+}
+
+func (s *batcherWorkflowTestSuite) TestGetBatcherTaskListName() {
+	s.Equal(BatcherTaskListName, GetBatcherTaskListName(""))
+	s.Equal(BatcherTaskListName+"-cluster-a", GetBatcherTaskListName("cluster-a"))
+	s.Equal(BatcherTaskListName, getBatcherTaskListName(BatcherTaskListName, 0))
+	s.Equal(BatcherTaskListName+"-cluster-a-2", getBatcherTaskListName(GetBatcherTaskListName("cluster-a"), 2))
+}
+
+func (s *batcherWorkflowTestSuite) TestDeleteClosedExecution() {
+	controller := gomock.NewController(s.T())
+	defer controller.Finish()
+	mockResource := resource.NewTest(controller, metrics.Worker)
+	defer mockResource.Finish(s.T())
+
+	batcher := New(&BootstrapParams{
+		Config: Config{
+			AdminOperationToken: dynamicconfig.GetStringPropertyFn("admin-token"),
+			MaxConcurrency:      dynamicconfig.GetIntPropertyFn(4),
+			RPS:                 dynamicconfig.GetIntPropertyFn(100000),
+			NumHistoryShards:    1,
+		},
+		MetricsClient:      mockResource.MetricsClient,
+		Logger:             mockResource.Logger,
+		ClientBean:         mockResource.ClientBean,
+		ExecutionManagerFn: mockResource.GetExecutionManager,
+		HistoryManager:     mockResource.HistoryMgr,
+		VisibilityManager:  mockResource.VisibilityMgr,
+	})
+	ctx := context.WithValue(context.Background(), batcherContextKey, batcher)
+	params := BatchParams{
+		DomainName:       "test-domain",
+		OperatorIdentity: "test-operator",
+		BatchType:        BatchTypeDeleteClosed,
+	}
+
+	s.Empty(SignAdminOperation("", "job-id", params))
+	s.Error(checkAdminOperationToken(batcher, "job-id", params))
+	params.DeleteClosedParams.AdminOperationSignature = "admin-token"
+	s.Error(checkAdminOperationToken(batcher, "job-id", params))
+	params.DeleteClosedParams.AdminOperationSignature = SignAdminOperation("admin-token", "job-id", params)
+	s.NoError(checkAdminOperationToken(batcher, "job-id", params))
+	// the signature is only valid for the batch job and the params it is computed for
+	s.Error(checkAdminOperationToken(batcher, "another-job-id", params))
+	params.Query = "CloseTime > 0"
+	s.Error(checkAdminOperationToken(batcher, "job-id", params))
+	params.Query = ""
+	mockResource.FrontendClient.EXPECT().DescribeDomain(gomock.Any(), gomock.Any()).
+		Return(&shared.DescribeDomainResponse{DomainInfo: &shared.DomainInfo{UUID: common.StringPtr("domain-id")}}, nil)
+	params, err := prepareDeletion(ctx, params, mockResource.FrontendClient)
+	s.NoError(err)
+	s.Equal("domain-id", params._deleteDomainID)
+
+	mockResource.HistoryClient.EXPECT().GetMutableState(gomock.Any(), gomock.Any()).
+		Return(&h.GetMutableStateResponse{IsWorkflowRunning: common.BoolPtr(true)}, nil)
+	s.Equal(errTaskSkipped, deleteClosedExecution(ctx, params, "wid", "rid"))
+
+	mockResource.HistoryClient.EXPECT().GetMutableState(gomock.Any(), gomock.Any()).
+		Return(&h.GetMutableStateResponse{
+			IsWorkflowRunning:  common.BoolPtr(false),
+			CurrentBranchToken: []byte("branch-token"),
+		}, nil)
+	mockResource.HistoryMgr.On("DeleteHistoryBranch", &persistence.DeleteHistoryBranchRequest{
+		BranchToken: []byte("branch-token"),
+		ShardID:     common.IntPtr(0),
+	}).Return(nil).Once()
+	mockResource.ExecutionMgr.On("DeleteWorkflowExecution", &persistence.DeleteWorkflowExecutionRequest{
+		DomainID:   "domain-id",
+		WorkflowID: "wid",
+		RunID:      "rid",
+	}).Return(nil).Once()
+	mockResource.ExecutionMgr.On("DeleteCurrentWorkflowExecution", &persistence.DeleteCurrentWorkflowExecutionRequest{
+		DomainID:   "domain-id",
+		WorkflowID: "wid",
+		RunID:      "rid",
+	}).Return(nil).Once()
+	mockResource.VisibilityMgr.On("DeleteWorkflowExecution", &persistence.VisibilityDeleteWorkflowExecutionRequest{
+		DomainID:   "domain-id",
+		WorkflowID: "wid",
+		RunID:      "rid",
+	}).Return(nil).Once()
+	s.NoError(deleteClosedExecution(ctx, params, "wid", "rid"))
+}
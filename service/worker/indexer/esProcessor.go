@@ -23,6 +23,7 @@ package indexer
 import (
 	"context"
 	"encoding/json"
+	"sync"
 	"time"
 
 	"github.com/olivere/elastic"
@@ -60,11 +61,15 @@ type (
 
 	// esProcessorImpl implements ESProcessor, it's an agent of elastic.BulkProcessor
 	esProcessorImpl struct {
+		sync.RWMutex  // guards processor, which is replaced when the bulk settings change
 		processor     ElasticBulkProcessor
+		params        *es.BulkProcessorParameters
+		client        es.Client
 		mapToKafkaMsg collection.ConcurrentTxMap // used to map ES request to kafka message
 		config        *Config
 		logger        log.Logger
 		metricsClient metrics.Client
+		shutdownCh    chan struct{}
 	}
 
 	kafkaMessageWithMetrics struct { // value of esProcessorImpl.mapToKafkaMsg
@@ -80,42 +85,138 @@ const (
 	// retry configs for es bulk processor
 	esProcessorInitialRetryInterval = 200 * time.Millisecond
 	esProcessorMaxRetryInterval     = 20 * time.Second
+
+	// the bulk settings are fixed once elastic.BulkProcessor runs, they are re-read in this interval and the
+	// processor is replaced if any of them changes
+	esProcessorConfigRefreshInterval = time.Minute
+
+	// Stop waits up to this long for the buffered requests to be committed, a failed bulk is retried with backoff
+	// until then, and the requests still pending after it are redelivered by kafka since they are never acked
+	esProcessorStopTimeout = 30 * time.Second
 )
 
 // NewESProcessorAndStart create new ESProcessor and start
 func NewESProcessorAndStart(config *Config, client es.Client, processorName string,
 	logger log.Logger, metricsClient metrics.Client) (ESProcessor, error) {
 	p := &esProcessorImpl{
+		client:        client,
 		config:        config,
 		logger:        logger.WithTags(tag.ComponentIndexerESProcessor),
 		metricsClient: metricsClient,
+		shutdownCh:    make(chan struct{}),
 	}
 
-	params := &es.BulkProcessorParameters{
-		Name:          processorName,
-		NumOfWorkers:  config.ESProcessorNumOfWorkers(),
-		BulkActions:   config.ESProcessorBulkActions(),
-		BulkSize:      config.ESProcessorBulkSize(),
-		FlushInterval: config.ESProcessorFlushInterval(),
-		Backoff:       elastic.NewExponentialBackoff(esProcessorInitialRetryInterval, esProcessorMaxRetryInterval),
-		BeforeFunc:    p.bulkBeforeAction,
-		AfterFunc:     p.bulkAfterAction,
-	}
+	params := p.bulkProcessorParams(processorName)
 	processor, err := client.RunBulkProcessor(context.Background(), params)
 	if err != nil {
 		return nil, err
 	}
 
 	p.processor = processor
+	p.params = params
 	p.mapToKafkaMsg = collection.NewShardedConcurrentTxMap(1024, p.hashFn)
+	go p.refreshLoop()
 	return p, nil
 }
 
+// Stop flushes the buffered requests and blocks until they are committed or esProcessorStopTimeout passes
 func (p *esProcessorImpl) Stop() {
-	p.processor.Stop()
+	close(p.shutdownCh)
+	p.Lock()
+	defer p.Unlock()
+
+	pending := p.mapToKafkaMsg.Len()
+	stoppedCh := make(chan struct{})
+	go func() {
+		defer close(stoppedCh)
+		if err := p.processor.Stop(); err != nil {
+			p.logger.Error("Failed to flush bulk processor on stop.", tag.Error(err))
+		}
+	}()
+
+	select {
+	case <-stoppedCh:
+	case <-time.After(esProcessorStopTimeout):
+		// the bulk processor may still ack requests through mapToKafkaMsg, so it's kept
+		remaining := p.mapToKafkaMsg.Len()
+		p.logger.Warn("Timed out flushing bulk processor on stop.", tag.Number(int64(remaining)))
+		p.addFlushedOnShutdown(pending - remaining)
+		return
+	}
+	p.addFlushedOnShutdown(pending - p.mapToKafkaMsg.Len())
 	p.mapToKafkaMsg = nil
 }
 
+func (p *esProcessorImpl) addFlushedOnShutdown(flushed int) {
+	if flushed > 0 {
+		p.metricsClient.AddCounter(metrics.ESProcessorScope, metrics.ESProcessorFlushedOnShutdown, int64(flushed))
+	}
+}
+
+func (p *esProcessorImpl) bulkProcessorParams(processorName string) *es.BulkProcessorParameters {
+	return &es.BulkProcessorParameters{
+		Name:          processorName,
+		NumOfWorkers:  p.config.ESProcessorNumOfWorkers(),
+		BulkActions:   p.config.ESProcessorBulkActions(),
+		BulkSize:      p.config.ESProcessorBulkSize(),
+		FlushInterval: p.config.ESProcessorFlushInterval(),
+		Backoff:       elastic.NewExponentialBackoff(esProcessorInitialRetryInterval, esProcessorMaxRetryInterval),
+		BeforeFunc:    p.bulkBeforeAction,
+		AfterFunc:     p.bulkAfterAction,
+	}
+}
+
+func (p *esProcessorImpl) refreshLoop() {
+	ticker := time.NewTicker(esProcessorConfigRefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.shutdownCh:
+			return
+		case <-ticker.C:
+			p.refresh()
+		}
+	}
+}
+
+// refresh replaces the bulk processor if its settings changed in dynamic config, the requests added to the old
+// processor are flushed by stopping it, and they are still acked through mapToKafkaMsg which both share
+func (p *esProcessorImpl) refresh() {
+	p.RLock()
+	current := p.params
+	p.RUnlock()
+	params := p.bulkProcessorParams(current.Name)
+	if params.NumOfWorkers == current.NumOfWorkers &&
+		params.BulkActions == current.BulkActions &&
+		params.BulkSize == current.BulkSize &&
+		params.FlushInterval == current.FlushInterval {
+		return
+	}
+
+	processor, err := p.client.RunBulkProcessor(context.Background(), params)
+	if err != nil {
+		p.logger.Error("Failed to start bulk processor with the new settings, keep the current one.", tag.Error(err))
+		return
+	}
+	p.Lock()
+	select {
+	case <-p.shutdownCh:
+		p.Unlock()
+		processor.Stop()
+		return
+	default:
+	}
+	old := p.processor
+	p.processor = processor
+	p.params = params
+	p.Unlock()
+	if err := old.Stop(); err != nil {
+		p.logger.Error("Failed to stop the replaced bulk processor.", tag.Error(err))
+	}
+	p.logger.Info("Replaced bulk processor with the new settings.",
+		tag.Number(int64(params.BulkActions)), tag.Value(params.FlushInterval))
+}
+
 // Add an ES request, and an map item for kafka message
 func (p *esProcessorImpl) Add(request elastic.BulkableRequest, key string, kafkaMsg messaging.Message) {
 	actionWhenFoundDuplicates := func(key interface{}, value interface{}) error {
@@ -128,6 +229,8 @@ func (p *esProcessorImpl) Add(request elastic.BulkableRequest, key string, kafka
 	if isDup {
 		return
 	}
+	p.RLock()
+	defer p.RUnlock()
 	p.processor.Add(request)
 }
 
@@ -82,17 +82,20 @@ func (s *esProcessorSuite) SetupTest() {
 	zapLogger, err := zap.NewDevelopment()
 	s.Require().NoError(err)
 
+	s.mockESClient = &esMocks.Client{}
+
 	p := &esProcessorImpl{
+		client:        s.mockESClient,
 		config:        config,
 		logger:        loggerimpl.NewLogger(zapLogger),
 		metricsClient: s.mockMetricClient,
+		shutdownCh:    make(chan struct{}),
 	}
 	p.mapToKafkaMsg = collection.NewShardedConcurrentTxMap(1024, p.hashFn)
 	p.processor = s.mockBulkProcessor
+	p.params = p.bulkProcessorParams("test-processor")
 
 	s.esProcessor = p
-
-	s.mockESClient = &esMocks.Client{}
 }
 
 func (s *esProcessorSuite) TearDownTest() {
@@ -136,6 +139,47 @@ func (s *esProcessorSuite) TestStop() {
 	s.Nil(s.esProcessor.mapToKafkaMsg)
 }
 
+func (s *esProcessorSuite) TestStop_FlushPending() {
+	mockKafkaMsg := &msgMocks.Message{}
+	mockKafkaMsg.On("Ack").Return(nil).Once()
+	s.esProcessor.mapToKafkaMsg.Put(testID, newKafkaMessageWithMetrics(mockKafkaMsg, &testStopWatch))
+	s.esProcessor.mapToKafkaMsg.Put("pending-id", newKafkaMessageWithMetrics(&msgMocks.Message{}, &testStopWatch))
+	// stopping the bulk processor commits the buffered request of testID
+	s.mockBulkProcessor.On("Stop").Run(func(args mock.Arguments) {
+		s.esProcessor.ackKafkaMsg(testID)
+	}).Return(nil).Once()
+	s.mockMetricClient.On("AddCounter", metrics.ESProcessorScope, metrics.ESProcessorFlushedOnShutdown, int64(1)).Once()
+
+	s.esProcessor.Stop()
+	s.Nil(s.esProcessor.mapToKafkaMsg)
+	mockKafkaMsg.AssertExpectations(s.T())
+	s.mockBulkProcessor.AssertExpectations(s.T())
+	s.mockMetricClient.AssertExpectations(s.T())
+}
+
+func (s *esProcessorSuite) TestRefresh() {
+	// unchanged settings keep the current processor
+	s.esProcessor.refresh()
+	s.Equal(s.mockBulkProcessor, s.esProcessor.processor)
+
+	s.esProcessor.config.ESProcessorBulkActions = dynamicconfig.GetIntPropertyFn(100)
+	newBulkProcessor := &elastic.BulkProcessor{}
+	s.mockESClient.On("RunBulkProcessor", mock.Anything, mock.MatchedBy(func(input *es.BulkProcessorParameters) bool {
+		return input.Name == "test-processor" && input.BulkActions == 100
+	})).Return(newBulkProcessor, nil).Once()
+	s.mockBulkProcessor.On("Stop").Return(nil).Once()
+	s.esProcessor.refresh()
+	s.True(newBulkProcessor == s.esProcessor.processor)
+	s.Equal(100, s.esProcessor.params.BulkActions)
+
+	// the current processor is kept if the new one fails to start
+	s.esProcessor.config.ESProcessorBulkActions = dynamicconfig.GetIntPropertyFn(200)
+	s.mockESClient.On("RunBulkProcessor", mock.Anything, mock.Anything).Return(nil, errors.New("some error")).Once()
+	s.esProcessor.refresh()
+	s.True(newBulkProcessor == s.esProcessor.processor)
+	s.Equal(100, s.esProcessor.params.BulkActions)
+}
+
 func (s *esProcessorSuite) TestAdd() {
 	request := elastic.NewBulkIndexRequest()
 	mockKafkaMsg := &msgMocks.Message{}
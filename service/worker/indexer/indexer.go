@@ -29,6 +29,7 @@ import (
 	"github.com/uber/cadence/common/log/tag"
 	"github.com/uber/cadence/common/messaging"
 	"github.com/uber/cadence/common/metrics"
+	"github.com/uber/cadence/common/persistence"
 	"github.com/uber/cadence/common/service/dynamicconfig"
 )
 
@@ -38,6 +39,7 @@ type (
 		config              *Config
 		kafkaClient         messaging.Client
 		esClient            es.Client
+		visibilityMgr       persistence.VisibilityManager
 		logger              log.Logger
 		metricsClient       metrics.Client
 		dynamicCollection   *dynamicconfig.Collection
@@ -53,6 +55,7 @@ type (
 		ESProcessorBulkSize      dynamicconfig.IntPropertyFn // max total size of bytes in bulk
 		ESProcessorFlushInterval dynamicconfig.DurationPropertyFn
 		ValidSearchAttributes    dynamicconfig.MapPropertyFn
+		AdvancedVisWritingMode   dynamicconfig.StringPropertyFn // mirror mode also writes records to visibilityMgr
 	}
 )
 
@@ -60,15 +63,17 @@ const (
 	visibilityProcessorName = "visibility-processor"
 )
 
-// NewIndexer create a new Indexer
+// NewIndexer create a new Indexer. visibilityMgr is optional, when given the indexer also
+// writes visibility records to it while advanced visibility writing mode is mirror.
 func NewIndexer(config *Config, client messaging.Client, esClient es.Client, esConfig *es.Config,
-	logger log.Logger, metricsClient metrics.Client) *Indexer {
+	visibilityMgr persistence.VisibilityManager, logger log.Logger, metricsClient metrics.Client) *Indexer {
 	logger = logger.WithTags(tag.ComponentIndexer)
 
 	return &Indexer{
 		config:              config,
 		kafkaClient:         client,
 		esClient:            esClient,
+		visibilityMgr:       visibilityMgr,
 		logger:              logger,
 		metricsClient:       metricsClient,
 		visibilityIndexName: esConfig.Indices[common.VisibilityAppName],
@@ -76,17 +81,19 @@ func NewIndexer(config *Config, client messaging.Client, esClient es.Client, esC
 }
 
 // Start indexer
-func (x Indexer) Start() error {
+func (x *Indexer) Start() error {
 	visibilityApp := common.VisibilityAppName
 	visConsumerName := getConsumerName(x.visibilityIndexName)
 	x.visibilityProcessor = newIndexProcessor(visibilityApp, visConsumerName, x.kafkaClient, x.esClient,
-		visibilityProcessorName, x.visibilityIndexName, x.config, x.logger, x.metricsClient)
+		x.visibilityMgr, visibilityProcessorName, x.visibilityIndexName, x.config, x.logger, x.metricsClient)
 	return x.visibilityProcessor.Start()
 }
 
-// Stop indexer
-func (x Indexer) Stop() {
-	x.visibilityProcessor.Stop()
+// Stop indexer, it blocks until the buffered requests are committed to ElasticSearch or the flush times out
+func (x *Indexer) Stop() {
+	if x.visibilityProcessor != nil {
+		x.visibilityProcessor.Stop()
+	}
 }
 
 func getConsumerName(topic string) string {
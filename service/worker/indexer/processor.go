@@ -39,6 +39,7 @@ import (
 	"github.com/uber/cadence/common/log/tag"
 	"github.com/uber/cadence/common/messaging"
 	"github.com/uber/cadence/common/metrics"
+	"github.com/uber/cadence/common/persistence"
 )
 
 type indexProcessor struct {
@@ -50,6 +51,8 @@ type indexProcessor struct {
 	esProcessor     ESProcessor
 	esProcessorName string
 	esIndexName     string
+	visibilityMgr   persistence.VisibilityManager
+	serializer      persistence.PayloadSerializer
 	config          *Config
 	logger          log.Logger
 	metricsClient   metrics.Client
@@ -72,7 +75,8 @@ var (
 )
 
 func newIndexProcessor(appName, consumerName string, kafkaClient messaging.Client, esClient es.Client,
-	esProcessorName, esIndexName string, config *Config, logger log.Logger, metricsClient metrics.Client) *indexProcessor {
+	visibilityMgr persistence.VisibilityManager, esProcessorName, esIndexName string, config *Config,
+	logger log.Logger, metricsClient metrics.Client) *indexProcessor {
 	return &indexProcessor{
 		appName:         appName,
 		consumerName:    consumerName,
@@ -80,6 +84,8 @@ func newIndexProcessor(appName, consumerName string, kafkaClient messaging.Clien
 		esClient:        esClient,
 		esProcessorName: esProcessorName,
 		esIndexName:     esIndexName,
+		visibilityMgr:   visibilityMgr,
+		serializer:      persistence.NewPayloadSerializer(),
 		config:          config,
 		logger:          logger.WithTags(tag.ComponentIndexerProcessor),
 		metricsClient:   metricsClient,
@@ -148,15 +154,16 @@ func (p *indexProcessor) processorPump() {
 
 	select {
 	case <-p.shutdownCh:
-		// Processor is shutting down, close the underlying consumer and esProcessor
+		// Processor is shutting down, close the underlying consumer, which ends the workers
 		p.consumer.Stop()
-		p.esProcessor.Stop()
 	}
 
 	p.logger.Info("Index processor pump shutting down.")
 	if success := common.AwaitWaitGroup(&workerWG, 10*time.Second); !success {
 		p.logger.Warn("Index processor timed out on worker shutdown.")
 	}
+	// the workers no longer add requests, flush the ones buffered in esProcessor
+	p.esProcessor.Stop()
 }
 
 func (p *indexProcessor) messageProcessLoop(workerWG *sync.WaitGroup, workerID int) {
@@ -189,7 +196,90 @@ func (p *indexProcessor) process(kafkaMsg messaging.Message) error {
 		return err
 	}
 
-	return p.addMessageToES(indexMsg, kafkaMsg, logger)
+	if err := p.addMessageToES(indexMsg, kafkaMsg, logger); err != nil {
+		return err
+	}
+
+	if p.isMirrorEnabled() {
+		// ES stays the source of truth for acking the kafka message, a failed write to the db
+		// is only logged as a divergence between the two visibility stores
+		if err := p.writeMessageToDB(indexMsg); err != nil {
+			logger.Error("Failed to write visibility record to db, visibility stores diverged.",
+				tag.WorkflowDomainID(indexMsg.GetDomainID()),
+				tag.WorkflowID(indexMsg.GetWorkflowID()),
+				tag.WorkflowRunID(indexMsg.GetRunID()),
+				tag.Error(err))
+			p.metricsClient.IncCounter(metrics.IndexProcessorScope, metrics.IndexProcessorMirrorDivergence)
+		}
+	}
+	return nil
+}
+
+// isMirrorEnabled is only true in mirror mode, where history no longer writes to the db, as history already writes
+// every record to the db in dual mode
+func (p *indexProcessor) isMirrorEnabled() bool {
+	return p.visibilityMgr != nil &&
+		p.config.AdvancedVisWritingMode != nil &&
+		p.config.AdvancedVisWritingMode() == common.AdvancedVisibilityWritingModeMirror
+}
+
+// writeMessageToDB mirrors an index message to the db visibility manager, open records are
+// inserted and closed records are replaced so replaying a message is idempotent
+func (p *indexProcessor) writeMessageToDB(msg *indexer.Message) error {
+	execution := shared.WorkflowExecution{
+		WorkflowId: common.StringPtr(msg.GetWorkflowID()),
+		RunId:      common.StringPtr(msg.GetRunID()),
+	}
+
+	switch msg.GetMessageType() {
+	case indexer.MessageTypeIndex:
+		fields := msg.Fields
+		memo, err := p.deserializeMemo(fields)
+		if err != nil {
+			return err
+		}
+		if _, ok := fields[definition.CloseTime]; !ok {
+			return p.visibilityMgr.RecordWorkflowExecutionStarted(&persistence.RecordWorkflowExecutionStartedRequest{
+				DomainUUID:         msg.GetDomainID(),
+				Execution:          execution,
+				WorkflowTypeName:   getStringField(fields, definition.WorkflowType),
+				StartTimestamp:     getIntField(fields, definition.StartTime),
+				ExecutionTimestamp: getIntField(fields, definition.ExecutionTime),
+				TaskID:             msg.GetVersion(),
+				Memo:               memo,
+			})
+		}
+		return p.visibilityMgr.RecordWorkflowExecutionClosed(&persistence.RecordWorkflowExecutionClosedRequest{
+			DomainUUID:         msg.GetDomainID(),
+			Execution:          execution,
+			WorkflowTypeName:   getStringField(fields, definition.WorkflowType),
+			StartTimestamp:     getIntField(fields, definition.StartTime),
+			ExecutionTimestamp: getIntField(fields, definition.ExecutionTime),
+			CloseTimestamp:     getIntField(fields, definition.CloseTime),
+			Status:             shared.WorkflowExecutionCloseStatus(getIntField(fields, definition.CloseStatus)),
+			HistoryLength:      getIntField(fields, definition.HistoryLength),
+			TaskID:             msg.GetVersion(),
+			Memo:               memo,
+		})
+	case indexer.MessageTypeDelete:
+		return p.visibilityMgr.DeleteWorkflowExecution(&persistence.VisibilityDeleteWorkflowExecutionRequest{
+			DomainID:   msg.GetDomainID(),
+			WorkflowID: msg.GetWorkflowID(),
+			RunID:      msg.GetRunID(),
+			TaskID:     msg.GetVersion(),
+		})
+	default:
+		return errUnknownMessageType
+	}
+}
+
+func (p *indexProcessor) deserializeMemo(fields map[string]*indexer.Field) (*shared.Memo, error) {
+	field, ok := fields[definition.Memo]
+	if !ok {
+		return nil, nil
+	}
+	encoding := common.EncodingType(getStringField(fields, definition.Encoding))
+	return p.serializer.DeserializeVisibilityMemo(persistence.NewDataBlob(field.GetBinaryData(), encoding))
 }
 
 func (p *indexProcessor) deserialize(payload []byte) (*indexer.Message, error) {
@@ -292,6 +382,20 @@ func (p *indexProcessor) isValidFieldToES(field string) bool {
 	return false
 }
 
+func getStringField(fields map[string]*indexer.Field, key string) string {
+	if field, ok := fields[key]; ok {
+		return field.GetStringData()
+	}
+	return ""
+}
+
+func getIntField(fields map[string]*indexer.Field, key string) int64 {
+	if field, ok := fields[key]; ok {
+		return field.GetIntData()
+	}
+	return 0
+}
+
 func fulfillDoc(doc map[string]interface{}, msg *indexer.Message, keyToKafkaMsg string) {
 	doc[definition.DomainID] = msg.GetDomainID()
 	doc[definition.WorkflowID] = msg.GetWorkflowID()
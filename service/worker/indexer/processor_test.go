@@ -0,0 +1,206 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package indexer
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/olivere/elastic"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/suite"
+
+	"github.com/uber/cadence/.gen/go/indexer"
+	"github.com/uber/cadence/.gen/go/shared"
+	"github.com/uber/cadence/common"
+	"github.com/uber/cadence/common/codec"
+	"github.com/uber/cadence/common/definition"
+	"github.com/uber/cadence/common/log"
+	"github.com/uber/cadence/common/messaging"
+	msgMocks "github.com/uber/cadence/common/messaging/mocks"
+	"github.com/uber/cadence/common/metrics"
+	mmocks "github.com/uber/cadence/common/metrics/mocks"
+	"github.com/uber/cadence/common/mocks"
+	"github.com/uber/cadence/common/persistence"
+	"github.com/uber/cadence/common/service/dynamicconfig"
+)
+
+type (
+	indexProcessorSuite struct {
+		suite.Suite
+		processor         *indexProcessor
+		esProcessor       *addCountingESProcessor
+		mockVisibilityMgr *mocks.VisibilityManager
+		mockMetricClient  *mmocks.Client
+		writingMode       string
+	}
+
+	// addCountingESProcessor counts the requests added to the bulk
+	addCountingESProcessor struct {
+		adds int
+	}
+)
+
+func (p *addCountingESProcessor) Stop() {}
+
+func (p *addCountingESProcessor) Add(request elastic.BulkableRequest, key string, kafkaMsg messaging.Message) {
+	p.adds++
+}
+
+func TestIndexProcessorSuite(t *testing.T) {
+	s := new(indexProcessorSuite)
+	suite.Run(t, s)
+}
+
+func (s *indexProcessorSuite) SetupTest() {
+	s.writingMode = common.AdvancedVisibilityWritingModeMirror
+	config := &Config{
+		ValidSearchAttributes: dynamicconfig.GetMapPropertyFn(definition.GetDefaultIndexedKeys()),
+		AdvancedVisWritingMode: func(opts ...dynamicconfig.FilterOption) string {
+			return s.writingMode
+		},
+	}
+	s.mockVisibilityMgr = &mocks.VisibilityManager{}
+	s.mockMetricClient = &mmocks.Client{}
+	s.esProcessor = &addCountingESProcessor{}
+	s.processor = newIndexProcessor(common.VisibilityAppName, "test-consumer", nil, nil, s.mockVisibilityMgr,
+		"test-processor", "test-index", config, log.NewNoop(), s.mockMetricClient)
+	s.processor.esProcessor = s.esProcessor
+}
+
+func (s *indexProcessorSuite) TearDownTest() {
+	s.mockVisibilityMgr.AssertExpectations(s.T())
+	s.mockMetricClient.AssertExpectations(s.T())
+}
+
+func (s *indexProcessorSuite) TestProcessMirrorsStartedRecord() {
+	s.mockVisibilityMgr.On("RecordWorkflowExecutionStarted", &persistence.RecordWorkflowExecutionStartedRequest{
+		DomainUUID: "domain-id",
+		Execution: shared.WorkflowExecution{
+			WorkflowId: common.StringPtr("workflow-id"),
+			RunId:      common.StringPtr("run-id"),
+		},
+		WorkflowTypeName:   "workflow-type",
+		StartTimestamp:     100,
+		ExecutionTimestamp: 200,
+		TaskID:             7,
+	}).Return(nil).Once()
+
+	s.NoError(s.processor.process(s.newKafkaMessage(s.newIndexMessage(nil))))
+	s.Equal(1, s.esProcessor.adds)
+}
+
+func (s *indexProcessorSuite) TestProcessMirrorsClosedRecord() {
+	memo := &shared.Memo{Fields: map[string][]byte{"key": []byte("value")}}
+	memoBlob, err := persistence.NewPayloadSerializer().SerializeVisibilityMemo(memo, common.EncodingTypeThriftRW)
+	s.NoError(err)
+	s.mockVisibilityMgr.On("RecordWorkflowExecutionClosed", &persistence.RecordWorkflowExecutionClosedRequest{
+		DomainUUID: "domain-id",
+		Execution: shared.WorkflowExecution{
+			WorkflowId: common.StringPtr("workflow-id"),
+			RunId:      common.StringPtr("run-id"),
+		},
+		WorkflowTypeName:   "workflow-type",
+		StartTimestamp:     100,
+		ExecutionTimestamp: 200,
+		CloseTimestamp:     300,
+		Status:             shared.WorkflowExecutionCloseStatusFailed,
+		HistoryLength:      10,
+		TaskID:             7,
+		Memo:               memo,
+	}).Return(nil).Once()
+
+	msg := s.newIndexMessage(map[string]*indexer.Field{
+		definition.CloseTime:     s.newIntField(300),
+		definition.CloseStatus:   s.newIntField(int64(shared.WorkflowExecutionCloseStatusFailed)),
+		definition.HistoryLength: s.newIntField(10),
+		definition.Memo:          {Type: indexer.FieldTypeBinary.Ptr(), BinaryData: memoBlob.Data},
+		definition.Encoding:      {Type: indexer.FieldTypeString.Ptr(), StringData: common.StringPtr(string(memoBlob.Encoding))},
+	})
+	s.NoError(s.processor.process(s.newKafkaMessage(msg)))
+	s.Equal(1, s.esProcessor.adds)
+}
+
+func (s *indexProcessorSuite) TestProcessMirrorsDeletedRecord() {
+	s.mockVisibilityMgr.On("DeleteWorkflowExecution", &persistence.VisibilityDeleteWorkflowExecutionRequest{
+		DomainID:   "domain-id",
+		WorkflowID: "workflow-id",
+		RunID:      "run-id",
+		TaskID:     7,
+	}).Return(nil).Once()
+
+	msg := s.newIndexMessage(nil)
+	msg.MessageType = indexer.MessageTypeDelete.Ptr()
+	msg.Fields = nil
+	s.NoError(s.processor.process(s.newKafkaMessage(msg)))
+	s.Equal(1, s.esProcessor.adds)
+}
+
+func (s *indexProcessorSuite) TestProcessMirrorDivergence() {
+	s.mockVisibilityMgr.On("RecordWorkflowExecutionStarted", mock.Anything).Return(errors.New("db is unavailable")).Once()
+	s.mockMetricClient.On("IncCounter", metrics.IndexProcessorScope, metrics.IndexProcessorMirrorDivergence).Once()
+
+	// the kafka message is still acked by the ES bulk
+	s.NoError(s.processor.process(s.newKafkaMessage(s.newIndexMessage(nil))))
+	s.Equal(1, s.esProcessor.adds)
+}
+
+func (s *indexProcessorSuite) TestProcessNotMirroredOutOfMirrorMode() {
+	// history writes the db records in dual mode, the visibility manager has no expectations
+	for _, mode := range []string{common.AdvancedVisibilityWritingModeOn, common.AdvancedVisibilityWritingModeDual} {
+		s.writingMode = mode
+		s.NoError(s.processor.process(s.newKafkaMessage(s.newIndexMessage(nil))))
+	}
+	s.Equal(2, s.esProcessor.adds)
+}
+
+func (s *indexProcessorSuite) newIndexMessage(fields map[string]*indexer.Field) *indexer.Message {
+	msg := &indexer.Message{
+		MessageType: indexer.MessageTypeIndex.Ptr(),
+		DomainID:    common.StringPtr("domain-id"),
+		WorkflowID:  common.StringPtr("workflow-id"),
+		RunID:       common.StringPtr("run-id"),
+		Version:     common.Int64Ptr(7),
+		Fields: map[string]*indexer.Field{
+			definition.WorkflowType:  {Type: indexer.FieldTypeString.Ptr(), StringData: common.StringPtr("workflow-type")},
+			definition.StartTime:     s.newIntField(100),
+			definition.ExecutionTime: s.newIntField(200),
+		},
+	}
+	for key, field := range fields {
+		msg.Fields[key] = field
+	}
+	return msg
+}
+
+func (s *indexProcessorSuite) newIntField(value int64) *indexer.Field {
+	return &indexer.Field{Type: indexer.FieldTypeInt.Ptr(), IntData: common.Int64Ptr(value)}
+}
+
+func (s *indexProcessorSuite) newKafkaMessage(msg *indexer.Message) messaging.Message {
+	payload, err := codec.NewThriftRWEncoder().Encode(msg)
+	s.NoError(err)
+	kafkaMsg := &msgMocks.Message{}
+	kafkaMsg.On("Value").Return(payload)
+	kafkaMsg.On("Partition").Return(int32(0))
+	kafkaMsg.On("Offset").Return(int64(0))
+	return kafkaMsg
+}
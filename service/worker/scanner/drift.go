@@ -0,0 +1,213 @@
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package scanner
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	"github.com/uber/cadence/.gen/go/history"
+	"github.com/uber/cadence/.gen/go/shared"
+	"github.com/uber/cadence/common"
+	"github.com/uber/cadence/common/log/tag"
+	"github.com/uber/cadence/common/metrics"
+	p "github.com/uber/cadence/common/persistence"
+	"github.com/uber/cadence/common/quotas"
+)
+
+type (
+	// visibilityDriftScanJob samples domains and checks their open visibility records against the
+	// execution store, the number of records that are open in visibility but not in the execution
+	// store is emitted as a per domain drift gauge. The execution store can't be counted per domain,
+	// so workflows that are open in the execution store but missing in visibility are not detected
+	visibilityDriftScanJob struct {
+		ctx scannerContext
+	}
+
+	// visibilityDriftResult is the outcome of the drift scan of one domain
+	visibilityDriftResult struct {
+		visibilityOpen int
+		executionOpen  int
+	}
+)
+
+const (
+	visibilityDriftScanJobName         = "visibility-drift"
+	visibilityDriftScanCronSchedule    = "0 * * * *"
+	visibilityDriftScanPageSize        = 100
+	visibilityDriftListDomainsPageSize = 200
+)
+
+func newVisibilityDriftScanJob(ctx scannerContext) *visibilityDriftScanJob {
+	return &visibilityDriftScanJob{ctx: ctx}
+}
+
+// Name returns the name of the visibility drift scan job
+func (j *visibilityDriftScanJob) Name() string {
+	return visibilityDriftScanJobName
+}
+
+// CronSchedule returns the cron schedule of the visibility drift scan job
+func (j *visibilityDriftScanJob) CronSchedule() string {
+	return visibilityDriftScanCronSchedule
+}
+
+// Run samples domains and emits the visibility drift of each of them
+func (j *visibilityDriftScanJob) Run(ctx context.Context, rateLimiter quotas.Limiter) error {
+	domains, err := j.sampleDomains(ctx, rateLimiter)
+	if err != nil {
+		return err
+	}
+
+	for _, domain := range domains {
+		logger := j.ctx.GetLogger().WithTags(tag.WorkflowDomainName(domain.Info.Name))
+		scope := j.ctx.GetMetricsClient().Scope(metrics.VisibilityDriftScannerScope, metrics.DomainTag(domain.Info.Name))
+		result, err := j.scanDomain(ctx, rateLimiter, domain.Info)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			logger.Warn("Failed to scan visibility drift of domain", tag.Error(err))
+			scope.IncCounter(metrics.VisibilityDriftScannerErrorCount)
+			continue
+		}
+		scope.UpdateGauge(metrics.VisibilityDriftScannerVisibilityOpen, float64(result.visibilityOpen))
+		scope.UpdateGauge(metrics.VisibilityDriftScannerExecutionOpen, float64(result.executionOpen))
+		scope.UpdateGauge(metrics.VisibilityDriftScannerDrift, float64(result.visibilityOpen-result.executionOpen))
+		if result.visibilityOpen != result.executionOpen {
+			logger.Info("Visibility drift detected", tag.Counter(result.visibilityOpen-result.executionOpen))
+		}
+	}
+	return nil
+}
+
+// sampleDomains returns up to VisibilityDriftDomainSampleSize random registered domains
+func (j *visibilityDriftScanJob) sampleDomains(
+	ctx context.Context,
+	rateLimiter quotas.Limiter,
+) ([]*p.GetDomainResponse, error) {
+
+	var domains []*p.GetDomainResponse
+	var pageToken []byte
+	for {
+		if err := rateLimiter.Wait(ctx); err != nil {
+			return nil, err
+		}
+		resp, err := j.ctx.GetMetadataManager().ListDomains(&p.ListDomainsRequest{
+			PageSize:      visibilityDriftListDomainsPageSize,
+			NextPageToken: pageToken,
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, domain := range resp.Domains {
+			if domain.Info.Status != p.DomainStatusRegistered || j.ctx.isExcludedDomain(domain.Info.ID) {
+				continue
+			}
+			domains = append(domains, domain)
+		}
+		pageToken = resp.NextPageToken
+		if len(pageToken) == 0 {
+			break
+		}
+	}
+
+	sampleSize := j.ctx.cfg.VisibilityDriftDomainSampleSize()
+	if len(domains) <= sampleSize {
+		return domains, nil
+	}
+	rand.Shuffle(len(domains), func(i, k int) {
+		domains[i], domains[k] = domains[k], domains[i]
+	})
+	return domains[:sampleSize], nil
+}
+
+// scanDomain checks up to VisibilityDriftMaxWorkflowsPerDomain open visibility records of
+// the domain against the execution store
+func (j *visibilityDriftScanJob) scanDomain(
+	ctx context.Context,
+	rateLimiter quotas.Limiter,
+	domain *p.DomainInfo,
+) (visibilityDriftResult, error) {
+
+	result := visibilityDriftResult{}
+	maxWorkflows := j.ctx.cfg.VisibilityDriftMaxWorkflowsPerDomain()
+	request := &p.ListWorkflowExecutionsRequest{
+		DomainUUID:        domain.ID,
+		Domain:            domain.Name,
+		EarliestStartTime: 0,
+		LatestStartTime:   time.Now().UnixNano(),
+		PageSize:          visibilityDriftScanPageSize,
+	}
+	for result.visibilityOpen < maxWorkflows {
+		if err := rateLimiter.Wait(ctx); err != nil {
+			return result, err
+		}
+		resp, err := j.ctx.GetVisibilityManager().ListOpenWorkflowExecutions(request)
+		if err != nil {
+			return result, err
+		}
+		for _, execution := range resp.Executions {
+			if result.visibilityOpen >= maxWorkflows {
+				break
+			}
+			isOpen, err := j.isOpenInExecutionStore(ctx, rateLimiter, domain, execution.Execution)
+			if err != nil {
+				return result, err
+			}
+			result.visibilityOpen++
+			if isOpen {
+				result.executionOpen++
+			}
+		}
+		request.NextPageToken = resp.NextPageToken
+		if len(request.NextPageToken) == 0 {
+			break
+		}
+	}
+	return result, nil
+}
+
+func (j *visibilityDriftScanJob) isOpenInExecutionStore(
+	ctx context.Context,
+	rateLimiter quotas.Limiter,
+	domain *p.DomainInfo,
+	execution *shared.WorkflowExecution,
+) (bool, error) {
+
+	if err := rateLimiter.Wait(ctx); err != nil {
+		return false, err
+	}
+	resp, err := j.ctx.GetHistoryClient().DescribeWorkflowExecution(ctx, &history.DescribeWorkflowExecutionRequest{
+		DomainUUID: common.StringPtr(domain.ID),
+		Request: &shared.DescribeWorkflowExecutionRequest{
+			Domain:    common.StringPtr(domain.Name),
+			Execution: execution,
+		},
+	})
+	if err != nil {
+		if _, ok := err.(*shared.EntityNotExistsError); ok {
+			return false, nil
+		}
+		return false, err
+	}
+	return resp.WorkflowExecutionInfo.CloseStatus == nil, nil
+}
@@ -57,6 +57,8 @@ type (
 		metrics  metrics.Client
 		logger   log.Logger
 		isInTest bool
+		// isExcludedDomain tells whether to leave the history branches of the domain alone
+		isExcludedDomain func(domainID string) bool
 	}
 
 	taskDetail struct {
@@ -95,6 +97,7 @@ func NewScavenger(
 	rps int,
 	client historyserviceclient.Interface,
 	hbd ScavengerHeartbeatDetails,
+	isExcludedDomain func(domainID string) bool,
 	metricsClient metrics.Client,
 	logger log.Logger,
 ) *Scavenger {
@@ -109,6 +112,8 @@ func NewScavenger(
 		limiter: rateLimiter,
 		metrics: metricsClient,
 		logger:  logger,
+
+		isExcludedDomain: isExcludedDomain,
 	}
 }
 
@@ -151,6 +156,12 @@ func (s *Scavenger) Run(ctx context.Context) (ScavengerHeartbeatDetails, error)
 				s.metrics.IncCounter(metrics.HistoryScavengerScope, metrics.HistoryScavengerErrorCount)
 				continue
 			}
+			if s.isExcludedDomain(domainID) {
+				batchCount--
+				skips++
+				s.metrics.IncCounter(metrics.HistoryScavengerScope, metrics.HistoryScavengerSkipCount)
+				continue
+			}
 
 			taskCh <- taskDetail{
 				domainID:   domainID,
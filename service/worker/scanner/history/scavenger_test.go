@@ -27,6 +27,7 @@ import (
 	"time"
 
 	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/suite"
 	"github.com/uber-go/tally"
 	"go.uber.org/zap"
@@ -67,7 +68,7 @@ func (s *ScavengerTestSuite) createTestScavenger(rps int) (*mocks.HistoryV2Manag
 	db := &mocks.HistoryV2Manager{}
 	controller := gomock.NewController(s.T())
 	workflowClient := historyservicetest.NewMockClient(controller)
-	scvgr := NewScavenger(db, 100, workflowClient, ScavengerHeartbeatDetails{}, s.metric, s.logger)
+	scvgr := NewScavenger(db, 100, workflowClient, ScavengerHeartbeatDetails{}, func(string) bool { return false }, s.metric, s.logger)
 	scvgr.isInTest = true
 	return db, workflowClient, scvgr, controller
 }
@@ -257,6 +258,60 @@ func (s *ScavengerTestSuite) TestNoGarbageTwoPages() {
 	s.Equal(0, len(hbd.NextPageToken))
 }
 
+func (s *ScavengerTestSuite) TestExcludedDomainSkipped() {
+	db := &mocks.HistoryV2Manager{}
+	controller := gomock.NewController(s.T())
+	defer controller.Finish()
+	client := historyservicetest.NewMockClient(controller)
+	scope := tally.NewTestScope("", nil)
+	scvgr := NewScavenger(db, 100, client, ScavengerHeartbeatDetails{}, func(domainID string) bool {
+		return domainID == "domainID1"
+	}, metrics.NewClient(scope, metrics.Worker), s.logger)
+	scvgr.isInTest = true
+	db.On("GetAllHistoryTreeBranches", &p.GetAllHistoryTreeBranchesRequest{
+		PageSize: pageSize,
+	}).Return(&p.GetAllHistoryTreeBranchesResponse{
+		Branches: []p.HistoryBranchDetail{
+			{
+				TreeID:   "treeID1",
+				BranchID: "branchID1",
+				ForkTime: time.Now().Add(-cleanUpThreshold * 2),
+				Info:     p.BuildHistoryGarbageCleanupInfo("domainID1", "workflowID1", "runID1"),
+			},
+			{
+				TreeID:   "treeID2",
+				BranchID: "branchID2",
+				ForkTime: time.Now().Add(-cleanUpThreshold * 2),
+				Info:     p.BuildHistoryGarbageCleanupInfo("domainID2", "workflowID2", "runID2"),
+			},
+		},
+	}, nil).Once()
+
+	// the branch of the excluded domain is neither checked nor deleted
+	client.EXPECT().DescribeMutableState(gomock.Any(), &history.DescribeMutableStateRequest{
+		DomainUUID: common.StringPtr("domainID2"),
+		Execution: &shared.WorkflowExecution{
+			WorkflowId: common.StringPtr("workflowID2"),
+			RunId:      common.StringPtr("runID2"),
+		},
+	}).Return(nil, nil)
+
+	hbd, err := scvgr.Run(context.Background())
+	s.Nil(err)
+	s.Equal(1, hbd.SkipCount)
+	s.Equal(1, hbd.SuccCount)
+	s.Equal(0, hbd.ErrorCount)
+	s.Equal(1, hbd.CurrentPage)
+	db.AssertNotCalled(s.T(), "DeleteHistoryBranch", mock.Anything)
+	skips := int64(0)
+	for _, counter := range scope.Snapshot().Counters() {
+		if counter.Name() == "scavenger_skips" {
+			skips += counter.Value()
+		}
+	}
+	s.Equal(int64(1), skips)
+}
+
 func (s *ScavengerTestSuite) TestDeletingBranchesTwoPages() {
 	db, client, scvgr, controller := s.createTestScavenger(100)
 	defer controller.Finish()
@@ -0,0 +1,150 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package scanner
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.uber.org/cadence/activity"
+	cclient "go.uber.org/cadence/client"
+	"go.uber.org/cadence/workflow"
+
+	"github.com/uber/cadence/common/log/tag"
+	"github.com/uber/cadence/common/quotas"
+)
+
+type (
+	// ScanJob is a custom scan job that runs on the scanner infrastructure,
+	// sharing its persistence rate limiter and its cron based scheduling
+	ScanJob interface {
+		// Name returns the unique name of the job
+		Name() string
+		// CronSchedule returns the cron schedule of the job, e.g. "0 */12 * * *"
+		CronSchedule() string
+		// Run runs one iteration of the job, all persistence calls
+		// made by the job must be throttled by the given rateLimiter
+		Run(ctx context.Context, rateLimiter quotas.Limiter) error
+	}
+)
+
+const (
+	scanJobWFIDPrefix       = "cadence-sys-scan-job-"
+	scanJobWFTypeName       = "cadence-sys-scan-job-workflow"
+	scanJobTaskListName     = "cadence-sys-scan-job-tasklist-0"
+	scanJobActivityName     = "cadence-sys-scan-job-activity"
+	scanJobWFStartToCloseTO = 5 * 24 * time.Hour
+)
+
+var (
+	scanJobHBInterval = 10 * time.Second
+
+	scanJobRegistry = struct {
+		sync.Mutex
+		jobs map[string]ScanJob
+	}{jobs: make(map[string]ScanJob)}
+)
+
+func init() {
+	workflow.RegisterWithOptions(ScanJobWorkflow, workflow.RegisterOptions{Name: scanJobWFTypeName})
+	activity.RegisterWithOptions(ScanJobActivity, activity.RegisterOptions{Name: scanJobActivityName})
+}
+
+// RegisterScanJob registers a custom scan job, it must be called before the worker service starts
+func RegisterScanJob(job ScanJob) error {
+	scanJobRegistry.Lock()
+	defer scanJobRegistry.Unlock()
+	if job.Name() == "" {
+		return fmt.Errorf("scan job name must not be empty")
+	}
+	if _, ok := scanJobRegistry.jobs[job.Name()]; ok {
+		return fmt.Errorf("scan job %v is already registered", job.Name())
+	}
+	scanJobRegistry.jobs[job.Name()] = job
+	return nil
+}
+
+// RegisteredScanJobs returns all the registered custom scan jobs
+func RegisteredScanJobs() []ScanJob {
+	scanJobRegistry.Lock()
+	defer scanJobRegistry.Unlock()
+	jobs := make([]ScanJob, 0, len(scanJobRegistry.jobs))
+	for _, job := range scanJobRegistry.jobs {
+		jobs = append(jobs, job)
+	}
+	return jobs
+}
+
+func scanJobWFStartOptions(job ScanJob) cclient.StartWorkflowOptions {
+	return cclient.StartWorkflowOptions{
+		ID:                           scanJobWFIDPrefix + job.Name(),
+		TaskList:                     scanJobTaskListName,
+		ExecutionStartToCloseTimeout: scanJobWFStartToCloseTO,
+		WorkflowIDReusePolicy:        cclient.WorkflowIDReusePolicyAllowDuplicate,
+		CronSchedule:                 job.CronSchedule(),
+	}
+}
+
+// ScanJobWorkflow is the workflow that runs a custom scan job
+func ScanJobWorkflow(
+	ctx workflow.Context,
+	jobName string,
+) error {
+
+	future := workflow.ExecuteActivity(workflow.WithActivityOptions(ctx, activityOptions), scanJobActivityName, jobName)
+	return future.Get(ctx, nil)
+}
+
+// ScanJobActivity is the activity that runs a custom scan job
+func ScanJobActivity(
+	activityCtx context.Context,
+	jobName string,
+) error {
+
+	ctx := activityCtx.Value(scannerContextKey).(scannerContext)
+	job, ok := ctx.scanJobs[jobName]
+	if !ok {
+		return fmt.Errorf("scan job %v is not registered", jobName)
+	}
+
+	logger := ctx.GetLogger().WithTags(tag.Name(jobName))
+	logger.Info("Starting scan job")
+	doneC := make(chan error, 1)
+	go func() {
+		doneC <- job.Run(activityCtx, ctx.rateLimiter)
+	}()
+
+	ticker := time.NewTicker(scanJobHBInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case err := <-doneC:
+			if err != nil {
+				logger.Error("Scan job failed", tag.Error(err))
+			}
+			return err
+		case <-ticker.C:
+			activity.RecordHeartbeat(activityCtx)
+		}
+	}
+}
@@ -34,6 +34,7 @@ import (
 	"github.com/uber/cadence/common/backoff"
 	"github.com/uber/cadence/common/cluster"
 	"github.com/uber/cadence/common/log/tag"
+	"github.com/uber/cadence/common/quotas"
 	"github.com/uber/cadence/common/resource"
 	"github.com/uber/cadence/common/service/config"
 	"github.com/uber/cadence/common/service/dynamicconfig"
@@ -53,6 +54,15 @@ type (
 		Persistence *config.Persistence
 		// ClusterMetadata contains the metadata for this cluster
 		ClusterMetadata cluster.Metadata
+		// ExcludedDomains is the list of domain names to skip by the scavengers, it's read on every use
+		// so that a domain can be excluded without a restart
+		ExcludedDomains dynamicconfig.PropertyFn
+		// VisibilityDriftScanEnabled decides whether to run the visibility drift scan job
+		VisibilityDriftScanEnabled dynamicconfig.BoolPropertyFn
+		// VisibilityDriftDomainSampleSize is the number of domains sampled by each visibility drift scan
+		VisibilityDriftDomainSampleSize dynamicconfig.IntPropertyFn
+		// VisibilityDriftMaxWorkflowsPerDomain is the max number of open visibility records checked per domain
+		VisibilityDriftMaxWorkflowsPerDomain dynamicconfig.IntPropertyFn
 	}
 
 	// BootstrapParams contains the set of params needed to bootstrap
@@ -62,15 +72,19 @@ type (
 		Config Config
 		// TallyScope is an instance of tally metrics scope
 		TallyScope tally.Scope
+		// ScanJobs are the custom scan jobs to run along with the builtin ones
+		ScanJobs []ScanJob
 	}
 
 	// scannerContext is the context object that get's
 	// passed around within the scanner workflows / activities
 	scannerContext struct {
 		resource.Resource
-		cfg        Config
-		tallyScope tally.Scope
-		zapLogger  *zap.Logger
+		cfg         Config
+		tallyScope  tally.Scope
+		zapLogger   *zap.Logger
+		rateLimiter quotas.Limiter
+		scanJobs    map[string]ScanJob
 	}
 
 	// Scanner is the background sub-system that does full scans
@@ -97,14 +111,27 @@ func New(
 	if err != nil {
 		resource.GetLogger().Fatal("failed to initialize zap logger", tag.Error(err))
 	}
-	return &Scanner{
+	scanJobs := make(map[string]ScanJob, len(params.ScanJobs))
+	for _, job := range params.ScanJobs {
+		scanJobs[job.Name()] = job
+	}
+	scanner := &Scanner{
 		context: scannerContext{
 			Resource:   resource,
 			cfg:        cfg,
 			tallyScope: params.TallyScope,
 			zapLogger:  zapLogger,
+			rateLimiter: quotas.NewDynamicRateLimiter(func() float64 {
+				return float64(cfg.PersistenceMaxQPS())
+			}),
+			scanJobs: scanJobs,
 		},
 	}
+	if cfg.VisibilityDriftScanEnabled != nil && cfg.VisibilityDriftScanEnabled() {
+		job := newVisibilityDriftScanJob(scanner.context)
+		scanJobs[job.Name()] = job
+	}
+	return scanner
 }
 
 // Start starts the scanner
@@ -126,12 +153,22 @@ func (s *Scanner) Start() error {
 		workerTaskListName = historyScannerTaskListName
 	}
 
+	if len(s.context.scanJobs) > 0 {
+		for name, job := range s.context.scanJobs {
+			go s.startWorkflowWithRetry(scanJobWFStartOptions(job), scanJobWFTypeName, name)
+		}
+		if err := worker.New(s.context.GetSDKClient(), common.SystemLocalDomainName, scanJobTaskListName, workerOpts).Start(); err != nil {
+			return err
+		}
+	}
+
 	return worker.New(s.context.GetSDKClient(), common.SystemLocalDomainName, workerTaskListName, workerOpts).Start()
 }
 
 func (s *Scanner) startWorkflowWithRetry(
 	options cclient.StartWorkflowOptions,
 	workflowType string,
+	args ...interface{},
 ) {
 
 	// let history / matching service warm up
@@ -142,7 +179,7 @@ func (s *Scanner) startWorkflowWithRetry(
 	policy.SetMaximumInterval(time.Minute)
 	policy.SetExpirationInterval(backoff.NoInterval)
 	err := backoff.Retry(func() error {
-		return s.startWorkflow(sdkClient, options, workflowType)
+		return s.startWorkflow(sdkClient, options, workflowType, args...)
 	}, policy, func(err error) bool {
 		return true
 	})
@@ -155,10 +192,11 @@ func (s *Scanner) startWorkflow(
 	client cclient.Client,
 	options cclient.StartWorkflowOptions,
 	workflowType string,
+	args ...interface{},
 ) error {
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
-	_, err := client.StartWorkflow(ctx, options, workflowType)
+	_, err := client.StartWorkflow(ctx, options, workflowType, args...)
 	cancel()
 	if err != nil {
 		if _, ok := err.(*shared.WorkflowExecutionAlreadyStartedError); ok {
@@ -170,3 +208,25 @@ func (s *Scanner) startWorkflow(
 	s.context.GetLogger().Info(workflowType + " workflow successfully started")
 	return nil
 }
+
+// isExcludedDomain tells whether the domain is in ExcludedDomains of the config
+func (ctx scannerContext) isExcludedDomain(domainID string) bool {
+	if ctx.cfg.ExcludedDomains == nil {
+		return false
+	}
+	excludedDomains, ok := ctx.cfg.ExcludedDomains().([]interface{})
+	if !ok || len(excludedDomains) == 0 {
+		return false
+	}
+	domainName, err := ctx.GetDomainCache().GetDomainName(domainID)
+	if err != nil {
+		// let the scavengers deal with the domain as usual, e.g. it may have been deleted
+		return false
+	}
+	for _, excluded := range excludedDomains {
+		if excluded == domainName {
+			return true
+		}
+	}
+	return false
+}
@@ -44,6 +44,8 @@ type (
 		status   int32
 		stopC    chan struct{}
 		stopWG   sync.WaitGroup
+		// isExcludedDomain tells whether to leave the task lists of the domain alone
+		isExcludedDomain func(domainID string) bool
 	}
 
 	taskListKey struct {
@@ -61,6 +63,7 @@ type (
 		tasklist struct {
 			nProcessed int64
 			nDeleted   int64
+			nSkipped   int64
 		}
 		task struct {
 			nProcessed int64
@@ -99,7 +102,12 @@ var (
 // two conditions
 //  - either all task lists are processed successfully (or)
 //  - Stop() method is called to stop the scavenger
-func NewScavenger(db p.TaskManager, metricsClient metrics.Client, logger log.Logger) *Scavenger {
+func NewScavenger(
+	db p.TaskManager,
+	isExcludedDomain func(domainID string) bool,
+	metricsClient metrics.Client,
+	logger log.Logger,
+) *Scavenger {
 	stopC := make(chan struct{})
 	taskExecutor := executor.NewFixedSizePoolExecutor(
 		taskListBatchSize, executorMaxDeferredTasks, metricsClient, metrics.TaskListScavengerScope)
@@ -109,6 +117,8 @@ func NewScavenger(db p.TaskManager, metricsClient metrics.Client, logger log.Log
 		logger:   logger,
 		stopC:    stopC,
 		executor: taskExecutor,
+
+		isExcludedDomain: isExcludedDomain,
 	}
 }
 
@@ -160,6 +170,10 @@ func (s *Scavenger) run() {
 		}
 
 		for _, item := range resp.Items {
+			if s.isExcludedDomain(item.DomainID) {
+				atomic.AddInt64(&s.stats.tasklist.nSkipped, 1)
+				continue
+			}
 			atomic.AddInt64(&s.stats.tasklist.nProcessed, 1)
 			if !s.executor.Submit(s.newTask(&item)) {
 				return
@@ -198,6 +212,7 @@ func (s *Scavenger) emitStats() {
 	s.metrics.UpdateGauge(metrics.TaskListScavengerScope, metrics.TaskDeletedCount, float64(s.stats.task.nDeleted))
 	s.metrics.UpdateGauge(metrics.TaskListScavengerScope, metrics.TaskListProcessedCount, float64(s.stats.tasklist.nProcessed))
 	s.metrics.UpdateGauge(metrics.TaskListScavengerScope, metrics.TaskListDeletedCount, float64(s.stats.tasklist.nDeleted))
+	s.metrics.UpdateGauge(metrics.TaskListScavengerScope, metrics.TaskListSkippedCount, float64(s.stats.tasklist.nSkipped))
 }
 
 // newTask returns a new instance of an executable task which will process a single task list
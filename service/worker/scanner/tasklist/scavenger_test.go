@@ -23,6 +23,7 @@ package tasklist
 import (
 	"errors"
 	"fmt"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -62,7 +63,7 @@ func (s *ScavengerTestSuite) SetupTest() {
 		s.Require().NoError(err)
 	}
 	logger := loggerimpl.NewLogger(zapLogger)
-	s.scvgr = NewScavenger(s.taskMgr, metrics.NewClient(tally.NoopScope, metrics.Worker), logger)
+	s.scvgr = NewScavenger(s.taskMgr, func(string) bool { return false }, metrics.NewClient(tally.NoopScope, metrics.Worker), logger)
 	maxTasksPerJob = 4
 	executorPollInterval = time.Millisecond * 50
 }
@@ -166,6 +167,44 @@ func (s *ScavengerTestSuite) TestAllExpiredTasksWithErrors() {
 	s.Equal(1, len(result), "expected partial deletion due to transient errors")
 }
 
+func (s *ScavengerTestSuite) TestExcludedDomainTaskLists() {
+	nTasks := 32
+	nTaskLists := 3
+	for i := 0; i < nTaskLists; i++ {
+		name := fmt.Sprintf("test-expired-tl-%v", i)
+		s.taskListTable.generate(name, true)
+		tt := newMockTaskTable()
+		tt.generate(nTasks, true)
+		s.taskTables[name] = tt
+	}
+	excluded := s.taskListTable.get("test-expired-tl-0")
+	scope := tally.NewTestScope("", nil)
+	s.scvgr = NewScavenger(s.taskMgr, func(domainID string) bool {
+		return domainID == excluded.DomainID
+	}, metrics.NewClient(scope, metrics.Worker), s.scvgr.logger)
+	s.setupTaskMgrMocks()
+	s.runScavenger()
+	for tl, tbl := range s.taskTables {
+		tasks := tbl.get(100)
+		if tl == excluded.Name {
+			s.Equal(nTasks, len(tasks), "scavenger deleted tasks of an excluded domain")
+			s.NotNil(s.taskListTable.get(tl), "scavenger deleted a task list of an excluded domain")
+			continue
+		}
+		s.Equal(0, len(tasks), "failed to delete all expired tasks")
+		s.Nil(s.taskListTable.get(tl), "failed to delete expired executorTask list")
+	}
+	s.Equal(int64(1), atomic.LoadInt64(&s.scvgr.stats.tasklist.nSkipped))
+	s.Equal(int64(nTaskLists-1), atomic.LoadInt64(&s.scvgr.stats.tasklist.nProcessed))
+	skipped := 0.0
+	for _, gauge := range scope.Snapshot().Gauges() {
+		if gauge.Name() == "tasklist_skipped" {
+			skipped = gauge.Value()
+		}
+	}
+	s.Equal(1.0, skipped)
+}
+
 func (s *ScavengerTestSuite) runScavenger() {
 	s.scvgr.Start()
 	timer := time.NewTimer(10 * time.Second)
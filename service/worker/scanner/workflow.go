@@ -135,6 +135,7 @@ func HistoryScavengerActivity(
 		rps,
 		ctx.GetHistoryClient(),
 		hbd,
+		ctx.isExcludedDomain,
 		ctx.GetMetricsClient(),
 		ctx.GetLogger(),
 	)
@@ -147,7 +148,7 @@ func TaskListScavengerActivity(
 ) error {
 
 	ctx := activityCtx.Value(scannerContextKey).(scannerContext)
-	scavenger := tasklist.NewScavenger(ctx.GetTaskManager(), ctx.GetMetricsClient(), ctx.GetLogger())
+	scavenger := tasklist.NewScavenger(ctx.GetTaskManager(), ctx.isExcludedDomain, ctx.GetMetricsClient(), ctx.GetLogger())
 	ctx.GetLogger().Info("Starting task list scavenger")
 	scavenger.Start()
 	for scavenger.Alive() {
@@ -32,15 +32,26 @@ import (
 	"go.uber.org/cadence/worker"
 	"go.uber.org/zap"
 
+	"github.com/uber/cadence/.gen/go/history"
+	"github.com/uber/cadence/.gen/go/shared"
+	"github.com/uber/cadence/common"
 	"github.com/uber/cadence/common/metrics"
 	p "github.com/uber/cadence/common/persistence"
+	"github.com/uber/cadence/common/quotas"
 	"github.com/uber/cadence/common/resource"
+	"github.com/uber/cadence/common/service/dynamicconfig"
 )
 
-type scannerWorkflowTestSuite struct {
-	suite.Suite
-	testsuite.WorkflowTestSuite
-}
+type (
+	scannerWorkflowTestSuite struct {
+		suite.Suite
+		testsuite.WorkflowTestSuite
+	}
+
+	testScanJob struct {
+		runs int
+	}
+)
 
 func TestScannerWorkflowTestSuite(t *testing.T) {
 	suite.Run(t, new(scannerWorkflowTestSuite))
@@ -73,3 +84,133 @@ func (s *scannerWorkflowTestSuite) TestScavengerActivity() {
 	_, err := env.ExecuteActivity(taskListScavengerActivityName)
 	s.NoError(err)
 }
+
+func (s *scannerWorkflowTestSuite) TestScanJobWorkflow() {
+	env := s.NewTestWorkflowEnvironment()
+	env.OnActivity(scanJobActivityName, mock.Anything, "test-job").Return(nil)
+	env.ExecuteWorkflow(scanJobWFTypeName, "test-job")
+	s.True(env.IsWorkflowCompleted())
+	s.NoError(env.GetWorkflowError())
+}
+
+func (s *scannerWorkflowTestSuite) TestScanJobActivity() {
+	env := s.NewTestActivityEnvironment()
+	controller := gomock.NewController(s.T())
+	defer controller.Finish()
+	mockResource := resource.NewTest(controller, metrics.Worker)
+	defer mockResource.Finish(s.T())
+
+	job := &testScanJob{}
+	ctx := scannerContext{
+		Resource:    mockResource,
+		zapLogger:   zap.NewNop(),
+		rateLimiter: quotas.NewSimpleRateLimiter(10),
+		scanJobs:    map[string]ScanJob{job.Name(): job},
+	}
+	env.SetTestTimeout(time.Second * 5)
+	env.SetWorkerOptions(worker.Options{
+		BackgroundActivityContext: context.WithValue(context.Background(), scannerContextKey, ctx),
+	})
+	_, err := env.ExecuteActivity(scanJobActivityName, job.Name())
+	s.NoError(err)
+	s.Equal(1, job.runs)
+
+	_, err = env.ExecuteActivity(scanJobActivityName, "unknown-job")
+	s.Error(err)
+}
+
+func (s *scannerWorkflowTestSuite) TestRegisterScanJob() {
+	// the registry is global, so the test job is removed once the test is done
+	defer func() {
+		scanJobRegistry.Lock()
+		defer scanJobRegistry.Unlock()
+		delete(scanJobRegistry.jobs, (&testScanJob{}).Name())
+	}()
+	jobs := len(RegisteredScanJobs())
+	s.NoError(RegisterScanJob(&testScanJob{}))
+	s.Error(RegisterScanJob(&testScanJob{}))
+	s.Len(RegisteredScanJobs(), jobs+1)
+}
+
+func (s *scannerWorkflowTestSuite) TestIsExcludedDomain() {
+	controller := gomock.NewController(s.T())
+	defer controller.Finish()
+	mockResource := resource.NewTest(controller, metrics.Worker)
+	defer mockResource.Finish(s.T())
+
+	ctx := scannerContext{Resource: mockResource}
+	s.False(ctx.isExcludedDomain("domainID1"))
+	ctx.cfg.ExcludedDomains = func() interface{} { return []interface{}{} }
+	s.False(ctx.isExcludedDomain("domainID1"))
+
+	// the excluded domains are given by name, so the domain ids are resolved through the domain cache
+	ctx.cfg.ExcludedDomains = func() interface{} { return []interface{}{"excluded-domain"} }
+	mockResource.DomainCache.EXPECT().GetDomainName("domainID1").Return("excluded-domain", nil)
+	mockResource.DomainCache.EXPECT().GetDomainName("domainID2").Return("other-domain", nil)
+	mockResource.DomainCache.EXPECT().GetDomainName("domainID3").Return("", &shared.EntityNotExistsError{})
+	s.True(ctx.isExcludedDomain("domainID1"))
+	s.False(ctx.isExcludedDomain("domainID2"))
+	s.False(ctx.isExcludedDomain("domainID3"))
+}
+
+func (s *scannerWorkflowTestSuite) TestVisibilityDriftScanJob() {
+	controller := gomock.NewController(s.T())
+	defer controller.Finish()
+	mockResource := resource.NewTest(controller, metrics.Worker)
+	defer mockResource.Finish(s.T())
+
+	mockResource.MetadataMgr.On("ListDomains", mock.Anything).Return(&p.ListDomainsResponse{
+		Domains: []*p.GetDomainResponse{
+			{Info: &p.DomainInfo{ID: "deprecated-domain-id", Name: "deprecated-domain", Status: p.DomainStatusDeprecated}},
+			{Info: &p.DomainInfo{ID: "test-domain-id", Name: "test-domain", Status: p.DomainStatusRegistered}},
+		},
+	}, nil).Once()
+	executions := []*shared.WorkflowExecutionInfo{
+		{Execution: &shared.WorkflowExecution{WorkflowId: common.StringPtr("open"), RunId: common.StringPtr("run-1")}},
+		{Execution: &shared.WorkflowExecution{WorkflowId: common.StringPtr("closed"), RunId: common.StringPtr("run-2")}},
+		{Execution: &shared.WorkflowExecution{WorkflowId: common.StringPtr("missing"), RunId: common.StringPtr("run-3")}},
+	}
+	mockResource.VisibilityMgr.On("ListOpenWorkflowExecutions", mock.MatchedBy(func(request *p.ListWorkflowExecutionsRequest) bool {
+		return request.DomainUUID == "test-domain-id"
+	})).Return(&p.ListWorkflowExecutionsResponse{Executions: executions}, nil).Once()
+	mockResource.HistoryClient.EXPECT().DescribeWorkflowExecution(gomock.Any(), gomock.Any()).DoAndReturn(
+		func(_ context.Context, request *history.DescribeWorkflowExecutionRequest, _ ...interface{}) (*shared.DescribeWorkflowExecutionResponse, error) {
+			switch request.Request.Execution.GetWorkflowId() {
+			case "open":
+				return &shared.DescribeWorkflowExecutionResponse{WorkflowExecutionInfo: &shared.WorkflowExecutionInfo{}}, nil
+			case "closed":
+				return &shared.DescribeWorkflowExecutionResponse{WorkflowExecutionInfo: &shared.WorkflowExecutionInfo{
+					CloseStatus: shared.WorkflowExecutionCloseStatusCompleted.Ptr(),
+				}}, nil
+			default:
+				return nil, &shared.EntityNotExistsError{}
+			}
+		}).Times(3)
+
+	job := newVisibilityDriftScanJob(scannerContext{
+		Resource: mockResource,
+		cfg: Config{
+			VisibilityDriftDomainSampleSize:      dynamicconfig.GetIntPropertyFn(10),
+			VisibilityDriftMaxWorkflowsPerDomain: dynamicconfig.GetIntPropertyFn(10),
+		},
+	})
+	domains, err := job.sampleDomains(context.Background(), quotas.NewSimpleRateLimiter(100))
+	s.NoError(err)
+	s.Len(domains, 1)
+	result, err := job.scanDomain(context.Background(), quotas.NewSimpleRateLimiter(100), domains[0].Info)
+	s.NoError(err)
+	s.Equal(visibilityDriftResult{visibilityOpen: 3, executionOpen: 1}, result)
+}
+
+func (j *testScanJob) Name() string {
+	return "test-job"
+}
+
+func (j *testScanJob) CronSchedule() string {
+	return "0 */12 * * *"
+}
+
+func (j *testScanJob) Run(ctx context.Context, rateLimiter quotas.Limiter) error {
+	j.runs++
+	return rateLimiter.Wait(ctx)
+}
@@ -26,6 +26,7 @@ import (
 
 	"github.com/uber/cadence/.gen/go/shared"
 	"github.com/uber/cadence/common"
+	"github.com/uber/cadence/common/backoff"
 	"github.com/uber/cadence/common/definition"
 	"github.com/uber/cadence/common/log"
 	"github.com/uber/cadence/common/log/tag"
@@ -45,15 +46,16 @@ import (
 type (
 	// Service represents the cadence-worker service. This service hosts all background processing needed for cadence cluster:
 	// 1. Replicator: Handles applying replication tasks generated by remote clusters.
-	// 2. Indexer: Handles uploading of visibility records to elastic search.
+	// 2. Indexer: Handles uploading of visibility records to elastic search, and to the db as well in mirror writing mode.
 	// 3. Archiver: Handles archival of workflow histories.
 	Service struct {
 		resource.Resource
 
-		status int32
-		stopC  chan struct{}
-		params *service.BootstrapParams
-		config *Config
+		status  int32
+		stopC   chan struct{}
+		params  *service.BootstrapParams
+		config  *Config
+		indexer *indexer.Indexer // stopped before the resource so that the buffered visibility requests are flushed
 	}
 
 	// Config contains all the service config for worker
@@ -65,6 +67,7 @@ type (
 		BatcherCfg                    *batcher.Config
 		ThrottledLogRPS               dynamicconfig.IntPropertyFn
 		EnableBatcher                 dynamicconfig.BoolPropertyFn
+		EnableReplicator              dynamicconfig.BoolPropertyFn
 		EnableParentClosePolicyWorker dynamicconfig.BoolPropertyFn
 	}
 )
@@ -128,14 +131,33 @@ func NewConfig(params *service.BootstrapParams) *Config {
 		},
 		ScannerCfg: &scanner.Config{
 			PersistenceMaxQPS: dc.GetIntProperty(dynamicconfig.ScannerPersistenceMaxQPS, 100),
+			ExcludedDomains:   dc.GetProperty(dynamicconfig.ScannerExcludedDomains, []interface{}{}),
 			Persistence:       &params.PersistenceConfig,
 			ClusterMetadata:   params.ClusterMetadata,
+
+			VisibilityDriftScanEnabled:           dc.GetBoolProperty(dynamicconfig.ScannerVisibilityDriftScanEnabled, false),
+			VisibilityDriftDomainSampleSize:      dc.GetIntProperty(dynamicconfig.ScannerVisibilityDriftDomainSampleSize, 10),
+			VisibilityDriftMaxWorkflowsPerDomain: dc.GetIntProperty(dynamicconfig.ScannerVisibilityDriftMaxWorkflowsPerDomain, 1000),
 		},
 		BatcherCfg: &batcher.Config{
 			AdminOperationToken: dc.GetStringProperty(dynamicconfig.AdminOperationToken, common.DefaultAdminOperationToken),
 			ClusterMetadata:     params.ClusterMetadata,
+			MaxConcurrency:      dc.GetIntProperty(dynamicconfig.WorkerBatcherMaxConcurrency, 0),
+			MaxProcessors:       dc.GetIntProperty(dynamicconfig.WorkerBatcherMaxProcessors, 0),
+			RPS:                 dc.GetIntProperty(dynamicconfig.WorkerBatcherRPS, 0),
+			TaskListShards:      dc.GetIntProperty(dynamicconfig.WorkerBatcherTaskListShards, 1),
+			TaskListPerCluster:  dc.GetBoolProperty(dynamicconfig.WorkerBatcherTaskListPerCluster, false),
+			NamedQueries:        dc.GetMapProperty(dynamicconfig.WorkerBatcherNamedQueries, map[string]interface{}{}),
+			NumHistoryShards:    params.PersistenceConfig.NumHistoryShards,
+			// must be the same as history, which decides the archival system workflows to signal
+			NumArchiveSystemWorkflows: dc.GetIntProperty(dynamicconfig.NumArchiveSystemWorkflows, 1000),
+			ArchiveRequestRPS:         dc.GetIntProperty(dynamicconfig.ArchiveRequestRPS, 300),
+			// the defaults of the client
+			MaxConcurrentActivities: dc.GetIntProperty(dynamicconfig.WorkerBatcherMaxActivities, 1000),
+			MaxConcurrentDecisions:  dc.GetIntProperty(dynamicconfig.WorkerBatcherMaxDecisions, 1000),
 		},
 		EnableBatcher:                 dc.GetBoolProperty(dynamicconfig.EnableBatcher, false),
+		EnableReplicator:              dc.GetBoolProperty(dynamicconfig.EnableReplicator, true),
 		EnableParentClosePolicyWorker: dc.GetBoolProperty(dynamicconfig.EnableParentClosePolicyWorker, true),
 		ThrottledLogRPS:               dc.GetIntProperty(dynamicconfig.WorkerThrottledLogRPS, 20),
 	}
@@ -151,6 +173,7 @@ func NewConfig(params *service.BootstrapParams) *Config {
 			ESProcessorBulkSize:      dc.GetIntProperty(dynamicconfig.WorkerESProcessorBulkSize, 2<<24), // 16MB
 			ESProcessorFlushInterval: dc.GetDurationProperty(dynamicconfig.WorkerESProcessorFlushInterval, 1*time.Second),
 			ValidSearchAttributes:    dc.GetMapProperty(dynamicconfig.ValidSearchAttributes, definition.GetDefaultIndexedKeys()),
+			AdvancedVisWritingMode:   advancedVisWritingMode,
 		}
 	}
 	return config
@@ -173,7 +196,7 @@ func (s *Service) Start() {
 		s.startIndexer()
 	}
 
-	if s.GetClusterMetadata().IsGlobalDomainEnabled() {
+	if s.GetClusterMetadata().IsGlobalDomainEnabled() && s.config.EnableReplicator() {
 		s.startReplicator()
 	}
 	if s.GetArchivalMetadata().GetHistoryConfig().ClusterConfiguredForArchival() {
@@ -198,6 +221,9 @@ func (s *Service) Stop() {
 
 	close(s.stopC)
 
+	if s.indexer != nil {
+		s.indexer.Stop()
+	}
 	s.Resource.Stop()
 
 	s.params.Logger.Info("worker stopped", tag.ComponentWorker)
@@ -225,6 +251,21 @@ func (s *Service) startBatcher() {
 		Logger:        s.GetLogger(),
 		TallyScope:    s.params.MetricScope,
 		ClientBean:    s.GetClientBean(),
+
+		ExecutionManagerFn: s.GetExecutionManager,
+		HistoryManager:     s.GetHistoryManager(),
+		VisibilityManager:  s.GetVisibilityManager(),
+		HostIdentity:       s.GetHostInfo().Identity(),
+	}
+	if s.GetArchivalMetadata().GetHistoryConfig().ClusterConfiguredForArchival() {
+		params.ArchiverClient = archiver.NewClient(
+			s.GetMetricsClient(),
+			s.GetLogger(),
+			s.params.PublicClient,
+			s.config.BatcherCfg.NumArchiveSystemWorkflows,
+			s.config.BatcherCfg.ArchiveRequestRPS,
+			s.GetArchiverProvider(),
+		)
 	}
 	if err := batcher.New(params).Start(); err != nil {
 		s.GetLogger().Fatal("error starting batcher", tag.Error(err))
@@ -235,6 +276,7 @@ func (s *Service) startScanner() {
 	params := &scanner.BootstrapParams{
 		Config:     *s.config.ScannerCfg,
 		TallyScope: s.params.MetricScope,
+		ScanJobs:   scanner.RegisteredScanJobs(),
 	}
 	if err := scanner.New(s.Resource, params).Start(); err != nil {
 		s.GetLogger().Fatal("error starting scanner", tag.Error(err))
@@ -266,6 +308,7 @@ func (s *Service) startIndexer() {
 		s.GetMessagingClient(),
 		s.params.ESClient,
 		s.params.ESConfig,
+		s.GetVisibilityManager(),
 		s.GetLogger(),
 		s.GetMetricsClient(),
 	)
@@ -273,6 +316,7 @@ func (s *Service) startIndexer() {
 		visibilityIndexer.Stop()
 		s.GetLogger().Fatal("fail to start indexer", tag.Error(err))
 	}
+	s.indexer = visibilityIndexer
 }
 
 func (s *Service) startArchiver() {
@@ -293,19 +337,33 @@ func (s *Service) startArchiver() {
 }
 
 func (s *Service) ensureSystemDomainExists() {
+	// retry transient errors so that a brief unavailability of the metadata store
+	// during a coordinated restart doesn't bring down the worker
+	err := backoff.Retry(
+		s.checkOrRegisterSystemDomain,
+		common.CreatePersistanceRetryPolicy(),
+		common.IsPersistenceTransientError,
+	)
+	if err != nil {
+		s.GetLogger().Fatal("failed to ensure cadence system domain exists", tag.Error(err))
+	}
+}
+
+func (s *Service) checkOrRegisterSystemDomain() error {
 	_, err := s.GetMetadataManager().GetDomain(&persistence.GetDomainRequest{Name: common.SystemLocalDomainName})
 	switch err.(type) {
 	case nil:
-		// noop
+		return nil
 	case *shared.EntityNotExistsError:
 		s.GetLogger().Info("cadence-system domain does not exist, attempting to register domain")
-		s.registerSystemDomain()
+		return s.registerSystemDomain()
 	default:
-		s.GetLogger().Fatal("failed to verify if cadence system domain exists", tag.Error(err))
+		s.GetLogger().Warn("failed to verify if cadence system domain exists", tag.Error(err))
+		return err
 	}
 }
 
-func (s *Service) registerSystemDomain() {
+func (s *Service) registerSystemDomain() error {
 
 	currentClusterName := s.GetClusterMetadata().GetCurrentClusterName()
 	_, err := s.GetMetadataManager().CreateDomain(&persistence.CreateDomainRequest{
@@ -327,8 +385,10 @@ func (s *Service) registerSystemDomain() {
 	})
 	if err != nil {
 		if _, ok := err.(*shared.DomainAlreadyExistsError); ok {
-			return
+			return nil
 		}
-		s.GetLogger().Fatal("failed to register system domain", tag.Error(err))
+		s.GetLogger().Warn("failed to register system domain", tag.Error(err))
+		return err
 	}
+	return nil
 }
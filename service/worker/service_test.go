@@ -0,0 +1,88 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package worker
+
+import (
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+
+	"github.com/uber/cadence/.gen/go/shared"
+	"github.com/uber/cadence/common"
+	"github.com/uber/cadence/common/cluster"
+	"github.com/uber/cadence/common/metrics"
+	"github.com/uber/cadence/common/persistence"
+	"github.com/uber/cadence/common/resource"
+)
+
+type (
+	serviceSuite struct {
+		suite.Suite
+		*require.Assertions
+
+		controller   *gomock.Controller
+		mockResource *resource.Test
+
+		service *Service
+	}
+)
+
+func TestServiceSuite(t *testing.T) {
+	s := new(serviceSuite)
+	suite.Run(t, s)
+}
+
+func (s *serviceSuite) SetupTest() {
+	s.Assertions = require.New(s.T())
+
+	s.controller = gomock.NewController(s.T())
+	s.mockResource = resource.NewTest(s.controller, metrics.Worker)
+	s.service = &Service{
+		Resource: s.mockResource,
+		status:   common.DaemonStatusInitialized,
+	}
+}
+
+func (s *serviceSuite) TearDownTest() {
+	s.controller.Finish()
+	s.mockResource.Finish(s.T())
+}
+
+func (s *serviceSuite) TestEnsureSystemDomainExists_TransientGetDomainError() {
+	request := &persistence.GetDomainRequest{Name: common.SystemLocalDomainName}
+	s.mockResource.MetadataMgr.On("GetDomain", request).Return(nil, &shared.InternalServiceError{}).Twice()
+	s.mockResource.MetadataMgr.On("GetDomain", request).Return(&persistence.GetDomainResponse{}, nil).Once()
+
+	s.service.ensureSystemDomainExists()
+}
+
+func (s *serviceSuite) TestEnsureSystemDomainExists_TransientCreateDomainError() {
+	request := &persistence.GetDomainRequest{Name: common.SystemLocalDomainName}
+	s.mockResource.MetadataMgr.On("GetDomain", request).Return(nil, &shared.EntityNotExistsError{}).Twice()
+	s.mockResource.ClusterMetadata.EXPECT().GetCurrentClusterName().Return(cluster.TestCurrentClusterName).Times(2)
+	s.mockResource.MetadataMgr.On("CreateDomain", mock.Anything).Return(nil, &shared.ServiceBusyError{}).Once()
+	s.mockResource.MetadataMgr.On("CreateDomain", mock.Anything).Return(&persistence.CreateDomainResponse{}, nil).Once()
+
+	s.service.ensureSystemDomainExists()
+}
@@ -189,7 +189,7 @@ func newAdminShardManagementCommands() []cli.Command {
 		{
 			Name:    "closeShard",
 			Aliases: []string{"clsh"},
-			Usage:   "close a shard given a shard id",
+			Usage:   "close a shard given a shard id, the shard is reacquired right away by the host owning it",
 			Flags: []cli.Flag{
 				cli.IntFlag{
 					Name:  FlagShardID,
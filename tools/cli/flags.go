@@ -191,6 +191,10 @@ const (
 	FlagBatchTypeWithAlias                = FlagBatchType + ", bt"
 	FlagSignalName                        = "signal_name"
 	FlagSignalNameWithAlias               = FlagSignalName + ", sig"
+	FlagChildPolicy                       = "child_policy"
+	FlagQueryName                         = "query_name"
+	FlagQueryParams                       = "query_params"
+	FlagFailoverDomains                   = "failover_domains"
 	FlagRemoveTaskID                      = "task_id"
 	FlagRemoveTypeID                      = "type_id"
 	FlagRPS                               = "rps"
@@ -436,6 +436,32 @@ func newBatchCommands() []cli.Command {
 				TerminateBatchJob(c)
 			},
 		},
+		{
+			Name:  "pause",
+			Usage: "pause a batch operation job, it can be resumed later",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  FlagJobIDWithAlias,
+					Usage: "Batch Job ID",
+				},
+			},
+			Action: func(c *cli.Context) {
+				SignalBatchJob(c, batcher.BatchPauseSignalName)
+			},
+		},
+		{
+			Name:  "resume",
+			Usage: "resume a paused batch operation job",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  FlagJobIDWithAlias,
+					Usage: "Batch Job ID",
+				},
+			},
+			Action: func(c *cli.Context) {
+				SignalBatchJob(c, batcher.BatchResumeSignalName)
+			},
+		},
 		{
 			Name:    "list",
 			Aliases: []string{"l"},
@@ -459,6 +485,14 @@ func newBatchCommands() []cli.Command {
 					Name:  FlagListQueryWithAlias,
 					Usage: "Query to get workflows for being executed this batch operation",
 				},
+				cli.StringFlag{
+					Name:  FlagQueryName,
+					Usage: "Name of a query configured on the batcher workers to use instead of query",
+				},
+				cli.StringFlag{
+					Name:  FlagQueryParams,
+					Usage: "Optional JSON object of the parameters of the named query, e.g. {\"WorkflowType\":\"my-type\"}",
+				},
 				cli.StringFlag{
 					Name:  FlagReasonWithAlias,
 					Usage: "Reason to run this batch job",
@@ -474,13 +508,37 @@ func newBatchCommands() []cli.Command {
 				},
 				cli.StringFlag{
 					Name:  FlagInputWithAlias,
-					Usage: "Optional input of signal",
+					Usage: "Optional input of signal, which can use {{.WorkflowID}} and {{.RunID}} of each target workflow. Required as JSON object of search attributes for batch upsert_search_attributes",
+				},
+				cli.StringFlag{
+					Name:  FlagResetType,
+					Usage: "Required for batch reset, where to reset. Support one of these: " + strings.Join(batcher.AllResetTypes, ","),
+				},
+				cli.StringFlag{
+					Name:  FlagChildPolicy,
+					Usage: "Optional for batch cancel, what to do with the pending child workflows. Support one of these: " + strings.Join(batcher.AllChildPolicies, ","),
 				},
 				cli.IntFlag{
 					Name:  FlagRPS,
 					Value: batcher.DefaultRPS,
 					Usage: "RPS of processing",
 				},
+				cli.StringFlag{
+					Name:  FlagSecurityTokenWithAlias,
+					Usage: "Required for batch delete_closed and failover_domains, the admin operation token of the batcher workers",
+				},
+				cli.StringFlag{
+					Name:  FlagFailoverDomains,
+					Usage: "Required for batch failover_domains, comma separated domains to failover to the cluster of --" + FlagActiveClusterName,
+				},
+				cli.StringFlag{
+					Name:  FlagActiveClusterName,
+					Usage: "Required for batch failover_domains, the cluster to failover the domains to",
+				},
+				cli.StringFlag{
+					Name:  FlagCluster,
+					Usage: "Optional cluster of the batcher workers to run the batch job, required if they poll a tasklist per cluster",
+				},
 				cli.BoolFlag{
 					Name:  FlagYes,
 					Usage: "Optional flag to disable confirmation prompt",
@@ -26,7 +26,9 @@ import (
 	"fmt"
 	"os"
 	"strings"
+	"time"
 
+	"github.com/pborman/uuid"
 	"github.com/urfave/cli"
 	"go.uber.org/cadence/.gen/go/shared"
 	cclient "go.uber.org/cadence/client"
@@ -53,6 +55,23 @@ func TerminateBatchJob(c *cli.Context) {
 	prettyPrintJSONObject(output)
 }
 
+// SignalBatchJob sends the signal to a batch job, e.g. to pause or resume it
+func SignalBatchJob(c *cli.Context, signalName string) {
+	jobID := getRequiredOption(c, FlagJobID)
+	svcClient := cFactory.ClientFrontendClient(c)
+	client := cclient.NewClient(svcClient, common.SystemLocalDomainName, &cclient.Options{})
+	tcCtx, cancel := newContext(c)
+	defer cancel()
+	err := client.SignalWorkflow(tcCtx, jobID, "", signalName, nil)
+	if err != nil {
+		ErrorAndExit("Failed to signal batch job", err)
+	}
+	output := map[string]interface{}{
+		"msg": "batch job is signaled: " + signalName,
+	}
+	prettyPrintJSONObject(output)
+}
+
 // DescribeBatchJob describe the status of the batch job
 func DescribeBatchJob(c *cli.Context) {
 	jobID := getRequiredOption(c, FlagJobID)
@@ -128,9 +147,19 @@ func ListBatchJobs(c *cli.Context) {
 // StartBatchJob starts a batch job
 func StartBatchJob(c *cli.Context) {
 	domain := getRequiredGlobalOption(c, FlagDomain)
-	query := getRequiredOption(c, FlagListQuery)
-	reason := getRequiredOption(c, FlagReason)
+	query := c.String(FlagListQuery)
+	queryName := c.String(FlagQueryName)
 	batchType := getRequiredOption(c, FlagBatchType)
+	if query == "" && queryName == "" && batchType != batcher.BatchTypeFailoverDomains {
+		ErrorAndExit(fmt.Sprintf("Option %s or %s is required", FlagListQuery, FlagQueryName), nil)
+	}
+	var queryParams map[string]string
+	if c.IsSet(FlagQueryParams) {
+		if err := json.Unmarshal([]byte(c.String(FlagQueryParams)), &queryParams); err != nil {
+			ErrorAndExit("Query params must be a JSON object of strings", err)
+		}
+	}
+	reason := getRequiredOption(c, FlagReason)
 	if !validateBatchType(batchType) {
 		ErrorAndExit("batchType is not valid, supported:"+strings.Join(batcher.AllBatchTypes, ","), nil)
 	}
@@ -140,20 +169,49 @@ func StartBatchJob(c *cli.Context) {
 		sigName = getRequiredOption(c, FlagSignalName)
 		sigVal = getRequiredOption(c, FlagInput)
 	}
+	var searchAttributes map[string]interface{}
+	if batchType == batcher.BatchTypeUpsertSearchAttributes {
+		if err := json.Unmarshal([]byte(getRequiredOption(c, FlagInput)), &searchAttributes); err != nil {
+			ErrorAndExit("Input must be a JSON object of search attributes for batch upsert_search_attributes", err)
+		}
+	}
+	var resetType string
+	if batchType == batcher.BatchTypeReset {
+		resetType = getRequiredOption(c, FlagResetType)
+	}
+	var securityToken string
+	if batchType == batcher.BatchTypeDeleteClosed || batchType == batcher.BatchTypeFailoverDomains {
+		securityToken = getRequiredOption(c, FlagSecurityToken)
+	}
+	var failoverDomains []string
+	var activeCluster string
+	if batchType == batcher.BatchTypeFailoverDomains {
+		failoverDomains = strings.Split(getRequiredOption(c, FlagFailoverDomains), ",")
+		activeCluster = getRequiredOption(c, FlagActiveClusterName)
+	}
 	rps := c.Int(FlagRPS)
 
 	svcClient := cFactory.ClientFrontendClient(c)
 	client := cclient.NewClient(svcClient, common.SystemLocalDomainName, &cclient.Options{})
 	tcCtx, cancel := newContext(c)
 	defer cancel()
-	resp, err := client.CountWorkflow(tcCtx, &shared.CountWorkflowExecutionsRequest{
-		Domain: common.StringPtr(domain),
-		Query:  common.StringPtr(query),
-	})
-	if err != nil {
-		ErrorAndExit("Failed to count impacting workflows for starting a batch job", err)
+	if batchType == batcher.BatchTypeFailoverDomains {
+		fmt.Printf("This batch job will be failing over %v domains to %v.\n", len(failoverDomains), activeCluster)
+	} else if queryName != "" {
+		// the named query is only known to the batcher workers
+		fmt.Printf("This batch job will be operating on the workflows of named query %v.\n", queryName)
+	} else {
+		resp, err := client.CountWorkflow(tcCtx, &shared.CountWorkflowExecutionsRequest{
+			Domain: common.StringPtr(domain),
+			Query:  common.StringPtr(query),
+		})
+		if err != nil {
+			ErrorAndExit("Failed to count impacting workflows for starting a batch job", err)
+		}
+		fmt.Printf("This batch job will be operating on %v workflows.\n", resp.GetCount())
+		estimate := batcher.EstimateBatchDuration(resp.GetCount(), batcher.BatchParams{BatchType: batchType, RPS: rps})
+		fmt.Printf("It will take about %v at the requested RPS.\n", estimate.Round(time.Second))
 	}
-	fmt.Printf("This batch job will be operating on %v workflows.\n", resp.GetCount())
 	if !c.Bool(FlagYes) {
 		reader := bufio.NewReader(os.Stdin)
 		for {
@@ -174,7 +232,9 @@ func StartBatchJob(c *cli.Context) {
 	tcCtx, cancel = newContext(c)
 	defer cancel()
 	options := cclient.StartWorkflowOptions{
-		TaskList:                     batcher.BatcherTaskListName,
+		// the ID is known ahead so that the params of the admin batch types can be signed for the job
+		ID:                           uuid.New(),
+		TaskList:                     batcher.GetBatcherTaskListName(c.String(FlagCluster)),
 		ExecutionStartToCloseTimeout: batcher.InfiniteDuration,
 		Memo: map[string]interface{}{
 			"Reason": reason,
@@ -185,16 +245,38 @@ func StartBatchJob(c *cli.Context) {
 		},
 	}
 	params := batcher.BatchParams{
-		DomainName: domain,
-		Query:      query,
-		Reason:     reason,
-		BatchType:  batchType,
+		DomainName:       domain,
+		Query:            query,
+		QueryName:        queryName,
+		QueryParams:      queryParams,
+		Reason:           reason,
+		BatchType:        batchType,
+		OperatorIdentity: operator,
 		SignalParams: batcher.SignalParams{
 			SignalName: sigName,
 			Input:      sigVal,
 		},
+		UpsertSearchAttributesParams: batcher.UpsertSearchAttributesParams{
+			SearchAttributes: searchAttributes,
+		},
+		ResetParams: batcher.ResetParams{
+			ResetType: resetType,
+		},
+		CancelParams: batcher.CancelParams{
+			ChildPolicy: c.String(FlagChildPolicy),
+		},
+		FailoverDomainsParams: batcher.FailoverDomainsParams{
+			Domains:           failoverDomains,
+			ActiveClusterName: activeCluster,
+		},
 		RPS: rps,
 	}
+	signature := batcher.SignAdminOperation(securityToken, options.ID, params)
+	params.DeleteClosedParams.AdminOperationSignature = signature
+	params.FailoverDomainsParams.AdminOperationSignature = signature
+	if err := batcher.ValidateParams(params); err != nil {
+		ErrorAndExit("Invalid batch job parameters", err)
+	}
 	wf, err := client.StartWorkflow(tcCtx, options, batcher.BatchWFTypeName, params)
 	if err != nil {
 		ErrorAndExit("Failed to start batch job", err)
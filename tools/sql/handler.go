@@ -28,6 +28,7 @@ import (
 
 	"github.com/urfave/cli"
 
+	"github.com/uber/cadence/common/persistence/sql"
 	"github.com/uber/cadence/common/service/config"
 	"github.com/uber/cadence/schema/mysql"
 	"github.com/uber/cadence/tools/common/schema"
@@ -156,6 +157,40 @@ func createDatabase(cli *cli.Context) error {
 	return nil
 }
 
+// backfillExecutionTime sets the execution time of the visibility rows of a domain
+// written before the column existed to their start time
+func backfillExecutionTime(cli *cli.Context) error {
+	params, err := parseConnectParams(cli)
+	if err != nil {
+		return handleErr(schema.NewConfigError(err.Error()))
+	}
+	domainID := cli.String(cliOptDomainID)
+	if domainID == "" {
+		return handleErr(schema.NewConfigError("missing " + flag(cliOptDomainID) + " argument "))
+	}
+	batchSize := cli.Int(cliOptBatchSize)
+	if batchSize <= 0 {
+		return handleErr(schema.NewConfigError("invalid " + flag(cliOptBatchSize) + " argument "))
+	}
+	db, err := sql.NewSQLDB(&config.SQL{
+		PluginName:   params.PluginName,
+		User:         params.User,
+		Password:     params.Password,
+		DatabaseName: params.Database,
+		ConnectAddr:  fmt.Sprintf("%v:%v", params.Host, params.Port),
+	})
+	if err != nil {
+		return handleErr(err)
+	}
+	defer db.Close()
+	updated, err := db.BackfillExecutionTime(domainID, batchSize)
+	if err != nil {
+		return handleErr(fmt.Errorf("error backfilling execution time after %v rows: %v", updated, err))
+	}
+	log.Printf("backfilled the execution time of %v rows\n", updated)
+	return nil
+}
+
 func doCreateDatabase(p ConnectParams, name string) error {
 	p.Database = ""
 	conn, err := NewConnection(&p)
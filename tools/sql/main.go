@@ -28,7 +28,16 @@ import (
 	"github.com/uber/cadence/tools/common/schema"
 )
 
-const defaultSQLPort = 3306
+const (
+	defaultSQLPort = 3306
+
+	// cliOptDomainID is the cli option for the domain of backfill-execution-time
+	cliOptDomainID = "domain-id"
+	// cliOptBatchSize is the cli option for the number of rows updated per statement by backfill-execution-time
+	cliOptBatchSize = "batch-size"
+
+	defaultBackfillBatchSize = 1000
+)
 
 // RunTool runs the cadence-cassandra-tool command line tool
 func RunTool(args []string) error {
@@ -159,6 +168,24 @@ func BuildCLIOptions() *cli.App {
 				cliHandler(c, createDatabase)
 			},
 		},
+		{
+			Name:  "backfill-execution-time",
+			Usage: "set the execution time of the visibility rows of a domain written before the column existed",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  cliOptDomainID,
+					Usage: "id of the domain to backfill",
+				},
+				cli.IntFlag{
+					Name:  cliOptBatchSize,
+					Value: defaultBackfillBatchSize,
+					Usage: "number of rows updated per statement",
+				},
+			},
+			Action: func(c *cli.Context) {
+				cliHandler(c, backfillExecutionTime)
+			},
+		},
 	}
 
 	return app